@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 一阶自相关 rho 的收益率序列，rho=0 时为独立同分布
+func ar1Returns(n int, rho float64, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	returns := make([]float64, n)
+	prev := 0.0
+	for i := range returns {
+		prev = rho*prev + rng.NormFloat64()
+		returns[i] = prev
+	}
+	return returns
+}
+
+// AR(1) 的理论方差比 VR(n) = 1 + 2 * Σ(1-k/n) * rho^k
+func ar1VarianceRatio(rho float64, n int) float64 {
+	vr := 1.0
+	for k := 1; k < n; k++ {
+		vr += 2 * (1 - float64(k)/float64(n)) * math.Pow(rho, float64(k))
+	}
+	return vr
+}
+
+// 独立同分布时经验换算与平方根时间法则一致；有自相关时两者明显不同，经验换算接近理论值
+func TestAggregateVolatilityAutocorrelation(t *testing.T) {
+	const n = 4
+	for _, tc := range []struct {
+		name string
+		rho  float64
+	}{
+		{"独立同分布", 0},
+		{"正自相关", 0.3},
+		{"负自相关", -0.3},
+	} {
+		returns := ar1Returns(50000, tc.rho, 1)
+		perBar := math.Sqrt(sampleVariance(returns))
+		sqrtTime := aggregateVolatility(perBar, n, AggSqrtTime, returns)
+		empirical := aggregateVolatility(perBar, n, AggEmpirical, returns)
+
+		if want := perBar * 2; math.Abs(sqrtTime-want) > 1e-12 {
+			t.Errorf("%s: 平方根时间法则 = %v, want %v", tc.name, sqrtTime, want)
+		}
+		want := ar1VarianceRatio(tc.rho, n)
+		if got := varianceRatio(returns, n); math.Abs(got-want) > 0.05 {
+			t.Errorf("%s: VR(%d) = %.4f, want %.4f", tc.name, n, got, want)
+		}
+		ratio := empirical / sqrtTime
+		if tc.rho == 0 && math.Abs(ratio-1) > 0.03 {
+			t.Errorf("%s: 经验/平方根 = %.4f, want ≈ 1", tc.name, ratio)
+		}
+		if tc.rho != 0 && math.Abs(ratio-1) < 0.1 {
+			t.Errorf("%s: 经验/平方根 = %.4f, 应明显偏离 1", tc.name, ratio)
+		}
+	}
+}
+
+// 样本不足 minAggregatePeriods 个周期时经验换算返回 NaN
+func TestVarianceRatioTooFewPeriods(t *testing.T) {
+	returns := ar1Returns(5*minAggregatePeriods-1, 0, 2)
+	if vr := varianceRatio(returns, 5); !math.IsNaN(vr) {
+		t.Errorf("VR = %v, want NaN", vr)
+	}
+	if vr := varianceRatio(returns, 1); vr != 1 {
+		t.Errorf("VR(1) = %v, want 1", vr)
+	}
+}
+
+// 读取K线时使用 -csv-delimiter 和 -csv-encoding 的设置（Excel 导出的带 BOM、分号分隔的文件）
+func TestLoadKlinesCSVDelimiter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "klines.csv")
+	data := utf8BOM + "Open Time;Open Time (UTC);Open;High;Low;Close;Volume\n" +
+		"1767225600000;2026-01-01 00:00:00;2000;2003;1999;2002;76\n" +
+		"1767225660000;2026-01-01 00:01:00;2002;2006;2001;2005;25\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setCSVDelimiter(";"); err != nil {
+		t.Fatal(err)
+	}
+	defer setCSVDelimiter(",")
+
+	klines, rowErrors, err := loadKlinesCSV(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(klines) != 2 || len(rowErrors) != 0 {
+		t.Fatalf("读到 %d 根K线、%d 个错误行, want 2 和 0", len(klines), len(rowErrors))
+	}
+	if klines[1].Close != 2005 {
+		t.Errorf("Close = %v, want 2005", klines[1].Close)
+	}
+}
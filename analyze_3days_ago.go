@@ -1,69 +1,111 @@
 package main
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 func main() {
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	since := flag.String("since", "", "起始时间（RFC3339 或 2006-01-02），需与生成 zscore_matrix.csv 时相同，默认取最近 -lookback-days 天")
+	until := flag.String("until", "", "结束时间（不含），格式同 -since")
+	windowsFlag := flag.String("windows", "", "关键时间窗口（分钟，逗号分隔），默认 1,5,15,30,60,120,240,1440,2880,4320,10080")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	tz := flag.String("tz", "UTC", "报告中时间的显示时区（IANA 时区名，如 Asia/Shanghai、Local），CSV 中的时间始终为 UTC")
+	lookbackDays := flag.Int("lookback-days", 7, "默认分析的天数，需与生成 zscore_matrix.csv 时的 -lookback-days 相同")
+	format := flag.String("format", "text", "报告中表格的格式: text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）")
+	flag.Parse()
+	if *lookbackDays < 1 {
+		log.Fatalf("-lookback-days 必须大于等于 1: %d", *lookbackDays)
+	}
+	lookback := *lookbackDays * 1440
+	if err := setOutputFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+	if err := setDisplayTimezone(*tz); err != nil {
+		log.Fatal(err)
+	}
+	windows, err := parseWindows(*windowsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	fmt.Println("正在分析三天前的数据...")
 
 	// 读取价格数据
-	priceFile, err := os.Open("ETHUSDT_latest_14days.csv")
+	klines, _, err := loadKlines(inputPath(klinesFileName("latest_14days")), *strict)
 	if err != nil {
-		log.Fatal("无法打开价格文件:", err)
+		log.Fatal("读取价格数据失败:", err)
 	}
-	defer priceFile.Close()
 
-	priceReader := csv.NewReader(priceFile)
-	priceRecords, err := priceReader.ReadAll()
-	if err != nil {
-		log.Fatal("读取价格CSV失败:", err)
+	prices := make([]float64, len(klines))
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Close
+		timestamps[i] = formatTimestamp(k.Time) // 按 -tz 显示
 	}
 
-	// 解析价格数据（跳过标题行）
-	prices := make([]float64, 0, len(priceRecords)-1)
-	timestamps := make([]string, 0, len(priceRecords)-1)
-	for i := 1; i < len(priceRecords); i++ {
-		if len(priceRecords[i]) < 6 {
-			continue
-		}
-		closePrice, err := strconv.ParseFloat(priceRecords[i][5], 64)
+	// 默认只取最近 lookback 分钟的数据，指定 -since/-until 时取对应的时间范围
+	start, end := len(prices)-lookback, len(prices)
+	if *since != "" || *until != "" {
+		start, end, err = selectTimeRange(klines, *since, *until)
 		if err != nil {
-			continue
+			log.Fatal(err)
 		}
-		prices = append(prices, closePrice)
-		timestamps = append(timestamps, priceRecords[i][1]) // UTC时间
+	} else if start < 0 {
+		log.Fatalf("数据不足，%d 天需要至少 %d 条，实际只有 %d 条", *lookbackDays, lookback, len(prices))
 	}
-
-	if len(prices) < 1440*7 {
-		log.Fatalf("数据不足")
+	if end-start < 1440*3+1 {
+		log.Fatalf("数据不足，时间范围内需要至少 %d 条，实际只有 %d 条", 1440*3+1, end-start)
 	}
-
-	// 只取最近7天的数据
-	recent7Days := prices[len(prices)-1440*7:]
-	recent7DaysTimestamps := timestamps[len(timestamps)-1440*7:]
+	recentPrices := prices[start:end]
+	recentTimestamps := timestamps[start:end]
 
 	// 三天前大约是索引 4320 (3 * 1440)
 	threeDaysAgoIdx := 1440 * 3
 	fmt.Printf("三天前的时间点索引: %d\n", threeDaysAgoIdx)
-	fmt.Printf("对应时间: %s\n", recent7DaysTimestamps[threeDaysAgoIdx])
-	fmt.Printf("价格: %.2f\n\n", recent7Days[threeDaysAgoIdx])
+	fmt.Printf("对应时间: %s\n", recentTimestamps[threeDaysAgoIdx])
+	fmt.Printf("价格: %.2f\n\n", recentPrices[threeDaysAgoIdx])
 
 	// 读取z-score矩阵
-	zscoreFile, err := os.Open("zscore_matrix.csv")
+	version, zscoreRecords, err := readSchemaCSV(inputPath("zscore_matrix.csv"))
 	if err != nil {
-		log.Fatal("无法打开z-score文件:", err)
+		log.Fatal("读取z-score CSV失败:", err)
 	}
-	defer zscoreFile.Close()
-
-	zscoreReader := csv.NewReader(zscoreFile)
-	zscoreRecords, err := zscoreReader.ReadAll()
+	if err := checkSchema("zscore_matrix.csv", version, matrixSchemaVersion, matrixSchemaMigrations, zscoreRecords, []string{"TimeIndex"}); err != nil {
+		log.Fatal(err)
+	}
+	// 矩阵的每一行对应时间范围内的一根K线，行数不一致时下标会错位
+	if len(zscoreRecords)-1 != len(recentPrices) {
+		log.Fatalf("zscore_matrix.csv 有 %d 行，当前时间范围有 %d 条数据，请使用与生成矩阵时相同的 -lookback-days/-since/-until",
+			len(zscoreRecords)-1, len(recentPrices))
+	}
+	columns, err := matrixColumns("zscore_matrix.csv", zscoreRecords[0])
 	if err != nil {
-		log.Fatal("读取z-score CSV失败:", err)
+		log.Fatal(err)
+	}
+	if missing := missingMatrixWindows(columns, windows, len(recentPrices)); len(missing) > 0 {
+		fmt.Printf("注意: zscore_matrix.csv 中没有这些窗口（生成时的 -matrix-windows 没有包含）: %v\n", missing)
 	}
 
 	// 分析三天前附近的数据（前后各1小时，即60个数据点）
@@ -72,8 +114,8 @@ func main() {
 	if startIdx < 0 {
 		startIdx = 0
 	}
-	if endIdx >= len(recent7Days) {
-		endIdx = len(recent7Days) - 1
+	if endIdx >= len(recentPrices) {
+		endIdx = len(recentPrices) - 1
 	}
 
 	fmt.Printf("分析时间段: 索引 %d 到 %d (三天前后各1小时)\n", startIdx, endIdx)
@@ -96,8 +138,12 @@ func main() {
 
 		// 检查不同时间窗口的z-score
 		// 重点关注短时间窗口（1-60分钟）和中等窗口（60-240分钟）
-		for window := 1; window <= 240 && window < len(row); window++ {
-			zscore, err := strconv.ParseFloat(row[window], 64)
+		for window := 1; window <= 240; window++ {
+			col, ok := columns[window]
+			if !ok || col >= len(row) {
+				continue
+			}
+			zscore, err := strconv.ParseFloat(row[col], 64)
 			if err != nil {
 				continue
 			}
@@ -112,43 +158,836 @@ func main() {
 
 	fmt.Printf("\n最大z-score: %.4f\n", maxZScore)
 	fmt.Printf("出现在索引: %d\n", maxZScoreIdx)
-	fmt.Printf("对应时间: %s\n", recent7DaysTimestamps[maxZScoreIdx])
-	fmt.Printf("价格: %.2f\n", recent7Days[maxZScoreIdx])
+	fmt.Printf("对应时间: %s\n", recentTimestamps[maxZScoreIdx])
+	fmt.Printf("价格: %.2f\n", recentPrices[maxZScoreIdx])
 	fmt.Printf("时间窗口: %d 分钟\n\n", maxZScoreWindow)
 
 	// 分析三天前时间点附近的价格变化
 	fmt.Println("三天前附近的价格变化:")
-	fmt.Println("时间\t\t\t价格\t\t变化%")
-	fmt.Println("-" + string(make([]byte, 60)) + "-")
-
-	basePrice := recent7Days[threeDaysAgoIdx]
+	priceTable := newTable("时间", "价格", "变化%")
+	basePrice := recentPrices[threeDaysAgoIdx]
 	for i := -10; i <= 10; i++ {
 		idx := threeDaysAgoIdx + i
-		if idx >= 0 && idx < len(recent7Days) {
-			price := recent7Days[idx]
+		if idx >= 0 && idx < len(recentPrices) {
+			price := recentPrices[idx]
 			change := ((price - basePrice) / basePrice) * 100
-			fmt.Printf("%s\t%.2f\t\t%.4f%%\n", recent7DaysTimestamps[idx], price, change)
+			priceTable.Add(recentTimestamps[idx], fmt.Sprintf("%.2f", price), fmt.Sprintf("%.4f%%", change))
 		}
 	}
+	priceTable.Print()
 
 	// 分析三天前时间点的z-score分布
 	fmt.Println("\n三天前时间点的z-score分布（不同窗口）:")
-	fmt.Println("窗口(分钟)\tz-score\t\t收益率%")
-	fmt.Println("-" + string(make([]byte, 50)) + "-")
-
+	zTable := newTable("窗口(分钟)", "z-score", "收益率%")
 	if threeDaysAgoIdx+1 < len(zscoreRecords) {
 		row := zscoreRecords[threeDaysAgoIdx+1]
-		keyWindows := []int{1, 5, 15, 30, 60, 120, 240, 1440, 2880, 4320}
-		for _, window := range keyWindows {
-			if window < len(row) {
-				zscore, _ := strconv.ParseFloat(row[window], 64)
+		for _, window := range windows {
+			if col, ok := columns[window]; ok && col < len(row) {
+				zscore, _ := strconv.ParseFloat(row[col], 64)
 				if threeDaysAgoIdx >= window {
-					prevPrice := recent7Days[threeDaysAgoIdx-window]
-					returnPct := ((recent7Days[threeDaysAgoIdx] - prevPrice) / prevPrice) * 100
-					fmt.Printf("%d\t\t%.4f\t\t%.4f%%\n", window, zscore, returnPct)
+					prevPrice := recentPrices[threeDaysAgoIdx-window]
+					returnPct := ((recentPrices[threeDaysAgoIdx] - prevPrice) / prevPrice) * 100
+					zTable.Add(strconv.Itoa(window), fmt.Sprintf("%.4f", zscore), fmt.Sprintf("%.4f%%", returnPct))
 				}
 			}
 		}
 	}
+	zTable.Print()
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 旧版本文件的迁移说明，Compatible 表示当前读取方仍能正确解析（例如只在末尾追加过列）
+type SchemaMigration struct {
+	Compatible bool
+	Note       string
+}
+
+// 读取带版本行的CSV，返回版本号和全部记录（含标题行）
+func readSchemaCSV(path string) (int, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	br, err := newCSVInput(path, file)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	if prefix, err := br.Peek(len(schemaPrefix)); err == nil && string(prefix) == schemaPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaPrefix)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s 的版本行格式错误: %q", path, strings.TrimSpace(line))
+		}
+	}
+
+	records, err := newCSVReader(br).ReadAll()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, records, nil
+}
+
+// 校验版本和标题：当前版本直接通过，兼容的旧版本打印迁移说明后继续，其余情况报错
+// expected 为读取方依赖的前几列
+func checkSchema(name string, version, current int, migrations map[int]SchemaMigration, records [][]string, expected []string) error {
+	if version != current {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%s 的版本 schema=%d 无法识别（当前为 schema=%d），请用当前版本的工具重新生成", name, version, current)
+		}
+		if !m.Compatible {
+			return fmt.Errorf("%s 是不兼容的旧版本 schema=%d: %s", name, version, m.Note)
+		}
+		fmt.Printf("注意: %s 是旧版本 schema=%d: %s\n", name, version, m.Note)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("%s 缺少标题行", name)
+	}
+	header := records[0]
+	if len(header) < len(expected) {
+		return fmt.Errorf("%s 的标题只有 %d 列，至少需要 %d 列", name, len(header), len(expected))
+	}
+	for i, col := range expected {
+		if header[i] != col {
+			return fmt.Errorf("%s 第 %d 列应为 %s，实际为 %s", name, i+1, col, header[i])
+		}
+	}
+	return nil
+}
+
+// z-score矩阵的版本，列有变化时加1，并在 matrixSchemaMigrations 中说明旧版本如何处理
+// v3: 缺少波动率数据的窗口写为 NaN（v2 写为 0）
+// v4: 列可以只包含 -matrix-windows 指定的窗口，标题为窗口的分钟数，读取方按标题找列
+const matrixSchemaVersion = 4
+
+// 旧版本z-score矩阵的迁移说明
+var matrixSchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "加版本行之前生成的矩阵，列布局相同；缺少波动率数据的窗口为 0，无法和 z=0 区分"},
+	2: {Compatible: true, Note: "缺少波动率数据的窗口为 0，无法和 z=0 区分"},
+	3: {Compatible: true, Note: "包含全部窗口，按标题读取结果相同"},
+}
+
+// 解析 -since/-until 参数，支持 RFC3339（如 2024-01-02T15:04:05Z）或日期（如 2024-01-02，按 UTC 零点）
+func parseTimeFlag(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间 %q，请使用 RFC3339（如 2024-01-02T15:04:05Z）或日期（如 2024-01-02）", value)
+}
+
+// 按开盘时间选出 [since, until) 范围内的1分钟K线，返回下标范围 [start, end)
+// since 或 until 为空表示该端不限制；指定的时间必须落在数据覆盖的范围内
+func selectTimeRange(klines []Kline, since, until string) (int, int, error) {
+	if len(klines) == 0 {
+		return 0, 0, fmt.Errorf("没有K线数据")
+	}
+	first := time.UnixMilli(klines[0].OpenTime).UTC()
+	last := time.UnixMilli(klines[len(klines)-1].OpenTime).UTC().Add(time.Minute)
+
+	sinceTime, untilTime := first, last
+	var err error
+	if since != "" {
+		if sinceTime, err = parseTimeFlag(since); err != nil {
+			return 0, 0, err
+		}
+	}
+	if until != "" {
+		if untilTime, err = parseTimeFlag(until); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if !sinceTime.Before(untilTime) {
+		return 0, 0, fmt.Errorf("-since (%s) 必须早于 -until (%s)", sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339))
+	}
+	if sinceTime.Before(first) || untilTime.After(last) {
+		return 0, 0, fmt.Errorf("时间范围 %s 到 %s 超出了数据覆盖的范围 %s 到 %s",
+			sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339), first.Format(time.RFC3339), last.Format(time.RFC3339))
+	}
+
+	start := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= sinceTime.UnixMilli() })
+	end := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= untilTime.UnixMilli() })
+	if start >= end {
+		return 0, 0, fmt.Errorf("时间范围 %s 到 %s 内没有数据", sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339))
+	}
+	return start, end, nil
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// CSV 中 Open Time (UTC) 列的时间格式
+const timestampLayout = "2006-01-02 15:04:05"
+
+// 报告中显示时间使用的时区，由 -tz 指定，默认 UTC
+var displayLocation = time.UTC
+
+// 解析 -tz 参数（IANA 时区名，如 Asia/Shanghai，或 UTC、Local）
+func setDisplayTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("未知的时区: %s（请使用 IANA 时区名，如 Asia/Shanghai、UTC、Local）", name)
+	}
+	displayLocation = loc
+	return nil
+}
+
+// 解析CSV中的时间字符串，CSV中的时间都是 UTC
+func parseTimestamp(value string) (time.Time, error) {
+	return time.ParseInLocation(timestampLayout, value, time.UTC)
+}
+
+// 把CSV中的时间转换到 -tz 时区显示，并带上时区缩写；无法解析时原样返回
+func formatTimestamp(value string) string {
+	t, err := parseTimestamp(value)
+	if err != nil {
+		return value
+	}
+	return t.In(displayLocation).Format(timestampLayout + " MST")
+}
+
+// 控制台表格的输出格式，由 -format 指定
+var outputFormat = "text"
+
+// 设置 -format：text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）
+func setOutputFormat(name string) error {
+	switch name {
+	case "text", "tsv", "markdown":
+		outputFormat = name
+		return nil
+	}
+	return fmt.Errorf("未知的输出格式: %s（可选 text、tsv 或 markdown）", name)
+}
+
+// 控制台报告中的表格，所有表格都经 Render 按 -format 输出，不再手工用 \t 对齐
+type Table struct {
+	header []string
+	rows   [][]string
+}
+
+func newTable(header ...string) *Table {
+	return &Table{header: header}
+}
+
+func (t *Table) Add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// 按 outputFormat 输出表格
+func (t *Table) Render(w io.Writer) error {
+	switch outputFormat {
+	case "tsv":
+		return t.renderTSV(w)
+	case "markdown":
+		return t.renderMarkdown(w)
+	}
+	return t.renderText(w)
+}
+
+// 输出到标准输出，与 fmt.Printf 一样忽略写入错误
+func (t *Table) Print() {
+	t.Render(os.Stdout)
+}
+
+// 按终端显示宽度对齐；text/tabwriter 按字符数计算宽度，中文表头和说明会错位，所以这里自己补空格
+func (t *Table) renderText(w io.Writer) error {
+	widths := make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = displayWidth(h)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i < len(widths) && displayWidth(cell) > widths[i] {
+				widths[i] = displayWidth(cell)
+			}
+		}
+	}
+
+	// 列之间空两格，最后一列不补空格
+	writeRow := func(cells []string) error {
+		var b strings.Builder
+		for i, cell := range cells {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(cell)
+			if i < len(cells)-1 && i < len(widths) {
+				b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)))
+			}
+		}
+		b.WriteString("\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(widths))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderTSV(w io.Writer) error {
+	// 单元格里的制表符和换行会打乱列，换成空格
+	clean := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = clean.Replace(cell)
+		}
+		_, err := io.WriteString(w, strings.Join(escaped, "\t")+"\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderMarkdown(w io.Writer) error {
+	clean := strings.NewReplacer("|", `\|`, "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		// 列数不足的行补空单元格，GitHub 要求每行列数与标题一致
+		escaped := make([]string, len(t.header))
+		for i := range escaped {
+			if i < len(cells) {
+				escaped[i] = clean.Replace(cells[i])
+			}
+		}
+		_, err := io.WriteString(w, "| "+strings.Join(escaped, " | ")+" |\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(t.header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 终端显示宽度：中日韩字符和全角符号占两列
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r >= 0x1100 && (r <= 0x115f || (r >= 0x2e80 && r <= 0xa4cf) || (r >= 0xac00 && r <= 0xd7a3) ||
+			(r >= 0xf900 && r <= 0xfaff) || (r >= 0xfe30 && r <= 0xfe4f) || (r >= 0xff00 && r <= 0xff60) || (r >= 0xffe0 && r <= 0xffe6)) {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// z-score矩阵标题中的窗口（分钟）到列下标的映射，第 0 列是 TimeIndex
+// v4 起矩阵可以只包含生成时 -matrix-windows 指定的窗口，必须按标题中的窗口值找列，不能假设第 N 列就是 N 分钟窗口
+func matrixColumns(name string, header []string) (map[int]int, error) {
+	columns := make(map[int]int, len(header))
+	for i, col := range header[1:] {
+		window, err := strconv.Atoi(col)
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("%s 第 %d 列的窗口不是正整数: %q", name, i+2, col)
+		}
+		columns[window] = i + 1
+	}
+	return columns, nil
+}
+
+// windows 中不超过 maxWindow（矩阵覆盖的分钟数）却不在矩阵中的窗口，用于提示生成矩阵时的 -matrix-windows 没有包含它们
+func missingMatrixWindows(columns map[int]int, windows []int, maxWindow int) []int {
+	var missing []int
+	for _, window := range windows {
+		if _, ok := columns[window]; !ok && window <= maxWindow {
+			missing = append(missing, window)
+		}
+	}
+	return missing
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
 }
 
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
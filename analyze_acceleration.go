@@ -0,0 +1,911 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func main() {
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	window := flag.Int("window", 60, "变化率（ROC）的窗口（分钟）")
+	smooth := flag.Int("smooth", 1, "价格平滑的K线数（简单移动平均），1 表示不平滑；加速度是收益率的差分，噪声较大，可用它降低噪声")
+	lookbackDays := flag.Int("lookback-days", 1, "输出和分析最近多少天的数据")
+	recent := flag.Int("recent", 10, "打印最近多少个拐点")
+	tz := flag.String("tz", "UTC", "报告中时间的显示时区（IANA 时区名，如 Asia/Shanghai、Local），CSV 中的时间始终为 UTC")
+	flag.IntVar(&csvPrecision, "precision", -1, "CSV中浮点列的小数位数，-1 表示使用各列默认位数（默认价格 2 位，ROC 和加速度 6 位）")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	format := flag.String("format", "text", "报告中表格的格式: text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）")
+	flag.Parse()
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+	if *window < 1 {
+		log.Fatalf("-window 必须大于等于 1: %d", *window)
+	}
+	if *smooth < 1 {
+		log.Fatalf("-smooth 必须大于等于 1: %d", *smooth)
+	}
+	if *lookbackDays < 1 {
+		log.Fatalf("-lookback-days 必须大于等于 1: %d", *lookbackDays)
+	}
+	if err := setOutputFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+	if err := setDisplayTimezone(*tz); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
+	fmt.Println("正在读取数据...")
+
+	klines, _, err := loadKlines(inputPath(klinesFileName("minute_klines")), *strict)
+	if err != nil {
+		log.Fatal("读取价格数据失败:", err)
+	}
+
+	prices := make([]float64, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Close
+	}
+	prices = smoothPrices(prices, *smooth)
+
+	roc := computeROC(prices, *window, *returnMode)
+	accel := acceleration(roc)
+	inflections := inflectionPoints(accel)
+
+	// 只输出最近 lookback 分钟，前面留出计算 ROC 和加速度需要的数据
+	lookback := *lookbackDays * 1440
+	if len(prices) < lookback+*window+1 {
+		log.Fatalf("数据不足，%d 天加上 %d 分钟窗口需要至少 %d 条，实际只有 %d 条",
+			*lookbackDays, *window, lookback+*window+1, len(prices))
+	}
+	start := len(prices) - lookback
+
+	err = writeFileAtomic(outputPath("acceleration.csv"), func(w io.Writer) error {
+		if err := writeSchemaLine(w, accelerationSchemaVersion); err != nil {
+			return err
+		}
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Time", "Price", "ROC_Pct", "Acceleration_Pct", "Inflection"})
+		for i := start; i < len(prices); i++ {
+			inflection := "0"
+			if inflections[i] {
+				inflection = "1"
+			}
+			writer.Write([]string{
+				klines[i].Time,
+				formatFloat(prices[i], 2),
+				formatFloat(roc[i], 6),
+				formatFloat(accel[i], 6),
+				inflection,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		log.Fatal("保存结果失败:", err)
+	}
+
+	var points []int
+	for i := start; i < len(prices); i++ {
+		if inflections[i] {
+			points = append(points, i)
+		}
+	}
+	fmt.Printf("最近 %d 天共 %d 个拐点（%d 分钟ROC的加速度变号）\n", *lookbackDays, len(points), *window)
+
+	if len(points) > *recent {
+		points = points[len(points)-*recent:]
+	}
+	if len(points) > 0 {
+		fmt.Printf("\n最近 %d 个拐点:\n", len(points))
+		table := newTable("时间", "价格", "ROC%", "加速度%")
+		for _, i := range points {
+			table.Add(formatTimestamp(klines[i].Time), fmt.Sprintf("%.2f", prices[i]), fmt.Sprintf("%.4f", roc[i]), fmt.Sprintf("%+.6f", accel[i]))
+		}
+		table.Print()
+	}
+
+	last := len(prices) - 1
+	fmt.Printf("\n当前 %s: ROC = %.4f%%，加速度 = %+.6f%%，%s\n",
+		formatTimestamp(klines[last].Time), roc[last], accel[last], describeMotion(roc[last], accel[last]))
+	fmt.Println("\n结果已保存到:", outputPath("acceleration.csv"))
+}
+
+// acceleration.csv 的版本，列有变化时加1
+const accelerationSchemaVersion = 1
+
+// 变化率：每个点相对 window 根K线之前的收益率（百分比），前 window 个点为 NaN
+func computeROC(prices []float64, window int, returnMode string) []float64 {
+	roc := make([]float64, len(prices))
+	for i := range roc {
+		if i < window {
+			roc[i] = math.NaN()
+			continue
+		}
+		roc[i] = calculateReturn(prices[i-window], prices[i], returnMode)
+	}
+	return roc
+}
+
+// 加速度：相邻两点 ROC 的差（价格的二阶差分），任一 ROC 为 NaN 时为 NaN
+func acceleration(roc []float64) []float64 {
+	accel := make([]float64, len(roc))
+	for i := range accel {
+		if i == 0 {
+			accel[i] = math.NaN()
+			continue
+		}
+		accel[i] = roc[i] - roc[i-1]
+	}
+	return accel
+}
+
+// 拐点：加速度与前一个点符号相反（跳过 NaN 和 0）
+func inflectionPoints(accel []float64) []bool {
+	points := make([]bool, len(accel))
+	for i := 1; i < len(accel); i++ {
+		prev, cur := accel[i-1], accel[i]
+		if math.IsNaN(prev) || math.IsNaN(cur) || prev == 0 || cur == 0 {
+			continue
+		}
+		points[i] = (prev > 0) != (cur > 0)
+	}
+	return points
+}
+
+// 根据 ROC 和加速度的符号描述当前走势
+func describeMotion(roc, accel float64) string {
+	switch {
+	case math.IsNaN(roc) || math.IsNaN(accel):
+		return "数据不足"
+	case roc >= 0 && accel >= 0:
+		return "加速上涨"
+	case roc >= 0:
+		return "上涨减速"
+	case accel <= 0:
+		return "加速下跌"
+	default:
+		return "下跌减速"
+	}
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// 用 n 根K线的简单移动平均平滑价格，减少单根K线的噪声，n<=1 时原样返回
+// 平滑会带来约 (n-1)/2 根K线的滞后，并压低短窗口的波动，所以波动率和z-score必须使用相同的 -smooth
+// 前 n-1 个点用已有的K线求平均，保证长度和下标不变
+func smoothPrices(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	smoothed := make([]float64, len(prices))
+	sum := 0.0
+	for i, p := range prices {
+		sum += p
+		count := i + 1
+		if i >= n {
+			sum -= prices[i-n]
+			count = n
+		}
+		smoothed[i] = sum / float64(count)
+	}
+	return smoothed
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 写入版本行，必须在标题行之前调用
+func writeSchemaLine(w io.Writer, version int) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", schemaPrefix, version)
+	return err
+}
+
+// CSV 中浮点列的小数位数，由 -precision 指定；小于 0 时各列使用自己的默认位数
+var csvPrecision = -1
+
+// 按 -precision 格式化CSV中的浮点数，未指定时使用该列的默认位数
+func formatFloat(value float64, defaultPrecision int) string {
+	precision := defaultPrecision
+	if csvPrecision >= 0 {
+		precision = csvPrecision
+	}
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
+
+// CSV 中 Open Time (UTC) 列的时间格式
+const timestampLayout = "2006-01-02 15:04:05"
+
+// 报告中显示时间使用的时区，由 -tz 指定，默认 UTC
+var displayLocation = time.UTC
+
+// 解析 -tz 参数（IANA 时区名，如 Asia/Shanghai，或 UTC、Local）
+func setDisplayTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("未知的时区: %s（请使用 IANA 时区名，如 Asia/Shanghai、UTC、Local）", name)
+	}
+	displayLocation = loc
+	return nil
+}
+
+// 解析CSV中的时间字符串，CSV中的时间都是 UTC
+func parseTimestamp(value string) (time.Time, error) {
+	return time.ParseInLocation(timestampLayout, value, time.UTC)
+}
+
+// 把CSV中的时间转换到 -tz 时区显示，并带上时区缩写；无法解析时原样返回
+func formatTimestamp(value string) string {
+	t, err := parseTimestamp(value)
+	if err != nil {
+		return value
+	}
+	return t.In(displayLocation).Format(timestampLayout + " MST")
+}
+
+// 控制台表格的输出格式，由 -format 指定
+var outputFormat = "text"
+
+// 设置 -format：text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）
+func setOutputFormat(name string) error {
+	switch name {
+	case "text", "tsv", "markdown":
+		outputFormat = name
+		return nil
+	}
+	return fmt.Errorf("未知的输出格式: %s（可选 text、tsv 或 markdown）", name)
+}
+
+// 控制台报告中的表格，所有表格都经 Render 按 -format 输出，不再手工用 \t 对齐
+type Table struct {
+	header []string
+	rows   [][]string
+}
+
+func newTable(header ...string) *Table {
+	return &Table{header: header}
+}
+
+func (t *Table) Add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// 按 outputFormat 输出表格
+func (t *Table) Render(w io.Writer) error {
+	switch outputFormat {
+	case "tsv":
+		return t.renderTSV(w)
+	case "markdown":
+		return t.renderMarkdown(w)
+	}
+	return t.renderText(w)
+}
+
+// 输出到标准输出，与 fmt.Printf 一样忽略写入错误
+func (t *Table) Print() {
+	t.Render(os.Stdout)
+}
+
+// 按终端显示宽度对齐；text/tabwriter 按字符数计算宽度，中文表头和说明会错位，所以这里自己补空格
+func (t *Table) renderText(w io.Writer) error {
+	widths := make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = displayWidth(h)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i < len(widths) && displayWidth(cell) > widths[i] {
+				widths[i] = displayWidth(cell)
+			}
+		}
+	}
+
+	// 列之间空两格，最后一列不补空格
+	writeRow := func(cells []string) error {
+		var b strings.Builder
+		for i, cell := range cells {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(cell)
+			if i < len(cells)-1 && i < len(widths) {
+				b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)))
+			}
+		}
+		b.WriteString("\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(widths))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderTSV(w io.Writer) error {
+	// 单元格里的制表符和换行会打乱列，换成空格
+	clean := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = clean.Replace(cell)
+		}
+		_, err := io.WriteString(w, strings.Join(escaped, "\t")+"\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderMarkdown(w io.Writer) error {
+	clean := strings.NewReplacer("|", `\|`, "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		// 列数不足的行补空单元格，GitHub 要求每行列数与标题一致
+		escaped := make([]string, len(t.header))
+		for i := range escaped {
+			if i < len(cells) {
+				escaped[i] = clean.Replace(cells[i])
+			}
+		}
+		_, err := io.WriteString(w, "| "+strings.Join(escaped, " | ")+" |\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(t.header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 终端显示宽度：中日韩字符和全角符号占两列
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r >= 0x1100 && (r <= 0x115f || (r >= 0x2e80 && r <= 0xa4cf) || (r >= 0xac00 && r <= 0xd7a3) ||
+			(r >= 0xf900 && r <= 0xfaff) || (r >= 0xfe30 && r <= 0xfe4f) || (r >= 0xff00 && r <= 0xff60) || (r >= 0xffe0 && r <= 0xffe6)) {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// 二次曲线的价格 p = 10000 + 0.01*t^2：二阶差分是常数，ROC 的加速度约为 2*0.01*window/p*100
+// （价格从 10000 涨到约 10400，分母的变化让加速度逐渐减小约 10%），
+// 全程为正且没有拐点，走势为加速上涨
+func TestAccelerationQuadratic(t *testing.T) {
+	const window = 10
+	prices := make([]float64, 200)
+	for i := range prices {
+		prices[i] = 10000 + 0.01*float64(i*i)
+	}
+	accel := acceleration(computeROC(prices, window, "simple"))
+
+	want := 2 * 0.01 * window / 10000 * 100
+	for i, a := range accel {
+		if i <= window {
+			if !math.IsNaN(a) {
+				t.Fatalf("第 %d 个 = %v, 数据不足应为 NaN", i, a)
+			}
+			continue
+		}
+		if math.Abs(a-want) > 0.15*want {
+			t.Errorf("第 %d 个加速度 = %v, want 约 %v", i, a, want)
+		}
+	}
+	for i, inflection := range inflectionPoints(accel) {
+		if inflection {
+			t.Errorf("二次曲线在第 %d 个点有拐点", i)
+		}
+	}
+	last := len(prices) - 1
+	if got := describeMotion(computeROC(prices, window, "simple")[last], accel[last]); got != "加速上涨" {
+		t.Errorf("走势 = %s, want 加速上涨", got)
+	}
+}
+
+// 先加速上涨后减速（三次曲线 t^2 - t^3/300 在 t=100 处凹凸性改变），加速度在拐点附近由正变负
+func TestInflectionPoints(t *testing.T) {
+	prices := make([]float64, 200)
+	for i := range prices {
+		x := float64(i)
+		prices[i] = 10000 + 0.01*(x*x-x*x*x/300)
+	}
+	points := inflectionPoints(acceleration(computeROC(prices, 1, "simple")))
+	var found []int
+	for i, inflection := range points {
+		if inflection {
+			found = append(found, i)
+		}
+	}
+	if len(found) != 1 || found[0] < 95 || found[0] > 105 {
+		t.Errorf("拐点 = %v, want 一个在 100 附近", found)
+	}
+}
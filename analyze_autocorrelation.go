@@ -0,0 +1,965 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func main() {
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	windowsFlag := flag.String("windows", "", "关键时间窗口（分钟，逗号分隔），默认 1,5,15,30,60,120,240,1440,2880,4320,10080")
+	lags := flag.Int("lags", 20, "Ljung-Box 检验使用的滞后阶数")
+	alpha := flag.Float64("alpha", 0.05, "显著性水平，p值低于该值认为存在显著的自相关")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	format := flag.String("format", "text", "报告中表格的格式: text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）")
+	flag.Parse()
+	windows, err := parseWindows(*windowsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+	if err := setOutputFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+	if *lags < 1 {
+		log.Fatalf("-lags 必须大于等于 1: %d", *lags)
+	}
+
+	fmt.Println("正在读取数据...")
+
+	klines, _, err := loadKlines(inputPath(klinesFileName("minute_klines")), *strict)
+	if err != nil {
+		log.Fatal("读取价格数据失败:", err)
+	}
+	prices := make([]float64, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Close
+	}
+	fmt.Printf("共读取 %d 条数据\n\n", len(prices))
+
+	// z-score 假设各窗口的收益率独立同分布，但相邻的重叠窗口共享了 window-1 根K线，
+	// 收益率必然高度自相关，样本数也远没有看上去那么多，z-score 的p值会偏乐观
+	fmt.Printf("各窗口收益率的 Ljung-Box 检验（滞后 %d 阶，显著性水平 %.2f）:\n", *lags, *alpha)
+	table := newTable("窗口(分钟)", "样本", "重叠 lag1", "Q(重叠)", "p值", "不重叠 lag1", "p值", "结论")
+	for _, window := range windows {
+		overlapping := windowReturns(prices, window, 1, *returnMode)
+		if len(overlapping) <= *lags+1 {
+			if *strict {
+				log.Fatalf("%d 分钟窗口数据不足（-strict）", window)
+			}
+			continue
+		}
+		acf := autocorrelation(overlapping, 1)
+		stat, pValue := ljungBox(overlapping, *lags)
+
+		// 不重叠的收益率（步长等于窗口）才接近独立样本，数据不足时显示 N/A
+		nonOverlapLag1, nonOverlapP := "N/A", "N/A"
+		nonOverlapping := windowReturns(prices, window, window, *returnMode)
+		if len(nonOverlapping) > *lags+1 {
+			_, p := ljungBox(nonOverlapping, *lags)
+			nonOverlapLag1 = fmt.Sprintf("%.4f", autocorrelation(nonOverlapping, 1)[0])
+			nonOverlapP = fmt.Sprintf("%.4f", p)
+		}
+
+		conclusion := "无显著自相关"
+		if pValue < *alpha {
+			conclusion = "存在显著自相关"
+		}
+		table.Add(strconv.Itoa(window), strconv.Itoa(len(overlapping)), fmt.Sprintf("%.4f", acf[0]), fmt.Sprintf("%.1f", stat),
+			fmt.Sprintf("%.4f", pValue), nonOverlapLag1, nonOverlapP, conclusion)
+	}
+	table.Print()
+
+	// 自相关只看固定的几个滞后，Hurst 指数综合了从几十分钟到数据长度 1/4 的全部尺度
+	fmt.Println("\nHurst 指数（R/S 分析，1分钟对数收益率）:")
+	h, r2, err := hurstExponent(prices)
+	if err != nil {
+		if *strict {
+			log.Fatal(err)
+		}
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("H = %.4f（log-log 回归 R² = %.4f）: %s\n", h, r2, classifyHurst(h))
+	fmt.Printf("H > %.2f 为趋势，H < %.2f 为均值回归；R/S 在小样本下略偏高，结论只作参考\n", 0.5+hurstNeutralBand, 0.5-hurstNeutralBand)
+}
+
+// 窗口收益率序列，step=1 为相互重叠的滚动窗口，step=window 为首尾相接不重叠的窗口
+func windowReturns(prices []float64, window, step int, returnMode string) []float64 {
+	returns := make([]float64, 0, len(prices)/step)
+	for i := window; i < len(prices); i += step {
+		returns = append(returns, calculateReturn(prices[i-window], prices[i], returnMode))
+	}
+	return returns
+}
+
+// 样本自相关系数，返回滞后 1..maxLag 阶的结果（下标0对应滞后1阶）
+func autocorrelation(returns []float64, maxLag int) []float64 {
+	mean := calculateMean(returns)
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+
+	acf := make([]float64, maxLag)
+	if variance == 0 {
+		return acf
+	}
+	for lag := 1; lag <= maxLag && lag < len(returns); lag++ {
+		cov := 0.0
+		for i := lag; i < len(returns); i++ {
+			cov += (returns[i] - mean) * (returns[i-lag] - mean)
+		}
+		acf[lag-1] = cov / variance
+	}
+	return acf
+}
+
+// Ljung-Box 检验: Q = n(n+2) * sum(rho_k^2 / (n-k))，原假设（无自相关）下服从自由度为 lags 的卡方分布
+// 返回统计量和p值，p值越小越说明存在自相关
+func ljungBox(returns []float64, lags int) (float64, float64) {
+	n := float64(len(returns))
+	acf := autocorrelation(returns, lags)
+	stat := 0.0
+	for k, rho := range acf {
+		stat += rho * rho / (n - float64(k+1))
+	}
+	stat *= n * (n + 2)
+	return stat, 1 - chiSquaredCDF(stat, lags)
+}
+
+// 卡方分布的累积分布函数 P(X <= x)，自由度为 k
+func chiSquaredCDF(x float64, k int) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return regularizedGammaP(float64(k)/2, x/2)
+}
+
+// 正则化下不完全伽马函数 P(a, x)
+// x < a+1 时用级数展开，否则用连分式计算 Q(a, x) 再取 1-Q，两种方法在各自区间内收敛都很快
+func regularizedGammaP(a, x float64) float64 {
+	const (
+		maxIterations = 1000
+		epsilon       = 1e-14
+	)
+	lgammaA, _ := math.Lgamma(a)
+	prefix := math.Exp(a*math.Log(x) - x - lgammaA)
+
+	if x < a+1 {
+		term := 1 / a
+		sum := term
+		for n := 1; n < maxIterations; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*epsilon {
+				break
+			}
+		}
+		return sum * prefix
+	}
+
+	// Lentz 算法计算连分式
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < maxIterations; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return 1 - prefix*h
+}
+
+// 空切片返回0，避免 0/0 得到 NaN
+func calculateMean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0.0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// 控制台表格的输出格式，由 -format 指定
+var outputFormat = "text"
+
+// 设置 -format：text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）
+func setOutputFormat(name string) error {
+	switch name {
+	case "text", "tsv", "markdown":
+		outputFormat = name
+		return nil
+	}
+	return fmt.Errorf("未知的输出格式: %s（可选 text、tsv 或 markdown）", name)
+}
+
+// 控制台报告中的表格，所有表格都经 Render 按 -format 输出，不再手工用 \t 对齐
+type Table struct {
+	header []string
+	rows   [][]string
+}
+
+func newTable(header ...string) *Table {
+	return &Table{header: header}
+}
+
+func (t *Table) Add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// 按 outputFormat 输出表格
+func (t *Table) Render(w io.Writer) error {
+	switch outputFormat {
+	case "tsv":
+		return t.renderTSV(w)
+	case "markdown":
+		return t.renderMarkdown(w)
+	}
+	return t.renderText(w)
+}
+
+// 输出到标准输出，与 fmt.Printf 一样忽略写入错误
+func (t *Table) Print() {
+	t.Render(os.Stdout)
+}
+
+// 按终端显示宽度对齐；text/tabwriter 按字符数计算宽度，中文表头和说明会错位，所以这里自己补空格
+func (t *Table) renderText(w io.Writer) error {
+	widths := make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = displayWidth(h)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i < len(widths) && displayWidth(cell) > widths[i] {
+				widths[i] = displayWidth(cell)
+			}
+		}
+	}
+
+	// 列之间空两格，最后一列不补空格
+	writeRow := func(cells []string) error {
+		var b strings.Builder
+		for i, cell := range cells {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(cell)
+			if i < len(cells)-1 && i < len(widths) {
+				b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)))
+			}
+		}
+		b.WriteString("\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(widths))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderTSV(w io.Writer) error {
+	// 单元格里的制表符和换行会打乱列，换成空格
+	clean := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = clean.Replace(cell)
+		}
+		_, err := io.WriteString(w, strings.Join(escaped, "\t")+"\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderMarkdown(w io.Writer) error {
+	clean := strings.NewReplacer("|", `\|`, "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		// 列数不足的行补空单元格，GitHub 要求每行列数与标题一致
+		escaped := make([]string, len(t.header))
+		for i := range escaped {
+			if i < len(cells) {
+				escaped[i] = clean.Replace(cells[i])
+			}
+		}
+		_, err := io.WriteString(w, "| "+strings.Join(escaped, " | ")+" |\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(t.header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 终端显示宽度：中日韩字符和全角符号占两列
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r >= 0x1100 && (r <= 0x115f || (r >= 0x2e80 && r <= 0xa4cf) || (r >= 0xac00 && r <= 0xd7a3) ||
+			(r >= 0xf900 && r <= 0xfaff) || (r >= 0xfe30 && r <= 0xfe4f) || (r >= 0xff00 && r <= 0xff60) || (r >= 0xffe0 && r <= 0xffe6)) {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// 普通最小二乘回归 y = slope*x + intercept，同时返回拟合优度 R²
+// 长度不一致、样本少于2个或 x 没有变化（无法确定斜率）时返回错误
+func ols(x, y []float64) (float64, float64, float64, error) {
+	if len(x) != len(y) {
+		return 0, 0, 0, fmt.Errorf("回归数据长度不一致: x %d 个，y %d 个", len(x), len(y))
+	}
+	if len(x) < 2 {
+		return 0, 0, 0, fmt.Errorf("回归至少需要 2 个样本，实际 %d 个", len(x))
+	}
+
+	n := float64(len(x))
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= n
+	meanY /= n
+
+	var sxx, syy, sxy float64
+	for i := range x {
+		dx := x[i] - meanX
+		dy := y[i] - meanY
+		sxx += dx * dx
+		syy += dy * dy
+		sxy += dx * dy
+	}
+	if sxx == 0 {
+		return 0, 0, 0, fmt.Errorf("x 的方差为 0，无法回归")
+	}
+
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+	// y 没有变化时直线完全拟合
+	r2 := 1.0
+	if syy > 0 {
+		r2 = sxy * sxy / (sxx * syy)
+	}
+	return slope, intercept, r2, nil
+}
+
+// R/S 分析使用的最小子区间长度，更短的子区间 R/S 偏差太大
+const hurstMinChunk = 16
+
+// H 与 0.5 相差不超过该值时视为随机游走：R/S 估计在有限样本下有偏差，不能只看大于还是小于 0.5
+const hurstNeutralBand = 0.05
+
+// 用重标极差（R/S）分析估计价格序列的 Hurst 指数
+// 先取对数收益率，子区间长度从 hurstMinChunk 起每次翻倍，直到收益率个数的 1/4；每个长度把序列切成不重叠的子区间，
+// 子区间内的累计离差极差 R 除以标准差 S，取平均后对长度做 log-log 回归，斜率即 H，同时返回拟合优度 R²
+// H>0.5 表示趋势（涨跌倾向于延续），H<0.5 表示均值回归，H≈0.5 为随机游走；数据不足时返回错误
+func hurstExponent(prices []float64) (float64, float64, error) {
+	returns := make([]float64, 0, len(prices))
+	for i := 1; i < len(prices); i++ {
+		if prices[i-1] > 0 && prices[i] > 0 {
+			returns = append(returns, math.Log(prices[i]/prices[i-1]))
+		}
+	}
+
+	var logSizes, logRS []float64
+	for size := hurstMinChunk; size <= len(returns)/4; size *= 2 {
+		total, chunks := 0.0, 0
+		for start := 0; start+size <= len(returns); start += size {
+			if rs, ok := rescaledRange(returns[start : start+size]); ok {
+				total += rs
+				chunks++
+			}
+		}
+		if chunks > 0 {
+			logSizes = append(logSizes, math.Log(float64(size)))
+			logRS = append(logRS, math.Log(total/float64(chunks)))
+		}
+	}
+	if len(logSizes) < 3 {
+		return 0, 0, fmt.Errorf("数据不足，计算 Hurst 指数需要至少 %d 个收益率，实际 %d 个", hurstMinChunk*4*4, len(returns))
+	}
+	h, _, r2, err := ols(logSizes, logRS)
+	return h, r2, err
+}
+
+// 一个子区间的 R/S：累计离差的极差除以（总体）标准差，标准差为 0 时无法计算
+func rescaledRange(values []float64) (float64, bool) {
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	var cumulative, minCum, maxCum, sumSq float64
+	for _, v := range values {
+		d := v - mean
+		cumulative += d
+		if cumulative < minCum {
+			minCum = cumulative
+		}
+		if cumulative > maxCum {
+			maxCum = cumulative
+		}
+		sumSq += d * d
+	}
+	s := math.Sqrt(sumSq / float64(len(values)))
+	if s == 0 {
+		return 0, false
+	}
+	return (maxCum - minCum) / s, true
+}
+
+// 按 Hurst 指数给出序列的类型
+func classifyHurst(h float64) string {
+	switch {
+	case h > 0.5+hurstNeutralBand:
+		return "趋势（涨跌倾向于延续）"
+	case h < 0.5-hurstNeutralBand:
+		return "均值回归（涨跌倾向于反转）"
+	}
+	return "接近随机游走"
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// 随机游走的1分钟收益率（价格的一阶差分）没有自相关，Ljung-Box 不拒绝原假设；
+// 带惯性的收益率（AR(1)，系数 0.8）和随机游走的重叠窗口收益率自相关很强，p值接近0
+func TestLjungBoxRandomWalkVsTrending(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	prices := make([]float64, 3000)
+	trending := make([]float64, 3000)
+	price, r := 2000.0, 0.0
+	for i := range prices {
+		price *= 1 + rng.NormFloat64()*0.001
+		prices[i] = price
+		r = 0.8*r + rng.NormFloat64()
+		trending[i] = r
+	}
+
+	returns := windowReturns(prices, 1, 1, "log")
+	if acf := autocorrelation(returns, 1)[0]; math.Abs(acf) > 0.1 {
+		t.Errorf("随机游走 lag1 自相关 = %.4f, want 接近0", acf)
+	}
+	if _, p := ljungBox(returns, 10); p < 0.01 {
+		t.Errorf("随机游走 p值 = %.4f, 不应拒绝无自相关的原假设", p)
+	}
+
+	if acf := autocorrelation(trending, 1)[0]; math.Abs(acf-0.8) > 0.05 {
+		t.Errorf("AR(1) lag1 自相关 = %.4f, want 约 0.8", acf)
+	}
+	if stat, p := ljungBox(trending, 10); p > 1e-6 {
+		t.Errorf("AR(1): Q = %.1f, p值 = %g, want 接近0", stat, p)
+	}
+
+	overlapping := windowReturns(prices, 60, 1, "log")
+	if _, p := ljungBox(overlapping, 10); p > 1e-6 {
+		t.Errorf("60 分钟重叠窗口 p值 = %g, want 接近0", p)
+	}
+}
+
+// 自由度为 2 的卡方分布 CDF 有解析解 1-exp(-x/2)；自由度为 1 时 P(X <= 3.841) 约为 0.95
+func TestChiSquaredCDF(t *testing.T) {
+	for _, x := range []float64{0.1, 1, 5, 20, 80} {
+		if got, want := chiSquaredCDF(x, 2), 1-math.Exp(-x/2); math.Abs(got-want) > 1e-9 {
+			t.Errorf("chiSquaredCDF(%v, 2) = %v, want %v", x, got, want)
+		}
+	}
+	if got := chiSquaredCDF(3.841458820694124, 1); math.Abs(got-0.95) > 1e-6 {
+		t.Errorf("chiSquaredCDF(3.84, 1) = %v, want 0.95", got)
+	}
+	if got := chiSquaredCDF(0, 10); got != 0 {
+		t.Errorf("chiSquaredCDF(0, 10) = %v", got)
+	}
+}
+
+// 收益率带惯性（AR(1) 系数 0.6）的价格序列 H 明显大于 0.5，收益率交替反转（系数 -0.6）的 H 明显小于 0.5；
+// 数据不足三个子区间长度时返回错误
+func TestHurstExponentTrendingVsMeanReverting(t *testing.T) {
+	series := func(phi float64, seed int64) []float64 {
+		rng := rand.New(rand.NewSource(seed))
+		prices := make([]float64, 4097)
+		price, r := 2000.0, 0.0
+		for i := range prices {
+			prices[i] = price
+			r = phi*r + rng.NormFloat64()*0.001
+			price *= math.Exp(r)
+		}
+		return prices
+	}
+
+	h, r2, err := hurstExponent(series(0.6, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h <= 0.5+hurstNeutralBand || r2 < 0.9 {
+		t.Errorf("趋势序列 H = %.4f (R² = %.4f), 期望 H > %.2f", h, r2, 0.5+hurstNeutralBand)
+	}
+	if got := classifyHurst(h); !strings.HasPrefix(got, "趋势") {
+		t.Errorf("趋势序列被归类为 %q", got)
+	}
+
+	h, r2, err = hurstExponent(series(-0.6, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h >= 0.5-hurstNeutralBand || r2 < 0.9 {
+		t.Errorf("均值回归序列 H = %.4f (R² = %.4f), 期望 H < %.2f", h, r2, 0.5-hurstNeutralBand)
+	}
+	if got := classifyHurst(h); !strings.HasPrefix(got, "均值回归") {
+		t.Errorf("均值回归序列被归类为 %q", got)
+	}
+
+	if _, _, err := hurstExponent(series(0, 3)[:hurstMinChunk*4*2]); err == nil {
+		t.Error("数据不足时应返回错误")
+	}
+}
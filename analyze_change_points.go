@@ -0,0 +1,986 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func main() {
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	priceModeName := flag.String("price-mode", "close", "价格取法: close、mid、typical 或 ohlc4")
+	since := flag.String("since", "", "起始时间（RFC3339 或 2006-01-02），默认从数据开头")
+	until := flag.String("until", "", "结束时间（不含），格式同 -since")
+	bar := flag.Int("bar", 60, "收益率的周期（分钟），按首尾相接不重叠的区间计算；1分钟收益率噪声太大，均值的变化很难看出")
+	threshold := flag.Float64("threshold", 5, "CUSUM 的报警阈值 h（以标准差为单位），越大越不容易误报，发现变化也越晚")
+	drift := flag.Float64("drift", 0.5, "CUSUM 的容许偏移 k（以标准差为单位），小于该值的偏离不累积；通常取要检测的变化幅度的一半")
+	target := flag.String("target", "both", "检测的对象: mean（收益率均值，即趋势）、volatility（|收益率| 的均值，即波动率）或 both")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、数据不足）时直接报错，而不是跳过继续")
+	tz := flag.String("tz", "UTC", "报告中时间的显示时区（IANA 时区名，如 Asia/Shanghai、Local）")
+	format := flag.String("format", "text", "报告中表格的格式: text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）")
+	flag.Parse()
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+	if *bar < 1 {
+		log.Fatalf("-bar 必须大于等于 1: %d", *bar)
+	}
+	if !(*threshold > 0) {
+		log.Fatalf("-threshold 必须大于 0: %v", *threshold)
+	}
+	if *drift < 0 {
+		log.Fatalf("-drift 不能为负数: %v", *drift)
+	}
+	if *target != "mean" && *target != "volatility" && *target != "both" {
+		log.Fatalf("未知的检测对象: %s（可选 mean、volatility 或 both）", *target)
+	}
+	if err := setOutputFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+	if err := setDisplayTimezone(*tz); err != nil {
+		log.Fatal(err)
+	}
+	priceMode, err := parsePriceMode(*priceModeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("正在读取数据...")
+
+	klines, _, err := loadKlines(inputPath(klinesFileName("minute_klines")), *strict)
+	if err != nil {
+		log.Fatal("读取价格数据失败:", err)
+	}
+	start, end := 0, len(klines)
+	if *since != "" || *until != "" {
+		start, end, err = selectTimeRange(klines, *since, *until)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	klines = klines[start:end]
+	prices := klinePrices(klines, priceMode)
+	fmt.Printf("共读取 %d 条数据\n", len(prices))
+
+	returns, times := barReturns(klines, prices, *bar, *returnMode)
+	if len(returns) < 2 {
+		if *strict {
+			log.Fatalf("数据不足，只有 %d 个 %d 分钟收益率（-strict）", len(returns), *bar)
+		}
+		fmt.Printf("数据不足，只有 %d 个 %d 分钟收益率\n", len(returns), *bar)
+		return
+	}
+	fmt.Printf("%d 个 %d 分钟收益率，CUSUM 阈值 h=%.2f，容许偏移 k=%.2f（标准差）\n", len(returns), *bar, *threshold, *drift)
+
+	if *target == "mean" || *target == "both" {
+		fmt.Printf("\n收益率均值（趋势）的变点:\n")
+		printChangePoints(returns, times, cusum(returns, *threshold, *drift), "均值%")
+	}
+	if *target == "volatility" || *target == "both" {
+		// 波动率变化表现为 |收益率| 的均值变化，对 |r| 做同样的 CUSUM
+		absReturns := make([]float64, len(returns))
+		for i, r := range returns {
+			absReturns[i] = math.Abs(r)
+		}
+		fmt.Printf("\n波动率（|收益率| 的均值）的变点:\n")
+		printChangePoints(absReturns, times, cusum(absReturns, *threshold, *drift), "平均|收益率|%")
+	}
+}
+
+// 首尾相接、不重叠的 bar 分钟收益率，times[i] 为第 i 个收益率区间开始的时间
+func barReturns(klines []Kline, prices []float64, bar int, returnMode string) ([]float64, []string) {
+	var returns []float64
+	var times []string
+	for i := bar; i < len(prices); i += bar {
+		returns = append(returns, calculateReturn(prices[i-bar], prices[i], returnMode))
+		times = append(times, klines[i-bar].Time)
+	}
+	return returns, times
+}
+
+// 每个状态开始时只用来估计均值、不参与累积的值的个数
+const cusumWarmup = 30
+
+// 双边 CUSUM 变点检测，返回各变点的下标（新状态的第一个值），按时间顺序。
+// 偏离按整个序列的标准差归一，threshold（h）和 drift（k）都以标准差为单位：
+//
+//	S+ = max(0, S+ + (x - mu)/sigma - k)，S- = max(0, S- - (x - mu)/sigma - k)
+//
+// mu 为当前状态（上一个变点之后）已有值的均值，前 cusumWarmup 个值只用来估计 mu。任一侧超过 h 时报警，变点取该侧最后一次为 0 之后的位置，
+// 比报警位置更接近真正开始变化的地方；之后从变点重新估计 mu，两侧清零继续检测。NaN 跳过
+func cusum(returns []float64, threshold, drift float64) []int {
+	sigma := nanStdDev(returns)
+	if !(sigma > 0) {
+		return nil
+	}
+
+	var changePoints []int
+	segmentStart := 0
+	var sum float64 // 当前状态中已有值的和
+	var count int
+	var pos, neg float64         // S+ 和 S-
+	posStart, negStart := -1, -1 // 两侧最后一次为 0 之后的第一个下标
+
+	for i := 0; i < len(returns); i++ {
+		x := returns[i]
+		if math.IsNaN(x) {
+			continue
+		}
+		if count < cusumWarmup {
+			// 新状态的前几个值只用来估计均值，均值估得不准时偏离会被放大，误报明显增多
+			sum += x
+			count++
+			continue
+		}
+		deviation := (x - sum/float64(count)) / sigma
+		sum += x
+		count++
+
+		if pos == 0 {
+			posStart = i
+		}
+		if neg == 0 {
+			negStart = i
+		}
+		pos = math.Max(0, pos+deviation-drift)
+		neg = math.Max(0, neg-deviation-drift)
+		if pos <= threshold && neg <= threshold {
+			continue
+		}
+
+		changePoint := posStart
+		if neg > threshold {
+			changePoint = negStart
+		}
+		if changePoint <= segmentStart {
+			changePoint = segmentStart + 1
+		}
+		changePoints = append(changePoints, changePoint)
+
+		// 从变点开始重新估计新状态的均值
+		segmentStart = changePoint
+		sum, count = 0, 0
+		for _, v := range returns[changePoint : i+1] {
+			if !math.IsNaN(v) {
+				sum += v
+				count++
+			}
+		}
+		pos, neg = 0, 0
+	}
+	return changePoints
+}
+
+// 跳过 NaN 的样本标准差，样本少于 2 个时返回 0
+func nanStdDev(values []float64) float64 {
+	var n int
+	var mean, m2 float64
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		n++
+		delta := v - mean
+		mean += delta / float64(n)
+		m2 += delta * (v - mean)
+	}
+	if n < 2 {
+		return 0
+	}
+	return math.Sqrt(m2 / float64(n-1))
+}
+
+// 跳过 NaN 的均值，没有有效值时返回 NaN
+func nanMean(values []float64) float64 {
+	var sum float64
+	var n int
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return sum / float64(n)
+}
+
+// 输出变点：时间、变化方向，以及变点前后两段（到上一个/下一个变点为止）的均值
+func printChangePoints(values []float64, times []string, changePoints []int, label string) {
+	if len(changePoints) == 0 {
+		fmt.Println("未检测到变点")
+		return
+	}
+	table := newTable("序号", "变点时间", "方向", "之前"+label, "之后"+label, "之前区间数", "之后区间数")
+	for n, cp := range changePoints {
+		prev := 0
+		if n > 0 {
+			prev = changePoints[n-1]
+		}
+		next := len(values)
+		if n+1 < len(changePoints) {
+			next = changePoints[n+1]
+		}
+		before, after := nanMean(values[prev:cp]), nanMean(values[cp:next])
+		direction := "上升"
+		if after < before {
+			direction = "下降"
+		}
+		table.Add(strconv.Itoa(n+1), formatTimestamp(times[cp]), direction, fmt.Sprintf("%.4f", before), fmt.Sprintf("%.4f", after),
+			strconv.Itoa(cp-prev), strconv.Itoa(next-cp))
+	}
+	table.Print()
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 价格序列的取法，默认用收盘价
+type PriceMode int
+
+const (
+	PriceClose   PriceMode = iota // 收盘价 C
+	PriceMid                      // 中间价 (H+L)/2
+	PriceTypical                  // 典型价 (H+L+C)/3
+	PriceOHLC4                    // (O+H+L+C)/4
+)
+
+func parsePriceMode(name string) (PriceMode, error) {
+	switch name {
+	case "close":
+		return PriceClose, nil
+	case "mid":
+		return PriceMid, nil
+	case "typical":
+		return PriceTypical, nil
+	case "ohlc4":
+		return PriceOHLC4, nil
+	}
+	return PriceClose, fmt.Errorf("未知的价格取法: %s（可选 close、mid、typical 或 ohlc4）", name)
+}
+
+// 按 mode 取一根K线的价格
+func (k Kline) Price(mode PriceMode) float64 {
+	switch mode {
+	case PriceMid:
+		return (k.High + k.Low) / 2
+	case PriceTypical:
+		return (k.High + k.Low + k.Close) / 3
+	case PriceOHLC4:
+		return (k.Open + k.High + k.Low + k.Close) / 4
+	}
+	return k.Close
+}
+
+// 按 mode 取出整段K线的价格序列
+func klinePrices(klines []Kline, mode PriceMode) []float64 {
+	prices := make([]float64, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Price(mode)
+	}
+	return prices
+}
+
+// 解析 -since/-until 参数，支持 RFC3339（如 2024-01-02T15:04:05Z）或日期（如 2024-01-02，按 UTC 零点）
+func parseTimeFlag(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间 %q，请使用 RFC3339（如 2024-01-02T15:04:05Z）或日期（如 2024-01-02）", value)
+}
+
+// 按开盘时间选出 [since, until) 范围内的1分钟K线，返回下标范围 [start, end)
+// since 或 until 为空表示该端不限制；指定的时间必须落在数据覆盖的范围内
+func selectTimeRange(klines []Kline, since, until string) (int, int, error) {
+	if len(klines) == 0 {
+		return 0, 0, fmt.Errorf("没有K线数据")
+	}
+	first := time.UnixMilli(klines[0].OpenTime).UTC()
+	last := time.UnixMilli(klines[len(klines)-1].OpenTime).UTC().Add(time.Minute)
+
+	sinceTime, untilTime := first, last
+	var err error
+	if since != "" {
+		if sinceTime, err = parseTimeFlag(since); err != nil {
+			return 0, 0, err
+		}
+	}
+	if until != "" {
+		if untilTime, err = parseTimeFlag(until); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if !sinceTime.Before(untilTime) {
+		return 0, 0, fmt.Errorf("-since (%s) 必须早于 -until (%s)", sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339))
+	}
+	if sinceTime.Before(first) || untilTime.After(last) {
+		return 0, 0, fmt.Errorf("时间范围 %s 到 %s 超出了数据覆盖的范围 %s 到 %s",
+			sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339), first.Format(time.RFC3339), last.Format(time.RFC3339))
+	}
+
+	start := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= sinceTime.UnixMilli() })
+	end := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= untilTime.UnixMilli() })
+	if start >= end {
+		return 0, 0, fmt.Errorf("时间范围 %s 到 %s 内没有数据", sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339))
+	}
+	return start, end, nil
+}
+
+// 控制台表格的输出格式，由 -format 指定
+var outputFormat = "text"
+
+// 设置 -format：text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）
+func setOutputFormat(name string) error {
+	switch name {
+	case "text", "tsv", "markdown":
+		outputFormat = name
+		return nil
+	}
+	return fmt.Errorf("未知的输出格式: %s（可选 text、tsv 或 markdown）", name)
+}
+
+// 控制台报告中的表格，所有表格都经 Render 按 -format 输出，不再手工用 \t 对齐
+type Table struct {
+	header []string
+	rows   [][]string
+}
+
+func newTable(header ...string) *Table {
+	return &Table{header: header}
+}
+
+func (t *Table) Add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// 按 outputFormat 输出表格
+func (t *Table) Render(w io.Writer) error {
+	switch outputFormat {
+	case "tsv":
+		return t.renderTSV(w)
+	case "markdown":
+		return t.renderMarkdown(w)
+	}
+	return t.renderText(w)
+}
+
+// 输出到标准输出，与 fmt.Printf 一样忽略写入错误
+func (t *Table) Print() {
+	t.Render(os.Stdout)
+}
+
+// 按终端显示宽度对齐；text/tabwriter 按字符数计算宽度，中文表头和说明会错位，所以这里自己补空格
+func (t *Table) renderText(w io.Writer) error {
+	widths := make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = displayWidth(h)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i < len(widths) && displayWidth(cell) > widths[i] {
+				widths[i] = displayWidth(cell)
+			}
+		}
+	}
+
+	// 列之间空两格，最后一列不补空格
+	writeRow := func(cells []string) error {
+		var b strings.Builder
+		for i, cell := range cells {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(cell)
+			if i < len(cells)-1 && i < len(widths) {
+				b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)))
+			}
+		}
+		b.WriteString("\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(widths))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderTSV(w io.Writer) error {
+	// 单元格里的制表符和换行会打乱列，换成空格
+	clean := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = clean.Replace(cell)
+		}
+		_, err := io.WriteString(w, strings.Join(escaped, "\t")+"\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderMarkdown(w io.Writer) error {
+	clean := strings.NewReplacer("|", `\|`, "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		// 列数不足的行补空单元格，GitHub 要求每行列数与标题一致
+		escaped := make([]string, len(t.header))
+		for i := range escaped {
+			if i < len(cells) {
+				escaped[i] = clean.Replace(cells[i])
+			}
+		}
+		_, err := io.WriteString(w, "| "+strings.Join(escaped, " | ")+" |\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(t.header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 终端显示宽度：中日韩字符和全角符号占两列
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r >= 0x1100 && (r <= 0x115f || (r >= 0x2e80 && r <= 0xa4cf) || (r >= 0xac00 && r <= 0xd7a3) ||
+			(r >= 0xf900 && r <= 0xfaff) || (r >= 0xfe30 && r <= 0xfe4f) || (r >= 0xff00 && r <= 0xff60) || (r >= 0xffe0 && r <= 0xffe6)) {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// CSV 中 Open Time (UTC) 列的时间格式
+const timestampLayout = "2006-01-02 15:04:05"
+
+// 报告中显示时间使用的时区，由 -tz 指定，默认 UTC
+var displayLocation = time.UTC
+
+// 解析 -tz 参数（IANA 时区名，如 Asia/Shanghai，或 UTC、Local）
+func setDisplayTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("未知的时区: %s（请使用 IANA 时区名，如 Asia/Shanghai、UTC、Local）", name)
+	}
+	displayLocation = loc
+	return nil
+}
+
+// 解析CSV中的时间字符串，CSV中的时间都是 UTC
+func parseTimestamp(value string) (time.Time, error) {
+	return time.ParseInLocation(timestampLayout, value, time.UTC)
+}
+
+// 把CSV中的时间转换到 -tz 时区显示，并带上时区缩写；无法解析时原样返回
+func formatTimestamp(value string) string {
+	t, err := parseTimestamp(value)
+	if err != nil {
+		return value
+	}
+	return t.In(displayLocation).Format(timestampLayout + " MST")
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// 收益率均值在中途阶跃（上升或下降）时，CUSUM 在真实位置附近报告一个变点，阶跃之前没有误报；
+// 没有变化的序列不报告变点，常数序列（标准差为 0）返回 nil；h=5 时几百个值中就会有一次误报，这里取 h=8
+func TestCUSUMFindsMeanStep(t *testing.T) {
+	const n, step = 500, 300
+	for _, tc := range []struct {
+		name  string
+		shift float64
+	}{
+		{"均值上升", 0.1},
+		{"均值下降", -0.1},
+	} {
+		rng := rand.New(rand.NewSource(1168))
+		returns := make([]float64, n)
+		for i := range returns {
+			returns[i] = rng.NormFloat64() * 0.1
+			if i >= step {
+				returns[i] += tc.shift
+			}
+		}
+		returns[100] = math.NaN()
+
+		changePoints := cusum(returns, 8, 0.5)
+		if len(changePoints) == 0 {
+			t.Fatalf("%s: 没有发现变点", tc.name)
+		}
+		if cp := changePoints[0]; cp < step-10 || cp > step+20 {
+			t.Errorf("%s: 第一个变点在 %d, want %d 附近（全部 %v）", tc.name, cp, step, changePoints)
+		}
+		for i := 1; i < len(changePoints); i++ {
+			if changePoints[i] <= changePoints[i-1] {
+				t.Errorf("%s: 变点没有按时间顺序: %v", tc.name, changePoints)
+			}
+		}
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	flat := make([]float64, n)
+	for i := range flat {
+		flat[i] = rng.NormFloat64() * 0.1
+	}
+	if changePoints := cusum(flat, 8, 0.5); len(changePoints) != 0 {
+		t.Errorf("没有变化的序列报告了变点 %v", changePoints)
+	}
+	if changePoints := cusum([]float64{1, 1, 1, 1}, 5, 0.5); changePoints != nil {
+		t.Errorf("常数序列: %v", changePoints)
+	}
+}
+
+// 不重叠的 bar 分钟收益率，时间为每个区间开始的K线时间
+func TestBarReturns(t *testing.T) {
+	prices := []float64{100, 101, 102, 103, 104, 105, 106}
+	klines := make([]Kline, len(prices))
+	for i := range klines {
+		klines[i] = Kline{Time: string(rune('a' + i)), Close: prices[i]}
+	}
+	returns, times := barReturns(klines, prices, 3, "simple")
+	if len(returns) != 2 || math.Abs(returns[0]-3) > 1e-9 || math.Abs(returns[1]-(106.0/103-1)*100) > 1e-9 {
+		t.Errorf("returns = %v", returns)
+	}
+	if len(times) != 2 || times[0] != "a" || times[1] != "d" {
+		t.Errorf("times = %v, want [a d]", times)
+	}
+}
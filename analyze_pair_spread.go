@@ -0,0 +1,893 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func main() {
+	symbolA := flag.String("a", "ETHUSDT", "交易对A（价差 = ln(A) - beta*ln(B)）")
+	symbolB := flag.String("b", "BTCUSDT", "交易对B")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	window := flag.Int("window", 1440, "价差滚动z-score的窗口（分钟）")
+	threshold := flag.Float64("threshold", 2, "价差z-score超过该阈值时提示均值回归信号")
+	minGap := flag.Int("min-gap", 10, "两次突破阈值之间间隔少于该分钟数时合并为同一个事件（相邻的分钟总是合并）")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	flag.IntVar(&csvPrecision, "precision", -1, "CSV中浮点列的小数位数，-1 表示使用各列默认位数（默认价格保留原始精度，比值和价差 8 位，z-score 4 位）")
+	windowsFlag := flag.String("windows", "", "计算 beta 的收益率窗口（分钟，逗号分隔），默认 1,5,15,30,60,120,240,1440,2880,4320,10080")
+	tz := flag.String("tz", "UTC", "报告中时间的显示时区（IANA 时区名，如 Asia/Shanghai、Local），CSV 中的时间始终为 UTC")
+	flag.Parse()
+	if err := setDisplayTimezone(*tz); err != nil {
+		log.Fatal(err)
+	}
+	windows, err := parseWindows(*windowsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *window < 2 {
+		log.Fatalf("-window 必须大于等于 2: %d", *window)
+	}
+	if *threshold <= 0 {
+		log.Fatalf("-threshold 必须大于 0: %v", *threshold)
+	}
+	if *minGap < 0 {
+		log.Fatalf("-min-gap 不能为负数: %d", *minGap)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
+	fmt.Println("正在读取数据...")
+
+	klinesA, _, err := loadKlines(inputPath(*symbolA+"_minute_klines.csv"), *strict)
+	if err != nil {
+		log.Fatalf("读取 %s 价格数据失败: %v", *symbolA, err)
+	}
+	klinesB, _, err := loadKlines(inputPath(*symbolB+"_minute_klines.csv"), *strict)
+	if err != nil {
+		log.Fatalf("读取 %s 价格数据失败: %v", *symbolB, err)
+	}
+
+	pairs := alignKlines(klinesA, klinesB)
+	fmt.Printf("%s %d 条，%s %d 条，按时间对齐后 %d 条\n", *symbolA, len(klinesA), *symbolB, len(klinesB), len(pairs))
+	if len(pairs) <= *window {
+		log.Fatalf("对齐后的数据只有 %d 条，少于窗口 %d 分钟", len(pairs), *window)
+	}
+
+	logA := make([]float64, len(pairs))
+	logB := make([]float64, len(pairs))
+	for i, p := range pairs {
+		logA[i] = math.Log(p.PriceA)
+		logB[i] = math.Log(p.PriceB)
+	}
+
+	// 对冲比例 beta：ln(A) 对 ln(B) 做最小二乘回归的斜率
+	beta, intercept, r2, err := ols(logB, logA)
+	if err != nil {
+		log.Fatal("计算对冲比例失败:", err)
+	}
+
+	returnsA := make([]float64, 0, len(pairs)-1)
+	returnsB := make([]float64, 0, len(pairs)-1)
+	for i := 1; i < len(pairs); i++ {
+		returnsA = append(returnsA, calculateReturn(pairs[i-1].PriceA, pairs[i].PriceA, "log"))
+		returnsB = append(returnsB, calculateReturn(pairs[i-1].PriceB, pairs[i].PriceB, "log"))
+	}
+
+	spreads := make([]float64, len(pairs))
+	for i := range pairs {
+		spreads[i] = logA[i] - beta*logB[i]
+	}
+	zscores := rollingZScores(spreads, *window)
+
+	fmt.Printf("\n对冲比例 beta = %.4f（截距 %.4f，R² %.4f）\n", beta, intercept, r2)
+	fmt.Printf("分钟收益率相关系数: %.4f\n", correlation(returnsA, returnsB))
+	fmt.Printf("价差均值 %.6f，标准差 %.6f\n", calculateMean(spreads), calculateStdDev(spreads, calculateMean(spreads)))
+
+	// 收益率 beta：A 的窗口收益率对 B 的窗口收益率回归，衡量 B 涨跌 1% 时 A 平均涨跌多少
+	// 与上面基于价格水平的对冲比例不同，不要求两者协整
+	fmt.Printf("\n%s 相对 %s 的 beta（重叠窗口的对数收益率）:\n", *symbolA, *symbolB)
+	for _, w := range windows {
+		if w >= len(pairs)/2 {
+			continue
+		}
+		assetReturns := make([]float64, 0, len(pairs)-w)
+		benchReturns := make([]float64, 0, len(pairs)-w)
+		for i := w; i < len(pairs); i++ {
+			assetReturns = append(assetReturns, calculateReturn(pairs[i-w].PriceA, pairs[i].PriceA, "log"))
+			benchReturns = append(benchReturns, calculateReturn(pairs[i-w].PriceB, pairs[i].PriceB, "log"))
+		}
+		beta := computeBeta(assetReturns, benchReturns)
+		if math.IsNaN(beta) {
+			fmt.Printf("%d 分钟: %s 收益率没有变化，无法计算 beta\n", w, *symbolB)
+			continue
+		}
+		fmt.Printf("%d 分钟: beta = %.4f\n", w, beta)
+	}
+
+	// 保存价差序列
+	err = writeFileAtomic(outputPath("pair_spread.csv"), func(w io.Writer) error {
+		if err := writeSchemaLine(w, pairSpreadSchemaVersion); err != nil {
+			return err
+		}
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Time", "Price_A", "Price_B", "Ratio", "Spread", "ZScore"})
+		for i, p := range pairs {
+			writer.Write([]string{
+				p.Time,
+				formatFloat(p.PriceA, -1),
+				formatFloat(p.PriceB, -1),
+				formatFloat(p.PriceA/p.PriceB, 8),
+				formatFloat(spreads[i], 8),
+				formatFloat(zscores[i], 4),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		log.Fatal("写入价差数据失败:", err)
+	}
+	fmt.Println("价差数据已保存到:", outputPath("pair_spread.csv"))
+
+	// 价差偏离越大越可能回归: z >= threshold 做空价差（卖A买B），z <= -threshold 做多价差（买A卖B）
+	fmt.Printf("\n价差 %d 分钟滚动z-score超过 ±%.2f 的事件:\n", *window, *threshold)
+	events := append(scanExtremes(zscores, *threshold, 1, *minGap), scanExtremes(zscores, *threshold, -1, *minGap)...)
+	if len(events) == 0 {
+		fmt.Println("无")
+	}
+	for _, e := range events {
+		action := "做空价差（卖A买B）"
+		if e.PeakZ < 0 {
+			action = "做多价差（买A卖B）"
+		}
+		fmt.Printf("%s ~ %s  峰值 z=%.2f  %s\n", formatTimestamp(pairs[e.Start].Time), formatTimestamp(pairs[e.End].Time), e.PeakZ, action)
+	}
+
+	last := len(pairs) - 1
+	fmt.Printf("\n最新 %s: 比值 %.6f，价差 %.6f，z-score %.2f\n", formatTimestamp(pairs[last].Time), pairs[last].PriceA/pairs[last].PriceB, spreads[last], zscores[last])
+	switch {
+	case zscores[last] >= *threshold:
+		fmt.Println("信号: 做空价差（卖A买B）")
+	case zscores[last] <= -*threshold:
+		fmt.Println("信号: 做多价差（买A卖B）")
+	default:
+		fmt.Println("信号: 无")
+	}
+}
+
+const pairSpreadSchemaVersion = 1
+
+// 按开盘时间对齐后的两个交易对的收盘价
+type PairPoint struct {
+	Time   string
+	PriceA float64
+	PriceB float64
+}
+
+// 只保留两边都有数据的分钟，任何一边缺失的K线直接丢弃
+func alignKlines(klinesA, klinesB []Kline) []PairPoint {
+	pricesB := make(map[int64]float64, len(klinesB))
+	for _, k := range klinesB {
+		pricesB[k.OpenTime] = k.Close
+	}
+	pairs := make([]PairPoint, 0, len(klinesA))
+	for _, k := range klinesA {
+		if priceB, ok := pricesB[k.OpenTime]; ok {
+			pairs = append(pairs, PairPoint{Time: k.Time, PriceA: k.Close, PriceB: priceB})
+		}
+	}
+	return pairs
+}
+
+// 皮尔逊相关系数
+func correlation(x, y []float64) float64 {
+	meanX := calculateMean(x)
+	meanY := calculateMean(y)
+	var sxx, syy, sxy float64
+	for i := range x {
+		sxx += (x[i] - meanX) * (x[i] - meanX)
+		syy += (y[i] - meanY) * (y[i] - meanY)
+		sxy += (x[i] - meanX) * (y[i] - meanY)
+	}
+	if sxx == 0 || syy == 0 {
+		return 0
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// 滚动z-score: 第 i 个值相对前 window 个值（不含自身）的均值和标准差的偏离，不足一个窗口时为 NaN
+// 累加前先减去第一个值，价差的波动远小于其本身的量级，直接累加平方和会丢失精度
+func rollingZScores(values []float64, window int) []float64 {
+	zscores := make([]float64, len(values))
+	if len(values) == 0 {
+		return zscores
+	}
+	offset := values[0]
+	var sum, sumSq float64
+	for i, value := range values {
+		v := value - offset
+		if i >= window {
+			n := float64(window)
+			mean := sum / n
+			variance := (sumSq - sum*mean) / (n - 1)
+			if variance > 0 {
+				zscores[i] = (v - mean) / math.Sqrt(variance)
+			} else {
+				zscores[i] = math.NaN()
+			}
+			old := values[i-window] - offset
+			sum -= old
+			sumSq -= old * old
+		} else {
+			zscores[i] = math.NaN()
+		}
+		sum += v
+		sumSq += v * v
+	}
+	return zscores
+}
+
+// 空切片返回0，避免 0/0 得到 NaN
+func calculateMean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0.0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func calculateStdDev(values []float64, mean float64) float64 {
+	if len(values) <= 1 {
+		return 0.0
+	}
+	sumSqDiff := 0.0
+	for _, v := range values {
+		diff := v - mean
+		sumSqDiff += diff * diff
+	}
+	variance := sumSqDiff / float64(len(values)-1)
+	return math.Sqrt(variance)
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 写入版本行，必须在标题行之前调用
+func writeSchemaLine(w io.Writer, version int) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", schemaPrefix, version)
+	return err
+}
+
+// 一段连续突破阈值的行情，下标相对于传入的z-score序列
+type ExtremeEvent struct {
+	Start   int
+	End     int // 最后一个突破阈值的点（含）
+	PeakIdx int
+	PeakZ   float64
+}
+
+// 两个突破点之间有 gap 个未突破的点时是否属于同一个事件：间隔少于 minGap，或两点相邻
+func sameExtremeEvent(gap, minGap int) bool {
+	return gap == 0 || gap < minGap
+}
+
+// 把突破阈值的点合并成事件，避免一段持续的行情每分钟都报一次
+// sign 为 1 时找 z > threshold（暴涨），为 -1 时找 z < -threshold（暴跌）；NaN 视为未突破
+// 两个突破点之间未突破的点少于 minGap 时算作同一个事件；相邻的突破点总是同一个事件（minGap 为 0 时也是）
+func scanExtremes(zscores []float64, threshold float64, sign int, minGap int) []ExtremeEvent {
+	var events []ExtremeEvent
+	for i, z := range zscores {
+		if !(float64(sign)*z > threshold) {
+			continue
+		}
+
+		if n := len(events); n > 0 && sameExtremeEvent(i-events[n-1].End-1, minGap) {
+			event := &events[n-1]
+			event.End = i
+			if float64(sign)*z > float64(sign)*event.PeakZ {
+				event.PeakIdx = i
+				event.PeakZ = z
+			}
+			continue
+		}
+		events = append(events, ExtremeEvent{Start: i, End: i, PeakIdx: i, PeakZ: z})
+	}
+	return events
+}
+
+// beta = cov(asset, bench) / var(bench)，即 asset 收益率对 bench 收益率回归的斜率
+// 两个序列按下标对齐，任一方为 NaN 的样本跳过；有效样本少于 2 个或 bench 方差为 0 时返回 NaN
+func computeBeta(assetReturns, benchReturns []float64) float64 {
+	var asset, bench []float64
+	for i := range assetReturns {
+		if i >= len(benchReturns) || math.IsNaN(assetReturns[i]) || math.IsNaN(benchReturns[i]) {
+			continue
+		}
+		asset = append(asset, assetReturns[i])
+		bench = append(bench, benchReturns[i])
+	}
+	beta, _, _, err := ols(bench, asset)
+	if err != nil {
+		return math.NaN()
+	}
+	return beta
+}
+
+// 普通最小二乘回归 y = slope*x + intercept，同时返回拟合优度 R²
+// 长度不一致、样本少于2个或 x 没有变化（无法确定斜率）时返回错误
+func ols(x, y []float64) (float64, float64, float64, error) {
+	if len(x) != len(y) {
+		return 0, 0, 0, fmt.Errorf("回归数据长度不一致: x %d 个，y %d 个", len(x), len(y))
+	}
+	if len(x) < 2 {
+		return 0, 0, 0, fmt.Errorf("回归至少需要 2 个样本，实际 %d 个", len(x))
+	}
+
+	n := float64(len(x))
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= n
+	meanY /= n
+
+	var sxx, syy, sxy float64
+	for i := range x {
+		dx := x[i] - meanX
+		dy := y[i] - meanY
+		sxx += dx * dx
+		syy += dy * dy
+		sxy += dx * dy
+	}
+	if sxx == 0 {
+		return 0, 0, 0, fmt.Errorf("x 的方差为 0，无法回归")
+	}
+
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+	// y 没有变化时直线完全拟合
+	r2 := 1.0
+	if syy > 0 {
+		r2 = sxy * sxy / (sxx * syy)
+	}
+	return slope, intercept, r2, nil
+}
+
+// CSV 中浮点列的小数位数，由 -precision 指定；小于 0 时各列使用自己的默认位数
+var csvPrecision = -1
+
+// 按 -precision 格式化CSV中的浮点数，未指定时使用该列的默认位数
+func formatFloat(value float64, defaultPrecision int) string {
+	precision := defaultPrecision
+	if csvPrecision >= 0 {
+		precision = csvPrecision
+	}
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
+
+// CSV 中 Open Time (UTC) 列的时间格式
+const timestampLayout = "2006-01-02 15:04:05"
+
+// 报告中显示时间使用的时区，由 -tz 指定，默认 UTC
+var displayLocation = time.UTC
+
+// 解析 -tz 参数（IANA 时区名，如 Asia/Shanghai，或 UTC、Local）
+func setDisplayTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("未知的时区: %s（请使用 IANA 时区名，如 Asia/Shanghai、UTC、Local）", name)
+	}
+	displayLocation = loc
+	return nil
+}
+
+// 解析CSV中的时间字符串，CSV中的时间都是 UTC
+func parseTimestamp(value string) (time.Time, error) {
+	return time.ParseInLocation(timestampLayout, value, time.UTC)
+}
+
+// 把CSV中的时间转换到 -tz 时区显示，并带上时区缩写；无法解析时原样返回
+func formatTimestamp(value string) string {
+	t, err := parseTimestamp(value)
+	if err != nil {
+		return value
+	}
+	return t.In(displayLocation).Format(timestampLayout + " MST")
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// 协整的两个序列：ln(B) 为随机游走，ln(A) = 0.5 + 1.5*ln(B) + 平稳的 AR(1) 噪声
+// 回归得到的对冲比例接近 1.5，价差平稳且均值回归（一阶自回归系数明显小于1，频繁穿过均值）
+func TestCointegratedSpreadMeanReverts(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	const n = 5000
+	var klinesA, klinesB []Kline
+	logB, noise := math.Log(100), 0.0
+	for i := 0; i < n; i++ {
+		logB += rng.NormFloat64() * 0.002
+		noise = 0.5*noise + rng.NormFloat64()*0.001
+		openTime := int64(1767225600000) + int64(i)*60000
+		klinesA = append(klinesA, Kline{OpenTime: openTime, Close: math.Exp(0.5 + 1.5*logB + noise)})
+		if i%100 != 50 { // B 偶尔缺一根K线
+			klinesB = append(klinesB, Kline{OpenTime: openTime, Close: math.Exp(logB)})
+		}
+	}
+
+	pairs := alignKlines(klinesA, klinesB)
+	if len(pairs) != n-n/100 {
+		t.Fatalf("对齐后 %d 条, want %d", len(pairs), n-n/100)
+	}
+	lnA := make([]float64, len(pairs))
+	lnB := make([]float64, len(pairs))
+	for i, p := range pairs {
+		lnA[i] = math.Log(p.PriceA)
+		lnB[i] = math.Log(p.PriceB)
+	}
+	beta, _, r2, err := ols(lnB, lnA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(beta-1.5) > 0.02 || r2 < 0.99 {
+		t.Errorf("beta = %.4f, R² = %.4f, want 约 1.5, 接近1", beta, r2)
+	}
+
+	spreads := make([]float64, len(pairs))
+	for i := range pairs {
+		spreads[i] = lnA[i] - beta*lnB[i]
+	}
+	phi, _, _, err := ols(spreads[:len(spreads)-1], spreads[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phi > 0.7 {
+		t.Errorf("价差的一阶自回归系数 = %.4f, 应明显小于1（均值回归）", phi)
+	}
+	mean := calculateMean(spreads)
+	crossings := 0
+	for i := 1; i < len(spreads); i++ {
+		if (spreads[i]-mean)*(spreads[i-1]-mean) < 0 {
+			crossings++
+		}
+	}
+	if crossings < len(spreads)/10 {
+		t.Errorf("价差只穿过均值 %d 次", crossings)
+	}
+	if sd := calculateStdDev(spreads, mean); sd > 0.005 {
+		t.Errorf("价差标准差 = %.6f, want 与噪声同一量级", sd)
+	}
+
+	// 平稳价差的滚动z-score大多在 ±3 以内
+	zscores := rollingZScores(spreads, 240)
+	outside := 0
+	for _, z := range zscores[240:] {
+		if math.Abs(z) > 3 {
+			outside++
+		}
+	}
+	if outside > len(zscores)/100 {
+		t.Errorf("%d 个z-score超过 ±3", outside)
+	}
+}
+
+// 滚动z-score与逐个窗口直接计算的结果一致，价格量级很大时也不丢失精度
+func TestRollingZScoresMatchesDirect(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	values := make([]float64, 300)
+	for i := range values {
+		values[i] = 1e6 + rng.NormFloat64()*1e-3
+	}
+	const window = 20
+	zscores := rollingZScores(values, window)
+	for i, z := range zscores {
+		if i < window {
+			if !math.IsNaN(z) {
+				t.Fatalf("第 %d 个 = %v, 不足一个窗口应为 NaN", i, z)
+			}
+			continue
+		}
+		prev := values[i-window : i]
+		mean := calculateMean(prev)
+		want := (values[i] - mean) / calculateStdDev(prev, mean)
+		if math.Abs(z-want) > 1e-6 {
+			t.Errorf("第 %d 个 = %v, want %v", i, z, want)
+		}
+	}
+}
+
+// 资产收益率恰好是基准的 2 倍时 beta 为 2，加上与基准无关的噪声后仍约为 2；
+// 基准没有变化时返回 NaN，NaN 样本被跳过
+func TestComputeBeta(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	bench := make([]float64, 2000)
+	asset := make([]float64, len(bench))
+	noisy := make([]float64, len(bench))
+	for i := range bench {
+		bench[i] = rng.NormFloat64() * 0.1
+		asset[i] = 2 * bench[i]
+		noisy[i] = 2*bench[i] + rng.NormFloat64()*0.05
+	}
+	if beta := computeBeta(asset, bench); math.Abs(beta-2) > 1e-9 {
+		t.Errorf("2 倍基准的 beta = %v, want 2", beta)
+	}
+	if beta := computeBeta(noisy, bench); math.Abs(beta-2) > 0.05 {
+		t.Errorf("带噪声的 beta = %v, want 约 2", beta)
+	}
+
+	asset[10], bench[20] = math.NaN(), math.NaN()
+	if beta := computeBeta(asset, bench); math.Abs(beta-2) > 1e-9 {
+		t.Errorf("跳过 NaN 后 beta = %v, want 2", beta)
+	}
+	if beta := computeBeta([]float64{1, 2, 3}, []float64{0.5, 0.5, 0.5}); !math.IsNaN(beta) {
+		t.Errorf("基准方差为0时 beta = %v, want NaN", beta)
+	}
+	if beta := computeBeta([]float64{1}, []float64{0.5}); !math.IsNaN(beta) {
+		t.Errorf("只有一个样本时 beta = %v, want NaN", beta)
+	}
+}
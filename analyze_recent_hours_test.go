@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// 矩阵的一列中植入两次极端行情（第 500 分钟 z=-4.5，最新的第 2300 分钟 z=5）：
+// 上一次同等幅度的行情在 1800 分钟（30 小时）之前；紧挨着当前点的突破属于同一段行情，不算作“上一次”
+func TestLastComparableExtreme(t *testing.T) {
+	const n, first, current = 2301, 500, 2300
+	zscores := make([]float64, n)
+	for i := range zscores {
+		zscores[i] = math.Sin(float64(i)/13) * 1.5
+	}
+	for i := 0; i < 60; i++ {
+		zscores[i] = math.NaN() // 不够一个窗口长度
+	}
+	zscores[first] = -4.5
+	zscores[current] = 5
+	// 当前这段行情：前几分钟已经突破，间隔都小于 minGap
+	zscores[current-3], zscores[current-8] = 4.6, 4.8
+
+	prev := lastComparableExtreme(zscores, current, 4.5, 10)
+	if prev != first {
+		t.Fatalf("上一次同等幅度的行情在第 %d 分钟, want %d", prev, first)
+	}
+	if gap := current - prev; gap != 1800 {
+		t.Errorf("间隔 %d 分钟, want 1800（30 小时）", gap)
+	}
+
+	// 与当前这段行情间隔不小于 minGap 的突破算作另一次行情
+	zscores[current-19] = 4.7
+	if prev := lastComparableExtreme(zscores, current, 4.5, 10); prev != current-19 {
+		t.Errorf("间隔 11 分钟的突破: prev = %d, want %d", prev, current-19)
+	}
+	if prev := lastComparableExtreme(zscores, current, 4.5, 12); prev != first {
+		t.Errorf("minGap=12 时间隔 11 分钟的突破属于同一段行情: prev = %d, want %d", prev, first)
+	}
+
+	// minGap 为 0 时紧挨着的突破仍属于同一段行情，与 scanExtremes 的合并规则相同
+	zscores[current-1] = 4.6
+	if prev := lastComparableExtreme(zscores, current, 4.5, 0); prev != current-3 {
+		t.Errorf("minGap=0: prev = %d, want %d（跳过相邻的第 %d 分钟）", prev, current-3, current-1)
+	}
+
+	// 阈值高于之前所有的 |z| 时没有可比的行情
+	if prev := lastComparableExtreme(zscores, current, 4.9, 10); prev != -1 {
+		t.Errorf("没有同等幅度的行情时 prev = %d, want -1", prev)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// 双峰分布（30% 在 -2 附近，70% 在 3 附近）：Silverman 带宽下密度在细网格上积分约为1，
+// 最高点在样本较多的峰（众数）附近，两峰之间的密度很低
+func TestKDEBimodal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	returns := make([]float64, 4000)
+	for i := range returns {
+		if rng.Float64() < 0.3 {
+			returns[i] = -2 + rng.NormFloat64()*0.5
+		} else {
+			returns[i] = 3 + rng.NormFloat64()*0.5
+		}
+	}
+	bandwidth := silvermanBandwidth(returns)
+	if bandwidth <= 0 || bandwidth > 0.5 {
+		t.Fatalf("带宽 = %v", bandwidth)
+	}
+
+	const step = 0.01
+	var grid []float64
+	for x := -6.0; x <= 7; x += step {
+		grid = append(grid, x)
+	}
+	density := kde(returns, bandwidth, grid)
+
+	integral, peak := 0.0, 0
+	for i, d := range density {
+		integral += d * step
+		if d > density[peak] {
+			peak = i
+		}
+	}
+	if math.Abs(integral-1) > 0.01 {
+		t.Errorf("密度积分 = %v, want 约 1", integral)
+	}
+	if math.Abs(grid[peak]-3) > 0.2 {
+		t.Errorf("密度峰值在 %v, want 约 3", grid[peak])
+	}
+	valley := kde(returns, bandwidth, []float64{0.5})[0]
+	if valley > density[peak]/20 {
+		t.Errorf("两峰之间的密度 %v, 峰值 %v", valley, density[peak])
+	}
+}
+
+// 标准正态样本的 Silverman 带宽约为 0.9 * n^(-1/5)；样本不足或带宽无效时密度为0
+func TestSilvermanBandwidth(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	returns := make([]float64, 10000)
+	for i := range returns {
+		returns[i] = rng.NormFloat64()
+	}
+	want := 0.9 * math.Pow(10000, -0.2)
+	if got := silvermanBandwidth(returns); math.Abs(got-want) > 0.05*want {
+		t.Errorf("silvermanBandwidth = %v, want 约 %v", got, want)
+	}
+	if got := silvermanBandwidth([]float64{1}); got != 0 {
+		t.Errorf("单个样本的带宽 = %v, want 0", got)
+	}
+	for _, d := range kde(returns, 0, []float64{0, 1}) {
+		if d != 0 {
+			t.Errorf("带宽为0时密度 = %v, want 0", d)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// 构造一周的1分钟价格，UTC 14 点的收益率波动是其他小时的10倍
+func seasonalSeries(volatileHour int) ([]float64, []string) {
+	rng := rand.New(rand.NewSource(1))
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) // 周一
+	n := 7 * 24 * 60
+	prices := make([]float64, n)
+	timestamps := make([]string, n)
+	price := 2000.0
+	for i := 0; i < n; i++ {
+		t := start.Add(time.Duration(i) * time.Minute)
+		sigma := 0.0001
+		if t.Hour() == volatileHour {
+			sigma = 0.001
+		}
+		price *= 1 + rng.NormFloat64()*sigma
+		prices[i] = price
+		timestamps[i] = t.Format(timestampLayout)
+	}
+	return prices, timestamps
+}
+
+func mostVolatileHour(result SeasonalResult) int {
+	best := 0
+	for h, bucket := range result.Hourly {
+		if bucket.StdDev > result.Hourly[best].StdDev {
+			best = h
+		}
+	}
+	return best
+}
+
+func TestSeasonalStatsDetectsVolatileHour(t *testing.T) {
+	prices, timestamps := seasonalSeries(14)
+	result, skipped := seasonalStats(prices, timestamps, 0)
+	if skipped != 0 {
+		t.Fatalf("跳过了 %d 条", skipped)
+	}
+	if h := mostVolatileHour(result); h != 14 {
+		t.Fatalf("波动最大的小时 = %d, want 14", h)
+	}
+	// 其他小时的标准差都明显更小
+	for h, bucket := range result.Hourly {
+		if h != 14 && bucket.StdDev > result.Hourly[14].StdDev/3 {
+			t.Errorf("%d 点标准差 %.5f, 14 点 %.5f", h, bucket.StdDev, result.Hourly[14].StdDev)
+		}
+		if bucket.Count < 7*60-1 {
+			t.Errorf("%d 点只有 %d 条收益率", h, bucket.Count)
+		}
+	}
+	// 每个星期都包含一个高波动的小时，星期之间没有明显差异
+	for d, bucket := range result.Weekday {
+		if bucket.Count == 0 || math.Abs(bucket.StdDev-result.Weekday[1].StdDev) > result.Weekday[1].StdDev/3 {
+			t.Errorf("星期 %d: %+v, 星期一 %+v", d, bucket, result.Weekday[1])
+		}
+	}
+}
+
+// 交易日从 UTC 16:00 开始（UTC+8 零点）时，UTC 14 点是交易日的 22 点
+func TestSeasonalStatsDayBoundary(t *testing.T) {
+	prices, timestamps := seasonalSeries(14)
+	result, _ := seasonalStats(prices, timestamps, 16*time.Hour)
+	if h := mostVolatileHour(result); h != 22 {
+		t.Fatalf("波动最大的小时 = %d, want 22", h)
+	}
+}
+
+func TestSeasonalStatsSkipsBadTimestamps(t *testing.T) {
+	prices := []float64{100, 101, 102, 103}
+	timestamps := []string{"2024-01-01 00:00:00", "bad", "2024-01-01 00:02:00", "2024-01-01T00:03:00"}
+	result, skipped := seasonalStats(prices, timestamps, 0)
+	if skipped != 2 {
+		t.Errorf("skipped = %d, want 2", skipped)
+	}
+	if result.Hourly[0].Count != 1 {
+		t.Errorf("0 点收益率条数 = %d, want 1", result.Hourly[0].Count)
+	}
+}
+
+// 交易日在 -day-boundary 指定的时刻切换星期和小时，而不是在 UTC 零点：
+// 16h 时 UTC 周一 15:59 属于周一交易日的 23 点，16:00 属于周二交易日的 0 点，UTC 周一 23:59 仍是周二；
+// 2h 时 UTC 周二 01:59 还属于周一交易日
+func TestSeasonalStatsDayRollsAtBoundary(t *testing.T) {
+	for _, tc := range []struct {
+		boundary time.Duration
+		utc      string
+		weekday  time.Weekday
+		hour     int
+	}{
+		{0, "2024-01-01 23:59:00", time.Monday, 23},
+		{0, "2024-01-02 00:00:00", time.Tuesday, 0},
+		{16 * time.Hour, "2024-01-01 15:59:00", time.Monday, 23},
+		{16 * time.Hour, "2024-01-01 16:00:00", time.Tuesday, 0},
+		{16 * time.Hour, "2024-01-01 23:59:00", time.Tuesday, 7},
+		{2 * time.Hour, "2024-01-02 01:59:00", time.Monday, 23},
+		{2 * time.Hour, "2024-01-02 02:00:00", time.Tuesday, 0},
+	} {
+		// 只有一个收益率，落在 timestamps[1] 所在的桶
+		result, _ := seasonalStats([]float64{100, 101}, []string{"", tc.utc}, tc.boundary)
+		for d, bucket := range result.Weekday {
+			if want := btoi(time.Weekday(d) == tc.weekday); bucket.Count != want {
+				t.Errorf("-day-boundary %v, UTC %s: 星期 %d 有 %d 条, want %d", tc.boundary, tc.utc, d, bucket.Count, want)
+			}
+		}
+		for h, bucket := range result.Hourly {
+			if want := btoi(h == tc.hour); bucket.Count != want {
+				t.Errorf("-day-boundary %v, UTC %s: %d 点有 %d 条, want %d", tc.boundary, tc.utc, h, bucket.Count, want)
+			}
+		}
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,903 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func main() {
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log（需与 calculate_volatility 使用同一方式）")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	window := flag.Int("window", 60, "计算信号使用的z-score窗口（分钟）")
+	threshold := flag.Float64("threshold", 2, "开仓阈值: z <= -threshold 做多，z >= threshold 做空（均值回归）")
+	hold := flag.Int("hold", 60, "持仓K线数（分钟），到期按收盘价平仓")
+	kellyScale := flag.Float64("kelly-scale", 0.5, "建议仓位使用的 Kelly 比例（0.5 即半 Kelly），全 Kelly 对胜率和盈亏比的估计误差非常敏感")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	flag.IntVar(&csvPrecision, "precision", -1, "CSV中浮点列的小数位数，-1 表示使用各列默认位数（默认价格 2 位，z-score 4 位，百分比 6 位）")
+	flag.Parse()
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+	if *window < 1 || *hold < 1 {
+		log.Fatal("-window 和 -hold 必须大于等于 1")
+	}
+	if *threshold <= 0 {
+		log.Fatalf("-threshold 必须大于 0: %v", *threshold)
+	}
+	if *kellyScale <= 0 || *kellyScale > 1 {
+		log.Fatalf("-kelly-scale 必须在 (0, 1] 之间: %v", *kellyScale)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
+	fmt.Println("正在读取数据...")
+
+	klines, _, err := loadKlines(inputPath(klinesFileName("minute_klines")), *strict)
+	if err != nil {
+		log.Fatal("读取价格数据失败:", err)
+	}
+	prices := make([]float64, len(klines))
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Close
+		timestamps[i] = k.Time
+	}
+
+	volatilityData, err := loadVolatilityData(inputPath("multi_timeframe_volatility.csv"), *strict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	volData, exists := volatilityData[*window]
+	if !exists || volData.StdDev <= 0 {
+		log.Fatalf("波动率数据中没有 %d 分钟窗口", *window)
+	}
+
+	fmt.Printf("共读取 %d 条数据，窗口 %d 分钟，阈值 %.2f，持仓 %d 分钟\n\n", len(prices), *window, *threshold, *hold)
+
+	result := runBacktest(prices, *window, volData, *threshold, *hold, *returnMode)
+
+	// 保存每笔交易到CSV
+	err = writeFileAtomic(outputPath("backtest_trades.csv"), func(w io.Writer) error {
+		if err := writeSchemaLine(w, backtestSchemaVersion); err != nil {
+			return err
+		}
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Entry_Time", "Side", "Entry_ZScore", "Entry_Price", "Exit_Price", "Return_Pct", "MFE_Pct", "MAE_Pct"})
+		for _, t := range result.Trades {
+			writer.Write([]string{
+				timestamps[t.EntryIdx],
+				t.Side.String(),
+				formatFloat(t.EntryZScore, 4),
+				formatFloat(t.EntryPrice, 2),
+				formatFloat(t.ExitPrice, 2),
+				formatFloat(t.ReturnPct, 6),
+				formatFloat(t.MFEPct, 6),
+				formatFloat(t.MAEPct, 6),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		log.Fatal("保存结果失败:", err)
+	}
+
+	fmt.Printf("交易笔数: %d\n", len(result.Trades))
+	if len(result.Trades) == 0 {
+		return
+	}
+	fmt.Printf("胜率: %.2f%%\n", result.WinRate*100)
+	fmt.Printf("平均收益率: %.4f%%\n", result.AvgReturnPct)
+	fmt.Printf("平均盈利: %.4f%%，平均亏损: %.4f%%\n", result.AvgWinPct, result.AvgLossPct)
+	fmt.Printf("平均MFE（持仓期间最大浮盈）: %.4f%%，最大: %.4f%%\n", result.AvgMFEPct, result.MaxMFEPct)
+	fmt.Printf("平均MAE（持仓期间最大浮亏）: %.4f%%，最大: %.4f%%\n", result.AvgMAEPct, result.MaxMAEPct)
+
+	kelly := kellyFraction(result.WinRate, result.AvgWinPct, result.AvgLossPct)
+	if kelly > 0 {
+		fmt.Printf("Kelly 仓位: %.2f%%，建议仓位（%.2f 倍 Kelly）: %.2f%%\n", kelly*100, *kellyScale, kelly**kellyScale*100)
+	} else {
+		fmt.Println("Kelly 仓位: 0（策略没有正期望，不建议开仓）")
+	}
+	fmt.Printf("结果已保存到 %s\n", outputPath("backtest_trades.csv"))
+}
+
+// 交易方向
+type Side int
+
+const (
+	Long  Side = 1
+	Short Side = -1
+)
+
+func (s Side) String() string {
+	if s == Short {
+		return "SHORT"
+	}
+	return "LONG"
+}
+
+type Trade struct {
+	EntryIdx    int
+	Side        Side
+	EntryZScore float64
+	EntryPrice  float64
+	ExitPrice   float64
+	ReturnPct   float64 // 到期平仓的收益率
+	MFEPct      float64 // 持仓期间最大浮盈（>= 0），可作为止盈参考
+	MAEPct      float64 // 持仓期间最大浮亏（<= 0），可作为止损参考
+}
+
+type BacktestResult struct {
+	Trades       []Trade
+	WinRate      float64 // 收益率 > 0 的交易占比
+	AvgReturnPct float64
+	AvgWinPct    float64 // 盈利交易的平均收益率
+	AvgLossPct   float64 // 亏损交易的平均收益率（负数）
+	AvgMFEPct    float64
+	AvgMAEPct    float64
+	MaxMFEPct    float64
+	MaxMAEPct    float64 // 所有交易中最差的浮亏
+}
+
+// 用单个窗口的z-score做均值回归回测：z <= -threshold 做多，z >= threshold 做空，
+// 持有 hold 根K线后平仓。持仓期间不再开新仓，最后不足 hold 根K线的信号忽略
+func runBacktest(prices []float64, window int, volData VolatilityData, threshold float64, hold int, returnMode string) BacktestResult {
+	var result BacktestResult
+	for i := window; i+hold < len(prices); i++ {
+		returnPct := calculateReturn(prices[i-window], prices[i], returnMode)
+		if math.IsNaN(returnPct) || volData.StdDev <= 0 {
+			continue
+		}
+		zScore := (returnPct - volData.Mean) / volData.StdDev
+
+		var side Side
+		switch {
+		case zScore <= -threshold:
+			side = Long
+		case zScore >= threshold:
+			side = Short
+		default:
+			continue
+		}
+
+		trade := newTrade(prices[i:i+hold+1], side)
+		trade.EntryIdx = i
+		trade.EntryZScore = zScore
+		result.Trades = append(result.Trades, trade)
+		i += hold
+	}
+
+	summarizeTrades(&result)
+	return result
+}
+
+// path[0] 为开仓价，path[len-1] 为平仓价，按方向计算终值收益率和持仓期间的 MFE/MAE
+func newTrade(path []float64, side Side) Trade {
+	entry := path[0]
+	trade := Trade{
+		Side:       side,
+		EntryPrice: entry,
+		ExitPrice:  path[len(path)-1],
+	}
+	for _, p := range path[1:] {
+		excursion := float64(side) * (p - entry) / entry * 100
+		trade.MFEPct = math.Max(trade.MFEPct, excursion)
+		trade.MAEPct = math.Min(trade.MAEPct, excursion)
+	}
+	trade.ReturnPct = float64(side) * (trade.ExitPrice - entry) / entry * 100
+	return trade
+}
+
+// 汇总胜率、平均盈亏和 MFE/MAE
+func summarizeTrades(result *BacktestResult) {
+	if len(result.Trades) == 0 {
+		return
+	}
+
+	wins, losses := 0, 0
+	sumReturn, sumWin, sumLoss, sumMFE, sumMAE := 0.0, 0.0, 0.0, 0.0, 0.0
+	for _, t := range result.Trades {
+		sumReturn += t.ReturnPct
+		sumMFE += t.MFEPct
+		sumMAE += t.MAEPct
+		if t.ReturnPct > 0 {
+			wins++
+			sumWin += t.ReturnPct
+		} else if t.ReturnPct < 0 {
+			losses++
+			sumLoss += t.ReturnPct
+		}
+		result.MaxMFEPct = math.Max(result.MaxMFEPct, t.MFEPct)
+		result.MaxMAEPct = math.Min(result.MaxMAEPct, t.MAEPct)
+	}
+
+	n := float64(len(result.Trades))
+	result.WinRate = float64(wins) / n
+	result.AvgReturnPct = sumReturn / n
+	result.AvgMFEPct = sumMFE / n
+	result.AvgMAEPct = sumMAE / n
+	if wins > 0 {
+		result.AvgWinPct = sumWin / float64(wins)
+	}
+	if losses > 0 {
+		result.AvgLossPct = sumLoss / float64(losses)
+	}
+}
+
+// Kelly 公式: f = p - (1-p)/b，p 为胜率，b 为盈亏比（平均盈利 / 平均亏损的绝对值）
+// 结果限制在 [0, 1]，没有正期望时返回 0；没有亏损交易时盈亏比无穷大，返回 1
+func kellyFraction(winRate, avgWin, avgLoss float64) float64 {
+	if winRate <= 0 || avgWin <= 0 {
+		return 0
+	}
+	loss := math.Abs(avgLoss)
+	if loss == 0 {
+		return 1
+	}
+	f := winRate - (1-winRate)/(avgWin/loss)
+	return math.Max(0, math.Min(1, f))
+}
+
+// backtest_trades.csv 的版本，列有变化时加1
+const backtestSchemaVersion = 1
+
+type VolatilityData struct {
+	Mean        float64
+	StdDev      float64
+	SampleCount int
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 旧版本文件的迁移说明，Compatible 表示当前读取方仍能正确解析（例如只在末尾追加过列）
+type SchemaMigration struct {
+	Compatible bool
+	Note       string
+}
+
+// 读取带版本行的CSV，返回版本号和全部记录（含标题行）
+func readSchemaCSV(path string) (int, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	br, err := newCSVInput(path, file)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	if prefix, err := br.Peek(len(schemaPrefix)); err == nil && string(prefix) == schemaPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaPrefix)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s 的版本行格式错误: %q", path, strings.TrimSpace(line))
+		}
+	}
+
+	records, err := newCSVReader(br).ReadAll()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, records, nil
+}
+
+// 校验版本和标题：当前版本直接通过，兼容的旧版本打印迁移说明后继续，其余情况报错
+// expected 为读取方依赖的前几列
+func checkSchema(name string, version, current int, migrations map[int]SchemaMigration, records [][]string, expected []string) error {
+	if version != current {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%s 的版本 schema=%d 无法识别（当前为 schema=%d），请用当前版本的工具重新生成", name, version, current)
+		}
+		if !m.Compatible {
+			return fmt.Errorf("%s 是不兼容的旧版本 schema=%d: %s", name, version, m.Note)
+		}
+		fmt.Printf("注意: %s 是旧版本 schema=%d: %s\n", name, version, m.Note)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("%s 缺少标题行", name)
+	}
+	header := records[0]
+	if len(header) < len(expected) {
+		return fmt.Errorf("%s 的标题只有 %d 列，至少需要 %d 列", name, len(header), len(expected))
+	}
+	for i, col := range expected {
+		if header[i] != col {
+			return fmt.Errorf("%s 第 %d 列应为 %s，实际为 %s", name, i+1, col, header[i])
+		}
+	}
+	return nil
+}
+
+// 波动率表的版本，列有变化时加1，并在 volatilitySchemaMigrations 中说明旧版本如何处理
+const volatilitySchemaVersion = 3
+
+// 读取波动率表时依赖的列
+var volatilityRequiredHeader = []string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count"}
+
+// 旧版本波动率表的迁移说明
+var volatilitySchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有 VaR_Pct 列（由旧版 calculate_volatility 或 calculate_multi_timeframe_volatility.py 生成），不影响z-score计算，需要 VaR 时请重新运行 calculate_volatility"},
+	2: {Compatible: true, Note: "没有 Realized_Vol_Pct 列，不影响z-score计算"},
+}
+
+// 读取 calculate_volatility 输出的波动率表
+// 无法解析的行默认跳过，strict 为 true 时返回错误
+func loadVolatilityData(path string, strict bool) (map[int]VolatilityData, error) {
+	version, volRecords, err := readSchemaCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取波动率CSV失败: %v", err)
+	}
+	if err := checkSchema("multi_timeframe_volatility.csv", version, volatilitySchemaVersion, volatilitySchemaMigrations, volRecords, volatilityRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	// 解析波动率数据（跳过标题行）
+	volatilityData := make(map[int]VolatilityData)
+	for i := 1; i < len(volRecords); i++ {
+		data, window, err := parseVolatilityRow(volRecords[i])
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("multi_timeframe_volatility.csv 第 %d 条数据解析失败: %v", i, err)
+			}
+			continue
+		}
+		volatilityData[window] = data
+	}
+	return volatilityData, nil
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// 写入版本行，必须在标题行之前调用
+func writeSchemaLine(w io.Writer, version int) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", schemaPrefix, version)
+	return err
+}
+
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 解析波动率表的一行: Window_Minutes, Window_Days, Mean_Pct, StdDev_Pct, Sample_Count, ...
+func parseVolatilityRow(record []string) (VolatilityData, int, error) {
+	if len(record) < 5 {
+		return VolatilityData{}, 0, fmt.Errorf("列数不足: 需要至少 5 列，实际 %d 列", len(record))
+	}
+	window, err := strconv.Atoi(record[0])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Window_Minutes 格式错误: %v", err)
+	}
+	mean, err := strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Mean_Pct 格式错误: %v", err)
+	}
+	stdDev, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("StdDev_Pct 格式错误: %v", err)
+	}
+	sampleCount, err := strconv.Atoi(record[4])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Sample_Count 格式错误: %v", err)
+	}
+	return VolatilityData{Mean: mean, StdDev: stdDev, SampleCount: sampleCount}, window, nil
+}
+
+// CSV 中浮点列的小数位数，由 -precision 指定；小于 0 时各列使用自己的默认位数
+var csvPrecision = -1
+
+// 按 -precision 格式化CSV中的浮点数，未指定时使用该列的默认位数
+func formatFloat(value float64, defaultPrecision int) string {
+	precision := defaultPrecision
+	if csvPrecision >= 0 {
+		precision = csvPrecision
+	}
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// 急跌后做多，持仓期间先涨后跌：MFE 为途中的最高点，MAE 为途中的最低点，收益率按平仓价计算
+func TestBacktestMFEMAE(t *testing.T) {
+	prices := []float64{100, 100, 100, 100, 100, 90, 95, 99, 92, 85, 88}
+	result := runBacktest(prices, 1, VolatilityData{Mean: 0, StdDev: 1}, 2, 5, "simple")
+	if len(result.Trades) != 1 {
+		t.Fatalf("%d 笔交易, want 1: %+v", len(result.Trades), result.Trades)
+	}
+	trade := result.Trades[0]
+	if trade.EntryIdx != 5 || trade.Side != Long || trade.EntryPrice != 90 || trade.ExitPrice != 88 {
+		t.Fatalf("trade = %+v", trade)
+	}
+	for _, tc := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"MFE", trade.MFEPct, (99.0 - 90) / 90 * 100},
+		{"MAE", trade.MAEPct, (85.0 - 90) / 90 * 100},
+		{"Return", trade.ReturnPct, (88.0 - 90) / 90 * 100},
+		{"EntryZScore", trade.EntryZScore, -10},
+	} {
+		if !near(tc.got, tc.want) {
+			t.Errorf("%s = %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+// 做空时价格下跌是浮盈；一路盈利的交易 MAE 为0，一路亏损的交易 MFE 为0；汇总取平均和最值
+func TestTradeExcursionsAndSummary(t *testing.T) {
+	short := newTrade([]float64{100, 95, 103, 98}, Short)
+	if !near(short.MFEPct, 5) || !near(short.MAEPct, -3) || !near(short.ReturnPct, 2) {
+		t.Errorf("做空: MFE=%v MAE=%v Return=%v, want 5, -3, 2", short.MFEPct, short.MAEPct, short.ReturnPct)
+	}
+	winner := newTrade([]float64{100, 101, 104, 102}, Long)
+	if !near(winner.MFEPct, 4) || winner.MAEPct != 0 {
+		t.Errorf("一路盈利: MFE=%v MAE=%v, want 4, 0", winner.MFEPct, winner.MAEPct)
+	}
+	loser := newTrade([]float64{100, 99, 94, 96}, Long)
+	if loser.MFEPct != 0 || !near(loser.MAEPct, -6) {
+		t.Errorf("一路亏损: MFE=%v MAE=%v, want 0, -6", loser.MFEPct, loser.MAEPct)
+	}
+
+	result := BacktestResult{Trades: []Trade{short, winner, loser}}
+	summarizeTrades(&result)
+	if !near(result.AvgMFEPct, 3) || !near(result.AvgMAEPct, -3) || !near(result.MaxMFEPct, 5) || !near(result.MaxMAEPct, -6) {
+		t.Errorf("汇总: AvgMFE=%v AvgMAE=%v MaxMFE=%v MaxMAE=%v, want 3, -3, 5, -6",
+			result.AvgMFEPct, result.AvgMAEPct, result.MaxMFEPct, result.MaxMAEPct)
+	}
+	if !near(result.WinRate, 2.0/3) || !near(result.AvgWinPct, 2) || !near(result.AvgLossPct, -4) {
+		t.Errorf("胜率=%v 平均盈利=%v 平均亏损=%v", result.WinRate, result.AvgWinPct, result.AvgLossPct)
+	}
+}
+
+// 已知胜率和盈亏比的 Kelly 值；没有正期望时为0，没有亏损交易时为1，结果不超出 [0, 1]
+func TestKellyFraction(t *testing.T) {
+	for _, tc := range []struct {
+		name                     string
+		winRate, avgWin, avgLoss float64
+		want                     float64
+	}{
+		{"胜率 60%，盈亏比 1", 0.6, 1, -1, 0.2},
+		{"胜率 50%，盈亏比 2", 0.5, 2, -1, 0.25},
+		{"胜率 40%，盈亏比 3", 0.4, 1.5, -0.5, 0.2},
+		{"亏损用正数表示", 0.6, 1, 1, 0.2},
+		{"期望为0", 0.5, 1, -1, 0},
+		{"负期望", 0.3, 1, -1, 0},
+		{"没有盈利交易", 0, 0, -1, 0},
+		{"没有亏损交易", 1, 0.8, 0, 1},
+	} {
+		if got := kellyFraction(tc.winRate, tc.avgWin, tc.avgLoss); !near(got, tc.want) {
+			t.Errorf("%s: kellyFraction(%v, %v, %v) = %v, want %v", tc.name, tc.winRate, tc.avgWin, tc.avgLoss, got, tc.want)
+		}
+	}
+}
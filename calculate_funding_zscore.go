@@ -0,0 +1,910 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// 资金费率（U本位合约）的 z-score：与价格使用同一套均值/标准差/z-score 计算，
+// 判断当前的资金费率（以及最近若干期累计的资金费率）是否处于极端水平
+//
+//	go run calculate_funding_zscore.go -fetch                 # 下载资金费率历史并计算
+//	go run calculate_funding_zscore.go -series price          # 用同样的方法计算价格（对数收益率）的 z-score
+
+// 资金费率文件的列，Funding_Rate 为原始比例（0.0001 即 0.01%）
+var fundingHeader = []string{"Funding_Time", "Funding_Time (UTC)", "Funding_Rate", "Mark_Price"}
+
+const fundingSchemaVersion = 1
+
+// 资金费率默认窗口（期数，每期一般为8小时）：单期、1天、3天、7天、30天
+var defaultFundingWindows = []int{1, 3, 9, 21, 90}
+
+// 单次请求最多返回的资金费率条数
+const fundingPageLimit = 1000
+
+// 一期资金费率
+type FundingRate struct {
+	Time      int64 // 结算时间（毫秒）
+	Rate      float64
+	MarkPrice float64 // 早期数据没有标记价格时为 0
+}
+
+// 一个窗口在序列上的 z-score 统计
+type SeriesZScore struct {
+	Window      int
+	Current     float64 // 最新一个窗口的变化量
+	Mean        float64
+	StdDev      float64
+	SampleCount int
+	ZScore      float64
+}
+
+func main() {
+	seriesName := flag.String("series", "funding", "分析的序列: funding（资金费率）或 price（1分钟收盘价的对数收益率）")
+	input := flag.String("input", "", "输入文件，默认 funding 为 <symbol>_funding_rates.csv，price 为 <symbol>_minute_klines.csv")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	fetch := flag.Bool("fetch", false, "先从 /fapi/v1/fundingRate 下载资金费率历史，保存到输入文件（只用于 funding）")
+	fetchDays := flag.Int("fetch-days", 365, "下载最近多少天的资金费率")
+	symbol := flag.String("symbol", "", "合约交易对，默认为 ETH 加上 -symbol-suffix")
+	windowsFlag := flag.String("windows", "", "窗口（逗号分隔）；funding 按期数，默认 1,3,9,21,90；price 按分钟，默认与其他工具相同")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行）时直接报错，而不是跳过继续")
+	flag.Parse()
+	if *seriesName != "funding" && *seriesName != "price" {
+		log.Fatalf("未知的序列: %s（可选 funding 或 price）", *seriesName)
+	}
+	if *fetch && *seriesName != "funding" {
+		log.Fatal("-fetch 只能用于 -series funding")
+	}
+	if *fetchDays < 1 {
+		log.Fatalf("-fetch-days 必须大于等于 1: %d", *fetchDays)
+	}
+	windows, err := parseWindows(*windowsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *windowsFlag == "" && *seriesName == "funding" {
+		windows = defaultFundingWindows
+	}
+	if *symbol == "" {
+		*symbol = composeSymbol(baseAsset, symbolSuffix)
+	}
+	if *input == "" {
+		*input = *symbol + "_minute_klines.csv"
+		if *seriesName == "funding" {
+			*input = *symbol + "_funding_rates.csv"
+		}
+	}
+
+	// 两条路径只在构造序列上不同：序列的 window 期差值就是要做 z-score 的量
+	var series []float64
+	var unit string
+	if *seriesName == "funding" {
+		if *fetch {
+			if err := os.MkdirAll(inputDir, 0755); err != nil {
+				log.Fatal("创建输入目录失败:", err)
+			}
+			since := time.Now().AddDate(0, 0, -*fetchDays)
+			fmt.Printf("正在下载 %s 自 %s 起的资金费率...\n", *symbol, since.Format("2006-01-02"))
+			rates, err := fetchFundingRates(*symbol, since.UnixMilli())
+			if err != nil {
+				log.Fatal("下载资金费率失败:", err)
+			}
+			if err := writeFundingRatesCSV(inputPath(*input), rates); err != nil {
+				log.Fatal("保存资金费率失败:", err)
+			}
+			fmt.Printf("已保存 %d 期资金费率到 %s\n", len(rates), inputPath(*input))
+		}
+
+		rates, err := loadFundingRates(inputPath(*input), *strict)
+		if err != nil {
+			log.Fatal("读取资金费率失败:", err)
+		}
+		if len(rates) == 0 {
+			log.Fatal("资金费率文件中没有数据")
+		}
+		fmt.Printf("共读取 %d 期资金费率（%s 至 %s）\n", len(rates),
+			time.UnixMilli(rates[0].Time).UTC().Format("2006-01-02 15:04"),
+			time.UnixMilli(rates[len(rates)-1].Time).UTC().Format("2006-01-02 15:04"))
+		fmt.Printf("最新资金费率: %.4f%%\n", rates[len(rates)-1].Rate*100)
+		series = cumulativeFunding(rates)
+		unit = "期"
+	} else {
+		klines, _, err := loadKlines(inputPath(*input), *strict)
+		if err != nil {
+			log.Fatal("读取价格数据失败:", err)
+		}
+		fmt.Printf("共读取 %d 条价格\n", len(klines))
+		series = logPrices(klines)
+		unit = "分钟"
+	}
+
+	results := zScoreOverSeries(series, windows)
+	if len(results) == 0 {
+		log.Fatalf("数据只有 %d 条，不足以计算任何窗口", len(series))
+	}
+
+	fmt.Println()
+	if *seriesName == "funding" {
+		fmt.Println("窗口内累计资金费率的 z-score:")
+	} else {
+		fmt.Println("对数收益率的 z-score:")
+	}
+	fmt.Printf("%-10s %12s %12s %12s %8s %10s\n", "窗口", "当前(%)", "均值(%)", "标准差(%)", "样本数", "z-score")
+	for _, r := range results {
+		fmt.Printf("%-10s %12.6f %12.6f %12.6f %8d %10.4f\n",
+			fmt.Sprintf("%d %s", r.Window, unit), r.Current, r.Mean, r.StdDev, r.SampleCount, r.ZScore)
+	}
+	for _, window := range windows {
+		if window >= len(series) {
+			fmt.Printf("警告: %d %s窗口数据不足，已跳过\n", window, unit)
+		}
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+	output := outputPath(*seriesName + "_zscore_results.csv")
+	err = writeFileAtomic(output, func(w io.Writer) error {
+		if err := writeSchemaLine(w, 1); err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Window", "Current_Pct", "Mean_Pct", "StdDev_Pct", "Sample_Count", "Z_Score"})
+		for _, r := range results {
+			writer.Write([]string{
+				strconv.Itoa(r.Window),
+				strconv.FormatFloat(r.Current, 'f', 6, 64),
+				strconv.FormatFloat(r.Mean, 'f', 6, 64),
+				strconv.FormatFloat(r.StdDev, 'f', 6, 64),
+				strconv.Itoa(r.SampleCount),
+				strconv.FormatFloat(r.ZScore, 'f', 4, 64),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		log.Fatal("保存结果失败:", err)
+	}
+	fmt.Printf("\n结果已保存到 %s\n", output)
+}
+
+// 对每个窗口，用序列上所有重叠的 window 期差值 series[i]-series[i-window] 计算均值和样本标准差，
+// 返回最新一个差值的 z-score；与 calculate_volatility 一样跳过 NaN，数据不足（少于 2 个差值）的窗口不出现在结果中
+// 价格传入 100*ln(价格)，差值即对数收益率(%)；资金费率传入累计费率(%)，差值即窗口内累计的资金费率
+func zScoreOverSeries(series []float64, windows []int) []SeriesZScore {
+	results := make([]SeriesZScore, 0, len(windows))
+	last := len(series) - 1
+	for _, window := range windows {
+		if window > last {
+			continue
+		}
+		current := series[last] - series[last-window]
+		if math.IsNaN(current) {
+			continue
+		}
+
+		changes := make([]float64, 0, len(series)-window)
+		for i := window; i < len(series); i++ {
+			change := series[i] - series[i-window]
+			if !math.IsNaN(change) {
+				changes = append(changes, change)
+			}
+		}
+		if len(changes) < 2 {
+			continue
+		}
+
+		var sum float64
+		for _, c := range changes {
+			sum += c
+		}
+		mean := sum / float64(len(changes))
+		var sumSq float64
+		for _, c := range changes {
+			sumSq += (c - mean) * (c - mean)
+		}
+		stdDev := math.Sqrt(sumSq / float64(len(changes)-1))
+
+		var zScore float64
+		if stdDev > 0 {
+			zScore = (current - mean) / stdDev
+		}
+		results = append(results, SeriesZScore{
+			Window:      window,
+			Current:     current,
+			Mean:        mean,
+			StdDev:      stdDev,
+			SampleCount: len(changes),
+			ZScore:      zScore,
+		})
+	}
+	return results
+}
+
+// 资金费率的累计和（%），第 i 项为前 i 期费率之和，series[0] = 0
+func cumulativeFunding(rates []FundingRate) []float64 {
+	series := make([]float64, len(rates)+1)
+	for i, r := range rates {
+		series[i+1] = series[i] + r.Rate*100
+	}
+	return series
+}
+
+// 收盘价取 100*ln，相邻差值即对数收益率(%)，与 calculate_volatility -return-mode log 一致
+func logPrices(klines []Kline) []float64 {
+	series := make([]float64, len(klines))
+	for i, k := range klines {
+		series[i] = math.Log(k.Close) * 100
+	}
+	return series
+}
+
+// 读取资金费率CSV，按标题找列，兼容本程序输出的格式和 data.binance.vision 的格式（calc_time, last_funding_rate）
+// 时间必须严格递增；strict 为 true 时遇到无法解析的行直接报错，否则跳过
+func loadFundingRates(path string, strict bool) ([]FundingRate, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: 读取标题失败: %v", path, err)
+	}
+	timeCol := findColumn(header, "fundingtime", "calctime")
+	rateCol := findColumn(header, "fundingrate", "lastfundingrate")
+	markCol := findColumn(header, "markprice")
+	if timeCol < 0 || rateCol < 0 {
+		return nil, fmt.Errorf("%s: 找不到时间或资金费率列，标题为 %v", path, header)
+	}
+
+	var rates []FundingRate
+	skipped := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rate, err := parseFundingRecord(record, timeCol, rateCol, markCol)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			if strict {
+				return nil, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			skipped++
+			continue
+		}
+		rates = append(rates, rate)
+	}
+	if skipped > 0 {
+		fmt.Printf("跳过 %d 行无法解析的资金费率\n", skipped)
+	}
+	for i := 1; i < len(rates); i++ {
+		if rates[i].Time <= rates[i-1].Time {
+			return nil, fmt.Errorf("%s: 资金费率时间不是严格递增（%d 之后是 %d）", path, rates[i-1].Time, rates[i].Time)
+		}
+	}
+	return rates, nil
+}
+
+func parseFundingRecord(record []string, timeCol, rateCol, markCol int) (FundingRate, error) {
+	if len(record) <= timeCol || len(record) <= rateCol {
+		return FundingRate{}, fmt.Errorf("列数不足: %d 列", len(record))
+	}
+	t, err := strconv.ParseInt(strings.TrimSpace(record[timeCol]), 10, 64)
+	if err != nil {
+		return FundingRate{}, fmt.Errorf("时间格式错误: %v", err)
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(record[rateCol]), 64)
+	if err != nil || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return FundingRate{}, fmt.Errorf("资金费率格式错误: %q", record[rateCol])
+	}
+	var mark float64
+	if markCol >= 0 && markCol < len(record) && record[markCol] != "" {
+		mark, _ = strconv.ParseFloat(record[markCol], 64)
+	}
+	return FundingRate{Time: t, Rate: rate, MarkPrice: mark}, nil
+}
+
+// 按名称找列：忽略大小写、下划线和空格，返回第一个匹配的下标，找不到为 -1
+func findColumn(header []string, names ...string) int {
+	for i, h := range header {
+		normalized := strings.ToLower(strings.NewReplacer("_", "", " ", "").Replace(h))
+		for _, name := range names {
+			if normalized == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// 从 since（毫秒）开始分页下载资金费率，直到最新一期
+func fetchFundingRates(symbol string, since int64) ([]FundingRate, error) {
+	var rates []FundingRate
+	for {
+		url := fmt.Sprintf("https://fapi.binance.com/fapi/v1/fundingRate?symbol=%s&startTime=%d&limit=%d", symbol, since, fundingPageLimit)
+		resp, err := http.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var page []struct {
+			FundingTime int64  `json:"fundingTime"`
+			FundingRate string `json:"fundingRate"`
+			MarkPrice   string `json:"markPrice"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("解析资金费率失败: %v, 原始数据: %s", err, body)
+		}
+		for _, p := range page {
+			rate, err := strconv.ParseFloat(p.FundingRate, 64)
+			if err != nil {
+				return nil, fmt.Errorf("资金费率格式错误: %q", p.FundingRate)
+			}
+			mark, _ := strconv.ParseFloat(p.MarkPrice, 64)
+			rates = append(rates, FundingRate{Time: p.FundingTime, Rate: rate, MarkPrice: mark})
+		}
+		if len(page) < fundingPageLimit {
+			return rates, nil
+		}
+		since = page[len(page)-1].FundingTime + 1
+		// 避免触发限频
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func writeFundingRatesCSV(path string, rates []FundingRate) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		if err := writeSchemaLine(w, fundingSchemaVersion); err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		writer.Write(fundingHeader)
+		for _, r := range rates {
+			mark := ""
+			if r.MarkPrice > 0 {
+				mark = strconv.FormatFloat(r.MarkPrice, 'f', -1, 64)
+			}
+			writer.Write([]string{
+				strconv.FormatInt(r.Time, 10),
+				time.UnixMilli(r.Time).UTC().Format("2006-01-02 15:04:05"),
+				strconv.FormatFloat(r.Rate, 'f', -1, 64),
+				mark,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 写入版本行，必须在标题行之前调用
+func writeSchemaLine(w io.Writer, version int) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", schemaPrefix, version)
+	return err
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
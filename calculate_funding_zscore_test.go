@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 资金费率 1%、2%、3%、4%、10%（累计序列 0,1,3,6,10,20）：
+// 1 期差值为 1,2,3,4,10，均值 4、样本标准差 sqrt(12.5)；2 期差值为 3,5,7,14，均值 7.25、标准差 sqrt(68.75/3)；
+// 5 期只有一个差值、6 期超出序列长度，不出现在结果中
+func TestZScoreOverFundingSeries(t *testing.T) {
+	var rates []FundingRate
+	for i, r := range []float64{0.01, 0.02, 0.03, 0.04, 0.10} {
+		rates = append(rates, FundingRate{Time: int64(i) * 8 * 3600 * 1000, Rate: r})
+	}
+	series := cumulativeFunding(rates)
+	if want := []float64{0, 1, 3, 6, 10, 20}; !near(series, want) {
+		t.Fatalf("累计费率 = %v, want %v", series, want)
+	}
+
+	results := zScoreOverSeries(series, []int{1, 2, 5, 6})
+	if len(results) != 2 {
+		t.Fatalf("结果 = %+v, want 1 期和 2 期两个窗口", results)
+	}
+	for i, want := range []SeriesZScore{
+		{Window: 1, Current: 10, Mean: 4, StdDev: math.Sqrt(12.5), SampleCount: 5, ZScore: 6 / math.Sqrt(12.5)},
+		{Window: 2, Current: 14, Mean: 7.25, StdDev: math.Sqrt(68.75 / 3), SampleCount: 4, ZScore: 6.75 / math.Sqrt(68.75/3)},
+	} {
+		got := results[i]
+		if got.Window != want.Window || got.SampleCount != want.SampleCount ||
+			!near([]float64{got.Current, got.Mean, got.StdDev, got.ZScore}, []float64{want.Current, want.Mean, want.StdDev, want.ZScore}) {
+			t.Errorf("%d 期 = %+v, want %+v", want.Window, got, want)
+		}
+	}
+}
+
+// 费率不变时标准差为0，z-score 记为 0；跨过 NaN 的差值不计入样本
+func TestZScoreOverSeriesFlatAndNaN(t *testing.T) {
+	if results := zScoreOverSeries([]float64{0, 1, 2, 3}, []int{1}); len(results) != 1 || results[0].StdDev != 0 || results[0].ZScore != 0 {
+		t.Errorf("费率不变: %+v", results)
+	}
+	results := zScoreOverSeries([]float64{0, 1, math.NaN(), 3, 5, 6}, []int{1})
+	if len(results) != 1 || results[0].SampleCount != 3 {
+		t.Errorf("跳过 NaN: %+v, want 3 个样本", results)
+	}
+}
+
+// 本程序写出的资金费率文件和 data.binance.vision 格式（calc_time, last_funding_rate）都能读回
+func TestLoadFundingRates(t *testing.T) {
+	dir := t.TempDir()
+	want := []FundingRate{
+		{Time: 1767225600000, Rate: 0.0001, MarkPrice: 2000.5},
+		{Time: 1767254400000, Rate: -0.00005},
+	}
+	path := filepath.Join(dir, "ETHUSDT_funding_rates.csv")
+	if err := writeFundingRatesCSV(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadFundingRates(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("读回 %+v, want %+v", got, want)
+	}
+
+	vision := filepath.Join(dir, "vision.csv")
+	content := "calc_time,funding_interval_hours,last_funding_rate\n1767225600000,8,0.0001\n1767254400000,8,-0.00005\n"
+	if err := os.WriteFile(vision, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = loadFundingRates(vision, true)
+	if err != nil || len(got) != 2 || got[1].Rate != -0.00005 {
+		t.Errorf("vision 格式读回 %+v, %v", got, err)
+	}
+
+	unordered := filepath.Join(dir, "unordered.csv")
+	if err := os.WriteFile(unordered, []byte("fundingTime,fundingRate\n2,0.1\n1,0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFundingRates(unordered, false); err == nil {
+		t.Error("时间不是递增的文件应返回错误")
+	}
+}
+
+func near(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
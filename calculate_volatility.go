@@ -1,142 +1,447 @@
 package main
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 func main() {
-	fmt.Println("正在读取数据...")
-	
-	// 读取CSV文件
-	file, err := os.Open("ETHUSDT_minute_klines.csv")
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	varQuantile := flag.Float64("var-quantile", 0.01, "经验VaR使用的分位数（0.01 即1% VaR）")
+	smooth := flag.Int("smooth", 1, "价格平滑的K线数（简单移动平均），1 表示不平滑；平滑会带来滞后")
+	median := flag.Int("median", 1, "中值滤波的K线数（去掉瞬间的错误报价），1 表示不过滤；在 -smooth 之前应用")
+	maxBarMove := flag.Float64("max-bar-move", 0.5, "单根K线相对前一个正常价格的最大涨跌幅（0.5 即 50%），超过的视为错误报价并报告；0 表示不检测")
+	repairSpikes := flag.Bool("repair-spikes", false, "按两侧正常价格线性插值修复超过 -max-bar-move 的K线（默认只报告）；波动率和z-score必须使用相同的设置")
+	priceModeName := flag.String("price-mode", "close", "价格取法: close、mid、typical 或 ohlc4")
+	halflife := flag.Float64("decay-halflife", 0, "时间衰减的半衰期（分钟），越早的收益率权重越低；<=0 表示等权（默认）")
+	cpuProfile := flag.String("cpuprofile", "", "把CPU profile写入该文件（用 go tool pprof 查看）")
+	memProfile := flag.String("memprofile", "", "结束时把内存 profile 写入该文件")
+	windowsFlag := flag.String("windows", "", "关键时间窗口（分钟，逗号分隔），默认 1,5,15,30,60,120,240,1440,2880,4320,10080")
+	lineProgress := flag.Bool("q", false, "不用单行刷新的进度，每次更新输出一行（stdout 不是终端时默认如此）")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	flag.IntVar(&csvPrecision, "precision", -1, "CSV中浮点列的小数位数，-1 表示使用各列默认位数（默认 Window_Days 4 位，其余 6 位）")
+	lookbackDays := flag.Int("lookback-days", 7, "最长的波动率窗口（天），需覆盖 z-score 矩阵使用的 -lookback-days")
+	approx := flag.Bool("approx", false, "用分位数草图（t-digest）近似计算VaR，均值和标准差逐个累加，不保存每个窗口的全部收益率，内存与数据长度无关；VaR 有少量误差，不能与 -decay-halflife 同时使用")
+	sketchCompression := flag.Float64("sketch-compression", 200, "-approx 的 t-digest 压缩参数，越大越精确、占用内存越多（质心数量少于该值）")
+	winsorizeFlag := flag.String("winsorize", "", "计算均值、标准差和已实现波动率之前，把每个窗口的收益率截到这两个百分位之间（如 1,99），减小极端值的影响而不丢弃样本；VaR 仍按原始收益率计算；默认不截断，不能与 -approx 同时使用")
+	dumpWindow := flag.Int("dump-window", 0, "把该窗口（分钟）参与计算的每个收益率连同时间写入 returns_<窗口>min.csv，便于排查异常的 z-score；0 表示不输出（文件可能很大）")
+	flag.Parse()
+	if *lookbackDays < 1 {
+		log.Fatalf("-lookback-days 必须大于等于 1: %d", *lookbackDays)
+	}
+	lookback := *lookbackDays * 1440
+	if *dumpWindow < 0 || *dumpWindow > lookback {
+		log.Fatalf("-dump-window 必须在 0 到 %d 之间: %d", lookback, *dumpWindow)
+	}
+	windows, err := parseWindows(*windowsFlag)
 	if err != nil {
-		log.Fatal("无法打开文件:", err)
+		log.Fatal(err)
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+	priceMode, err := parsePriceMode(*priceModeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *smooth < 1 {
+		log.Fatalf("-smooth 必须大于等于 1: %d", *smooth)
+	}
+	if *median < 1 {
+		log.Fatalf("-median 必须大于等于 1: %d", *median)
+	}
+	if *maxBarMove < 0 {
+		log.Fatalf("-max-bar-move 不能为负数: %v", *maxBarMove)
+	}
+	if *varQuantile < 0 || *varQuantile > 1 {
+		log.Fatalf("分位数必须在 0 到 1 之间: %v", *varQuantile)
+	}
+	if *approx && *halflife > 0 {
+		log.Fatal("-approx 不能与 -decay-halflife 同时使用：时间衰减权重需要保存全部收益率")
+	}
+	winsorLower, winsorUpper, err := parseWinsorize(*winsorizeFlag)
 	if err != nil {
-		log.Fatal("读取CSV失败:", err)
+		log.Fatal(err)
+	}
+	if *approx && *winsorizeFlag != "" {
+		log.Fatal("-approx 不能与 -winsorize 同时使用：截断需要先知道每个窗口全部收益率的分位数")
+	}
+	if *sketchCompression < 20 {
+		log.Fatalf("-sketch-compression 必须大于等于 20: %v", *sketchCompression)
 	}
 
-	// 解析价格数据（跳过标题行）
-	prices := make([]float64, 0, len(records)-1)
-	for i := 1; i < len(records); i++ {
-		if len(records[i]) < 6 {
-			continue
-		}
-		closePrice, err := strconv.ParseFloat(records[i][5], 64) // Close在索引5
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
+	// CPU profile 覆盖读取、计算和写入的整个过程
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
 		if err != nil {
-			continue
+			log.Fatal("创建CPU profile文件失败:", err)
 		}
-		prices = append(prices, closePrice)
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatal("启动CPU profile失败:", err)
+		}
+		defer func() {
+			pprof.StopCPUProfile()
+			f.Close()
+			fmt.Printf("CPU profile 已保存到 %s\n", *cpuProfile)
+		}()
 	}
 
+	fmt.Println("正在读取数据...")
+
+	// 读取CSV文件
+	klines, _, err := loadKlines(inputPath(klinesFileName("minute_klines")), *strict)
+	if err != nil {
+		log.Fatal("读取价格数据失败:", err)
+	}
+
+	prices := klinePrices(klines, priceMode)
+	prices = checkPriceSpikes(klines, prices, *maxBarMove, *repairSpikes)
+	prices = medianFilter(prices, *median)
+	prices = smoothPrices(prices, *smooth)
+
 	fmt.Printf("共读取 %d 条数据\n", len(prices))
 
 	// 计算不同时间窗口的标准差
-	maxWindow := 1440 * 7 // 7天 = 10080分钟
-	results := make([]Result, 0, maxWindow)
+	maxWindow := lookback
+	if len(prices) <= maxWindow {
+		if *strict {
+			log.Fatalf("数据不足，计算 %d 分钟窗口需要至少 %d 条，实际只有 %d 条（-strict）", maxWindow, maxWindow+1, len(prices))
+		}
+		fmt.Printf("警告: 数据只有 %d 条，不足以覆盖 %d 天，只计算到 %d 分钟窗口\n", len(prices), *lookbackDays, len(prices)-1)
+	}
 
 	fmt.Printf("\n开始计算从1分钟到%d分钟的标准差...\n", maxWindow)
-	fmt.Println("这可能需要一些时间，请耐心等待...\n")
+	fmt.Print("这可能需要一些时间，请耐心等待...\n\n")
 
-	startTime := time.Now()
-	
-	for window := 1; window <= maxWindow && window < len(prices); window++ {
-		// 计算该窗口的收益率
-		returns := make([]float64, 0, len(prices)-window)
-		for i := window; i < len(prices); i++ {
-			returnPct := ((prices[i] - prices[i-window]) / prices[i-window]) * 100
-			returns = append(returns, returnPct)
-		}
-
-		if len(returns) > 1 {
-			mean := calculateMean(returns)
-			stdDev := calculateStdDev(returns, mean)
-			
-			results = append(results, Result{
-				WindowMinutes: window,
-				WindowDays:    float64(window) / 1440.0,
-				MeanPct:       mean,
-				StdDevPct:     stdDev,
-				SampleCount:   len(returns),
-			})
+	// 按最长的收益率序列预先算好衰减权重，每个窗口取末尾对应长度的部分
+	var weights []float64
+	if *halflife > 0 {
+		weights = decayWeights(len(prices)-1, *halflife)
+		fmt.Printf("使用时间衰减权重，半衰期 %.0f 分钟\n\n", *halflife)
+	}
+	if *winsorizeFlag != "" {
+		fmt.Printf("均值和标准差按截断到第 %g 到第 %g 百分位的收益率计算\n\n", winsorLower, winsorUpper)
+	}
 
-			// 进度输出
-			if window <= 100 && window%10 == 0 {
-				progress := float64(window) / float64(maxWindow) * 100
-				elapsed := time.Since(startTime).Seconds()
-				fmt.Printf("[%.1f%%] 窗口 %d 分钟 (%.4f 天): 标准差 = %.6f%%, 样本数 = %d, 已用时: %.1f秒\n",
-					progress, window, float64(window)/1440.0, stdDev, len(returns), elapsed)
-			} else if window > 100 && window%100 == 0 {
-				progress := float64(window) / float64(maxWindow) * 100
-				elapsed := time.Since(startTime).Seconds()
-				fmt.Printf("[%.1f%%] 窗口 %d 分钟 (%.4f 天): 标准差 = %.6f%%, 样本数 = %d, 已用时: %.1f秒\n",
-					progress, window, float64(window)/1440.0, stdDev, len(returns), elapsed)
-			} else if window <= 10 {
-				fmt.Printf("窗口 %d 分钟 (%.4f 天): 标准差 = %.6f%%, 样本数 = %d\n",
-					window, float64(window)/1440.0, stdDev, len(returns))
-			}
-		} else if len(returns) == 1 {
-			results = append(results, Result{
-				WindowMinutes: window,
-				WindowDays:    float64(window) / 1440.0,
-				MeanPct:       returns[0],
-				StdDevPct:     0.0,
-				SampleCount:   1,
-			})
-		}
+	startTime := time.Now()
+	reporter := newProgress(os.Stdout, *lineProgress)
+	cfg := VolatilityConfig{
+		MaxWindow:         maxWindow,
+		ReturnMode:        *returnMode,
+		VarQuantile:       *varQuantile,
+		Weights:           weights,
+		WinsorLower:       winsorLower,
+		WinsorUpper:       winsorUpper,
+		Approx:            *approx,
+		SketchCompression: *sketchCompression,
+		DumpWindow:        *dumpWindow,
 	}
+	results, dumped := buildVolatility(klines, prices, cfg, func(window, total int) {
+		// 前 10 个窗口逐个输出，100 分钟以内每 10 个窗口、之后每 100 个窗口输出一次
+		if window <= 10 || (window <= 100 && window%10 == 0) || window%100 == 0 || window == total {
+			reporter.Update("[%.1f%%] 窗口 %d 分钟 (%.4f 天), 已用时: %.1f秒",
+				float64(window)/float64(total)*100, window, float64(window)/1440.0, time.Since(startTime).Seconds())
+		}
+	})
 
 	// 保存结果到CSV
+	reporter.Done()
+
 	fmt.Println("\n正在保存结果到CSV...")
-	outputFile, err := os.Create("multi_timeframe_volatility.csv")
-	if err != nil {
-		log.Fatal("创建输出文件失败:", err)
-	}
-	defer outputFile.Close()
+	err = writeFileAtomic(outputPath("multi_timeframe_volatility.csv"), func(w io.Writer) error {
+		if err := writeSchemaLine(w, volatilitySchemaVersion); err != nil {
+			return err
+		}
 
-	writer := csv.NewWriter(outputFile)
-	defer writer.Flush()
+		writer := csv.NewWriter(w)
 
-	// 写入标题
-	writer.Write([]string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count"})
+		// 写入标题
+		writer.Write(volatilityHeader)
 
-	// 写入数据
-	for _, result := range results {
-		writer.Write([]string{
-			strconv.Itoa(result.WindowMinutes),
-			strconv.FormatFloat(result.WindowDays, 'f', 4, 64),
-			strconv.FormatFloat(result.MeanPct, 'f', 6, 64),
-			strconv.FormatFloat(result.StdDevPct, 'f', 6, 64),
-			strconv.Itoa(result.SampleCount),
-		})
+		// 写入数据
+		for _, result := range results {
+			writer.Write([]string{
+				strconv.Itoa(result.WindowMinutes),
+				formatFloat(result.WindowDays, 4),
+				formatFloat(result.MeanPct, 6),
+				formatFloat(result.StdDevPct, 6),
+				strconv.Itoa(result.SampleCount),
+				formatFloat(result.VaRPct, 6),
+				formatFloat(result.RealizedPct, 6),
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		log.Fatal("保存结果失败:", err)
+	}
+
+	if *dumpWindow > 0 {
+		dumpPath := outputPath(fmt.Sprintf("returns_%dmin.csv", *dumpWindow))
+		if err := writeDumpedReturns(dumpPath, dumped); err != nil {
+			log.Fatal("保存收益率序列失败:", err)
+		}
+		fmt.Printf("%d 分钟窗口的 %d 个收益率已保存到 %s\n", *dumpWindow, len(dumped), dumpPath)
 	}
 
 	totalTime := time.Since(startTime).Seconds()
 	fmt.Printf("\n计算完成！\n")
 	fmt.Printf("共计算了 %d 个时间窗口\n", len(results))
 	fmt.Printf("总用时: %.1f秒\n", totalTime)
-	fmt.Printf("结果已保存到 multi_timeframe_volatility.csv\n")
+	fmt.Printf("结果已保存到 %s\n", outputPath("multi_timeframe_volatility.csv"))
 
 	// 显示关键时间点的结果
 	fmt.Println("\n关键时间窗口的标准差:")
-	keyWindows := []int{1, 5, 15, 30, 60, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
-	for _, kw := range keyWindows {
-		if kw <= len(results) {
-			result := results[kw-1]
-			fmt.Printf("%d 分钟 (%.4f 天): 标准差 = %.6f%%\n",
-				result.WindowMinutes, result.WindowDays, result.StdDevPct)
+	for _, kw := range windows {
+		if result, ok := findResult(results, kw); ok {
+			fmt.Printf("%d 分钟 (%.4f 天): 标准差 = %.6f%%, 已实现波动率 = %.6f%%\n",
+				result.WindowMinutes, result.WindowDays, result.StdDevPct, result.RealizedPct)
 		}
 	}
+
+	if *memProfile != "" {
+		if err := writeMemProfile(*memProfile); err != nil {
+			log.Fatal("写入内存 profile 失败:", err)
+		}
+		fmt.Printf("内存 profile 已保存到 %s\n", *memProfile)
+	}
+}
+
+// -dump-window 输出的一个收益率，价格为经过 -median/-smooth 处理后实际参与计算的价格
+type DumpedReturn struct {
+	StartTime  string
+	EndTime    string
+	StartPrice float64
+	EndPrice   float64
+	ReturnPct  float64
+}
+
+// returns_<窗口>min.csv 的版本，列有变化时加1
+const dumpedReturnsSchemaVersion = 1
+
+func writeDumpedReturns(path string, dumped []DumpedReturn) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		if err := writeSchemaLine(w, dumpedReturnsSchemaVersion); err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Start_Time", "End_Time", "Start_Price", "End_Price", "Return_Pct"})
+		for _, d := range dumped {
+			writer.Write([]string{
+				d.StartTime,
+				d.EndTime,
+				formatFloat(d.StartPrice, -1),
+				formatFloat(d.EndPrice, -1),
+				formatFloat(d.ReturnPct, 8),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+}
+
+// 写入堆内存 profile，写之前先 GC，让统计反映仍在使用的内存
+func writeMemProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
+}
+
+// 进度回调：done 从 1 递增到 total，可用于在其他程序或界面中显示计算进度
+type ProgressFunc func(done, total int)
+
+// buildVolatility 的计算参数，对应同名的命令行参数
+type VolatilityConfig struct {
+	MaxWindow         int       // 最长窗口（分钟）
+	ReturnMode        string    // simple 或 log
+	VarQuantile       float64   // 经验VaR的分位数
+	Weights           []float64 // 时间衰减权重，nil 表示等权
+	WinsorLower       float64   // Winsorize 的下限百分位，0 表示不截断
+	WinsorUpper       float64   // Winsorize 的上限百分位，100 表示不截断
+	Approx            bool      // 用分位数草图近似VaR
+	SketchCompression float64
+	DumpWindow        int // 收集该窗口的每个收益率，0 表示不收集
+}
+
+// 计算 1 到 cfg.MaxWindow 分钟每个窗口的收益率统计，没有样本的窗口不输出；
+// progress 不为 nil 时每算完一个窗口回调一次，done 即该窗口的分钟数，
+// total 为实际计算到的最长窗口（数据不足时小于 MaxWindow）
+func buildVolatility(klines []Kline, prices []float64, cfg VolatilityConfig, progress ProgressFunc) ([]Result, []DumpedReturn) {
+	total := cfg.MaxWindow
+	if total > len(prices)-1 {
+		total = len(prices) - 1
+	}
+	if total < 0 {
+		total = 0
+	}
+	results := make([]Result, 0, total)
+	var dumped []DumpedReturn
+
+	for window := 1; window <= total; window++ {
+		// 计算该窗口的收益率；Approx 时不保存收益率，只更新累加量和分位数草图
+		var returns []float64
+		var acc *returnAccumulator
+		if cfg.Approx {
+			acc = newReturnAccumulator(cfg.SketchCompression)
+		} else {
+			returns = make([]float64, 0, len(prices)-window)
+		}
+		for i := window; i < len(prices); i++ {
+			returnPct := calculateReturn(prices[i-window], prices[i], cfg.ReturnMode)
+			if math.IsNaN(returnPct) {
+				continue
+			}
+			if acc != nil {
+				acc.Add(returnPct)
+			} else {
+				returns = append(returns, returnPct)
+			}
+			if window == cfg.DumpWindow {
+				dumped = append(dumped, DumpedReturn{
+					StartTime:  klines[i-window].Time,
+					EndTime:    klines[i].Time,
+					StartPrice: prices[i-window],
+					EndPrice:   prices[i],
+					ReturnPct:  returnPct,
+				})
+			}
+		}
+
+		var result Result
+		if acc != nil {
+			result = acc.Result(window, cfg.VarQuantile)
+		} else {
+			result = windowResult(window, returns, cfg.Weights, cfg.VarQuantile, cfg.WinsorLower, cfg.WinsorUpper)
+		}
+		if result.SampleCount > 0 {
+			results = append(results, result)
+		}
+		if progress != nil {
+			progress(window, total)
+		}
+	}
+	return results, dumped
+}
+
+// 按窗口分钟数查找结果；没有样本的窗口不在 results 中，不能按下标取
+func findResult(results []Result, window int) (Result, bool) {
+	for _, result := range results {
+		if result.WindowMinutes == window {
+			return result, true
+		}
+	}
+	return Result{}, false
+}
+
+// 按一个窗口的全部收益率计算结果，weights 不为 nil 时使用时间衰减权重；没有收益率时 SampleCount 为 0
+func windowResult(window int, returns, weights []float64, varQuantile, winsorLower, winsorUpper float64) Result {
+	if len(returns) == 1 {
+		return Result{
+			WindowMinutes: window,
+			WindowDays:    float64(window) / 1440.0,
+			MeanPct:       returns[0],
+			StdDevPct:     0.0,
+			SampleCount:   1,
+			VaRPct:        returns[0],
+			RealizedPct:   math.Abs(returns[0]),
+		}
+	}
+	if len(returns) == 0 {
+		return Result{WindowMinutes: window}
+	}
+
+	// VaR 本身就是尾部的度量，用截断之前的收益率
+	stats := returns
+	if winsorLower > 0 || winsorUpper < 100 {
+		stats = winsorize(returns, winsorLower, winsorUpper)
+	}
+
+	var mean, stdDev float64
+	if weights != nil {
+		w := weights[len(weights)-len(stats):]
+		mean = calculateWeightedMean(stats, w)
+		stdDev = calculateWeightedStdDev(stats, w, mean)
+	} else {
+		mean = calculateMean(stats)
+		stdDev = calculateStdDev(stats, mean)
+	}
+	return Result{
+		WindowMinutes: window,
+		WindowDays:    float64(window) / 1440.0,
+		MeanPct:       mean,
+		StdDevPct:     stdDev,
+		SampleCount:   len(returns),
+		VaRPct:        rollingQuantile(returns, varQuantile),
+		RealizedPct:   realizedVolatility(stats) / math.Sqrt(float64(len(stats))),
+	}
+}
+
+// 解析 -winsorize（下限和上限百分位，如 "1,99"），为空时返回 0, 100（不截断）
+func parseWinsorize(value string) (float64, float64, error) {
+	if value == "" {
+		return 0, 100, nil
+	}
+	fields := strings.Split(value, ",")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("无效的 -winsorize: %q（需要两个百分位，如 1,99）", value)
+	}
+	lower, err1 := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	upper, err2 := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if err1 != nil || err2 != nil || !(lower >= 0 && lower < upper && upper <= 100) {
+		return 0, 0, fmt.Errorf("无效的 -winsorize: %q（需要 0 <= 下限 < 上限 <= 100）", value)
+	}
+	return lower, upper, nil
+}
+
+// Winsorize：低于第 lowerPct 百分位的值改为该分位数，高于第 upperPct 百分位的改为该分位数，
+// 分位数与 VaR 使用同一种插值；返回新切片，不修改输入
+func winsorize(returns []float64, lowerPct, upperPct float64) []float64 {
+	if len(returns) == 0 {
+		return nil
+	}
+	lo := rollingQuantile(returns, lowerPct/100)
+	hi := rollingQuantile(returns, upperPct/100)
+	clamped := make([]float64, len(returns))
+	for i, r := range returns {
+		clamped[i] = math.Min(math.Max(r, lo), hi)
+	}
+	return clamped
 }
 
 type Result struct {
@@ -145,9 +450,15 @@ type Result struct {
 	MeanPct       float64
 	StdDevPct     float64
 	SampleCount   int
+	VaRPct        float64 // 收益率的经验分位数（非参数VaR）
+	RealizedPct   float64 // 已实现波动率，按样本数缩放到单个窗口
 }
 
+// 空切片返回0，避免 0/0 得到 NaN
 func calculateMean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0.0
+	}
 	sum := 0.0
 	for _, v := range values {
 		sum += v
@@ -155,6 +466,57 @@ func calculateMean(values []float64) float64 {
 	return sum / float64(len(values))
 }
 
+// 时间衰减权重：最后一个（最新的）权重为1，每往前 halflife 个点权重减半
+func decayWeights(n int, halflife float64) []float64 {
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = math.Pow(0.5, float64(n-1-i)/halflife)
+	}
+	return weights
+}
+
+func calculateWeightedMean(values, weights []float64) float64 {
+	sum, sumW := 0.0, 0.0
+	for i, v := range values {
+		sum += weights[i] * v
+		sumW += weights[i]
+	}
+	if sumW == 0 {
+		return 0.0
+	}
+	return sum / sumW
+}
+
+// 加权标准差，使用可靠性权重（reliability weights）的无偏估计:
+// variance = sum(w*(x-mean)^2) / (V1 - V2/V1)，V1 = sum(w)，V2 = sum(w^2)
+// 权重全部相等时与 calculateStdDev 的 n-1 无偏估计相同
+func calculateWeightedStdDev(values, weights []float64, mean float64) float64 {
+	sumSqDiff, v1, v2 := 0.0, 0.0, 0.0
+	for i, v := range values {
+		diff := v - mean
+		sumSqDiff += weights[i] * diff * diff
+		v1 += weights[i]
+		v2 += weights[i] * weights[i]
+	}
+	denominator := v1 - v2/v1
+	if denominator <= 0 {
+		return 0.0
+	}
+	return math.Sqrt(sumSqDiff / denominator)
+}
+
+// 已实现波动率 sqrt(sum(r^2))，不减均值，数值随样本数增长，比较不同长度的样本时需要除以 sqrt(N)
+// 与标准差的区别：标准差围绕样本均值计算，趋势行情中会扣掉漂移；已实现波动率把漂移也算作波动，
+// 均值接近0的短窗口（分钟级）两者几乎相同，适合衡量一段时间内实际发生的价格变动幅度；
+// 长窗口的重叠收益率带有明显漂移时，z-score 应使用标准差，与 Mean_Pct 配套
+func realizedVolatility(returns []float64) float64 {
+	sumSq := 0.0
+	for _, r := range returns {
+		sumSq += r * r
+	}
+	return math.Sqrt(sumSq)
+}
+
 func calculateStdDev(values []float64, mean float64) float64 {
 	if len(values) <= 1 {
 		return 0.0
@@ -168,3 +530,962 @@ func calculateStdDev(values []float64, mean float64) float64 {
 	return math.Sqrt(variance)
 }
 
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// 经验分位数，分位点之间线性插值：位置 h=(n-1)*q，在第 floor(h) 和 floor(h)+1 小的值之间插值
+// q=0 为最小值，q=0.5 为中位数，q=1 为最大值；作为正态假设VaR的非参数补充
+// 用快速选择代替排序，平均 O(n)，不修改输入
+func rollingQuantile(returns []float64, q float64) float64 {
+	n := len(returns)
+	if n == 0 {
+		return 0
+	}
+
+	values := make([]float64, n)
+	copy(values, returns)
+
+	h := float64(n-1) * q
+	lo := int(math.Floor(h))
+	lower := selectKth(values, lo)
+	if lo+1 >= n {
+		return lower
+	}
+
+	// 快速选择之后下标 lo 右侧的元素都不小于 lower，其中最小的就是第 lo+1 小的值
+	upper := values[lo+1]
+	for _, v := range values[lo+2:] {
+		if v < upper {
+			upper = v
+		}
+	}
+	return lower + (h-float64(lo))*(upper-lower)
+}
+
+// 快速选择：重排 values，使第 k 小的元素位于下标 k，左侧都不大于它，右侧都不小于它
+func selectKth(values []float64, k int) float64 {
+	left, right := 0, len(values)-1
+	for left < right {
+		pivot := values[(left+right)/2]
+		i, j := left, right
+		for i <= j {
+			for values[i] < pivot {
+				i++
+			}
+			for values[j] > pivot {
+				j--
+			}
+			if i <= j {
+				values[i], values[j] = values[j], values[i]
+				i++
+				j--
+			}
+		}
+		if k <= j {
+			right = j
+		} else if k >= i {
+			left = i
+		} else {
+			break
+		}
+	}
+	return values[k]
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 写入版本行，必须在标题行之前调用
+func writeSchemaLine(w io.Writer, version int) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", schemaPrefix, version)
+	return err
+}
+
+// 波动率表的版本，列有变化时加1，并在 volatilitySchemaMigrations 中说明旧版本如何处理
+const volatilitySchemaVersion = 3
+
+// 波动率表的列，新列只追加在末尾
+var volatilityHeader = []string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count", "VaR_Pct", "Realized_Vol_Pct"}
+
+// 用 n 根K线的简单移动平均平滑价格，减少单根K线的噪声，n<=1 时原样返回
+// 平滑会带来约 (n-1)/2 根K线的滞后，并压低短窗口的波动，所以波动率和z-score必须使用相同的 -smooth
+// 前 n-1 个点用已有的K线求平均，保证长度和下标不变
+func smoothPrices(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	smoothed := make([]float64, len(prices))
+	sum := 0.0
+	for i, p := range prices {
+		sum += p
+		count := i + 1
+		if i >= n {
+			sum -= prices[i-n]
+			count = n
+		}
+		smoothed[i] = sum / float64(count)
+	}
+	return smoothed
+}
+
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 价格序列的取法，默认用收盘价
+type PriceMode int
+
+const (
+	PriceClose   PriceMode = iota // 收盘价 C
+	PriceMid                      // 中间价 (H+L)/2
+	PriceTypical                  // 典型价 (H+L+C)/3
+	PriceOHLC4                    // (O+H+L+C)/4
+)
+
+func parsePriceMode(name string) (PriceMode, error) {
+	switch name {
+	case "close":
+		return PriceClose, nil
+	case "mid":
+		return PriceMid, nil
+	case "typical":
+		return PriceTypical, nil
+	case "ohlc4":
+		return PriceOHLC4, nil
+	}
+	return PriceClose, fmt.Errorf("未知的价格取法: %s（可选 close、mid、typical 或 ohlc4）", name)
+}
+
+// 按 mode 取一根K线的价格
+func (k Kline) Price(mode PriceMode) float64 {
+	switch mode {
+	case PriceMid:
+		return (k.High + k.Low) / 2
+	case PriceTypical:
+		return (k.High + k.Low + k.Close) / 3
+	case PriceOHLC4:
+		return (k.Open + k.High + k.Low + k.Close) / 4
+	}
+	return k.Close
+}
+
+// 按 mode 取出整段K线的价格序列
+func klinePrices(klines []Kline, mode PriceMode) []float64 {
+	prices := make([]float64, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Price(mode)
+	}
+	return prices
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// 进度输出。stdout 是终端时用 \r 覆盖同一行，避免刷屏；
+// 重定向到文件或管道、或指定 -q 时每次更新输出一行，方便在日志里查看
+type Progress struct {
+	mu      sync.Mutex
+	w       io.Writer
+	compact bool
+	pending bool // 紧凑模式下当前进度行还没有换行
+}
+
+func newProgress(f *os.File, lineMode bool) *Progress {
+	return &Progress{w: f, compact: !lineMode && isTerminal(f)}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// 输出一条进度，可以在多个 goroutine 中同时调用
+func (p *Progress) Update(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if p.compact {
+		// \033[K 清除上一条更长的进度留下的字符
+		fmt.Fprintf(p.w, "\r%s\033[K", msg)
+		p.pending = true
+		return
+	}
+	fmt.Fprintln(p.w, msg)
+}
+
+// 结束当前进度行，之后的普通输出从新的一行开始
+func (p *Progress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending {
+		fmt.Fprintln(p.w)
+		p.pending = false
+	}
+}
+
+// CSV 中浮点列的小数位数，由 -precision 指定；小于 0 时各列使用自己的默认位数
+var csvPrecision = -1
+
+// 按 -precision 格式化CSV中的浮点数，未指定时使用该列的默认位数
+func formatFloat(value float64, defaultPrecision int) string {
+	precision := defaultPrecision
+	if csvPrecision >= 0 {
+		precision = csvPrecision
+	}
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
+
+// 用最近 n 根K线（含当前）的中位数过滤价格，去掉瞬间的错误报价：单根离群的价格在 n>=3 时会被完全剔除，
+// 而持续的真实行情只会滞后约 (n-1)/2 根K线；n<=1 时原样返回
+// 和 -smooth 一样，波动率和z-score必须使用相同的 -median；前 n-1 个点用已有的K线取中位数
+func medianFilter(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	filtered := make([]float64, len(prices))
+	// 窗口内的价格按升序保存，每次滑动二分查找删除最旧的、插入最新的
+	sorted := make([]float64, 0, n)
+	for i, p := range prices {
+		if i >= n {
+			old := prices[i-n]
+			j := sort.SearchFloat64s(sorted, old)
+			sorted = append(sorted[:j], sorted[j+1:]...)
+		}
+		j := sort.SearchFloat64s(sorted, p)
+		sorted = append(sorted, 0)
+		copy(sorted[j+1:], sorted[j:])
+		sorted[j] = p
+
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 1 {
+			filtered[i] = sorted[mid]
+		} else {
+			filtered[i] = (sorted[mid-1] + sorted[mid]) / 2
+		}
+	}
+	return filtered
+}
+
+// 检测单根K线的异常跳变（例如小数点错位导致价格差10倍），这类错误报价会让波动率和z-score严重失真
+// 与最近一个正常价格相比涨跌幅超过 maxBarMove（0.5 即 50%）的K线视为异常，直到价格回到该范围内为止；
+// 返回修复后的副本（异常K线按两侧正常价格线性插值）和被标记的下标，调用方可以只报告不使用副本
+// 数据末尾没有回到正常范围的异常K线无法插值，只标记不修复；maxBarMove<=0 时不检测
+func sanitizePrices(prices []float64, maxBarMove float64) ([]float64, []int) {
+	if maxBarMove <= 0 || len(prices) == 0 {
+		return prices, nil
+	}
+	repaired := make([]float64, len(prices))
+	copy(repaired, prices)
+
+	var flagged []int
+	lastGood := -1 // 最近一个正常价格的下标，第一个有效价格视为正常
+	for i, p := range prices {
+		if !(p > 0) {
+			continue // 缺失或非正数的价格由收益率计算跳过，这里不处理
+		}
+		if lastGood < 0 || math.Abs(p/prices[lastGood]-1) <= maxBarMove {
+			// 回到正常范围，对中间被标记的K线插值
+			for j := lastGood + 1; lastGood >= 0 && j < i; j++ {
+				if prices[j] > 0 {
+					frac := float64(j-lastGood) / float64(i-lastGood)
+					repaired[j] = prices[lastGood] + (p-prices[lastGood])*frac
+				}
+			}
+			lastGood = i
+			continue
+		}
+		flagged = append(flagged, i)
+	}
+	return repaired, flagged
+}
+
+// 报告异常跳变的K线，repair 为 true 时返回插值修复后的价格，否则原样返回
+func checkPriceSpikes(klines []Kline, prices []float64, maxBarMove float64, repair bool) []float64 {
+	repaired, flagged := sanitizePrices(prices, maxBarMove)
+	if len(flagged) == 0 {
+		return prices
+	}
+	fmt.Printf("警告: %d 根K线与前一个正常价格相差超过 %.0f%%，可能是错误报价（首个在 %s，价格 %v）\n",
+		len(flagged), maxBarMove*100, klines[flagged[0]].Time, prices[flagged[0]])
+	if !repair {
+		fmt.Println("可以用 -repair-spikes 按两侧正常价格插值修复")
+		return prices
+	}
+	unrepaired := 0
+	for _, i := range flagged {
+		if repaired[i] == prices[i] {
+			unrepaired++
+		}
+	}
+	fmt.Printf("已插值修复 %d 根K线", len(flagged)-unrepaired)
+	if unrepaired > 0 {
+		fmt.Printf("，数据末尾的 %d 根没有回到正常范围，无法修复", unrepaired)
+	}
+	fmt.Println()
+	return repaired
+}
+
+// 流式累加一个窗口的收益率：均值和方差用 Welford 算法，平方和用于已实现波动率，分位数用 QuantileSketch
+// 除草图外只占常数内存，用于 -approx
+type returnAccumulator struct {
+	count  int
+	mean   float64
+	m2     float64 // 与均值之差的平方和
+	sumSq  float64
+	first  float64
+	sketch *QuantileSketch
+}
+
+func newReturnAccumulator(compression float64) *returnAccumulator {
+	return &returnAccumulator{sketch: newQuantileSketch(compression)}
+}
+
+func (a *returnAccumulator) Add(x float64) {
+	a.count++
+	if a.count == 1 {
+		a.first = x
+	}
+	delta := x - a.mean
+	a.mean += delta / float64(a.count)
+	a.m2 += delta * (x - a.mean)
+	a.sumSq += x * x
+	a.sketch.Add(x)
+}
+
+// 与 windowResult 的等权结果对应，只有 VaRPct 是近似值
+func (a *returnAccumulator) Result(window int, varQuantile float64) Result {
+	switch a.count {
+	case 0:
+		return Result{WindowMinutes: window}
+	case 1:
+		return windowResult(window, []float64{a.first}, nil, varQuantile, 0, 100)
+	}
+	return Result{
+		WindowMinutes: window,
+		WindowDays:    float64(window) / 1440.0,
+		MeanPct:       a.mean,
+		StdDevPct:     math.Sqrt(a.m2 / float64(a.count-1)),
+		SampleCount:   a.count,
+		VaRPct:        a.sketch.Quantile(varQuantile),
+		RealizedPct:   math.Sqrt(a.sumSq) / math.Sqrt(float64(a.count)),
+	}
+}
+
+// t-digest 的一个质心：若干相邻样本的均值和个数
+type centroid struct {
+	Mean   float64
+	Weight float64
+}
+
+// 有界内存的分位数草图（合并式 t-digest）
+// 样本先进缓冲区，满了之后与已有质心一起排序，相邻质心在规模函数允许的范围内合并，
+// 质心数量少于 compression 个，与样本数无关
+// 精度：规模函数让靠近两端的质心更小，所以尾部分位数（如 1% VaR）最准，中位数附近误差最大，
+// 秩误差大致在 1/compression 量级；compression=200 时中位数的秩误差通常在 0.5% 以内，
+// 1% 分位数的秩误差通常在 0.05% 以内。需要与 rollingQuantile 完全一致的结果时不要使用
+type QuantileSketch struct {
+	compression float64
+	centroids   []centroid
+	buffer      []float64
+	count       float64
+	min, max    float64
+}
+
+func newQuantileSketch(compression float64) *QuantileSketch {
+	return &QuantileSketch{
+		compression: compression,
+		buffer:      make([]float64, 0, int(compression)*5),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// 加入一个样本，NaN 忽略
+func (s *QuantileSketch) Add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+	s.buffer = append(s.buffer, x)
+	s.count++
+	if x < s.min {
+		s.min = x
+	}
+	if x > s.max {
+		s.max = x
+	}
+	if len(s.buffer) == cap(s.buffer) {
+		s.compress()
+	}
+}
+
+// 样本数
+func (s *QuantileSketch) Count() int {
+	return int(s.count)
+}
+
+// 规模函数 k1：把累计比例 q 映射到质心编号，相邻质心的 k 值之差不超过 1
+func (s *QuantileSketch) scale(q float64) float64 {
+	return s.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// 把缓冲区合并进质心
+func (s *QuantileSketch) compress() {
+	if len(s.buffer) == 0 {
+		return
+	}
+	all := make([]centroid, 0, len(s.centroids)+len(s.buffer))
+	all = append(all, s.centroids...)
+	for _, x := range s.buffer {
+		all = append(all, centroid{Mean: x, Weight: 1})
+	}
+	s.buffer = s.buffer[:0]
+	sort.Slice(all, func(i, j int) bool { return all[i].Mean < all[j].Mean })
+
+	merged := all[:1]
+	cumulative := 0.0 // 当前质心之前的总权重
+	limit := s.scale(0) + 1
+	for _, c := range all[1:] {
+		last := &merged[len(merged)-1]
+		q := (cumulative + last.Weight + c.Weight) / s.count
+		if s.scale(q) <= limit {
+			last.Mean += (c.Mean - last.Mean) * c.Weight / (last.Weight + c.Weight)
+			last.Weight += c.Weight
+			continue
+		}
+		cumulative += last.Weight
+		limit = s.scale(cumulative/s.count) + 1
+		merged = append(merged, c)
+	}
+	s.centroids = append(s.centroids[:0], merged...)
+}
+
+// 近似的 q 分位数，与 rollingQuantile 的定义一致（q=0 为最小值，q=1 为最大值）
+// 在相邻质心的中心之间线性插值，两端用精确的最小值和最大值；没有样本时返回 0
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	s.compress()
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 || len(s.centroids) == 1 && s.centroids[0].Weight == 1 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	// 第 k 小（从 0 开始）的样本位置，与 rollingQuantile 的 h=(n-1)*q 对应
+	// 权重为 w 的质心覆盖位置 [cumulative, cumulative+w)，把均值放在 cumulative+(w-1)/2
+	target := (s.count - 1) * q
+	prevPos, prevMean := 0.0, s.min
+	cumulative := 0.0
+	for _, c := range s.centroids {
+		pos := cumulative + (c.Weight-1)/2
+		if target < pos {
+			if pos == prevPos {
+				return c.Mean
+			}
+			return prevMean + (target-prevPos)/(pos-prevPos)*(c.Mean-prevMean)
+		}
+		prevPos, prevMean = pos, c.Mean
+		cumulative += c.Weight
+	}
+	lastPos := s.count - 1
+	if lastPos == prevPos {
+		return s.max
+	}
+	return prevMean + (target-prevPos)/(lastPos-prevPos)*(s.max-prevMean)
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
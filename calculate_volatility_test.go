@@ -0,0 +1,650 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 没有样本的窗口不在结果中，关键窗口要按分钟数查找而不是按下标
+func TestFindResultSparse(t *testing.T) {
+	results := []Result{{WindowMinutes: 1}, {WindowMinutes: 2}, {WindowMinutes: 5}}
+
+	for _, tc := range []struct {
+		window int
+		ok     bool
+	}{
+		{1, true},
+		{5, true},
+		{3, false},
+		{60, false},
+	} {
+		result, ok := findResult(results, tc.window)
+		if ok != tc.ok {
+			t.Errorf("findResult(%d) ok = %v, want %v", tc.window, ok, tc.ok)
+			continue
+		}
+		if ok && result.WindowMinutes != tc.window {
+			t.Errorf("findResult(%d) = %d 分钟窗口", tc.window, result.WindowMinutes)
+		}
+	}
+}
+
+// 在已排序的已知序列上，0、0.5、1 分位数分别是最小值、中位数和最大值，分位点之间线性插值
+func TestRollingQuantile(t *testing.T) {
+	odd := []float64{-3, -1, 0, 2, 4, 7, 9}
+	even := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, tc := range []struct {
+		returns []float64
+		q       float64
+		want    float64
+	}{
+		{odd, 0, -3},
+		{odd, 0.5, 2},
+		{odd, 1, 9},
+		{even, 0, 1},
+		{even, 0.5, 5.5},
+		{even, 1, 10},
+		{even, 0.25, 3.25},
+		{even, 0.01, 1.09},
+		{[]float64{5}, 0.5, 5},
+		{nil, 0.5, 0},
+	} {
+		if got := rollingQuantile(tc.returns, tc.q); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("rollingQuantile(%v, %v) = %v, want %v", tc.returns, tc.q, got, tc.want)
+		}
+	}
+}
+
+// 快速选择的结果与排序后插值相同，且不修改输入
+func TestRollingQuantileMatchesSort(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	returns := make([]float64, 1001)
+	for i := range returns {
+		returns[i] = rng.NormFloat64()
+	}
+	original := append([]float64(nil), returns...)
+	sorted := append([]float64(nil), returns...)
+	sort.Float64s(sorted)
+
+	for _, q := range []float64{0, 0.001, 0.01, 0.05, 0.5, 0.95, 0.99, 1} {
+		h := float64(len(sorted)-1) * q
+		lo := int(math.Floor(h))
+		want := sorted[lo]
+		if lo+1 < len(sorted) {
+			want += (h - float64(lo)) * (sorted[lo+1] - sorted[lo])
+		}
+		if got := rollingQuantile(returns, q); math.Abs(got-want) > 1e-12 {
+			t.Errorf("q=%v: %v, want %v", q, got, want)
+		}
+	}
+	if !reflect.DeepEqual(returns, original) {
+		t.Error("rollingQuantile 修改了输入")
+	}
+}
+
+// 已实现波动率与标准差：均值为0时只差 (N-1)/N 的自由度修正，有漂移时已实现波动率把漂移也算作波动
+func TestRealizedVolatilityVsStdDev(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		returns  []float64
+		realized float64
+		stdDev   float64
+	}{
+		{"正负交替", []float64{1, -1, 1, -1}, 2, math.Sqrt(4.0 / 3)},
+		{"单边上涨", []float64{2, 2, 2, 2}, 4, 0},
+		{"漂移加波动", []float64{3, 1, 3, 1}, math.Sqrt(20), math.Sqrt(4.0 / 3)},
+		{"空序列", nil, 0, 0},
+	} {
+		realized := realizedVolatility(tc.returns)
+		stdDev := calculateStdDev(tc.returns, calculateMean(tc.returns))
+		if math.Abs(realized-tc.realized) > 1e-12 || math.Abs(stdDev-tc.stdDev) > 1e-12 {
+			t.Errorf("%s: 已实现波动率 %v, 标准差 %v, want %v, %v", tc.name, realized, stdDev, tc.realized, tc.stdDev)
+		}
+	}
+}
+
+// 写入CSV的 Realized_Vol_Pct 按样本数缩放到单个窗口：零均值的收益率上与标准差基本一致
+func TestRealizedVolatilityScaled(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	klines := make([]Kline, 5000)
+	prices := make([]float64, len(klines))
+	price := 2000.0
+	for i := range klines {
+		price *= 1 + rng.NormFloat64()*0.001
+		klines[i] = Kline{OpenTime: 1767225600000 + int64(i)*60000, Time: "2026-01-01 00:00:00", Close: price}
+		prices[i] = price
+	}
+	results, _ := buildVolatility(klines, prices, VolatilityConfig{MaxWindow: 60, ReturnMode: "log", VarQuantile: 0.01, WinsorUpper: 100}, nil)
+	for _, window := range []int{1, 5, 60} {
+		result, ok := findResult(results, window)
+		if !ok {
+			t.Fatalf("没有 %d 分钟窗口", window)
+		}
+		// 按窗口内的样本数缩放后与单个收益率同量级：1 分钟约 0.1%，60 分钟约 0.1%*sqrt(60)
+		if ratio := result.RealizedPct / result.StdDevPct; ratio < 0.95 || ratio > 1.1 {
+			t.Errorf("%d 分钟: 已实现波动率 %v, 标准差 %v", window, result.RealizedPct, result.StdDevPct)
+		}
+		if want := 0.1 * math.Sqrt(float64(window)); math.Abs(result.RealizedPct-want) > 0.2*want {
+			t.Errorf("%d 分钟: 已实现波动率 %v, want ≈ %v", window, result.RealizedPct, want)
+		}
+	}
+}
+
+// 编译本工具，并在 dir/in 中写入两天的1分钟K线，返回可执行文件和输入目录
+func buildVolatilityTool(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("-short 时跳过编译工具")
+	}
+	binary := filepath.Join(dir, "calculate_volatility")
+	if out, err := exec.Command("go", "build", "-o", binary, "calculate_volatility.go").CombinedOutput(); err != nil {
+		t.Fatalf("编译失败: %v\n%s", err, out)
+	}
+
+	inDir := filepath.Join(dir, "in")
+	if err := os.Mkdir(inDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{"Open Time,Open Time (UTC),Open,High,Low,Close,Volume"}
+	for i := 0; i < 2*1440; i++ {
+		price := 2000 + 10*math.Sin(float64(i)/7)
+		lines = append(lines, fmt.Sprintf("%d,2026-01-01 00:00:00,%f,%f,%f,%f,1", 1767225600000+int64(i)*60000, price, price+1, price-1, price))
+	}
+	if err := os.WriteFile(filepath.Join(inDir, "ETHUSDT_minute_klines.csv"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return binary, inDir
+}
+
+func runTool(t *testing.T, workDir, binary string, args ...string) {
+	t.Helper()
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = workDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("运行失败: %v\n%s", err, out)
+	}
+}
+
+// 在临时目录中运行工具：K线从 -input-dir 读取，结果写入 -output-dir（不存在时创建），工作目录中不留下任何文件
+func TestOutputDir(t *testing.T) {
+	tmp := t.TempDir()
+	binary, inDir := buildVolatilityTool(t, tmp)
+	outDir := filepath.Join(tmp, "out", "ETH")
+	workDir := filepath.Join(tmp, "work")
+	if err := os.Mkdir(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	runTool(t, workDir, binary, "-input-dir", inDir, "-output-dir", outDir, "-lookback-days", "1", "-q")
+
+	if _, err := os.Stat(filepath.Join(outDir, "multi_timeframe_volatility.csv")); err != nil {
+		t.Errorf("输出目录中没有结果: %v", err)
+	}
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("工作目录中写入了 %s", entry.Name())
+	}
+}
+
+// 指定 -cpuprofile 和 -memprofile 时写出非空的 profile 文件
+func TestProfileFlags(t *testing.T) {
+	tmp := t.TempDir()
+	binary, inDir := buildVolatilityTool(t, tmp)
+	cpuPath := filepath.Join(tmp, "cpu.pprof")
+	memPath := filepath.Join(tmp, "mem.pprof")
+
+	runTool(t, tmp, binary, "-input-dir", inDir, "-output-dir", tmp, "-lookback-days", "1", "-q",
+		"-cpuprofile", cpuPath, "-memprofile", memPath)
+
+	for _, path := range []string{cpuPath, memPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("没有写入 profile: %v", err)
+			continue
+		}
+		if info.Size() == 0 {
+			t.Errorf("%s 是空文件", filepath.Base(path))
+		}
+	}
+}
+
+// 最近一段波动放大时，时间衰减的标准差接近最近的波动水平，等权标准差被之前的平静期拉低
+func TestDecayedStdDevTracksRecentSpike(t *testing.T) {
+	returns := make([]float64, 1000)
+	for i := range returns {
+		size := 0.1
+		if i >= 900 {
+			size = 1.0
+		}
+		if i%2 == 0 {
+			returns[i] = size
+		} else {
+			returns[i] = -size
+		}
+	}
+
+	equal := calculateStdDev(returns, calculateMean(returns))
+	weights := decayWeights(len(returns), 30)
+	decayed := calculateWeightedStdDev(returns, weights, calculateWeightedMean(returns, weights))
+	if !(equal > 0.3 && equal < 0.4) {
+		t.Errorf("等权标准差 = %v, want 约 0.35", equal)
+	}
+	if !(decayed > 0.95 && decayed < 1.05) {
+		t.Errorf("衰减标准差 = %v, want 约 1（最近的波动水平）", decayed)
+	}
+
+	// 权重全部相等时与 calculateStdDev 的无偏估计相同
+	ones := make([]float64, len(returns))
+	for i := range ones {
+		ones[i] = 1
+	}
+	if got := calculateWeightedStdDev(returns, ones, calculateWeightedMean(returns, ones)); math.Abs(got-equal) > 1e-12 {
+		t.Errorf("等权重的加权标准差 = %v, want %v", got, equal)
+	}
+
+	// 最新的权重为1，往前一个半衰期减半
+	if weights[999] != 1 || math.Abs(weights[969]-0.5) > 1e-12 {
+		t.Errorf("权重: 最新 %v, 30 个点之前 %v, want 1, 0.5", weights[999], weights[969])
+	}
+}
+
+// K线文件中有一行无法解析：默认跳过并给出警告，-strict 时报错退出
+func TestStrictMalformedRow(t *testing.T) {
+	tmp := t.TempDir()
+	binary, inDir := buildVolatilityTool(t, tmp)
+	path := filepath.Join(inDir, "ETHUSDT_minute_klines.csv")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	lines[100] = "1767231480000,2026-01-01 01:38:00,2000,abc,1999,2000,1"
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-input-dir", inDir, "-output-dir", tmp, "-lookback-days", "1", "-q"}
+	out, err := exec.Command(binary, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("默认模式运行失败: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "跳过 1 / 2880 行无法解析的数据（首个错误在第 101 行") {
+		t.Errorf("默认模式没有警告:\n%s", out)
+	}
+
+	out, err = exec.Command(binary, append(args, "-strict")...).CombinedOutput()
+	if err == nil {
+		t.Fatalf("-strict 应报错退出:\n%s", out)
+	}
+	if !strings.Contains(string(out), "第 101 行解析失败") {
+		t.Errorf("-strict 的错误信息中没有出错的行:\n%s", out)
+	}
+}
+
+// 结果中的浮点列按 -precision 的位数写出，未指定时使用各列默认位数
+func TestPrecisionFlag(t *testing.T) {
+	tmp := t.TempDir()
+	binary, inDir := buildVolatilityTool(t, tmp)
+	for _, tc := range []struct {
+		precision string
+		want      []int // Window_Days, Mean_Pct, StdDev_Pct, VaR_Pct, Realized_Vol_Pct 的小数位数
+	}{
+		{"3", []int{3, 3, 3, 3, 3}},
+		{"", []int{4, 6, 6, 6, 6}},
+	} {
+		args := []string{"-input-dir", inDir, "-output-dir", tmp, "-lookback-days", "1", "-q"}
+		if tc.precision != "" {
+			args = append(args, "-precision", tc.precision)
+		}
+		runTool(t, tmp, binary, args...)
+
+		data, err := os.ReadFile(filepath.Join(tmp, "multi_timeframe_volatility.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := strings.Split(strings.TrimSpace(string(data)), "\n")[2:]
+		if len(rows) == 0 {
+			t.Fatal("没有结果")
+		}
+		for _, row := range rows {
+			fields := strings.Split(row, ",")
+			for i, column := range []int{1, 2, 3, 5, 6} {
+				_, decimals, _ := strings.Cut(fields[column], ".")
+				if len(decimals) != tc.want[i] {
+					t.Fatalf("-precision %q: 第 %d 列 %q 有 %d 位小数, want %d", tc.precision, column, fields[column], len(decimals), tc.want[i])
+				}
+			}
+		}
+	}
+}
+
+// 价格序列中有0时，引用到它的收益率被跳过，结果中没有 NaN/Inf；没有价格或只有一个价格时没有结果
+func TestBuildVolatilityZeroPrices(t *testing.T) {
+	prices := make([]float64, 200)
+	klines := make([]Kline, len(prices))
+	for i := range prices {
+		prices[i] = 2000 + 10*math.Sin(float64(i)/5)
+		klines[i] = Kline{OpenTime: 1767225600000 + int64(i)*60000, Close: prices[i]}
+	}
+	prices[50], prices[120] = 0, 0
+	for _, weights := range [][]float64{nil, decayWeights(len(prices)-1, 30)} {
+		cfg := VolatilityConfig{MaxWindow: 60, ReturnMode: "simple", VarQuantile: 0.05, WinsorUpper: 100, Weights: weights}
+		results, _ := buildVolatility(klines, prices, cfg, nil)
+		if len(results) != 60 {
+			t.Fatalf("%d 个窗口, want 60", len(results))
+		}
+		for _, r := range results {
+			for _, v := range []float64{r.MeanPct, r.StdDevPct, r.VaRPct, r.RealizedPct} {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					t.Fatalf("%d 分钟窗口: %+v", r.WindowMinutes, r)
+				}
+			}
+			want := 0
+			for i := r.WindowMinutes; i < len(prices); i++ {
+				if prices[i-r.WindowMinutes] > 0 && prices[i] > 0 {
+					want++
+				}
+			}
+			if r.SampleCount != want {
+				t.Errorf("%d 分钟窗口样本数 %d, want %d（跳过引用0价格的收益率）", r.WindowMinutes, r.SampleCount, want)
+			}
+		}
+	}
+
+	for _, prices := range [][]float64{nil, {2000}} {
+		cfg := VolatilityConfig{MaxWindow: 60, ReturnMode: "simple", WinsorUpper: 100}
+		if results, _ := buildVolatility(make([]Kline, len(prices)), prices, cfg, nil); len(results) != 0 {
+			t.Errorf("%d 个价格: %d 个结果", len(prices), len(results))
+		}
+	}
+	if mean := calculateWeightedMean([]float64{1, 2}, []float64{0, 0}); mean != 0 {
+		t.Errorf("权重全为0时均值 = %v, want 0", mean)
+	}
+}
+
+// -lookback-days 决定最长的窗口：两天的数据上 1 天计算 1 到 1440 分钟窗口；
+// 2 天超出了数据范围，给出警告并只计算到数据能覆盖的 2879 分钟
+func TestLookbackDaysWindowRange(t *testing.T) {
+	tmp := t.TempDir()
+	binary, inDir := buildVolatilityTool(t, tmp)
+	for _, tc := range []struct {
+		days      string
+		maxWindow int
+		warning   bool
+	}{
+		{"1", 1440, false},
+		{"2", 2879, true},
+	} {
+		out, err := exec.Command(binary, "-input-dir", inDir, "-output-dir", tmp, "-lookback-days", tc.days, "-q").CombinedOutput()
+		if err != nil {
+			t.Fatalf("-lookback-days %s 运行失败: %v\n%s", tc.days, err, out)
+		}
+		if warned := strings.Contains(string(out), "不足以覆盖"); warned != tc.warning {
+			t.Errorf("-lookback-days %s: 警告 = %v, want %v\n%s", tc.days, warned, tc.warning, out)
+		}
+
+		data, err := os.ReadFile(filepath.Join(tmp, "multi_timeframe_volatility.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := strings.Split(strings.TrimSpace(string(data)), "\n")[2:]
+		first, _, _ := strings.Cut(rows[0], ",")
+		last, _, _ := strings.Cut(rows[len(rows)-1], ",")
+		if len(rows) != tc.maxWindow || first != "1" || last != strconv.Itoa(tc.maxWindow) {
+			t.Errorf("-lookback-days %s: %d 个窗口（%s 到 %s）, want 1 到 %d", tc.days, len(rows), first, last, tc.maxWindow)
+		}
+	}
+}
+
+// -dump-window 输出的收益率与直接按价格重新计算的一致（跳过引用0价格的收益率），
+// 数量等于该窗口的样本数，均值等于结果中的 Mean_Pct；写出的CSV按行对应
+func TestDumpWindowMatchesRecomputation(t *testing.T) {
+	const window = 15
+	rng := rand.New(rand.NewSource(6))
+	prices := make([]float64, 300)
+	klines := make([]Kline, len(prices))
+	price := 2000.0
+	for i := range prices {
+		price *= 1 + rng.NormFloat64()*0.001
+		prices[i] = price
+		klines[i] = Kline{OpenTime: 1767225600000 + int64(i)*60000, Time: fmt.Sprintf("t%03d", i), Close: price}
+	}
+	prices[100] = 0
+
+	cfg := VolatilityConfig{MaxWindow: 30, ReturnMode: "log", VarQuantile: 0.05, WinsorUpper: 100, DumpWindow: window}
+	results, dumped := buildVolatility(klines, prices, cfg, nil)
+
+	var want []DumpedReturn
+	for i := window; i < len(prices); i++ {
+		if prices[i-window] > 0 && prices[i] > 0 {
+			want = append(want, DumpedReturn{
+				StartTime:  klines[i-window].Time,
+				EndTime:    klines[i].Time,
+				StartPrice: prices[i-window],
+				EndPrice:   prices[i],
+				ReturnPct:  math.Log(prices[i]/prices[i-window]) * 100,
+			})
+		}
+	}
+	if len(dumped) != len(want) {
+		t.Fatalf("输出 %d 个收益率, want %d", len(dumped), len(want))
+	}
+	sum := 0.0
+	for i := range want {
+		got := dumped[i]
+		if got.StartTime != want[i].StartTime || got.EndTime != want[i].EndTime || got.StartPrice != want[i].StartPrice ||
+			got.EndPrice != want[i].EndPrice || math.Abs(got.ReturnPct-want[i].ReturnPct) > 1e-12 {
+			t.Fatalf("第 %d 个收益率 = %+v, want %+v", i, got, want[i])
+		}
+		sum += got.ReturnPct
+	}
+	result := results[window-1]
+	if result.WindowMinutes != window || result.SampleCount != len(dumped) || math.Abs(result.MeanPct-sum/float64(len(dumped))) > 1e-12 {
+		t.Errorf("%d 分钟窗口结果 %+v 与输出的 %d 个收益率不一致", window, result, len(dumped))
+	}
+
+	path := filepath.Join(t.TempDir(), "returns_15min.csv")
+	if err := writeDumpedReturns(path, dumped); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(dumped)+2 || lines[1] != "Start_Time,End_Time,Start_Price,End_Price,Return_Pct" {
+		t.Fatalf("CSV 有 %d 行，标题 %q", len(lines), lines[1])
+	}
+	fields := strings.Split(lines[2], ",")
+	if r, err := strconv.ParseFloat(fields[4], 64); err != nil || fields[0] != want[0].StartTime || math.Abs(r-want[0].ReturnPct) > 1e-8 {
+		t.Errorf("CSV 第一个收益率 = %q", lines[2])
+	}
+
+	if _, dumped := buildVolatility(klines, prices, VolatilityConfig{MaxWindow: 30, ReturnMode: "log", WinsorUpper: 100}, nil); dumped != nil {
+		t.Errorf("没有指定 -dump-window 时输出了 %d 个收益率", len(dumped))
+	}
+}
+
+// 20 万个厚尾样本上草图的分位数与精确分位数相比，秩误差在中间不超过 0.5%、尾部（1% 及以下）不超过 0.05%；
+// 质心数量不随样本数增长，0 和 1 分位数为精确的最小值和最大值
+func TestQuantileSketchAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	samples := make([]float64, 200000)
+	sketch := newQuantileSketch(200)
+	for i := range samples {
+		x := rng.NormFloat64()
+		if rng.Float64() < 0.05 {
+			x *= 5
+		}
+		samples[i] = x
+		sketch.Add(x)
+	}
+	sketch.Add(math.NaN())
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	if sketch.Count() != len(samples) {
+		t.Errorf("Count = %d, want %d", sketch.Count(), len(samples))
+	}
+	for _, tc := range []struct{ q, maxRankErr float64 }{
+		{0.001, 0.0005},
+		{0.01, 0.0005},
+		{0.05, 0.002},
+		{0.25, 0.005},
+		{0.5, 0.005},
+		{0.75, 0.005},
+		{0.95, 0.002},
+		{0.99, 0.0005},
+		{0.999, 0.0005},
+	} {
+		got := sketch.Quantile(tc.q)
+		rank := float64(sort.SearchFloat64s(sorted, got)) / float64(len(sorted))
+		if math.Abs(rank-tc.q) > tc.maxRankErr {
+			t.Errorf("%g 分位数 = %v（秩 %.5f）, 精确值 %v, 秩误差超过 %g",
+				tc.q, got, rank, rollingQuantile(samples, tc.q), tc.maxRankErr)
+		}
+	}
+	if sketch.Quantile(0) != sorted[0] || sketch.Quantile(1) != sorted[len(sorted)-1] {
+		t.Errorf("0/1 分位数 = %v/%v, want %v/%v", sketch.Quantile(0), sketch.Quantile(1), sorted[0], sorted[len(sorted)-1])
+	}
+	if n := len(sketch.centroids); n >= 200 {
+		t.Errorf("质心 %d 个, want 少于 compression", n)
+	}
+	if got := newQuantileSketch(200).Quantile(0.5); got != 0 {
+		t.Errorf("没有样本时 = %v, want 0", got)
+	}
+}
+
+// -approx 的结果除 VaR 外与精确计算一致，VaR 与精确值的差距在一个很小的范围内
+func TestApproxVolatilityMatchesExact(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	prices := make([]float64, 5000)
+	klines := make([]Kline, len(prices))
+	price := 2000.0
+	for i := range prices {
+		price *= 1 + rng.NormFloat64()*0.001
+		prices[i] = price
+		klines[i] = Kline{OpenTime: 1767225600000 + int64(i)*60000, Close: price}
+	}
+	cfg := VolatilityConfig{MaxWindow: 60, ReturnMode: "simple", VarQuantile: 0.05, WinsorUpper: 100, SketchCompression: 200}
+	exact, _ := buildVolatility(klines, prices, cfg, nil)
+	cfg.Approx = true
+	approx, _ := buildVolatility(klines, prices, cfg, nil)
+	if len(approx) != len(exact) {
+		t.Fatalf("%d 个窗口, want %d", len(approx), len(exact))
+	}
+	for i := range exact {
+		e, a := exact[i], approx[i]
+		if a.SampleCount != e.SampleCount || math.Abs(a.MeanPct-e.MeanPct) > 1e-12 ||
+			math.Abs(a.StdDevPct-e.StdDevPct) > 1e-9 || math.Abs(a.RealizedPct-e.RealizedPct) > 1e-9 {
+			t.Errorf("%d 分钟: -approx %+v, 精确 %+v", e.WindowMinutes, a, e)
+		}
+		if math.Abs(a.VaRPct-e.VaRPct) > 0.02*e.StdDevPct {
+			t.Errorf("%d 分钟 VaR: -approx %v, 精确 %v", e.WindowMinutes, a.VaRPct, e.VaRPct)
+		}
+	}
+}
+
+// 一周（10080 根）1分钟K线上计算 1 到 1440 分钟窗口的波动率
+func BenchmarkComputeVolatility(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	klines := make([]Kline, 10080)
+	prices := make([]float64, len(klines))
+	price := 2000.0
+	for i := range klines {
+		price *= 1 + rng.NormFloat64()*0.001
+		klines[i] = Kline{OpenTime: 1767225600000 + int64(i)*60000, Time: "2026-01-01 00:00:00", Close: price}
+		prices[i] = price
+	}
+	cfg := VolatilityConfig{MaxWindow: 1440, ReturnMode: "simple", VarQuantile: 0.01, WinsorUpper: 100}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildVolatility(klines, prices, cfg, nil)
+	}
+}
+
+// 已知序列上 Winsorize 按指定百分位截断：0..100 两端换成极端值后，1,99 把它们截到 1 和 99，
+// 其余值不变；分位数落在两个值之间时按线性插值截断；不修改输入
+func TestWinsorize(t *testing.T) {
+	returns := make([]float64, 101)
+	for i := range returns {
+		returns[i] = float64(i)
+	}
+	returns[0], returns[100] = -50, 500
+	rand.New(rand.NewSource(1163)).Shuffle(len(returns), func(i, j int) { returns[i], returns[j] = returns[j], returns[i] })
+	original := append([]float64(nil), returns...)
+
+	clamped := winsorize(returns, 1, 99)
+	if !reflect.DeepEqual(returns, original) {
+		t.Error("winsorize 修改了输入")
+	}
+	for i, r := range returns {
+		want := math.Min(math.Max(r, 1), 99)
+		if clamped[i] != want {
+			t.Errorf("第 %d 个 %v 截断为 %v, want %v", i, r, clamped[i], want)
+		}
+	}
+	if mean := calculateMean(clamped); mean != 50 {
+		t.Errorf("截断后均值 = %v, want 50", mean)
+	}
+
+	small := []float64{10, 0, 3, 7, 1, 9, 2, 8, 5, 4, 6}
+	got := winsorize(small, 5, 95)
+	sort.Float64s(got)
+	if want := []float64{0.5, 1, 2, 3, 4, 5, 6, 7, 8, 9, 9.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("5,95 截断 = %v, want %v", got, want)
+	}
+	if got := winsorize(small, 0, 100); !reflect.DeepEqual(got, small) {
+		t.Errorf("0,100 不应截断: %v", got)
+	}
+
+	for value, want := range map[string][2]float64{"": {0, 100}, "1,99": {1, 99}, " 2.5 , 97.5 ": {2.5, 97.5}} {
+		lower, upper, err := parseWinsorize(value)
+		if err != nil || lower != want[0] || upper != want[1] {
+			t.Errorf("parseWinsorize(%q) = %v, %v, %v", value, lower, upper, err)
+		}
+	}
+	for _, value := range []string{"1", "99,1", "-1,99", "1,101", "a,b", "5,5"} {
+		if _, _, err := parseWinsorize(value); err == nil {
+			t.Errorf("parseWinsorize(%q) 应返回错误", value)
+		}
+	}
+}
+
+// 进度回调按窗口依次调用，done 从 1 递增到 total；数据不足时 total 为实际算到的最长窗口
+func TestBuildVolatilityProgress(t *testing.T) {
+	prices := make([]float64, 100)
+	klines := make([]Kline, len(prices))
+	for i := range prices {
+		prices[i] = 2000 + 10*math.Sin(float64(i)/5)
+		klines[i] = Kline{OpenTime: 1767225600000 + int64(i)*60000, Close: prices[i]}
+	}
+	for _, tc := range []struct{ maxWindow, total int }{{30, 30}, {1440, 99}} {
+		var calls [][2]int
+		cfg := VolatilityConfig{MaxWindow: tc.maxWindow, ReturnMode: "simple", VarQuantile: 0.05, WinsorUpper: 100}
+		results, _ := buildVolatility(klines, prices, cfg, func(done, total int) {
+			calls = append(calls, [2]int{done, total})
+		})
+		if len(calls) != tc.total || len(results) != tc.total {
+			t.Fatalf("MaxWindow=%d: 回调 %d 次, %d 个结果, want %d", tc.maxWindow, len(calls), len(results), tc.total)
+		}
+		for i, call := range calls {
+			if call != [2]int{i + 1, tc.total} {
+				t.Fatalf("MaxWindow=%d: 第 %d 次回调 (%d, %d), want (%d, %d)", tc.maxWindow, i+1, call[0], call[1], i+1, tc.total)
+			}
+		}
+	}
+}
@@ -1,116 +1,188 @@
 package main
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 func main() {
-	fmt.Println("正在读取数据...")
-
-	// 读取价格数据
-	priceFile, err := os.Open("ETHUSDT_minute_klines.csv")
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log（z-score 需与 calculate_volatility 使用同一方式）")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	smooth := flag.Int("smooth", 1, "价格平滑的K线数（简单移动平均），1 表示不平滑；需与 calculate_volatility 使用相同的值")
+	median := flag.Int("median", 1, "中值滤波的K线数（去掉瞬间的错误报价），1 表示不过滤；需与 calculate_volatility 使用相同的值")
+	maxBarMove := flag.Float64("max-bar-move", 0.5, "单根K线相对前一个正常价格的最大涨跌幅（0.5 即 50%），超过的视为错误报价并报告；0 表示不检测")
+	repairSpikes := flag.Bool("repair-spikes", false, "按两侧正常价格线性插值修复超过 -max-bar-move 的K线（默认只报告）；需与 calculate_volatility 使用相同的设置")
+	priceModeName := flag.String("price-mode", "close", "价格取法: close、mid、typical 或 ohlc4（需与 calculate_volatility 使用同一取法）")
+	windowsFlag := flag.String("windows", "", "关键时间窗口（分钟，逗号分隔），默认 1,5,15,30,60,120,240,1440,2880,4320,10080")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	rankNormalize := flag.Bool("rank-normalize", false, "秩变换：把当前收益率在该窗口全部历史收益率中的经验分位数映射为正态得分作为z-score，不假设收益率服从正态分布（肥尾时极端值不会被高估）；Mean_Pct/StdDev_Pct 仍为波动率表中的值，仅供参考")
+	ewmaLambda := flag.Float64("ewma-lambda", 0, "EWMA 衰减系数 λ（0 到 1 之间，如 0.94）：z-score 改用截至上一分钟的指数加权波动率标准化（均值按 0），波动率上升后极端程度随之下调；不使用波动率表，Mean_Pct 写 0、StdDev_Pct 写 EWMA 波动率；0 表示不使用（默认）")
+	flag.IntVar(&csvPrecision, "precision", -1, "CSV中浮点列的小数位数，-1 表示使用各列默认位数（默认 Z_Score 和 Window_Days 4 位，其余 6 位）")
+	flag.Parse()
+	windows, err := parseWindows(*windowsFlag)
 	if err != nil {
-		log.Fatal("无法打开价格文件:", err)
+		log.Fatal(err)
 	}
-	defer priceFile.Close()
-
-	priceReader := csv.NewReader(priceFile)
-	priceRecords, err := priceReader.ReadAll()
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+	priceMode, err := parsePriceMode(*priceModeName)
 	if err != nil {
-		log.Fatal("读取价格CSV失败:", err)
+		log.Fatal(err)
+	}
+	if *smooth < 1 {
+		log.Fatalf("-smooth 必须大于等于 1: %d", *smooth)
+	}
+	if *median < 1 {
+		log.Fatalf("-median 必须大于等于 1: %d", *median)
+	}
+	if *maxBarMove < 0 {
+		log.Fatalf("-max-bar-move 不能为负数: %v", *maxBarMove)
+	}
+	if *ewmaLambda < 0 || *ewmaLambda >= 1 {
+		log.Fatalf("-ewma-lambda 必须在 0 到 1 之间（不含 1）: %v", *ewmaLambda)
+	}
+	if *ewmaLambda > 0 && *rankNormalize {
+		log.Fatal("-ewma-lambda 不能与 -rank-normalize 同时使用")
 	}
 
-	// 解析价格数据（跳过标题行）
-	prices := make([]float64, 0, len(priceRecords)-1)
-	for i := 1; i < len(priceRecords); i++ {
-		if len(priceRecords[i]) < 6 {
-			continue
-		}
-		closePrice, err := strconv.ParseFloat(priceRecords[i][5], 64) // Close在索引5
-		if err != nil {
-			continue
-		}
-		prices = append(prices, closePrice)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
+	fmt.Println("正在读取数据...")
+
+	// 读取价格数据
+	klines, _, err := loadKlines(inputPath(klinesFileName("minute_klines")), *strict)
+	if err != nil {
+		log.Fatal("读取价格数据失败:", err)
 	}
 
+	prices := klinePrices(klines, priceMode)
+	prices = checkPriceSpikes(klines, prices, *maxBarMove, *repairSpikes)
+	prices = medianFilter(prices, *median)
+	prices = smoothPrices(prices, *smooth)
+
 	if len(prices) == 0 {
 		log.Fatal("没有有效的价格数据")
 	}
+	if *strict && len(prices) <= 1440 {
+		log.Fatalf("数据不足，需要至少 %d 条，实际只有 %d 条（-strict）", 1440+1, len(prices))
+	}
 
 	lastPrice := prices[len(prices)-1]
 	fmt.Printf("最后时刻价格: %.2f\n", lastPrice)
 	fmt.Printf("数据总条数: %d\n\n", len(prices))
 
-	// 读取波动率数据
-	volFile, err := os.Open("multi_timeframe_volatility.csv")
-	if err != nil {
-		log.Fatal("无法打开波动率文件:", err)
-	}
-	defer volFile.Close()
-
-	volReader := csv.NewReader(volFile)
-	volRecords, err := volReader.ReadAll()
-	if err != nil {
-		log.Fatal("读取波动率CSV失败:", err)
-	}
-
-	// 解析波动率数据（跳过标题行）
-	volatilityData := make(map[int]VolatilityData)
-	for i := 1; i < len(volRecords); i++ {
-		if len(volRecords[i]) < 5 {
-			continue
-		}
-		window, err := strconv.Atoi(volRecords[i][0])
-		if err != nil {
-			continue
-		}
-		mean, err := strconv.ParseFloat(volRecords[i][2], 64)
+	// 读取波动率数据，EWMA 模式不需要
+	var volatilityData map[int]VolatilityData
+	if *ewmaLambda == 0 {
+		volatilityData, err = loadVolatilityData(inputPath("multi_timeframe_volatility.csv"), *strict)
 		if err != nil {
-			continue
+			log.Fatal(err)
 		}
-		stdDev, err := strconv.ParseFloat(volRecords[i][3], 64)
-		if err != nil {
-			continue
-		}
-		volatilityData[window] = VolatilityData{
-			Mean:   mean,
-			StdDev: stdDev,
+		if err := checkVolatilityFreshness(volatilityData, len(prices)); err != nil {
+			if *strict {
+				log.Fatal(err)
+			}
+			fmt.Printf("警告: %v\n\n", err)
 		}
 	}
 
 	fmt.Println("开始计算z-score...")
-	fmt.Println("时间窗口范围: 1分钟到1440分钟（1天）\n")
+	if *rankNormalize {
+		fmt.Println("使用秩变换：z-score 为当前收益率在历史收益率中的分位数对应的正态得分")
+	}
+	if *ewmaLambda > 0 {
+		fmt.Printf("使用 EWMA 波动率（λ=%g）：z-score = 收益率 / 截至上一分钟的指数加权标准差\n", *ewmaLambda)
+	}
+	fmt.Print("时间窗口范围: 1分钟到1440分钟（1天）\n\n")
 
 	// 计算z-score
 	results := make([]ZScoreResult, 0, 1440)
+	missing := 0
 
 	for window := 1; window <= 1440 && window < len(prices); window++ {
 		// 计算最后时刻相对于窗口前价格的收益率
 		prevPrice := prices[len(prices)-1-window]
-		returnPct := ((lastPrice - prevPrice) / prevPrice) * 100
+		returnPct := calculateReturn(prevPrice, lastPrice, *returnMode)
+		// 只需要最后时刻的斜率，只传入最后 window+1 个价格
+		trend := trendSlope(prices[len(prices)-1-window:], window)[window]
+
+		// 秩变换不需要均值和标准差，缺少波动率数据时只是这两列写 NaN
+		rankZ := math.NaN()
+		if *rankNormalize {
+			rankZ = rankNormalScore(windowReturns(prices, window, *returnMode), returnPct)
+		}
 
 		// 获取该窗口的均值和标准差
+		// 缺少波动率数据时写 NaN，和 z=0（接近均值）区分开
 		volData, exists := volatilityData[window]
+		ewmaZ := math.NaN()
+		if *ewmaLambda > 0 {
+			// EWMA 模式不用波动率表，均值为 0，标准差为截至上一分钟的 EWMA 波动率
+			volData, exists = VolatilityData{StdDev: math.NaN()}, true
+			if returns := windowReturns(prices, window, *returnMode); len(returns) > 0 && !math.IsNaN(returnPct) {
+				volData = VolatilityData{StdDev: ewmaVolatility(returns, *ewmaLambda)[len(returns)-1], SampleCount: len(returns)}
+				ewmaZ = ewmaZScore(returns, *ewmaLambda)[len(returns)-1]
+			}
+		}
 		if !exists {
+			if *strict {
+				log.Fatalf("波动率数据中没有 %d 分钟窗口（-strict）", window)
+			}
+			missing++
+			results = append(results, ZScoreResult{
+				WindowMinutes: window,
+				WindowDays:    float64(window) / 1440.0,
+				ReturnPct:     returnPct,
+				TrendSlopePct: trend,
+				Mean:          math.NaN(),
+				StdDev:        math.NaN(),
+				ZScore:        rankZ,
+			})
 			continue
 		}
 
 		// 计算z-score: (收益率 - 均值) / 标准差
 		var zScore float64
-		if volData.StdDev > 0 {
+		if *rankNormalize {
+			zScore = rankZ
+		} else if *ewmaLambda > 0 {
+			zScore = ewmaZ
+		} else if volData.StdDev > 0 {
 			zScore = (returnPct - volData.Mean) / volData.StdDev
 		} else {
-			zScore = 0
+			// 标准差为0时z-score没有意义，和缺少波动率数据一样写 NaN
+			zScore = math.NaN()
 		}
 
 		results = append(results, ZScoreResult{
 			WindowMinutes: window,
 			WindowDays:    float64(window) / 1440.0,
 			ReturnPct:     returnPct,
+			TrendSlopePct: trend,
 			Mean:          volData.Mean,
 			StdDev:        volData.StdDev,
 			ZScore:        zScore,
@@ -125,79 +197,1138 @@ func main() {
 
 	// 保存结果到CSV
 	fmt.Println("\n正在保存结果到CSV...")
-	outputFile, err := os.Create("zscore_results.csv")
-	if err != nil {
-		log.Fatal("创建输出文件失败:", err)
-	}
-	defer outputFile.Close()
+	err = writeFileAtomic(outputPath("zscore_results.csv"), func(w io.Writer) error {
+		if err := writeSchemaLine(w, zscoreResultsSchemaVersion); err != nil {
+			return err
+		}
 
-	writer := csv.NewWriter(outputFile)
-	defer writer.Flush()
+		writer := csv.NewWriter(w)
 
-	// 写入标题
-	writer.Write([]string{"Window_Minutes", "Window_Days", "Return_Pct", "Mean_Pct", "StdDev_Pct", "Z_Score"})
+		// 写入标题
+		writer.Write([]string{"Window_Minutes", "Window_Days", "Return_Pct", "Mean_Pct", "StdDev_Pct", "Z_Score", "Trend_Slope_Pct", "Interpretation"})
 
-	// 写入数据
-	for _, result := range results {
-		writer.Write([]string{
-			strconv.Itoa(result.WindowMinutes),
-			strconv.FormatFloat(result.WindowDays, 'f', 4, 64),
-			strconv.FormatFloat(result.ReturnPct, 'f', 6, 64),
-			strconv.FormatFloat(result.Mean, 'f', 6, 64),
-			strconv.FormatFloat(result.StdDev, 'f', 6, 64),
-			strconv.FormatFloat(result.ZScore, 'f', 4, 64),
-		})
+		// 写入数据
+		for _, result := range results {
+			writer.Write([]string{
+				strconv.Itoa(result.WindowMinutes),
+				formatFloat(result.WindowDays, 4),
+				formatFloat(result.ReturnPct, 6),
+				formatFloat(result.Mean, 6),
+				formatFloat(result.StdDev, 6),
+				formatFloat(result.ZScore, 4),
+				formatFloat(result.TrendSlopePct, 6),
+				interpretZScore(result.ZScore),
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		log.Fatal("保存结果失败:", err)
 	}
 
 	fmt.Printf("计算完成！\n")
 	fmt.Printf("共计算了 %d 个时间窗口的z-score\n", len(results))
-	fmt.Printf("结果已保存到 zscore_results.csv\n\n")
+	if missing > 0 && *rankNormalize {
+		fmt.Printf("警告: %d 个窗口缺少波动率数据，Mean_Pct/StdDev_Pct 写为 NaN\n", missing)
+	} else if missing > 0 {
+		fmt.Printf("警告: %d 个窗口缺少波动率数据，z-score 写为 NaN\n", missing)
+	}
+	fmt.Printf("结果已保存到 %s\n\n", outputPath("zscore_results.csv"))
 
 	// 显示关键时间点的结果
 	fmt.Println("关键时间窗口的z-score:")
-	keyWindows := []int{1, 5, 15, 30, 60, 240, 1440}
-	for _, kw := range keyWindows {
+	for _, kw := range windows {
 		if kw <= len(results) {
 			result := results[kw-1]
-			fmt.Printf("%d 分钟 (%.4f 天): 收益率 = %.6f%%, z-score = %.4f\n",
-				result.WindowMinutes, result.WindowDays, result.ReturnPct, result.ZScore)
+			fmt.Printf("%d 分钟 (%.4f 天): 收益率 = %.6f%%, z-score = %.4f, 趋势斜率 = %.6f%%/分钟, %s\n",
+				result.WindowMinutes, result.WindowDays, result.ReturnPct, result.ZScore, result.TrendSlopePct, interpretZScore(result.ZScore))
 		}
 	}
 
-	// 找出z-score的极值
-	if len(results) > 0 {
-		maxZScore := results[0].ZScore
-		minZScore := results[0].ZScore
-		maxIdx := 0
-		minIdx := 0
-		for i, r := range results {
-			if r.ZScore > maxZScore {
-				maxZScore = r.ZScore
-				maxIdx = i
-			}
-			if r.ZScore < minZScore {
-				minZScore = r.ZScore
-				minIdx = i
-			}
+	// 找出z-score的极值（跳过缺少波动率数据的窗口）
+	maxIdx, minIdx := -1, -1
+	for i, r := range results {
+		if math.IsNaN(r.ZScore) {
+			continue
 		}
+		if maxIdx < 0 || r.ZScore > results[maxIdx].ZScore {
+			maxIdx = i
+		}
+		if minIdx < 0 || r.ZScore < results[minIdx].ZScore {
+			minIdx = i
+		}
+	}
+	if maxIdx >= 0 {
 		fmt.Printf("\n最大z-score: %.4f (窗口 %d 分钟, %.4f 天)\n",
-			maxZScore, results[maxIdx].WindowMinutes, results[maxIdx].WindowDays)
+			results[maxIdx].ZScore, results[maxIdx].WindowMinutes, results[maxIdx].WindowDays)
 		fmt.Printf("最小z-score: %.4f (窗口 %d 分钟, %.4f 天)\n",
-			minZScore, results[minIdx].WindowMinutes, results[minIdx].WindowDays)
+			results[minIdx].ZScore, results[minIdx].WindowMinutes, results[minIdx].WindowDays)
+	}
+}
+
+// 价格序列中 window 分钟的全部重叠收益率（与 calculate_volatility 相同），跳过无法计算的点
+func windowReturns(prices []float64, window int, returnMode string) []float64 {
+	returns := make([]float64, 0, len(prices)-window)
+	for i := window; i < len(prices); i++ {
+		if r := calculateReturn(prices[i-window], prices[i], returnMode); !math.IsNaN(r) {
+			returns = append(returns, r)
+		}
+	}
+	return returns
+}
+
+// EWMA 波动率（RiskMetrics）：sigma²_t = λ·sigma²_{t-1} + (1-λ)·r²_{t-1}，假设均值为 0。
+// 返回每个点之前（不含该点）的波动率，第一个点没有历史为 NaN；初值按 0 起算并除以 1-λ^t 修正偏差，
+// 开头几个点不会被低估。NaN 收益率不参与更新，该点的结果沿用上一个值
+func ewmaVolatility(returns []float64, lambda float64) []float64 {
+	sigmas := make([]float64, len(returns))
+	var s, weight float64 // 未修正的加权平方和，以及权重之和 1-λ^t
+	for i, r := range returns {
+		if weight > 0 {
+			sigmas[i] = math.Sqrt(s / weight)
+		} else {
+			sigmas[i] = math.NaN()
+		}
+		if math.IsNaN(r) {
+			continue
+		}
+		s = lambda*s + (1-lambda)*r*r
+		weight = lambda*weight + (1 - lambda)
+	}
+	return sigmas
+}
+
+// EWMA z-score：每个收益率除以截至上一个点的 EWMA 波动率，波动率随行情自适应，
+// 波动率上升后同样幅度的收益率不再一直被当作极端值；没有历史的点为 NaN，
+// 波动率为 0（开头一段价格不变）时 z-score 没有意义，和标准差为0一样为 NaN
+func ewmaZScore(returns []float64, lambda float64) []float64 {
+	sigmas := ewmaVolatility(returns, lambda)
+	zScores := make([]float64, len(returns))
+	for i, r := range returns {
+		if math.IsNaN(r) || !(sigmas[i] > 0) {
+			zScores[i] = math.NaN()
+			continue
+		}
+		zScores[i] = r / sigmas[i]
 	}
+	return zScores
 }
 
 type VolatilityData struct {
-	Mean   float64
-	StdDev float64
+	Mean        float64
+	StdDev      float64
+	SampleCount int
 }
 
 type ZScoreResult struct {
 	WindowMinutes int
 	WindowDays    float64
 	ReturnPct     float64
+	TrendSlopePct float64 // 窗口内价格回归斜率（每根K线百分比）
 	Mean          float64
 	StdDev        float64
 	ZScore        float64
 }
 
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 旧版本文件的迁移说明，Compatible 表示当前读取方仍能正确解析（例如只在末尾追加过列）
+type SchemaMigration struct {
+	Compatible bool
+	Note       string
+}
+
+// 读取带版本行的CSV，返回版本号和全部记录（含标题行）
+func readSchemaCSV(path string) (int, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	br, err := newCSVInput(path, file)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	if prefix, err := br.Peek(len(schemaPrefix)); err == nil && string(prefix) == schemaPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaPrefix)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s 的版本行格式错误: %q", path, strings.TrimSpace(line))
+		}
+	}
+
+	records, err := newCSVReader(br).ReadAll()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, records, nil
+}
+
+// 校验版本和标题：当前版本直接通过，兼容的旧版本打印迁移说明后继续，其余情况报错
+// expected 为读取方依赖的前几列
+func checkSchema(name string, version, current int, migrations map[int]SchemaMigration, records [][]string, expected []string) error {
+	if version != current {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%s 的版本 schema=%d 无法识别（当前为 schema=%d），请用当前版本的工具重新生成", name, version, current)
+		}
+		if !m.Compatible {
+			return fmt.Errorf("%s 是不兼容的旧版本 schema=%d: %s", name, version, m.Note)
+		}
+		fmt.Printf("注意: %s 是旧版本 schema=%d: %s\n", name, version, m.Note)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("%s 缺少标题行", name)
+	}
+	header := records[0]
+	if len(header) < len(expected) {
+		return fmt.Errorf("%s 的标题只有 %d 列，至少需要 %d 列", name, len(header), len(expected))
+	}
+	for i, col := range expected {
+		if header[i] != col {
+			return fmt.Errorf("%s 第 %d 列应为 %s，实际为 %s", name, i+1, col, header[i])
+		}
+	}
+	return nil
+}
+
+// 波动率表的版本，列有变化时加1，并在 volatilitySchemaMigrations 中说明旧版本如何处理
+const volatilitySchemaVersion = 3
+
+// 读取波动率表时依赖的列
+var volatilityRequiredHeader = []string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count"}
+
+// 旧版本波动率表的迁移说明
+var volatilitySchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有 VaR_Pct 列（由旧版 calculate_volatility 或 calculate_multi_timeframe_volatility.py 生成），不影响z-score计算，需要 VaR 时请重新运行 calculate_volatility"},
+	2: {Compatible: true, Note: "没有 Realized_Vol_Pct 列，不影响z-score计算"},
+}
+
+// 写入版本行，必须在标题行之前调用
+func writeSchemaLine(w io.Writer, version int) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", schemaPrefix, version)
+	return err
+}
+
+// zscore_results.csv 的版本，列有变化时加1
+// v3: 缺少波动率数据的窗口不再跳过，写为 NaN
+// v4: 增加 Trend_Slope_Pct 列（窗口内回归斜率，每根K线百分比）
+// v5: 增加 Interpretation 列（z-score 所在区间的说明）
+const zscoreResultsSchemaVersion = 5
+
+// 用 n 根K线的简单移动平均平滑价格，减少单根K线的噪声，n<=1 时原样返回
+// 平滑会带来约 (n-1)/2 根K线的滞后，并压低短窗口的波动，所以波动率和z-score必须使用相同的 -smooth
+// 前 n-1 个点用已有的K线求平均，保证长度和下标不变
+func smoothPrices(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	smoothed := make([]float64, len(prices))
+	sum := 0.0
+	for i, p := range prices {
+		sum += p
+		count := i + 1
+		if i >= n {
+			sum -= prices[i-n]
+			count = n
+		}
+		smoothed[i] = sum / float64(count)
+	}
+	return smoothed
+}
+
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 价格序列的取法，默认用收盘价
+type PriceMode int
+
+const (
+	PriceClose   PriceMode = iota // 收盘价 C
+	PriceMid                      // 中间价 (H+L)/2
+	PriceTypical                  // 典型价 (H+L+C)/3
+	PriceOHLC4                    // (O+H+L+C)/4
+)
+
+func parsePriceMode(name string) (PriceMode, error) {
+	switch name {
+	case "close":
+		return PriceClose, nil
+	case "mid":
+		return PriceMid, nil
+	case "typical":
+		return PriceTypical, nil
+	case "ohlc4":
+		return PriceOHLC4, nil
+	}
+	return PriceClose, fmt.Errorf("未知的价格取法: %s（可选 close、mid、typical 或 ohlc4）", name)
+}
+
+// 按 mode 取一根K线的价格
+func (k Kline) Price(mode PriceMode) float64 {
+	switch mode {
+	case PriceMid:
+		return (k.High + k.Low) / 2
+	case PriceTypical:
+		return (k.High + k.Low + k.Close) / 3
+	case PriceOHLC4:
+		return (k.Open + k.High + k.Low + k.Close) / 4
+	}
+	return k.Close
+}
+
+// 按 mode 取出整段K线的价格序列
+func klinePrices(klines []Kline, mode PriceMode) []float64 {
+	prices := make([]float64, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Price(mode)
+	}
+	return prices
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// 读取 calculate_volatility 输出的波动率表
+// 无法解析的行默认跳过，strict 为 true 时返回错误
+func loadVolatilityData(path string, strict bool) (map[int]VolatilityData, error) {
+	version, volRecords, err := readSchemaCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取波动率CSV失败: %v", err)
+	}
+	if err := checkSchema("multi_timeframe_volatility.csv", version, volatilitySchemaVersion, volatilitySchemaMigrations, volRecords, volatilityRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	// 解析波动率数据（跳过标题行）
+	volatilityData := make(map[int]VolatilityData)
+	for i := 1; i < len(volRecords); i++ {
+		data, window, err := parseVolatilityRow(volRecords[i])
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("multi_timeframe_volatility.csv 第 %d 条数据解析失败: %v", i, err)
+			}
+			continue
+		}
+		volatilityData[window] = data
+	}
+	return volatilityData, nil
+}
+
+// 解析波动率表的一行: Window_Minutes, Window_Days, Mean_Pct, StdDev_Pct, Sample_Count, ...
+func parseVolatilityRow(record []string) (VolatilityData, int, error) {
+	if len(record) < 5 {
+		return VolatilityData{}, 0, fmt.Errorf("列数不足: 需要至少 5 列，实际 %d 列", len(record))
+	}
+	window, err := strconv.Atoi(record[0])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Window_Minutes 格式错误: %v", err)
+	}
+	mean, err := strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Mean_Pct 格式错误: %v", err)
+	}
+	stdDev, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("StdDev_Pct 格式错误: %v", err)
+	}
+	sampleCount, err := strconv.Atoi(record[4])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Sample_Count 格式错误: %v", err)
+	}
+	return VolatilityData{Mean: mean, StdDev: stdDev, SampleCount: sampleCount}, window, nil
+}
+
+// 普通最小二乘回归 y = slope*x + intercept，同时返回拟合优度 R²
+// 长度不一致、样本少于2个或 x 没有变化（无法确定斜率）时返回错误
+func ols(x, y []float64) (float64, float64, float64, error) {
+	if len(x) != len(y) {
+		return 0, 0, 0, fmt.Errorf("回归数据长度不一致: x %d 个，y %d 个", len(x), len(y))
+	}
+	if len(x) < 2 {
+		return 0, 0, 0, fmt.Errorf("回归至少需要 2 个样本，实际 %d 个", len(x))
+	}
+
+	n := float64(len(x))
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= n
+	meanY /= n
+
+	var sxx, syy, sxy float64
+	for i := range x {
+		dx := x[i] - meanX
+		dy := y[i] - meanY
+		sxx += dx * dx
+		syy += dy * dy
+		sxy += dx * dy
+	}
+	if sxx == 0 {
+		return 0, 0, 0, fmt.Errorf("x 的方差为 0，无法回归")
+	}
+
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+	// y 没有变化时直线完全拟合
+	r2 := 1.0
+	if syy > 0 {
+		r2 = sxy * sxy / (sxx * syy)
+	}
+	return slope, intercept, r2, nil
+}
+
+// 趋势斜率：对每个位置的最近 window+1 个价格（跨 window 根K线）按时间下标做最小二乘回归，
+// 斜率除以窗口内的平均价格，单位为每根K线百分之几；比首尾两点的收益率更平滑，
+// 不会被窗口起点或终点的单根K线左右。前 window 个位置数据不足，为 NaN
+func trendSlope(prices []float64, window int) []float64 {
+	slopes := make([]float64, len(prices))
+	x := make([]float64, window+1)
+	for i := range x {
+		x[i] = float64(i)
+	}
+	for i := range prices {
+		if i < window {
+			slopes[i] = math.NaN()
+			continue
+		}
+		slope, intercept, _, err := ols(x, prices[i-window:i+1])
+		// 回归线在 x 均值处的值就是窗口内价格的均值
+		meanPrice := intercept + slope*float64(window)/2
+		if err != nil || meanPrice == 0 {
+			slopes[i] = math.NaN()
+			continue
+		}
+		slopes[i] = slope / meanPrice * 100
+	}
+	return slopes
+}
+
+// CSV 中浮点列的小数位数，由 -precision 指定；小于 0 时各列使用自己的默认位数
+var csvPrecision = -1
+
+// 按 -precision 格式化CSV中的浮点数，未指定时使用该列的默认位数
+func formatFloat(value float64, defaultPrecision int) string {
+	precision := defaultPrecision
+	if csvPrecision >= 0 {
+		precision = csvPrecision
+	}
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
+
+// 波动率表记录的K线数与当前价格数据相差超过该比例时，认为波动率表已过期
+const volatilityStaleTolerance = 0.01
+
+// 由波动率表的样本数反推计算时用了多少根K线：窗口 w 的样本数为 K线数 - w
+func volatilityBarCount(volatilityData map[int]VolatilityData) int {
+	smallest := 0
+	for window := range volatilityData {
+		if smallest == 0 || window < smallest {
+			smallest = window
+		}
+	}
+	if smallest == 0 {
+		return 0
+	}
+	return volatilityData[smallest].SampleCount + smallest
+}
+
+// 检查波动率表是否基于当前的价格数据计算，K线数相差超过容差时返回错误
+func checkVolatilityFreshness(volatilityData map[int]VolatilityData, bars int) error {
+	volBars := volatilityBarCount(volatilityData)
+	if volBars == 0 || bars == 0 {
+		return nil
+	}
+	diff := math.Abs(float64(bars-volBars)) / float64(bars)
+	if diff > volatilityStaleTolerance {
+		return fmt.Errorf("波动率表基于 %d 根K线计算，当前价格数据有 %d 根（相差 %.1f%%，超过 %.0f%% 的容差），波动率表可能已过期，请重新运行 calculate_volatility",
+			volBars, bars, diff*100, volatilityStaleTolerance*100)
+	}
+	return nil
+}
+
+// 用最近 n 根K线（含当前）的中位数过滤价格，去掉瞬间的错误报价：单根离群的价格在 n>=3 时会被完全剔除，
+// 而持续的真实行情只会滞后约 (n-1)/2 根K线；n<=1 时原样返回
+// 和 -smooth 一样，波动率和z-score必须使用相同的 -median；前 n-1 个点用已有的K线取中位数
+func medianFilter(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	filtered := make([]float64, len(prices))
+	// 窗口内的价格按升序保存，每次滑动二分查找删除最旧的、插入最新的
+	sorted := make([]float64, 0, n)
+	for i, p := range prices {
+		if i >= n {
+			old := prices[i-n]
+			j := sort.SearchFloat64s(sorted, old)
+			sorted = append(sorted[:j], sorted[j+1:]...)
+		}
+		j := sort.SearchFloat64s(sorted, p)
+		sorted = append(sorted, 0)
+		copy(sorted[j+1:], sorted[j:])
+		sorted[j] = p
+
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 1 {
+			filtered[i] = sorted[mid]
+		} else {
+			filtered[i] = (sorted[mid-1] + sorted[mid]) / 2
+		}
+	}
+	return filtered
+}
+
+// 检测单根K线的异常跳变（例如小数点错位导致价格差10倍），这类错误报价会让波动率和z-score严重失真
+// 与最近一个正常价格相比涨跌幅超过 maxBarMove（0.5 即 50%）的K线视为异常，直到价格回到该范围内为止；
+// 返回修复后的副本（异常K线按两侧正常价格线性插值）和被标记的下标，调用方可以只报告不使用副本
+// 数据末尾没有回到正常范围的异常K线无法插值，只标记不修复；maxBarMove<=0 时不检测
+func sanitizePrices(prices []float64, maxBarMove float64) ([]float64, []int) {
+	if maxBarMove <= 0 || len(prices) == 0 {
+		return prices, nil
+	}
+	repaired := make([]float64, len(prices))
+	copy(repaired, prices)
+
+	var flagged []int
+	lastGood := -1 // 最近一个正常价格的下标，第一个有效价格视为正常
+	for i, p := range prices {
+		if !(p > 0) {
+			continue // 缺失或非正数的价格由收益率计算跳过，这里不处理
+		}
+		if lastGood < 0 || math.Abs(p/prices[lastGood]-1) <= maxBarMove {
+			// 回到正常范围，对中间被标记的K线插值
+			for j := lastGood + 1; lastGood >= 0 && j < i; j++ {
+				if prices[j] > 0 {
+					frac := float64(j-lastGood) / float64(i-lastGood)
+					repaired[j] = prices[lastGood] + (p-prices[lastGood])*frac
+				}
+			}
+			lastGood = i
+			continue
+		}
+		flagged = append(flagged, i)
+	}
+	return repaired, flagged
+}
+
+// 报告异常跳变的K线，repair 为 true 时返回插值修复后的价格，否则原样返回
+func checkPriceSpikes(klines []Kline, prices []float64, maxBarMove float64, repair bool) []float64 {
+	repaired, flagged := sanitizePrices(prices, maxBarMove)
+	if len(flagged) == 0 {
+		return prices
+	}
+	fmt.Printf("警告: %d 根K线与前一个正常价格相差超过 %.0f%%，可能是错误报价（首个在 %s，价格 %v）\n",
+		len(flagged), maxBarMove*100, klines[flagged[0]].Time, prices[flagged[0]])
+	if !repair {
+		fmt.Println("可以用 -repair-spikes 按两侧正常价格插值修复")
+		return prices
+	}
+	unrepaired := 0
+	for _, i := range flagged {
+		if repaired[i] == prices[i] {
+			unrepaired++
+		}
+	}
+	fmt.Printf("已插值修复 %d 根K线", len(flagged)-unrepaired)
+	if unrepaired > 0 {
+		fmt.Printf("，数据末尾的 %d 根没有回到正常范围，无法修复", unrepaired)
+	}
+	fmt.Println()
+	return repaired
+}
+
+// z-score 的解释阈值：|z| 超过 zScoreSignificant 为显著偏离，超过 zScoreNotable 为偏离
+const (
+	zScoreSignificant = 2.0
+	zScoreNotable     = 1.0
+)
+
+// z-score 所在区间的说明，控制台报告和 zscore_results.csv 使用同一套阈值
+func interpretZScore(zscore float64) string {
+	switch {
+	case math.IsNaN(zscore):
+		return "无法计算（缺少波动率数据或标准差为0）"
+	case zscore > zScoreSignificant:
+		return "显著高于均值"
+	case zscore > zScoreNotable:
+		return "高于均值"
+	case zscore < -zScoreSignificant:
+		return "显著低于均值"
+	case zscore < -zScoreNotable:
+		return "低于均值"
+	}
+	return "接近均值"
+}
+
+// 标准正态分布的分位数函数（CDF 的反函数），p 不在 (0,1) 内时返回 ±Inf 或 NaN
+// 使用 Acklam 的有理函数近似，相对误差约 1e-9
+func normalQuantile(p float64) float64 {
+	switch {
+	case math.IsNaN(p) || p < 0 || p > 1:
+		return math.NaN()
+	case p == 0:
+		return math.Inf(-1)
+	case p == 1:
+		return math.Inf(1)
+	}
+
+	a := [6]float64{-3.969683028665376e+01, 2.209460984245205e+02, -2.759285104469687e+02, 1.383577518672690e+02, -3.066479806614716e+01, 2.506628277459239e+00}
+	b := [5]float64{-5.447609879822406e+01, 1.615858368580409e+02, -1.556989798598866e+02, 6.680131188771972e+01, -1.328068155288572e+01}
+	c := [6]float64{-7.784894002430293e-03, -3.223964580411365e-01, -2.400758277161838e+00, -2.549732539343734e+00, 4.374664141464968e+00, 2.938163982698783e+00}
+	d := [4]float64{7.784695709041462e-03, 3.224671290700398e-01, 2.445134137142996e+00, 3.754408661907416e+00}
+
+	// 两端用尾部近似，中间用中心近似
+	const pLow = 0.02425
+	if p < pLow {
+		q := math.Sqrt(-2 * math.Log(p))
+		return (((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) /
+			((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	}
+	if p > 1-pLow {
+		q := math.Sqrt(-2 * math.Log(1-p))
+		return -(((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) /
+			((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	}
+	q := p - 0.5
+	r := q * q
+	return (((((a[0]*r+a[1])*r+a[2])*r+a[3])*r+a[4])*r + a[5]) * q /
+		(((((b[0]*r+b[1])*r+b[2])*r+b[3])*r+b[4])*r + 1)
+}
+
+// 秩变换后的正态得分：value 在 history（应包含 value 本身）中的平均秩 r（从 1 开始，相同值取平均），
+// 映射为 normalQuantile(r/(n+1))（van der Waerden 得分）；不假设收益率服从正态分布，
+// 得分按构造近似服从标准正态，极端程度由经验分布决定：n 个样本时最大约为 normalQuantile(n/(n+1))
+// history 中的 NaN 忽略，没有有效样本时返回 NaN
+func rankNormalScore(history []float64, value float64) float64 {
+	if math.IsNaN(value) {
+		return math.NaN()
+	}
+	below, equal, n := 0, 0, 0
+	for _, h := range history {
+		if math.IsNaN(h) {
+			continue
+		}
+		n++
+		if h < value {
+			below++
+		} else if h == value {
+			equal++
+		}
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	if equal == 0 {
+		// value 不在 history 中时当作插入的一个样本
+		equal, n = 1, n+1
+	}
+	rank := float64(below) + float64(equal+1)/2
+	return normalQuantile(rank / float64(n+1))
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
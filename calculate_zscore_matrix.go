@@ -1,176 +1,1737 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 func main() {
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log（z-score 需与 calculate_volatility 使用同一方式）")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	since := flag.String("since", "", "起始时间（RFC3339 或 2006-01-02），默认取最近 -lookback-days 天")
+	until := flag.String("until", "", "结束时间（不含），格式同 -since")
+	smooth := flag.Int("smooth", 1, "价格平滑的K线数（简单移动平均），1 表示不平滑；需与 calculate_volatility 使用相同的值")
+	median := flag.Int("median", 1, "中值滤波的K线数（去掉瞬间的错误报价），1 表示不过滤；需与 calculate_volatility 使用相同的值")
+	maxBarMove := flag.Float64("max-bar-move", 0.5, "单根K线相对前一个正常价格的最大涨跌幅（0.5 即 50%），超过的视为错误报价并报告；0 表示不检测")
+	repairSpikes := flag.Bool("repair-spikes", false, "按两侧正常价格线性插值修复超过 -max-bar-move 的K线（默认只报告）；需与 calculate_volatility 使用相同的设置")
+	priceModeName := flag.String("price-mode", "close", "价格取法: close、mid、typical 或 ohlc4（需与 calculate_volatility 使用同一取法）")
+	lineProgress := flag.Bool("q", false, "不用单行刷新的进度，每次更新输出一行（stdout 不是终端时默认如此）")
+	baselineDays := flag.Int("baseline-days", 0, "滚动基准的天数：>0 时每个时间点的均值和标准差只用此前这么多天的收益率计算（自适应z-score，计算量大得多）；0 表示使用波动率表中的全局值")
+	maxCPUs := flag.Int("max-cpus", 0, "并行计算最多使用的CPU数，0 表示使用全部CPU（共享机器上可以限制占用）")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	flag.IntVar(&csvPrecision, "precision", -1, "CSV中浮点列的小数位数，-1 表示使用各列默认位数（默认 4 位）")
+	lookbackDays := flag.Int("lookback-days", 7, "矩阵覆盖的天数（行数和列数都是 天数*1440），需与 calculate_volatility 和分析脚本使用相同的值")
+	matrixWindowsFlag := flag.String("matrix-windows", "", "只输出这些窗口的列（分钟，逗号分隔，如 1,5,60,1440），文件和计算量按列数缩小；默认输出 1 到 天数*1440 的全部窗口")
+	maxMemoryFlag := flag.String("max-memory", "auto", "矩阵内存上限（如 512MB、2GB，按 1024 进制），预计超出时不开始计算；auto 表示本机当前可用内存，0 表示不检查")
+	profileMemory := flag.Bool("profile-memory", false, "输出内存报告：矩阵的估算与实际分配、进程向系统申请的内存")
+	checkpointMatrix := flag.Bool("checkpoint-matrix", false, "定期把算完的行写入输出目录的断点文件（zscore_matrix.checkpoint.*），中断（Ctrl+C 或进程被杀）后用相同的参数和数据重新运行时从断点继续；完成后删除断点；不能与 -baseline-days 同时使用")
+	checkpointInterval := flag.Duration("checkpoint-interval", time.Minute, "-checkpoint-matrix 两次写断点之间的最短间隔")
+	flag.Parse()
+	if *lookbackDays < 1 {
+		log.Fatalf("-lookback-days 必须大于等于 1: %d", *lookbackDays)
+	}
+	lookback := *lookbackDays * 1440
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+	priceMode, err := parsePriceMode(*priceModeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *smooth < 1 {
+		log.Fatalf("-smooth 必须大于等于 1: %d", *smooth)
+	}
+	if *median < 1 {
+		log.Fatalf("-median 必须大于等于 1: %d", *median)
+	}
+	if *maxBarMove < 0 {
+		log.Fatalf("-max-bar-move 不能为负数: %v", *maxBarMove)
+	}
+	if *baselineDays < 0 {
+		log.Fatalf("-baseline-days 不能为负数: %d", *baselineDays)
+	}
+	if *maxCPUs < 0 {
+		log.Fatalf("-max-cpus 不能为负数: %d", *maxCPUs)
+	}
+	if *checkpointMatrix && *baselineDays > 0 {
+		log.Fatal("-checkpoint-matrix 不能与 -baseline-days 同时使用：滚动基准按列计算，没有按行完成的进度")
+	}
+	if *checkpointInterval < 0 {
+		log.Fatalf("-checkpoint-interval 不能为负数: %v", *checkpointInterval)
+	}
+	workers := setMaxCPUs(*maxCPUs)
+	memoryLimit, err := parseMemoryLimit(*maxMemoryFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
 	fmt.Println("正在读取数据...")
 
 	// 读取最新的14天数据
-	priceFile, err := os.Open("ETHUSDT_latest_14days.csv")
+	klines, _, err := loadKlines(inputPath(klinesFileName("latest_14days")), *strict)
 	if err != nil {
-		log.Fatal("无法打开价格文件:", err)
+		log.Fatal("读取价格数据失败:", err)
 	}
-	defer priceFile.Close()
 
-	priceReader := csv.NewReader(priceFile)
-	priceRecords, err := priceReader.ReadAll()
+	prices := klinePrices(klines, priceMode)
+	prices = checkPriceSpikes(klines, prices, *maxBarMove, *repairSpikes)
+	prices = medianFilter(prices, *median)
+	prices = smoothPrices(prices, *smooth)
+
+	// 默认只取最近 lookback 分钟的数据，指定 -since/-until 时取对应的时间范围
+	start, end := len(prices)-lookback, len(prices)
+	if *since != "" || *until != "" {
+		start, end, err = selectTimeRange(klines, *since, *until)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if start < 0 {
+		log.Fatalf("数据不足，%d 天需要至少 %d 条，实际只有 %d 条", *lookbackDays, lookback, len(prices))
+	}
+	recentPrices := prices[start:end]
+	fmt.Printf("最近%d天数据: %d 条\n", *lookbackDays, len(recentPrices))
+
+	// 读取波动率数据
+	volatilityData, err := loadVolatilityData(inputPath("multi_timeframe_volatility.csv"), *strict)
 	if err != nil {
-		log.Fatal("读取价格CSV失败:", err)
+		log.Fatal(err)
+	}
+	// 矩阵用的是最近14天的数据，要和计算波动率时用的分钟K线文件核对
+	minuteBars, err := countKlineRows(inputPath(klinesFileName("minute_klines")))
+	if err != nil {
+		fmt.Printf("无法读取分钟K线文件，跳过波动率表时效检查: %v\n", err)
+	} else if err := checkVolatilityFreshness(volatilityData, minuteBars); err != nil {
+		if *strict {
+			log.Fatal(err)
+		}
+		fmt.Printf("警告: %v\n", err)
 	}
 
-	// 解析价格数据（跳过标题行）
-	prices := make([]float64, 0, len(priceRecords)-1)
-	for i := 1; i < len(priceRecords); i++ {
-		if len(priceRecords[i]) < 6 {
-			continue
+	maxWindow := lookback
+	windows, err := parseMatrixWindows(*matrixWindowsFlag, maxWindow)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 矩阵动辄几百MB，先估算，超出内存上限时在分配之前退出，而不是算到一半被系统杀掉
+	matrixBytes := matrixMemoryBytes(len(recentPrices), len(windows))
+	if err := checkMatrixMemory(len(recentPrices), len(windows), memoryLimit); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("开始计算 %d x %d 的z-score矩阵（约占用 %s 内存）...\n", len(recentPrices), len(windows), formatBytes(matrixBytes))
+	fmt.Print("这可能需要一些时间，请耐心等待...\n\n")
+
+	// 缺少波动率数据的窗口在矩阵中写为 NaN，和 z=0（接近均值）区分开；滚动基准不使用波动率表
+	missingWindows := 0
+	for _, window := range windows {
+		if _, exists := volatilityData[window]; !exists && *baselineDays == 0 {
+			missingWindows++
+		}
+	}
+	if missingWindows > 0 && *strict {
+		log.Fatalf("%d 个窗口缺少波动率数据（-strict）", missingWindows)
+	}
+	if missingWindows > 0 {
+		fmt.Printf("警告: %d 个窗口缺少波动率数据，z-score 写为 NaN\n\n", missingWindows)
+	}
+	baseline := *baselineDays * 1440
+	if baseline > 0 {
+		fmt.Printf("使用 %d 天的滚动基准\n", *baselineDays)
+		if start < baseline {
+			fmt.Printf("警告: 时间范围之前只有 %d 条数据，不足 %d 天，前面时间点的基准较短\n", start, *baselineDays)
+		}
+		fmt.Println()
+	}
+
+	// 创建矩阵：行=时间点，列=时间窗口
+	var memBefore runtime.MemStats
+	if *profileMemory {
+		runtime.ReadMemStats(&memBefore)
+	}
+	matrix := newMatrix(len(recentPrices), len(windows))
+	if *profileMemory {
+		var memAfter runtime.MemStats
+		runtime.ReadMemStats(&memAfter)
+		fmt.Printf("内存: 矩阵估算 %s，实际分配 %s\n\n", formatBytes(matrixBytes), formatBytes(int64(memAfter.TotalAlloc-memBefore.TotalAlloc)))
+	}
+
+	reporter := newProgress(os.Stdout, *lineProgress)
+	rowProgress := func(done, total int) {
+		if done%1000 == 0 || done <= 10 || done == total {
+			reporter.Update("进度: %.1f%% (%d/%d)", float64(done)/float64(total)*100, done, total)
 		}
-		closePrice, err := strconv.ParseFloat(priceRecords[i][5], 64) // Close在索引5
+	}
+
+	var checkpoint *MatrixCheckpointer
+	if baseline > 0 {
+		buildRollingZScoreMatrix(prices, start, baseline, windows, *returnMode, workers, matrix, func(done, total int) {
+			if done%100 == 0 || done <= 10 || done == total {
+				reporter.Update("进度: %.1f%% (%d/%d 个窗口)", float64(done)/float64(total)*100, done, total)
+			}
+		})
+	} else if *checkpointMatrix {
+		checkpoint = newMatrixCheckpointer(outputPath("zscore_matrix.checkpoint"), matrixFingerprint(recentPrices, windows, volatilityData, *returnMode), matrix)
+		from, err := checkpoint.Load()
 		if err != nil {
-			continue
+			fmt.Printf("警告: 无法使用断点（%v），从头计算\n\n", err)
+			from = 0
+		} else if from > 0 {
+			fmt.Printf("从断点继续：已完成 %d/%d 行\n\n", from, len(matrix))
+		}
+
+		// Ctrl+C 或 SIGTERM 时算完当前这一批行、写好断点再退出
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = buildZScoreMatrixCheckpointed(ctx, recentPrices, windows, volatilityData, *returnMode, workers, matrix, from, checkpoint, *checkpointInterval, rowProgress)
+		interrupted := ctx.Err() != nil
+		stop()
+		if err != nil {
+			reporter.Done()
+			if interrupted {
+				log.Fatalf("已中断，断点已保存（%d/%d 行），用相同的参数重新运行即可继续", checkpoint.Completed(), len(matrix))
+			}
+			log.Fatal("写入断点失败:", err)
+		}
+	} else {
+		buildZScoreMatrix(recentPrices, windows, volatilityData, *returnMode, workers, matrix, rowProgress)
+	}
+	reporter.Done()
+
+	// 保存矩阵到CSV
+	fmt.Println("\n正在保存矩阵到CSV...")
+	err = writeFileAtomic(outputPath("zscore_matrix.csv"), func(w io.Writer) error {
+		if err := writeSchemaLine(w, matrixSchemaVersion); err != nil {
+			return err
+		}
+
+		writer := csv.NewWriter(w)
+
+		// 写入标题行：TimeIndex 之后是各列的窗口分钟数
+		header := make([]string, len(windows)+1)
+		header[0] = "TimeIndex"
+		for j, window := range windows {
+			header[j+1] = strconv.Itoa(window)
+		}
+		writer.Write(header)
+
+		// 写入数据
+		for i, row := range matrix {
+			rowStr := make([]string, len(windows)+1)
+			rowStr[0] = strconv.Itoa(i)
+			for j, val := range row {
+				rowStr[j+1] = formatFloat(val, 4)
+			}
+			writer.Write(rowStr)
+
+			if (i+1)%1000 == 0 {
+				reporter.Update("已写入 %d/%d 行", i+1, len(matrix))
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		log.Fatal("保存结果失败:", err)
+	}
+	reporter.Done()
+	if checkpoint != nil {
+		if err := checkpoint.Remove(); err != nil {
+			fmt.Printf("警告: 删除断点文件失败: %v\n", err)
 		}
-		prices = append(prices, closePrice)
 	}
 
-	if len(prices) < 1440*7 {
-		log.Fatalf("数据不足，需要至少 %d 条，实际只有 %d 条", 1440*7, len(prices))
+	fmt.Printf("\n计算完成！\n")
+	fmt.Printf("矩阵大小: %d x %d\n", len(recentPrices), len(windows))
+	fmt.Printf("结果已保存到 %s\n", outputPath("zscore_matrix.csv"))
+	if *profileMemory {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		fmt.Printf("内存: 进程共向系统申请 %s，累计分配 %s，GC %d 次\n", formatBytes(int64(mem.Sys)), formatBytes(int64(mem.TotalAlloc)), mem.NumGC)
+	}
+}
+
+type VolatilityData struct {
+	Mean        float64
+	StdDev      float64
+	SampleCount int
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
 	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
 
-	// 只取最近7天的数据
-	recent7Days := prices[len(prices)-1440*7:]
-	fmt.Printf("最近7天数据: %d 条\n", len(recent7Days))
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
 
-	// 读取波动率数据
-	volFile, err := os.Open("multi_timeframe_volatility.csv")
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal("无法打开波动率文件:", err)
+		return nil, nil, err
 	}
-	defer volFile.Close()
+	defer file.Close()
 
-	volReader := csv.NewReader(volFile)
-	volRecords, err := volReader.ReadAll()
+	input, err := newCSVInput(path, file)
 	if err != nil {
-		log.Fatal("读取波动率CSV失败:", err)
+		return nil, nil, err
 	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
 
-	// 解析波动率数据（跳过标题行）
-	volatilityData := make(map[int]VolatilityData)
-	for i := 1; i < len(volRecords); i++ {
-		if len(volRecords[i]) < 5 {
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
 			continue
 		}
-		window, err := strconv.Atoi(volRecords[i][0])
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
 		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
 			continue
 		}
-		mean, err := strconv.ParseFloat(volRecords[i][2], 64)
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
 		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
 			continue
 		}
-		stdDev, err := strconv.ParseFloat(volRecords[i][3], 64)
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 旧版本文件的迁移说明，Compatible 表示当前读取方仍能正确解析（例如只在末尾追加过列）
+type SchemaMigration struct {
+	Compatible bool
+	Note       string
+}
+
+// 读取带版本行的CSV，返回版本号和全部记录（含标题行）
+func readSchemaCSV(path string) (int, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	br, err := newCSVInput(path, file)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	if prefix, err := br.Peek(len(schemaPrefix)); err == nil && string(prefix) == schemaPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaPrefix)))
 		if err != nil {
+			return 0, nil, fmt.Errorf("%s 的版本行格式错误: %q", path, strings.TrimSpace(line))
+		}
+	}
+
+	records, err := newCSVReader(br).ReadAll()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, records, nil
+}
+
+// 校验版本和标题：当前版本直接通过，兼容的旧版本打印迁移说明后继续，其余情况报错
+// expected 为读取方依赖的前几列
+func checkSchema(name string, version, current int, migrations map[int]SchemaMigration, records [][]string, expected []string) error {
+	if version != current {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%s 的版本 schema=%d 无法识别（当前为 schema=%d），请用当前版本的工具重新生成", name, version, current)
+		}
+		if !m.Compatible {
+			return fmt.Errorf("%s 是不兼容的旧版本 schema=%d: %s", name, version, m.Note)
+		}
+		fmt.Printf("注意: %s 是旧版本 schema=%d: %s\n", name, version, m.Note)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("%s 缺少标题行", name)
+	}
+	header := records[0]
+	if len(header) < len(expected) {
+		return fmt.Errorf("%s 的标题只有 %d 列，至少需要 %d 列", name, len(header), len(expected))
+	}
+	for i, col := range expected {
+		if header[i] != col {
+			return fmt.Errorf("%s 第 %d 列应为 %s，实际为 %s", name, i+1, col, header[i])
+		}
+	}
+	return nil
+}
+
+// 波动率表的版本，列有变化时加1，并在 volatilitySchemaMigrations 中说明旧版本如何处理
+const volatilitySchemaVersion = 3
+
+// 读取波动率表时依赖的列
+var volatilityRequiredHeader = []string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count"}
+
+// 旧版本波动率表的迁移说明
+var volatilitySchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有 VaR_Pct 列（由旧版 calculate_volatility 或 calculate_multi_timeframe_volatility.py 生成），不影响z-score计算，需要 VaR 时请重新运行 calculate_volatility"},
+	2: {Compatible: true, Note: "没有 Realized_Vol_Pct 列，不影响z-score计算"},
+}
+
+// 写入版本行，必须在标题行之前调用
+func writeSchemaLine(w io.Writer, version int) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", schemaPrefix, version)
+	return err
+}
+
+// z-score矩阵的版本，列有变化时加1，并在 matrixSchemaMigrations 中说明旧版本如何处理
+// v3: 缺少波动率数据的窗口写为 NaN（v2 写为 0）
+// v4: 列可以只包含 -matrix-windows 指定的窗口，标题为窗口的分钟数，读取方按标题找列
+const matrixSchemaVersion = 4
+
+// 计算 timeIdx 时刻各窗口的z-score，row[j] 对应 windows[j]
+// 缺少波动率数据或标准差为0的窗口设为 NaN，window > timeIdx 无法计算的窗口设为0
+func computeZScoreRow(prices []float64, timeIdx int, windows []int, volatilityData map[int]VolatilityData, returnMode string, row []float64) {
+	currentPrice := prices[timeIdx]
+
+	// 对于每个时间窗口
+	for j, window := range windows {
+		// 对于 window > timeIdx 的情况，无法计算，设为0
+		if window > timeIdx {
+			row[j] = 0
+			continue
+		}
+		prevPrice := prices[timeIdx-window]
+		returnPct := calculateReturn(prevPrice, currentPrice, returnMode)
+
+		// 获取该窗口的均值和标准差
+		volData, exists := volatilityData[window]
+		if !exists {
+			row[j] = math.NaN()
 			continue
 		}
-		volatilityData[window] = VolatilityData{
-			Mean:   mean,
-			StdDev: stdDev,
+
+		// 计算z-score，标准差为0时没有意义，和缺少波动率数据一样写为 NaN
+		zScore := math.NaN()
+		if volData.StdDev > 0 {
+			zScore = (returnPct - volData.Mean) / volData.StdDev
 		}
+
+		row[j] = zScore
 	}
+}
 
-	maxWindow := 1440 * 7
-	fmt.Printf("开始计算 %d x %d 的z-score矩阵...\n", len(recent7Days), maxWindow)
-	fmt.Println("这可能需要一些时间，请耐心等待...\n")
+// 进度回调：done 从 1 递增到 total，可用于在其他程序或界面中显示计算进度
+type ProgressFunc func(done, total int)
 
-	// 创建矩阵：行=时间点，列=时间窗口
-	matrix := make([][]float64, len(recent7Days))
+// 在多个 worker 之间累计完成数，按 done 递增的顺序逐个回调 fn（fn 为 nil 时只计数）
+type progressCounter struct {
+	mu    sync.Mutex
+	done  int
+	total int
+	fn    ProgressFunc
+}
+
+func (c *progressCounter) Add() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.done++
+	if c.fn != nil {
+		c.fn(c.done, c.total)
+	}
+}
+
+// 按波动率表并行计算z-score矩阵，matrix[t] 对应 prices[t]；
+// 各行相互独立，只读共享 prices 和 volatilityData，每个 worker 写自己的行，不需要加锁。
+// progress 不为 nil 时每算完一行回调一次，total 为行数
+func buildZScoreMatrix(prices []float64, windows []int, volatilityData map[int]VolatilityData, returnMode string, workers int, matrix [][]float64, progress ProgressFunc) {
+	counter := &progressCounter{total: len(matrix), fn: progress}
+	buildZScoreRows(prices, windows, volatilityData, returnMode, workers, matrix, 0, len(matrix), counter)
+}
+
+// 并行计算矩阵的第 [from, to) 行，返回时这些行都已算完
+func buildZScoreRows(prices []float64, windows []int, volatilityData map[int]VolatilityData, returnMode string, workers int, matrix [][]float64, from, to int, counter *progressCounter) {
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for timeIdx := range rows {
+				computeZScoreRow(prices, timeIdx, windows, volatilityData, returnMode, matrix[timeIdx])
+				counter.Add()
+			}
+		}()
+	}
+	for timeIdx := from; timeIdx < to; timeIdx++ {
+		rows <- timeIdx
+	}
+	close(rows)
+	wg.Wait()
+}
+
+// 带断点的 buildZScoreMatrix：从第 from 行开始，每次并行算一批行，距上次写断点超过 interval 时把新算完的行写入断点；
+// ctx 取消时算完当前这一批、写好断点后返回 ctx.Err()
+func buildZScoreMatrixCheckpointed(ctx context.Context, prices []float64, windows []int, volatilityData map[int]VolatilityData, returnMode string, workers int, matrix [][]float64, from int, checkpoint *MatrixCheckpointer, interval time.Duration, progress ProgressFunc) error {
+	counter := &progressCounter{done: from, total: len(matrix), fn: progress}
+	lastSave := time.Now()
+	for from < len(matrix) {
+		to := from + matrixCheckpointBatchRows
+		if to > len(matrix) {
+			to = len(matrix)
+		}
+		buildZScoreRows(prices, windows, volatilityData, returnMode, workers, matrix, from, to, counter)
+		from = to
+
+		if ctx.Err() != nil {
+			if err := checkpoint.Save(from); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+		// 全部算完后直接写结果，不再写断点
+		if from < len(matrix) && time.Since(lastSave) >= interval {
+			if err := checkpoint.Save(from); err != nil {
+				return err
+			}
+			lastSave = time.Now()
+		}
+	}
+	return nil
+}
+
+// 带断点计算时每批的行数，中断后最多要等这么多行算完
+const matrixCheckpointBatchRows = 256
+
+// -checkpoint-matrix 的断点元数据，与已完成的行分两个文件保存：
+// 行按 float64 小端序追加到 .rows 文件，元数据（.json）原子替换，只有元数据记录的 CompletedRows 行有效，
+// 写行时被杀留下的多余部分在下次写入时截掉
+type MatrixCheckpoint struct {
+	Rows          int    `json:"rows"`
+	Cols          int    `json:"cols"`
+	Fingerprint   string `json:"fingerprint"` // 价格、窗口、波动率表和收益率方式的哈希，任何一项变化都不能续算
+	CompletedRows int    `json:"completedRows"`
+}
+
+type MatrixCheckpointer struct {
+	metaPath    string
+	rowsPath    string
+	fingerprint string
+	matrix      [][]float64
+	saved       int // 已写入断点的行数
+}
+
+func newMatrixCheckpointer(base, fingerprint string, matrix [][]float64) *MatrixCheckpointer {
+	return &MatrixCheckpointer{
+		metaPath:    base + ".json",
+		rowsPath:    base + ".rows",
+		fingerprint: fingerprint,
+		matrix:      matrix,
+	}
+}
+
+func (c *MatrixCheckpointer) cols() int {
+	if len(c.matrix) == 0 {
+		return 0
+	}
+	return len(c.matrix[0])
+}
+
+// 已写入断点的行数
+func (c *MatrixCheckpointer) Completed() int {
+	return c.saved
+}
+
+// 读取断点，把已完成的行填回矩阵，返回已完成的行数；没有断点时返回 0，
+// 断点与当前的参数或数据不一致时返回错误（调用方应从头计算）
+func (c *MatrixCheckpointer) Load() (int, error) {
+	data, err := os.ReadFile(c.metaPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var cp MatrixCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return 0, fmt.Errorf("解析断点文件失败: %v", err)
+	}
+	if cp.Rows != len(c.matrix) || cp.Cols != c.cols() || cp.Fingerprint != c.fingerprint {
+		return 0, fmt.Errorf("断点对应的参数或数据与本次不同")
+	}
+	if cp.CompletedRows < 0 || cp.CompletedRows > cp.Rows {
+		return 0, fmt.Errorf("断点中的已完成行数无效: %d", cp.CompletedRows)
+	}
+
+	f, err := os.Open(c.rowsPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	buf := make([]byte, 8*c.cols())
+	for i := 0; i < cp.CompletedRows; i++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, fmt.Errorf("断点数据不完整（第 %d 行）: %v", i, err)
+		}
+		for j := range c.matrix[i] {
+			c.matrix[i][j] = math.Float64frombits(binary.LittleEndian.Uint64(buf[8*j:]))
+		}
+	}
+	c.saved = cp.CompletedRows
+	return cp.CompletedRows, nil
+}
+
+// 把第 [已保存, completed) 行追加到 .rows 文件并同步到磁盘，再原子替换元数据
+func (c *MatrixCheckpointer) Save(completed int) error {
+	f, err := os.OpenFile(c.rowsPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	rowBytes := int64(8 * c.cols())
+	if err := f.Truncate(int64(c.saved) * rowBytes); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(int64(c.saved)*rowBytes, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	buf := make([]byte, rowBytes)
+	for i := c.saved; i < completed; i++ {
+		for j, v := range c.matrix[i] {
+			binary.LittleEndian.PutUint64(buf[8*j:], math.Float64bits(v))
+		}
+		if _, err := w.Write(buf); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	cp := MatrixCheckpoint{Rows: len(c.matrix), Cols: c.cols(), Fingerprint: c.fingerprint, CompletedRows: completed}
+	err = writeFileAtomic(c.metaPath, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(cp)
+	})
+	if err != nil {
+		return err
+	}
+	c.saved = completed
+	return nil
+}
+
+// 结果写好后删除断点文件
+func (c *MatrixCheckpointer) Remove() error {
+	// 先删元数据，删到一半时留下的 .rows 文件不会被当作有效断点
+	if err := os.Remove(c.metaPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(c.rowsPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 断点的指纹：矩阵的值只取决于价格、窗口、各窗口的均值和标准差以及收益率方式
+func matrixFingerprint(prices []float64, windows []int, volatilityData map[int]VolatilityData, returnMode string) string {
+	h := fnv.New64a()
+	var buf [8]byte
+	writeUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	io.WriteString(h, returnMode)
+	writeUint64(uint64(len(prices)))
+	for _, p := range prices {
+		writeUint64(math.Float64bits(p))
+	}
+	writeUint64(uint64(len(windows)))
+	for _, window := range windows {
+		writeUint64(uint64(window))
+		volData, exists := volatilityData[window]
+		if !exists {
+			writeUint64(0)
+			continue
+		}
+		writeUint64(1)
+		writeUint64(math.Float64bits(volData.Mean))
+		writeUint64(math.Float64bits(volData.StdDev))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// 按滚动基准并行计算z-score矩阵：每个 worker 负责一个窗口，沿时间滑动基准区间，写不同的列，不需要加锁。
+// progress 不为 nil 时每算完一列回调一次，total 为窗口数
+func buildRollingZScoreMatrix(prices []float64, start, baseline int, windows []int, returnMode string, workers int, matrix [][]float64, progress ProgressFunc) {
+	counter := &progressCounter{total: len(windows), fn: progress}
+	columns := make(chan int) // 列下标
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for col := range columns {
+				computeRollingZScoreColumn(prices, start, windows[col], col, baseline, returnMode, matrix)
+				counter.Add()
+			}
+		}()
+	}
+	for col := range windows {
+		columns <- col
+	}
+	close(columns)
+	wg.Wait()
+}
+
+// 每行切片头的大小（64 位平台）
+const sliceHeaderBytes = 24
+
+// 矩阵占用的内存：rows*cols 个 float64 的一整块，加上每行的切片头
+func matrixMemoryBytes(rows, cols int) int64 {
+	return int64(rows)*int64(cols)*8 + int64(rows)*sliceHeaderBytes
+}
+
+// 分配 rows x cols 的矩阵，所有行共用一块连续内存，占用与 matrixMemoryBytes 一致
+// 逐行分配时每行都会被向上取整到分配器的规格，估算不准
+func newMatrix(rows, cols int) [][]float64 {
+	backing := make([]float64, rows*cols)
+	matrix := make([][]float64, rows)
 	for i := range matrix {
-		matrix[i] = make([]float64, maxWindow)
+		matrix[i] = backing[i*cols : (i+1)*cols : (i+1)*cols]
 	}
+	return matrix
+}
 
-	// 计算每个时间点的z-score
-	for timeIdx := 0; timeIdx < len(recent7Days); timeIdx++ {
-		currentPrice := recent7Days[timeIdx]
+// 矩阵预计超过内存上限时返回错误，limit 为 0 时不检查
+func checkMatrixMemory(rows, cols int, limit int64) error {
+	need := matrixMemoryBytes(rows, cols)
+	if limit <= 0 || need <= limit {
+		return nil
+	}
+	return fmt.Errorf("%d x %d 的z-score矩阵预计占用 %s，超过 %s 的内存上限（-max-memory）；"+
+		"请用 -matrix-windows 只计算需要的窗口列，或减小 -lookback-days / 用 -since、-until 缩短时间范围",
+		rows, cols, formatBytes(need), formatBytes(limit))
+}
 
-		// 对于每个时间窗口
-		for window := 1; window <= maxWindow && timeIdx >= window; window++ {
-			prevPrice := recent7Days[timeIdx-window]
-			returnPct := ((currentPrice - prevPrice) / prevPrice) * 100
+// 解析 -max-memory，返回字节数：auto 取本机当前可用内存（无法获取时不检查），0 表示不检查
+func parseMemoryLimit(value string) (int64, error) {
+	if strings.EqualFold(value, "auto") {
+		available := availableMemory()
+		if available == 0 {
+			fmt.Println("无法获取本机可用内存，跳过矩阵内存检查（可用 -max-memory 指定上限）")
+		}
+		return available, nil
+	}
+	return parseByteSize(value)
+}
 
-			// 获取该窗口的均值和标准差
-			volData, exists := volatilityData[window]
-			if !exists {
-				matrix[timeIdx][window-1] = 0
-				continue
+// 解析带单位的大小，如 512MB、2GB、1.5G，按 1024 进制；没有单位时为字节
+func parseByteSize(value string) (int64, error) {
+	text := strings.ToUpper(strings.TrimSpace(value))
+	units := []struct {
+		suffix string
+		size   float64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}}
+	multiplier := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(text, unit.suffix) {
+			text = strings.TrimSpace(strings.TrimSuffix(text, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("无效的内存大小: %q（如 512MB、2GB）", value)
+	}
+	return int64(number * multiplier), nil
+}
+
+// 本机当前可用的内存：Linux 上取 /proc/meminfo 的 MemAvailable，在容器中还不超过 cgroup v2 的剩余额度；无法获取时返回 0
+func availableMemory() int64 {
+	var available int64
+	if data, err := os.ReadFile("/proc/meminfo"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+				if kb, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+					available = kb * 1024
+				}
+			}
+		}
+	}
+
+	// memory.max 为 max 时没有限制
+	maxData, err1 := os.ReadFile("/sys/fs/cgroup/memory.max")
+	currentData, err2 := os.ReadFile("/sys/fs/cgroup/memory.current")
+	if err1 == nil && err2 == nil {
+		limit, errLimit := strconv.ParseInt(strings.TrimSpace(string(maxData)), 10, 64)
+		current, errCurrent := strconv.ParseInt(strings.TrimSpace(string(currentData)), 10, 64)
+		if errLimit == nil && errCurrent == nil {
+			if remaining := limit - current; available == 0 || remaining < available {
+				available = remaining
 			}
+		}
+	}
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// 以 1024 进制显示字节数
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
 
-			// 计算z-score
-			var zScore float64
-			if volData.StdDev > 0 {
-				zScore = (returnPct - volData.Mean) / volData.StdDev
-			} else {
-				zScore = 0
+// 解析 -matrix-windows：为空时返回 1 到 maxWindow 的全部窗口，否则去重并按从小到大排列
+func parseMatrixWindows(value string, maxWindow int) ([]int, error) {
+	if value == "" {
+		windows := make([]int, maxWindow)
+		for i := range windows {
+			windows[i] = i + 1
+		}
+		return windows, nil
+	}
+	seen := make(map[int]bool)
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 || window > maxWindow {
+			return nil, fmt.Errorf("无效的 -matrix-windows 窗口: %q（需要 1 到 %d 的整数分钟数）", field, maxWindow)
+		}
+		if !seen[window] {
+			seen[window] = true
+			windows = append(windows, window)
+		}
+	}
+	sort.Ints(windows)
+	return windows, nil
+}
+
+// 滚动基准下 window 分钟窗口的一列z-score（写入矩阵第 col 列）：第 t 行的均值和标准差取此前 baseline 根K线内的 window 分钟收益率（不含当前点），
+// 与波动率表一样使用重叠窗口和样本标准差；prices 为完整价格序列，矩阵第 t 行对应 prices[start+t]
+// 当前收益率的计算方式与固定基准相同（只用矩阵时间范围内的价格，超出时写 0），基准样本少于 2 个或方差为0时写 NaN
+func computeRollingZScoreColumn(prices []float64, start, window, col, baseline int, returnMode string, matrix [][]float64) {
+	returnAt := func(i int) float64 {
+		return calculateReturn(prices[i-window], prices[i], returnMode)
+	}
+
+	// 基准区间为收益率下标 [lo, hi)，用减去偏移量的累计和避免大数相减损失精度
+	lo, hi := window, window
+	offset := math.NaN()
+	var sum, sumSq float64
+	count := 0
+	for t := range matrix {
+		if t < window {
+			matrix[t][col] = 0
+			continue
+		}
+		current := start + t
+		for ; hi < current; hi++ {
+			r := returnAt(hi)
+			if math.IsNaN(r) {
+				continue
+			}
+			if math.IsNaN(offset) {
+				offset = r
+			}
+			sum += r - offset
+			sumSq += (r - offset) * (r - offset)
+			count++
+		}
+		for ; lo < current-baseline && lo < hi; lo++ {
+			r := returnAt(lo)
+			if math.IsNaN(r) {
+				continue
 			}
+			sum -= r - offset
+			sumSq -= (r - offset) * (r - offset)
+			count--
+		}
 
-			matrix[timeIdx][window-1] = zScore
+		if count < 2 {
+			matrix[t][col] = math.NaN()
+			continue
 		}
+		mean := sum / float64(count)
+		variance := (sumSq - sum*mean) / float64(count-1)
+		returnPct := calculateReturn(prices[current-window], prices[current], returnMode)
+		if variance > 0 {
+			matrix[t][col] = (returnPct - (offset + mean)) / math.Sqrt(variance)
+		} else {
+			matrix[t][col] = math.NaN()
+		}
+	}
+}
 
-		// 对于 window > timeIdx 的情况，无法计算，设为0
-		for window := timeIdx + 1; window <= maxWindow; window++ {
-			matrix[timeIdx][window-1] = 0
+// 解析 -since/-until 参数，支持 RFC3339（如 2024-01-02T15:04:05Z）或日期（如 2024-01-02，按 UTC 零点）
+func parseTimeFlag(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间 %q，请使用 RFC3339（如 2024-01-02T15:04:05Z）或日期（如 2024-01-02）", value)
+}
+
+// 按开盘时间选出 [since, until) 范围内的1分钟K线，返回下标范围 [start, end)
+// since 或 until 为空表示该端不限制；指定的时间必须落在数据覆盖的范围内
+func selectTimeRange(klines []Kline, since, until string) (int, int, error) {
+	if len(klines) == 0 {
+		return 0, 0, fmt.Errorf("没有K线数据")
+	}
+	first := time.UnixMilli(klines[0].OpenTime).UTC()
+	last := time.UnixMilli(klines[len(klines)-1].OpenTime).UTC().Add(time.Minute)
+
+	sinceTime, untilTime := first, last
+	var err error
+	if since != "" {
+		if sinceTime, err = parseTimeFlag(since); err != nil {
+			return 0, 0, err
+		}
+	}
+	if until != "" {
+		if untilTime, err = parseTimeFlag(until); err != nil {
+			return 0, 0, err
 		}
+	}
 
-		// 进度输出
-		if (timeIdx+1)%1000 == 0 || timeIdx < 10 {
-			progress := float64(timeIdx+1) / float64(len(recent7Days)) * 100
-			fmt.Printf("进度: %.1f%% (%d/%d)\n", progress, timeIdx+1, len(recent7Days))
+	if !sinceTime.Before(untilTime) {
+		return 0, 0, fmt.Errorf("-since (%s) 必须早于 -until (%s)", sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339))
+	}
+	if sinceTime.Before(first) || untilTime.After(last) {
+		return 0, 0, fmt.Errorf("时间范围 %s 到 %s 超出了数据覆盖的范围 %s 到 %s",
+			sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339), first.Format(time.RFC3339), last.Format(time.RFC3339))
+	}
+
+	start := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= sinceTime.UnixMilli() })
+	end := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= untilTime.UnixMilli() })
+	if start >= end {
+		return 0, 0, fmt.Errorf("时间范围 %s 到 %s 内没有数据", sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339))
+	}
+	return start, end, nil
+}
+
+// 用 n 根K线的简单移动平均平滑价格，减少单根K线的噪声，n<=1 时原样返回
+// 平滑会带来约 (n-1)/2 根K线的滞后，并压低短窗口的波动，所以波动率和z-score必须使用相同的 -smooth
+// 前 n-1 个点用已有的K线求平均，保证长度和下标不变
+func smoothPrices(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	smoothed := make([]float64, len(prices))
+	sum := 0.0
+	for i, p := range prices {
+		sum += p
+		count := i + 1
+		if i >= n {
+			sum -= prices[i-n]
+			count = n
 		}
+		smoothed[i] = sum / float64(count)
 	}
+	return smoothed
+}
 
-	// 保存矩阵到CSV
-	fmt.Println("\n正在保存矩阵到CSV...")
-	outputFile, err := os.Create("zscore_matrix.csv")
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 价格序列的取法，默认用收盘价
+type PriceMode int
+
+const (
+	PriceClose   PriceMode = iota // 收盘价 C
+	PriceMid                      // 中间价 (H+L)/2
+	PriceTypical                  // 典型价 (H+L+C)/3
+	PriceOHLC4                    // (O+H+L+C)/4
+)
+
+func parsePriceMode(name string) (PriceMode, error) {
+	switch name {
+	case "close":
+		return PriceClose, nil
+	case "mid":
+		return PriceMid, nil
+	case "typical":
+		return PriceTypical, nil
+	case "ohlc4":
+		return PriceOHLC4, nil
+	}
+	return PriceClose, fmt.Errorf("未知的价格取法: %s（可选 close、mid、typical 或 ohlc4）", name)
+}
+
+// 按 mode 取一根K线的价格
+func (k Kline) Price(mode PriceMode) float64 {
+	switch mode {
+	case PriceMid:
+		return (k.High + k.Low) / 2
+	case PriceTypical:
+		return (k.High + k.Low + k.Close) / 3
+	case PriceOHLC4:
+		return (k.Open + k.High + k.Low + k.Close) / 4
+	}
+	return k.Close
+}
+
+// 按 mode 取出整段K线的价格序列
+func klinePrices(klines []Kline, mode PriceMode) []float64 {
+	prices := make([]float64, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Price(mode)
+	}
+	return prices
+}
+
+// 进度输出。stdout 是终端时用 \r 覆盖同一行，避免刷屏；
+// 重定向到文件或管道、或指定 -q 时每次更新输出一行，方便在日志里查看
+type Progress struct {
+	mu      sync.Mutex
+	w       io.Writer
+	compact bool
+	pending bool // 紧凑模式下当前进度行还没有换行
+}
+
+func newProgress(f *os.File, lineMode bool) *Progress {
+	return &Progress{w: f, compact: !lineMode && isTerminal(f)}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// 输出一条进度，可以在多个 goroutine 中同时调用
+func (p *Progress) Update(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if p.compact {
+		// \033[K 清除上一条更长的进度留下的字符
+		fmt.Fprintf(p.w, "\r%s\033[K", msg)
+		p.pending = true
+		return
+	}
+	fmt.Fprintln(p.w, msg)
+}
+
+// 结束当前进度行，之后的普通输出从新的一行开始
+func (p *Progress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending {
+		fmt.Fprintln(p.w)
+		p.pending = false
+	}
+}
+
+// 读取 calculate_volatility 输出的波动率表
+// 无法解析的行默认跳过，strict 为 true 时返回错误
+func loadVolatilityData(path string, strict bool) (map[int]VolatilityData, error) {
+	version, volRecords, err := readSchemaCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取波动率CSV失败: %v", err)
+	}
+	if err := checkSchema("multi_timeframe_volatility.csv", version, volatilitySchemaVersion, volatilitySchemaMigrations, volRecords, volatilityRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	// 解析波动率数据（跳过标题行）
+	volatilityData := make(map[int]VolatilityData)
+	for i := 1; i < len(volRecords); i++ {
+		data, window, err := parseVolatilityRow(volRecords[i])
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("multi_timeframe_volatility.csv 第 %d 条数据解析失败: %v", i, err)
+			}
+			continue
+		}
+		volatilityData[window] = data
+	}
+	return volatilityData, nil
+}
+
+// 解析波动率表的一行: Window_Minutes, Window_Days, Mean_Pct, StdDev_Pct, Sample_Count, ...
+func parseVolatilityRow(record []string) (VolatilityData, int, error) {
+	if len(record) < 5 {
+		return VolatilityData{}, 0, fmt.Errorf("列数不足: 需要至少 5 列，实际 %d 列", len(record))
+	}
+	window, err := strconv.Atoi(record[0])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Window_Minutes 格式错误: %v", err)
+	}
+	mean, err := strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Mean_Pct 格式错误: %v", err)
+	}
+	stdDev, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("StdDev_Pct 格式错误: %v", err)
+	}
+	sampleCount, err := strconv.Atoi(record[4])
 	if err != nil {
-		log.Fatal("创建输出文件失败:", err)
+		return VolatilityData{}, 0, fmt.Errorf("Sample_Count 格式错误: %v", err)
 	}
-	defer outputFile.Close()
+	return VolatilityData{Mean: mean, StdDev: stdDev, SampleCount: sampleCount}, window, nil
+}
 
-	writer := csv.NewWriter(outputFile)
-	defer writer.Flush()
+// CSV 中浮点列的小数位数，由 -precision 指定；小于 0 时各列使用自己的默认位数
+var csvPrecision = -1
 
-	// 写入标题行（窗口1到maxWindow）
-	header := make([]string, maxWindow+1)
-	header[0] = "TimeIndex"
-	for i := 1; i <= maxWindow; i++ {
-		header[i] = strconv.Itoa(i)
+// 按 -precision 格式化CSV中的浮点数，未指定时使用该列的默认位数
+func formatFloat(value float64, defaultPrecision int) string {
+	precision := defaultPrecision
+	if csvPrecision >= 0 {
+		precision = csvPrecision
 	}
-	writer.Write(header)
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
+
+// 波动率表记录的K线数与当前价格数据相差超过该比例时，认为波动率表已过期
+const volatilityStaleTolerance = 0.01
 
-	// 写入数据
-	for i, row := range matrix {
-		rowStr := make([]string, maxWindow+1)
-		rowStr[0] = strconv.Itoa(i)
-		for j, val := range row {
-			rowStr[j+1] = strconv.FormatFloat(val, 'f', 4, 64)
+// 由波动率表的样本数反推计算时用了多少根K线：窗口 w 的样本数为 K线数 - w
+func volatilityBarCount(volatilityData map[int]VolatilityData) int {
+	smallest := 0
+	for window := range volatilityData {
+		if smallest == 0 || window < smallest {
+			smallest = window
 		}
-		writer.Write(rowStr)
+	}
+	if smallest == 0 {
+		return 0
+	}
+	return volatilityData[smallest].SampleCount + smallest
+}
+
+// 检查波动率表是否基于当前的价格数据计算，K线数相差超过容差时返回错误
+func checkVolatilityFreshness(volatilityData map[int]VolatilityData, bars int) error {
+	volBars := volatilityBarCount(volatilityData)
+	if volBars == 0 || bars == 0 {
+		return nil
+	}
+	diff := math.Abs(float64(bars-volBars)) / float64(bars)
+	if diff > volatilityStaleTolerance {
+		return fmt.Errorf("波动率表基于 %d 根K线计算，当前价格数据有 %d 根（相差 %.1f%%，超过 %.0f%% 的容差），波动率表可能已过期，请重新运行 calculate_volatility",
+			volBars, bars, diff*100, volatilityStaleTolerance*100)
+	}
+	return nil
+}
+
+// 统计K线CSV的数据行数（不含标题行），只数行不解析，用于和波动率表核对
+func countKlineRows(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
 
-		if (i+1)%1000 == 0 {
-			fmt.Printf("已写入 %d/%d 行\n", i+1, len(matrix))
+	rows := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			rows++
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if rows > 0 {
+		rows-- // 标题行
+	}
+	return rows, nil
+}
 
-	fmt.Printf("\n计算完成！\n")
-	fmt.Printf("矩阵大小: %d x %d\n", len(recent7Days), maxWindow)
-	fmt.Printf("结果已保存到 zscore_matrix.csv\n")
+// 用最近 n 根K线（含当前）的中位数过滤价格，去掉瞬间的错误报价：单根离群的价格在 n>=3 时会被完全剔除，
+// 而持续的真实行情只会滞后约 (n-1)/2 根K线；n<=1 时原样返回
+// 和 -smooth 一样，波动率和z-score必须使用相同的 -median；前 n-1 个点用已有的K线取中位数
+func medianFilter(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	filtered := make([]float64, len(prices))
+	// 窗口内的价格按升序保存，每次滑动二分查找删除最旧的、插入最新的
+	sorted := make([]float64, 0, n)
+	for i, p := range prices {
+		if i >= n {
+			old := prices[i-n]
+			j := sort.SearchFloat64s(sorted, old)
+			sorted = append(sorted[:j], sorted[j+1:]...)
+		}
+		j := sort.SearchFloat64s(sorted, p)
+		sorted = append(sorted, 0)
+		copy(sorted[j+1:], sorted[j:])
+		sorted[j] = p
+
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 1 {
+			filtered[i] = sorted[mid]
+		} else {
+			filtered[i] = (sorted[mid-1] + sorted[mid]) / 2
+		}
+	}
+	return filtered
 }
 
-type VolatilityData struct {
-	Mean   float64
-	StdDev float64
+// 按 -max-cpus 限制并行度，返回 worker 数
+// maxCPUs > 0 时同时设置 GOMAXPROCS，让其他 goroutine（如写文件、GC）也不超过该CPU数；<= 0 表示使用全部CPU
+func setMaxCPUs(maxCPUs int) int {
+	if maxCPUs <= 0 || maxCPUs >= runtime.NumCPU() {
+		return runtime.NumCPU()
+	}
+	runtime.GOMAXPROCS(maxCPUs)
+	return maxCPUs
+}
+
+// 检测单根K线的异常跳变（例如小数点错位导致价格差10倍），这类错误报价会让波动率和z-score严重失真
+// 与最近一个正常价格相比涨跌幅超过 maxBarMove（0.5 即 50%）的K线视为异常，直到价格回到该范围内为止；
+// 返回修复后的副本（异常K线按两侧正常价格线性插值）和被标记的下标，调用方可以只报告不使用副本
+// 数据末尾没有回到正常范围的异常K线无法插值，只标记不修复；maxBarMove<=0 时不检测
+func sanitizePrices(prices []float64, maxBarMove float64) ([]float64, []int) {
+	if maxBarMove <= 0 || len(prices) == 0 {
+		return prices, nil
+	}
+	repaired := make([]float64, len(prices))
+	copy(repaired, prices)
+
+	var flagged []int
+	lastGood := -1 // 最近一个正常价格的下标，第一个有效价格视为正常
+	for i, p := range prices {
+		if !(p > 0) {
+			continue // 缺失或非正数的价格由收益率计算跳过，这里不处理
+		}
+		if lastGood < 0 || math.Abs(p/prices[lastGood]-1) <= maxBarMove {
+			// 回到正常范围，对中间被标记的K线插值
+			for j := lastGood + 1; lastGood >= 0 && j < i; j++ {
+				if prices[j] > 0 {
+					frac := float64(j-lastGood) / float64(i-lastGood)
+					repaired[j] = prices[lastGood] + (p-prices[lastGood])*frac
+				}
+			}
+			lastGood = i
+			continue
+		}
+		flagged = append(flagged, i)
+	}
+	return repaired, flagged
+}
+
+// 报告异常跳变的K线，repair 为 true 时返回插值修复后的价格，否则原样返回
+func checkPriceSpikes(klines []Kline, prices []float64, maxBarMove float64, repair bool) []float64 {
+	repaired, flagged := sanitizePrices(prices, maxBarMove)
+	if len(flagged) == 0 {
+		return prices
+	}
+	fmt.Printf("警告: %d 根K线与前一个正常价格相差超过 %.0f%%，可能是错误报价（首个在 %s，价格 %v）\n",
+		len(flagged), maxBarMove*100, klines[flagged[0]].Time, prices[flagged[0]])
+	if !repair {
+		fmt.Println("可以用 -repair-spikes 按两侧正常价格插值修复")
+		return prices
+	}
+	unrepaired := 0
+	for _, i := range flagged {
+		if repaired[i] == prices[i] {
+			unrepaired++
+		}
+	}
+	fmt.Printf("已插值修复 %d 根K线", len(flagged)-unrepaired)
+	if unrepaired > 0 {
+		fmt.Printf("，数据末尾的 %d 根没有回到正常范围，无法修复", unrepaired)
+	}
+	fmt.Println()
+	return repaired
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
 }
 
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
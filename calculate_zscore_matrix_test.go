@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 缺少波动率数据和标准差为0的窗口写为 NaN，历史不足的窗口写为0
+func TestComputeZScoreRow(t *testing.T) {
+	prices := []float64{100, 101, 102, 103}
+	windows := []int{1, 2, 3, 5}
+	volatilityData := map[int]VolatilityData{
+		1: {Mean: 0, StdDev: 1},
+		3: {Mean: 0, StdDev: 0},
+		5: {Mean: 0, StdDev: 1},
+	}
+	row := make([]float64, len(windows))
+	computeZScoreRow(prices, 3, windows, volatilityData, "simple", row)
+
+	if want := (103.0 - 102) / 102 * 100; math.Abs(row[0]-want) > 1e-9 {
+		t.Errorf("1 分钟 z-score = %v, want %v", row[0], want)
+	}
+	if !math.IsNaN(row[1]) {
+		t.Errorf("缺少波动率数据的窗口 = %v, want NaN", row[1])
+	}
+	if !math.IsNaN(row[2]) {
+		t.Errorf("标准差为0的窗口 = %v, want NaN", row[2])
+	}
+	if row[3] != 0 {
+		t.Errorf("历史不足的窗口 = %v, want 0", row[3])
+	}
+}
+
+// 滚动基准在价格不变（方差为0）时写 NaN
+func TestRollingZScoreFlatPrices(t *testing.T) {
+	prices := make([]float64, 10)
+	for i := range prices {
+		prices[i] = 100
+	}
+	matrix := make([][]float64, len(prices))
+	for i := range matrix {
+		matrix[i] = make([]float64, 1)
+	}
+	computeRollingZScoreColumn(prices, 0, 1, 0, 5, "simple", matrix)
+	for t0 := 3; t0 < len(matrix); t0++ {
+		if !math.IsNaN(matrix[t0][0]) {
+			t.Errorf("第 %d 行 = %v, want NaN", t0, matrix[t0][0])
+		}
+	}
+}
+
+// 波动率从 0.1% 升到 0.5% 的价格序列：固定基准（全部历史的均值和标准差）下后段大量 |z| > 2，
+// 滚动基准只看最近 1000 根K线，进入新状态后 |z| > 2 的比例回到正态的约 5%；抽查的点与直接计算一致
+func TestRollingBaselineRegimeChange(t *testing.T) {
+	const calm, volatile, baseline = 4000, 3000, 1000
+	rng := rand.New(rand.NewSource(5))
+	prices := make([]float64, calm+volatile)
+	prices[0] = 2000
+	for i := 1; i < len(prices); i++ {
+		sigma := 0.001
+		if i >= calm {
+			sigma = 0.005
+		}
+		prices[i] = prices[i-1] * (1 + sigma*rng.NormFloat64())
+	}
+	windows := []int{1}
+
+	var returns []float64
+	for i := 1; i < len(prices); i++ {
+		returns = append(returns, calculateReturn(prices[i-1], prices[i], "simple"))
+	}
+	mean, stdDev := meanStdDev(returns)
+	fixed := newMatrix(len(prices), len(windows))
+	buildZScoreMatrix(prices, windows, map[int]VolatilityData{1: {Mean: mean, StdDev: stdDev}}, "simple", 2, fixed, nil)
+	rolling := newMatrix(len(prices), len(windows))
+	buildRollingZScoreMatrix(prices, 0, baseline, windows, "simple", 2, rolling, nil)
+
+	exceed := func(matrix [][]float64) float64 {
+		n := 0
+		for _, row := range matrix[calm+baseline:] {
+			if math.Abs(row[0]) > 2 {
+				n++
+			}
+		}
+		return float64(n) / float64(volatile-baseline)
+	}
+	if got := exceed(fixed); got < 0.15 {
+		t.Errorf("固定基准下新状态 |z| > 2 的比例 = %.3f, want > 0.15", got)
+	}
+	if got := exceed(rolling); got < 0.02 || got > 0.08 {
+		t.Errorf("滚动基准下新状态 |z| > 2 的比例 = %.3f, want 约 0.05", got)
+	}
+
+	for _, row := range []int{baseline + 10, calm + 10, calm + baseline + 500, len(prices) - 1} {
+		m, s := meanStdDev(returns[row-1-baseline : row-1])
+		want := (returns[row-1] - m) / s
+		if got := rolling[row][0]; math.Abs(got-want) > 1e-9*math.Max(1, math.Abs(want)) {
+			t.Errorf("第 %d 行滚动 z-score = %v, 直接计算为 %v", row, got, want)
+		}
+	}
+}
+
+// 样本均值和样本标准差（n-1）
+func meanStdDev(values []float64) (float64, float64) {
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)-1))
+}
+
+// 随机游走的价格和部分窗口缺少数据、标准差为0的波动率表
+func matrixTestData(n int) ([]float64, []int, map[int]VolatilityData) {
+	rng := rand.New(rand.NewSource(1))
+	prices := make([]float64, n)
+	prices[0] = 2000
+	for i := 1; i < n; i++ {
+		prices[i] = prices[i-1] * math.Exp(rng.NormFloat64()*0.001)
+	}
+	windows := []int{1, 5, 15, 60, 240, 1440}
+	volatilityData := map[int]VolatilityData{
+		1:    {Mean: 0.001, StdDev: 0.1},
+		5:    {Mean: -0.002, StdDev: 0.22},
+		15:   {Mean: 0, StdDev: 0},
+		240:  {Mean: 0.01, StdDev: 1.5},
+		1440: {Mean: 0.02, StdDev: 3},
+	}
+	return prices, windows, volatilityData
+}
+
+// 并行计算的矩阵与逐行顺序计算的完全相同（包括 NaN），进度按 1..total 顺序回调
+func TestBuildZScoreMatrixParallelMatchesSequential(t *testing.T) {
+	prices, windows, volatilityData := matrixTestData(3000)
+	for _, returnMode := range []string{"simple", "log"} {
+		sequential := newMatrix(len(prices), len(windows))
+		for timeIdx := range prices {
+			computeZScoreRow(prices, timeIdx, windows, volatilityData, returnMode, sequential[timeIdx])
+		}
+
+		for _, workers := range []int{1, 4, 16} {
+			parallel := newMatrix(len(prices), len(windows))
+			next := 1
+			buildZScoreMatrix(prices, windows, volatilityData, returnMode, workers, parallel, func(done, total int) {
+				if done != next || total != len(prices) {
+					t.Errorf("进度回调 (%d, %d), want (%d, %d)", done, total, next, len(prices))
+				}
+				next++
+			})
+			if next != len(prices)+1 {
+				t.Errorf("%d 个 worker: 进度回调 %d 次, want %d", workers, next-1, len(prices))
+			}
+			for timeIdx := range sequential {
+				for col := range windows {
+					if math.Float64bits(parallel[timeIdx][col]) != math.Float64bits(sequential[timeIdx][col]) {
+						t.Fatalf("%s %d 个 worker: 第 %d 行 %d 分钟 = %v, 顺序计算为 %v",
+							returnMode, workers, timeIdx, windows[col], parallel[timeIdx][col], sequential[timeIdx][col])
+					}
+				}
+			}
+		}
+	}
+}
+
+// worker 池不超过 -max-cpus 给出的上限：进度回调时正在运行的 goroutine 最多为调用前的数量加 worker 数
+func TestBuildZScoreMatrixRespectsWorkerCap(t *testing.T) {
+	prices, windows, volatilityData := matrixTestData(2000)
+	for _, workers := range []int{1, 2, 3} {
+		base := runtime.NumGoroutine()
+		peak := 0
+		matrix := newMatrix(len(prices), len(windows))
+		buildZScoreMatrix(prices, windows, volatilityData, "simple", workers, matrix, func(done, total int) {
+			if n := runtime.NumGoroutine() - base; n > peak {
+				peak = n
+			}
+		})
+		if peak > workers {
+			t.Errorf("上限 %d 个 worker: 同时运行 %d 个 goroutine", workers, peak)
+		}
+		if last := matrix[len(prices)-1]; last[0] == 0 {
+			t.Errorf("上限 %d 个 worker: 最后一行没有算完", workers)
+		}
+	}
+}
+
+// 编译矩阵工具，并在临时目录中写入两天的1分钟K线和 1 到 2879 分钟的波动率表
+func setupMatrixTool(t *testing.T) (string, string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("-short 时跳过编译工具")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "calculate_zscore_matrix")
+	if out, err := exec.Command("go", "build", "-o", binary, "calculate_zscore_matrix.go").CombinedOutput(); err != nil {
+		t.Fatalf("编译失败: %v\n%s", err, out)
+	}
+	const bars = 2 * 1440
+	klines := []string{"Open Time,Open Time (UTC),Open,High,Low,Close,Volume"}
+	for i := 0; i < bars; i++ {
+		price := 2000 + 10*math.Sin(float64(i)/7)
+		klines = append(klines, fmt.Sprintf("%d,2026-01-01 00:00:00,%f,%f,%f,%f,1", 1767225600000+int64(i)*60000, price, price, price, price))
+	}
+	volatility := []string{"# schema=3", "Window_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count,VaR_Pct,Realized_Vol_Pct"}
+	for window := 1; window < bars; window++ {
+		volatility = append(volatility, fmt.Sprintf("%d,0,0,0.1,%d,0,0", window, bars-window))
+	}
+	for name, lines := range map[string][]string{
+		"ETHUSDT_latest_14days.csv":      klines,
+		"ETHUSDT_minute_klines.csv":      klines,
+		"multi_timeframe_volatility.csv": volatility,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, binary
+}
+
+// -lookback-days 决定矩阵的大小：两天的数据上 1 天时取最后 1440 根K线，列为 1 到 1440 分钟窗口；
+// 3 天超出数据范围时报错
+func TestLookbackDaysMatrixSize(t *testing.T) {
+	dir, binary := setupMatrixTool(t)
+	args := []string{"-input-dir", dir, "-output-dir", dir, "-q", "-max-memory", "0"}
+	out, err := exec.Command(binary, append(args, "-lookback-days", "1")...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("运行失败: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "最近1天数据: 1440 条") {
+		t.Errorf("输出中没有取出的数据条数:\n%s", out)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "zscore_matrix.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")[1:]
+	header := strings.Split(lines[0], ",")
+	if len(header) != 1441 || header[1] != "1" || header[1440] != "1440" {
+		t.Errorf("标题有 %d 列（%s 到 %s）, want TimeIndex 和 1 到 1440", len(header), header[1], header[len(header)-1])
+	}
+	if rows := len(lines) - 1; rows != 1440 {
+		t.Errorf("矩阵有 %d 行, want 1440", rows)
+	}
+
+	out, err = exec.Command(binary, append(args, "-lookback-days", "3")...).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "数据不足，3 天需要至少 4320 条") {
+		t.Errorf("-lookback-days 3 应报数据不足: %v\n%s", err, out)
+	}
+}
+
+// -matrix-windows 只输出指定的窗口：列按窗口从小到大去重排列，标题为窗口的分钟数，
+// 每列的值与完整矩阵中同一窗口的列相同；超出 -lookback-days 的窗口报错
+func TestMatrixWindowsColumns(t *testing.T) {
+	dir, binary := setupMatrixTool(t)
+	readMatrix := func() [][]string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, "zscore_matrix.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n")[1:] {
+			rows = append(rows, strings.Split(line, ","))
+		}
+		return rows
+	}
+
+	args := []string{"-input-dir", dir, "-output-dir", dir, "-q", "-max-memory", "0", "-lookback-days", "1"}
+	if out, err := exec.Command(binary, args...).CombinedOutput(); err != nil {
+		t.Fatalf("运行失败: %v\n%s", err, out)
+	}
+	full := readMatrix()
+
+	if out, err := exec.Command(binary, append(args, "-matrix-windows", "60, 5,1440,5")...).CombinedOutput(); err != nil {
+		t.Fatalf("运行失败: %v\n%s", err, out)
+	}
+	restricted := readMatrix()
+	if got := strings.Join(restricted[0], ","); got != "TimeIndex,5,60,1440" {
+		t.Fatalf("标题 = %s, want TimeIndex,5,60,1440", got)
+	}
+	if len(restricted) != len(full) {
+		t.Fatalf("%d 行, 完整矩阵 %d 行", len(restricted), len(full))
+	}
+	for i := 1; i < len(full); i++ {
+		for j, window := range []int{5, 60, 1440} {
+			if restricted[i][j+1] != full[i][window] {
+				t.Fatalf("第 %d 行 %d 分钟 = %s, 完整矩阵为 %s", i, window, restricted[i][j+1], full[i][window])
+			}
+		}
+	}
+
+	out, err := exec.Command(binary, append(args, "-matrix-windows", "5,2000")...).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "无效的 -matrix-windows 窗口") {
+		t.Errorf("超出范围的窗口应报错: %v\n%s", err, out)
+	}
+}
+
+func BenchmarkBuildZScoreMatrix(b *testing.B) {
+	prices, windows, volatilityData := matrixTestData(7 * 1440)
+	matrix := newMatrix(len(prices), len(windows))
+	workerCounts := []int{1}
+	if n := runtime.GOMAXPROCS(0); n > 1 {
+		workerCounts = append(workerCounts, n)
+	}
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				buildZScoreMatrix(prices, windows, volatilityData, "simple", workers, matrix, nil)
+			}
+		})
+	}
+}
+
+// 内存估算与 newMatrix 实际分配的字节数一致（误差 1% 以内）；几十KB以下的矩阵会被分配器按规格向上取整，不在此列
+func TestMatrixMemoryEstimate(t *testing.T) {
+	for _, size := range [][2]int{{1024, 64}, {60, 1440}, {1440, 1440}} {
+		rows, cols := size[0], size[1]
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		matrix := newMatrix(rows, cols)
+		runtime.ReadMemStats(&after)
+		runtime.KeepAlive(matrix)
+
+		actual := int64(after.TotalAlloc - before.TotalAlloc)
+		estimate := matrixMemoryBytes(rows, cols)
+		if math.Abs(float64(actual-estimate)) > 0.01*float64(estimate) {
+			t.Errorf("%d x %d: 估算 %d 字节, 实际分配 %d 字节", rows, cols, estimate, actual)
+		}
+		if len(matrix) != rows || len(matrix[rows-1]) != cols || cap(matrix[0]) != cols {
+			t.Errorf("%d x %d: 矩阵形状错误", rows, cols)
+		}
+	}
+
+	if err := checkMatrixMemory(1440, 1440, matrixMemoryBytes(1440, 1440)); err != nil {
+		t.Errorf("刚好等于上限时不应报错: %v", err)
+	}
+	if err := checkMatrixMemory(1440, 1440, 0); err != nil {
+		t.Errorf("上限为 0 时不检查: %v", err)
+	}
+	if err := checkMatrixMemory(1440, 1440, 1<<20); err == nil || !strings.Contains(err.Error(), "-matrix-windows") {
+		t.Errorf("超过上限时应报错并建议 -matrix-windows: %v", err)
+	}
+	for value, want := range map[string]int64{"512MB": 512 << 20, "1.5g": 3 << 29, "2048": 2048, "0": 0} {
+		if got, err := parseByteSize(value); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("-1GB"); err == nil {
+		t.Error("负数应返回错误")
+	}
+}
+
+// 预计超过 -max-memory 时在分配矩阵之前退出：返回非零状态、提示缩小矩阵，不写出矩阵文件
+func TestMaxMemoryAborts(t *testing.T) {
+	dir, binary := setupMatrixTool(t)
+	out, err := exec.Command(binary, "-input-dir", dir, "-output-dir", dir, "-q", "-lookback-days", "1", "-max-memory", "1MB").CombinedOutput()
+	if err == nil {
+		t.Fatalf("超过内存上限时应退出失败:\n%s", out)
+	}
+	if !strings.Contains(string(out), "超过 1.0 MB 的内存上限") || strings.Contains(string(out), "开始计算") {
+		t.Errorf("输出应在开始计算前报告超出上限:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "zscore_matrix.csv")); !os.IsNotExist(err) {
+		t.Errorf("中止时不应写出矩阵文件: %v", err)
+	}
+}
+
+// 多个 worker 并行时进度回调仍按 done 递增的顺序逐个调用，最后一次 done 等于行数；回调为 nil 时照常计算
+func TestBuildZScoreMatrixProgress(t *testing.T) {
+	prices, windows, volatilityData := matrixTestData(500)
+	matrix := newMatrix(len(prices), len(windows))
+	var mu sync.Mutex
+	last := 0
+	buildZScoreMatrix(prices, windows, volatilityData, "simple", 4, matrix, func(done, total int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if done != last+1 || total != len(prices) {
+			t.Errorf("回调 (%d, %d), 上一次 done = %d, 行数 %d", done, total, last, len(prices))
+		}
+		last = done
+	})
+	if last != len(prices) {
+		t.Errorf("最后一次 done = %d, want %d", last, len(prices))
+	}
+
+	withoutProgress := newMatrix(len(prices), len(windows))
+	buildZScoreMatrix(prices, windows, volatilityData, "simple", 4, withoutProgress, nil)
+	// 矩阵中有 NaN，按文本比较
+	if fmt.Sprint(withoutProgress) != fmt.Sprint(matrix) {
+		t.Error("没有进度回调时结果不同")
+	}
+}
+
+// 计算到一半时中断（取消 ctx）：断点记录已完成的行；重新启动后从断点续算，最终矩阵与一次算完的完全相同（包括 NaN）；
+// 参数或数据变化后断点不能续用
+func TestMatrixCheckpointResume(t *testing.T) {
+	prices, windows, volatilityData := matrixTestData(1000)
+	want := newMatrix(len(prices), len(windows))
+	buildZScoreMatrix(prices, windows, volatilityData, "simple", 2, want, nil)
+
+	base := filepath.Join(t.TempDir(), "zscore_matrix.checkpoint")
+	fingerprint := matrixFingerprint(prices, windows, volatilityData, "simple")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := newMatrix(len(prices), len(windows))
+	checkpoint := newMatrixCheckpointer(base, fingerprint, interrupted)
+	err := buildZScoreMatrixCheckpointed(ctx, prices, windows, volatilityData, "simple", 2, interrupted, 0, checkpoint, 0, func(done, total int) {
+		if done == 400 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("中断时返回 %v, want context.Canceled", err)
+	}
+	// 取消时算完当前这一批（第 256 到 511 行）再写断点
+	if checkpoint.Completed() != 2*matrixCheckpointBatchRows {
+		t.Fatalf("断点记录 %d 行, want %d", checkpoint.Completed(), 2*matrixCheckpointBatchRows)
+	}
+
+	resumed := newMatrix(len(prices), len(windows))
+	checkpoint = newMatrixCheckpointer(base, fingerprint, resumed)
+	from, err := checkpoint.Load()
+	if err != nil || from != 2*matrixCheckpointBatchRows {
+		t.Fatalf("读取断点: from = %d, err = %v", from, err)
+	}
+	computed := 0
+	err = buildZScoreMatrixCheckpointed(context.Background(), prices, windows, volatilityData, "simple", 2, resumed, from, checkpoint, 0, func(done, total int) {
+		if computed == 0 && done != from+1 {
+			t.Errorf("续算的第一次回调 done = %d, want %d", done, from+1)
+		}
+		computed++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if computed != len(prices)-from {
+		t.Errorf("续算了 %d 行, want %d（已完成的行不应重算）", computed, len(prices)-from)
+	}
+	if fmt.Sprint(resumed) != fmt.Sprint(want) {
+		t.Error("续算得到的矩阵与一次算完的不同")
+	}
+
+	changed := newMatrixCheckpointer(base, matrixFingerprint(prices, windows, volatilityData, "log"), newMatrix(len(prices), len(windows)))
+	if _, err := changed.Load(); err == nil {
+		t.Error("收益率方式变化后断点不应能续用")
+	}
+	if err := checkpoint.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if from, err := newMatrixCheckpointer(base, fingerprint, newMatrix(len(prices), len(windows))).Load(); from != 0 || err != nil {
+		t.Errorf("删除断点后 Load() = %d, %v, want 从头开始", from, err)
+	}
+}
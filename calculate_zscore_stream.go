@@ -0,0 +1,1058 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func main() {
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log（z-score 需与 calculate_volatility 使用同一方式）")
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	marketName := flag.String("market", "spot", "行情市场: spot（现货）或 usdm（U本位合约）")
+	windowsFlag := flag.String("windows", "", "关键时间窗口（分钟，逗号分隔），默认 1,5,15,30,60,120,240,1440,2880,4320,10080")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	bufferSize := flag.Int("buffer", 64, "拉取和分析之间最多缓存的K线数，分析跟不上时丢弃最旧的K线，不阻塞拉取")
+	alertThreshold := flag.Float64("alert-threshold", 3, "|z| 超过该值时告警，0 表示不告警")
+	alertCooldown := flag.Duration("alert-cooldown", 15*time.Minute, "同一交易对同一窗口两次告警之间的最短间隔，期间持续超出阈值不再重复告警")
+	flag.Parse()
+	if *alertThreshold < 0 {
+		log.Fatalf("-alert-threshold 不能为负数: %v", *alertThreshold)
+	}
+	if *alertCooldown < 0 {
+		log.Fatalf("-alert-cooldown 不能为负数: %v", *alertCooldown)
+	}
+	if *bufferSize < 1 {
+		log.Fatalf("-buffer 必须大于等于 1: %d", *bufferSize)
+	}
+	windows, err := parseWindows(*windowsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	market, err := parseMarket(*marketName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+
+	fmt.Println("正在读取数据...")
+
+	// 读取波动率数据
+	volatilityData, err := loadVolatilityData(inputPath("multi_timeframe_volatility.csv"), *strict)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 读取最新的14天数据用于预热
+	klines, _, err := loadKlines(inputPath(klinesFileName("latest_14days")), *strict)
+	if err != nil {
+		log.Fatal("读取价格数据失败:", err)
+	}
+
+	tracker := newZScoreTracker(volatilityData, windows, *returnMode)
+
+	var lastOpenTime int64
+	for _, k := range klines {
+		tracker.Update(k.Close)
+		lastOpenTime = k.OpenTime
+	}
+	fmt.Printf("预热完成，已载入 %d 条价格\n\n", tracker.count)
+
+	// 拉取和分析分开运行：拉取协程只负责把新K线放进有界缓冲，分析慢时丢弃最旧的K线而不是卡住拉取
+	symbol := composeSymbol(baseAsset, symbolSuffix)
+	queue := newDropOldestQueue(*bufferSize)
+	go pollKlines(market, symbol, lastOpenTime, queue)
+	alerts := newAlertManager(*alertThreshold, *alertCooldown)
+
+	var reportedDrops int64
+	for event := range queue.C() {
+		if dropped := queue.Dropped(); dropped > reportedDrops {
+			fmt.Printf("警告: 分析跟不上，已丢弃 %d 根K线（累计 %d）\n", dropped-reportedDrops, dropped)
+			reportedDrops = dropped
+		}
+		// 中间缺的K线（被丢弃或没拉到）按缺失数据处理，跨过缺口的窗口 z-score 为 NaN
+		if gap := int((event.OpenTime-lastOpenTime)/60000) - 1; gap > 0 {
+			fmt.Printf("警告: 缺少 %d 根K线，跨过缺口的窗口 z-score 为 NaN\n", gap)
+			tracker.Skip(gap)
+		}
+		lastOpenTime = event.OpenTime
+
+		zScores := tracker.Update(event.Close)
+		eventTime := time.UnixMilli(event.OpenTime)
+		fmt.Printf("%s 价格: %.2f\n", eventTime.Format("2006-01-02 15:04:05"), event.Close)
+		for _, window := range windows {
+			z, ok := zScores[window]
+			if !ok {
+				continue
+			}
+			if math.IsNaN(z) {
+				fmt.Printf("  %d 分钟: z-score = NaN（跨过缺失数据或标准差为0）\n", window)
+				continue
+			}
+			fmt.Printf("  %d 分钟: z-score = %.4f\n", window, z)
+			if *alertThreshold == 0 {
+				continue
+			}
+			switch alerts.Observe(symbol, window, z, eventTime) {
+			case AlertFired:
+				fmt.Printf("  告警: %s %d 分钟 z-score = %.4f，超过 ±%.2f\n", symbol, window, z, *alertThreshold)
+			case AlertRecovered:
+				fmt.Printf("  恢复: %s %d 分钟 z-score = %.4f，回到 ±%.2f 以内\n", symbol, window, z, *alertThreshold)
+			}
+		}
+	}
+}
+
+// 一根已收盘的K线
+type KlineEvent struct {
+	OpenTime int64
+	Close    float64
+}
+
+// 有界缓冲：满了之后新K线挤掉最旧的一根，生产方永远不会阻塞
+// 对实时分析来说最新的K线最重要，积压的旧K线丢掉比让拉取停下来好
+type DropOldestQueue struct {
+	ch      chan KlineEvent
+	mu      sync.Mutex // 保证 Push 的“挤掉最旧再放入”不会和另一个 Push 交错
+	dropped int64
+}
+
+func newDropOldestQueue(size int) *DropOldestQueue {
+	return &DropOldestQueue{ch: make(chan KlineEvent, size)}
+}
+
+func (q *DropOldestQueue) Push(event KlineEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		select {
+		case q.ch <- event:
+			return
+		default:
+		}
+		// 缓冲已满，丢掉最旧的一根；消费方恰好取走时直接重试放入
+		select {
+		case <-q.ch:
+			atomic.AddInt64(&q.dropped, 1)
+		default:
+		}
+	}
+}
+
+func (q *DropOldestQueue) C() <-chan KlineEvent {
+	return q.ch
+}
+
+// 累计丢弃的K线数
+func (q *DropOldestQueue) Dropped() int64 {
+	return atomic.LoadInt64(&q.dropped)
+}
+
+// 拉取失败后的重试间隔，从 streamRetryMin 开始每次翻倍，最长 streamRetryMax
+const (
+	streamRetryMin = 5 * time.Second
+	streamRetryMax = time.Minute
+)
+
+// 每分钟拉取最新一根已收盘的K线，放入缓冲；请求失败时按退避间隔重试，网络恢复后自动继续
+func pollKlines(market Market, symbol string, lastOpenTime int64, queue *DropOldestQueue) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	backoff := streamRetryMin
+	for {
+		openTime, closePrice, err := fetchLastClosedKline(market, symbol)
+		if err != nil {
+			log.Printf("获取K线失败，%v 后重试: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > streamRetryMax {
+				backoff = streamRetryMax
+			}
+			continue
+		}
+		backoff = streamRetryMin
+		if openTime > lastOpenTime {
+			lastOpenTime = openTime
+			queue.Push(KlineEvent{OpenTime: openTime, Close: closePrice})
+		}
+		<-ticker.C
+	}
+}
+
+// 行情市场，决定请求的域名和接口路径
+type Market int
+
+const (
+	Spot Market = iota
+	USDMFutures
+)
+
+func parseMarket(name string) (Market, error) {
+	switch name {
+	case "spot":
+		return Spot, nil
+	case "usdm":
+		return USDMFutures, nil
+	}
+	return Spot, fmt.Errorf("未知的市场: %s（可选 spot 或 usdm）", name)
+}
+
+// 各市场的 REST 接口地址，测试中指向 httptest 服务器
+var marketBaseURLs = map[Market]string{
+	Spot:        "https://api.binance.com",
+	USDMFutures: "https://fapi.binance.com",
+}
+
+func (m Market) BaseURL() string {
+	return marketBaseURLs[m]
+}
+
+// 两个市场的K线返回格式相同，只有路径不同
+func (m Market) KlinesPath() string {
+	if m == USDMFutures {
+		return "/fapi/v1/klines"
+	}
+	return "/api/v3/klines"
+}
+
+// 请求最近两根1分钟K线，返回倒数第二根（最后一根已收盘）的开盘时间和收盘价
+func fetchLastClosedKline(market Market, symbol string) (int64, float64, error) {
+	url := fmt.Sprintf("%s%s?symbol=%s&interval=1m&limit=2", market.BaseURL(), market.KlinesPath(), symbol)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var klines [][]interface{}
+	if err := json.Unmarshal(body, &klines); err != nil {
+		return 0, 0, fmt.Errorf("解析K线失败: %v, 原始数据: %s", err, body)
+	}
+	if len(klines) < 2 || len(klines[0]) < 5 {
+		return 0, 0, fmt.Errorf("K线数据不足: %s", body)
+	}
+
+	openTime, ok := klines[0][0].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("开盘时间格式错误: %v", klines[0][0])
+	}
+	closeStr, ok := klines[0][4].(string)
+	if !ok {
+		return 0, 0, fmt.Errorf("收盘价格式错误: %v", klines[0][4])
+	}
+	closePrice, err := strconv.ParseFloat(closeStr, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !(closePrice > 0) {
+		return 0, 0, fmt.Errorf("收盘价必须大于 0: %s", closeStr)
+	}
+	return int64(openTime), closePrice, nil
+}
+
+// Observe 对一次 z-score 观测的处理结果
+type AlertAction int
+
+const (
+	AlertNone       AlertAction = iota // 在阈值以内，或数据缺失
+	AlertFired                         // 超出阈值，需要告警
+	AlertSuppressed                    // 超出阈值，但仍在冷却期内，不重复告警
+	AlertRecovered                     // 告警过之后回到阈值以内
+)
+
+type AlertKey struct {
+	Symbol string
+	Window int
+}
+
+type alertState struct {
+	active    bool      // 当前是否处于超出阈值的状态
+	fired     bool      // 本次超出阈值期间是否已经告警过
+	lastFired time.Time // 最近一次告警的时间（K线时间）
+}
+
+// 告警去重：按 (交易对, 窗口) 记录状态，价格持续极端时只在第一次和每过一个冷却期告警一次，
+// 回到阈值以内时发出一次恢复通知；恢复后冷却期内再次超出同样不告警，避免在阈值附近来回抖动时刷屏
+// 时间用K线时间而不是系统时间，回放历史数据时结果一致；可以被多个协程同时调用
+type AlertManager struct {
+	mu        sync.Mutex
+	threshold float64
+	cooldown  time.Duration
+	states    map[AlertKey]*alertState
+}
+
+func newAlertManager(threshold float64, cooldown time.Duration) *AlertManager {
+	return &AlertManager{
+		threshold: threshold,
+		cooldown:  cooldown,
+		states:    make(map[AlertKey]*alertState),
+	}
+}
+
+// 记录一次观测并返回需要采取的动作，NaN（跨过缺失数据）不改变状态
+func (m *AlertManager) Observe(symbol string, window int, z float64, now time.Time) AlertAction {
+	if math.IsNaN(z) {
+		return AlertNone
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := AlertKey{Symbol: symbol, Window: window}
+	state, ok := m.states[key]
+	if !ok {
+		state = &alertState{}
+		m.states[key] = state
+	}
+
+	if math.Abs(z) <= m.threshold {
+		if !state.active {
+			return AlertNone
+		}
+		state.active = false
+		if state.fired {
+			state.fired = false
+			return AlertRecovered
+		}
+		return AlertNone
+	}
+
+	state.active = true
+	if !state.lastFired.IsZero() && now.Sub(state.lastFired) < m.cooldown {
+		return AlertSuppressed
+	}
+	state.fired = true
+	state.lastFired = now
+	return AlertFired
+}
+
+type VolatilityData struct {
+	Mean        float64
+	StdDev      float64
+	SampleCount int
+}
+
+// 增量计算z-score：用环形缓冲区保存最近 maxWindow+1 个价格，
+// 每来一根新K线只计算被跟踪窗口的z-score，结果与批量矩阵一致
+type ZScoreTracker struct {
+	volatilityData map[int]VolatilityData
+	windows        []int
+	returnMode     string
+	prices         []float64 // 环形缓冲区
+	next           int       // 下一个写入位置
+	count          int       // 已写入的价格总数
+}
+
+func newZScoreTracker(volatilityData map[int]VolatilityData, windows []int, returnMode string) *ZScoreTracker {
+	maxWindow := 0
+	for _, w := range windows {
+		if w > maxWindow {
+			maxWindow = w
+		}
+	}
+	return &ZScoreTracker{
+		volatilityData: volatilityData,
+		windows:        windows,
+		returnMode:     returnMode,
+		prices:         make([]float64, maxWindow+1),
+	}
+}
+
+// 跳过 n 根缺失的K线，按 NaN 价格写入缓冲区，引用到它们的窗口 z-score 为 NaN
+func (t *ZScoreTracker) Skip(n int) {
+	for i := 0; i < n && i < len(t.prices); i++ {
+		t.prices[t.next] = math.NaN()
+		t.next = (t.next + 1) % len(t.prices)
+	}
+	t.count += n
+}
+
+// 推入最新价格，返回每个跟踪窗口的当前z-score
+// 历史数据不足或缺少波动率数据的窗口不出现在结果中；跨过缺失数据或标准差为0的窗口为 NaN
+func (t *ZScoreTracker) Update(price float64) map[int]float64 {
+	t.prices[t.next] = price
+	current := t.next
+	t.next = (t.next + 1) % len(t.prices)
+	t.count++
+
+	zScores := make(map[int]float64, len(t.windows))
+	for _, window := range t.windows {
+		if window >= t.count {
+			continue
+		}
+		volData, exists := t.volatilityData[window]
+		if !exists {
+			continue
+		}
+
+		prevPrice := t.prices[(current-window+len(t.prices))%len(t.prices)]
+		returnPct := calculateReturn(prevPrice, price, t.returnMode)
+
+		// 标准差为0时z-score没有意义，写为 NaN 而不是0，避免被当作“正好在均值上”
+		zScore := math.NaN()
+		if volData.StdDev > 0 {
+			zScore = (returnPct - volData.Mean) / volData.StdDev
+		}
+		zScores[window] = zScore
+	}
+	return zScores
+}
+
+// 计算 timeIdx 时刻各窗口的z-score，row[j] 对应 windows[j]
+// 缺少波动率数据或标准差为0的窗口设为 NaN，window > timeIdx 无法计算的窗口设为0
+func computeZScoreRow(prices []float64, timeIdx int, windows []int, volatilityData map[int]VolatilityData, returnMode string, row []float64) {
+	currentPrice := prices[timeIdx]
+
+	// 对于每个时间窗口
+	for j, window := range windows {
+		// 对于 window > timeIdx 的情况，无法计算，设为0
+		if window > timeIdx {
+			row[j] = 0
+			continue
+		}
+		prevPrice := prices[timeIdx-window]
+		returnPct := calculateReturn(prevPrice, currentPrice, returnMode)
+
+		// 获取该窗口的均值和标准差
+		volData, exists := volatilityData[window]
+		if !exists {
+			row[j] = math.NaN()
+			continue
+		}
+
+		// 计算z-score，标准差为0时没有意义，和缺少波动率数据一样写为 NaN
+		zScore := math.NaN()
+		if volData.StdDev > 0 {
+			zScore = (returnPct - volData.Mean) / volData.StdDev
+		}
+
+		row[j] = zScore
+	}
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 旧版本文件的迁移说明，Compatible 表示当前读取方仍能正确解析（例如只在末尾追加过列）
+type SchemaMigration struct {
+	Compatible bool
+	Note       string
+}
+
+// 读取带版本行的CSV，返回版本号和全部记录（含标题行）
+func readSchemaCSV(path string) (int, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	br, err := newCSVInput(path, file)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	if prefix, err := br.Peek(len(schemaPrefix)); err == nil && string(prefix) == schemaPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaPrefix)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s 的版本行格式错误: %q", path, strings.TrimSpace(line))
+		}
+	}
+
+	records, err := newCSVReader(br).ReadAll()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, records, nil
+}
+
+// 校验版本和标题：当前版本直接通过，兼容的旧版本打印迁移说明后继续，其余情况报错
+// expected 为读取方依赖的前几列
+func checkSchema(name string, version, current int, migrations map[int]SchemaMigration, records [][]string, expected []string) error {
+	if version != current {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%s 的版本 schema=%d 无法识别（当前为 schema=%d），请用当前版本的工具重新生成", name, version, current)
+		}
+		if !m.Compatible {
+			return fmt.Errorf("%s 是不兼容的旧版本 schema=%d: %s", name, version, m.Note)
+		}
+		fmt.Printf("注意: %s 是旧版本 schema=%d: %s\n", name, version, m.Note)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("%s 缺少标题行", name)
+	}
+	header := records[0]
+	if len(header) < len(expected) {
+		return fmt.Errorf("%s 的标题只有 %d 列，至少需要 %d 列", name, len(header), len(expected))
+	}
+	for i, col := range expected {
+		if header[i] != col {
+			return fmt.Errorf("%s 第 %d 列应为 %s，实际为 %s", name, i+1, col, header[i])
+		}
+	}
+	return nil
+}
+
+// 波动率表的版本，列有变化时加1，并在 volatilitySchemaMigrations 中说明旧版本如何处理
+const volatilitySchemaVersion = 3
+
+// 读取波动率表时依赖的列
+var volatilityRequiredHeader = []string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count"}
+
+// 旧版本波动率表的迁移说明
+var volatilitySchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有 VaR_Pct 列（由旧版 calculate_volatility 或 calculate_multi_timeframe_volatility.py 生成），不影响z-score计算，需要 VaR 时请重新运行 calculate_volatility"},
+	2: {Compatible: true, Note: "没有 Realized_Vol_Pct 列，不影响z-score计算"},
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// 读取 calculate_volatility 输出的波动率表
+// 无法解析的行默认跳过，strict 为 true 时返回错误
+func loadVolatilityData(path string, strict bool) (map[int]VolatilityData, error) {
+	version, volRecords, err := readSchemaCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取波动率CSV失败: %v", err)
+	}
+	if err := checkSchema("multi_timeframe_volatility.csv", version, volatilitySchemaVersion, volatilitySchemaMigrations, volRecords, volatilityRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	// 解析波动率数据（跳过标题行）
+	volatilityData := make(map[int]VolatilityData)
+	for i := 1; i < len(volRecords); i++ {
+		data, window, err := parseVolatilityRow(volRecords[i])
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("multi_timeframe_volatility.csv 第 %d 条数据解析失败: %v", i, err)
+			}
+			continue
+		}
+		volatilityData[window] = data
+	}
+	return volatilityData, nil
+}
+
+// 解析波动率表的一行: Window_Minutes, Window_Days, Mean_Pct, StdDev_Pct, Sample_Count, ...
+func parseVolatilityRow(record []string) (VolatilityData, int, error) {
+	if len(record) < 5 {
+		return VolatilityData{}, 0, fmt.Errorf("列数不足: 需要至少 5 列，实际 %d 列", len(record))
+	}
+	window, err := strconv.Atoi(record[0])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Window_Minutes 格式错误: %v", err)
+	}
+	mean, err := strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Mean_Pct 格式错误: %v", err)
+	}
+	stdDev, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("StdDev_Pct 格式错误: %v", err)
+	}
+	sampleCount, err := strconv.Atoi(record[4])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Sample_Count 格式错误: %v", err)
+	}
+	return VolatilityData{Mean: mean, StdDev: stdDev, SampleCount: sampleCount}, window, nil
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
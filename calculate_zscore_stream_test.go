@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 标准差为0的窗口 z-score 为 NaN，不能当作0（正好在均值上）
+func TestZScoreTrackerZeroStdDev(t *testing.T) {
+	volatilityData := map[int]VolatilityData{
+		1: {Mean: 0, StdDev: 0.5},
+		2: {Mean: 0, StdDev: 0},
+	}
+	tracker := newZScoreTracker(volatilityData, []int{1, 2}, "simple")
+	tracker.Update(100)
+	tracker.Update(101)
+	zScores := tracker.Update(102)
+
+	want := (102.0 - 101) / 101 * 100 / 0.5
+	if z := zScores[1]; math.Abs(z-want) > 1e-9 {
+		t.Errorf("1 分钟 z-score = %v, want %v", z, want)
+	}
+	if z, ok := zScores[2]; !ok || !math.IsNaN(z) {
+		t.Errorf("标准差为0的窗口 z-score = %v (ok=%v), want NaN", z, ok)
+	}
+}
+
+func sameZScore(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
+
+// 逐根推入价格，流式z-score与批量矩阵（computeZScoreRow）在每个相同下标上一致（包括环形缓冲区多次回绕之后）；
+// 矩阵中 window > 下标（写为0）和缺少波动率数据（写为 NaN）的窗口，流式结果中不出现
+func TestZScoreTrackerMatchesBatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	prices := make([]float64, 2000)
+	prices[0] = 2000
+	for i := 1; i < len(prices); i++ {
+		prices[i] = prices[i-1] * math.Exp(rng.NormFloat64()*0.001)
+	}
+	windows := []int{1, 5, 15, 60, 240}
+	volatilityData := map[int]VolatilityData{
+		1:   {Mean: 0.001, StdDev: 0.1},
+		5:   {Mean: -0.002, StdDev: 0.22},
+		15:  {Mean: 0, StdDev: 0},
+		240: {Mean: 0.01, StdDev: 1.5},
+	}
+
+	for _, returnMode := range []string{"simple", "log"} {
+		tracker := newZScoreTracker(volatilityData, windows, returnMode)
+		row := make([]float64, len(windows))
+		for i, price := range prices {
+			got := tracker.Update(price)
+			computeZScoreRow(prices, i, windows, volatilityData, returnMode, row)
+			for j, window := range windows {
+				z, ok := got[window]
+				if _, exists := volatilityData[window]; window > i || !exists {
+					if ok {
+						t.Fatalf("%s 第 %d 根 %d 分钟: 流式 %v, 矩阵中无法计算（%v），want 不出现", returnMode, i, window, z, row[j])
+					}
+					continue
+				}
+				if !ok || !sameZScore(z, row[j]) {
+					t.Fatalf("%s 第 %d 根 %d 分钟: 流式 %v (ok=%v), 批量 %v", returnMode, i, window, z, ok, row[j])
+				}
+			}
+		}
+	}
+}
+
+// 跳过的K线按缺失数据处理，引用到缺口的窗口为 NaN，缺口移出窗口后恢复
+func TestZScoreTrackerSkip(t *testing.T) {
+	volatilityData := map[int]VolatilityData{1: {StdDev: 1}, 3: {StdDev: 1}}
+	tracker := newZScoreTracker(volatilityData, []int{1, 3}, "simple")
+	for _, price := range []float64{100, 101, 102, 103} {
+		tracker.Update(price)
+	}
+	tracker.Skip(1)
+	zScores := tracker.Update(104)
+	if !math.IsNaN(zScores[1]) || math.IsNaN(zScores[3]) {
+		t.Errorf("缺口后第一根: %v, want 1 分钟为 NaN、3 分钟有值", zScores)
+	}
+	tracker.Update(105)
+	zScores = tracker.Update(106)
+	if math.IsNaN(zScores[1]) || !math.IsNaN(zScores[3]) {
+		t.Errorf("缺口后第三根: %v, want 1 分钟有值、3 分钟为 NaN", zScores)
+	}
+}
+
+// 合约市场的最新K线从 /fapi/v1/klines 获取，返回已收盘的那一根（倒数第二根）
+func TestFetchLastClosedKlineFutures(t *testing.T) {
+	var path, query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, query = r.URL.Path, r.URL.RawQuery
+		fmt.Fprint(w, `[[1767225600000,"2000.0","2001.0","1999.0","2000.5","1.5"],[1767225660000,"2000.5","2002.0","2000.0","2001.0","0.3"]]`)
+	}))
+	defer server.Close()
+	oldURL := marketBaseURLs[USDMFutures]
+	marketBaseURLs[USDMFutures] = server.URL
+	defer func() { marketBaseURLs[USDMFutures] = oldURL }()
+
+	openTime, closePrice, err := fetchLastClosedKline(USDMFutures, "ETHUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/fapi/v1/klines" || query != "symbol=ETHUSDT&interval=1m&limit=2" {
+		t.Errorf("请求 %s?%s, want /fapi/v1/klines?symbol=ETHUSDT&interval=1m&limit=2", path, query)
+	}
+	if openTime != 1767225600000 || closePrice != 2000.5 {
+		t.Errorf("fetchLastClosedKline = %d, %v, want 1767225600000, 2000.5", openTime, closePrice)
+	}
+}
+
+// 消费方很慢时生产方不会被卡住：多出的K线被计为丢弃，收到的K线按时间递增，最新的一根一定保留
+func TestDropOldestQueueSlowConsumer(t *testing.T) {
+	const total = 500
+	queue := newDropOldestQueue(8)
+	produced := make(chan struct{})
+	go func() {
+		for i := 1; i <= total; i++ {
+			queue.Push(KlineEvent{OpenTime: int64(i) * 60000, Close: float64(i)})
+		}
+		close(produced)
+	}()
+
+	var received []KlineEvent
+	for len(received) == 0 || received[len(received)-1].OpenTime != total*60000 {
+		select {
+		case event := <-queue.C():
+			received = append(received, event)
+			time.Sleep(time.Millisecond)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("5 秒内没有收到最新的K线，已收到 %d 根", len(received))
+		}
+	}
+	select {
+	case <-produced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("生产方被慢消费方卡住")
+	}
+
+	for i := 1; i < len(received); i++ {
+		if received[i].OpenTime <= received[i-1].OpenTime {
+			t.Fatalf("第 %d 根K线时间 %d 不晚于上一根 %d", i, received[i].OpenTime, received[i-1].OpenTime)
+		}
+	}
+	if dropped := queue.Dropped(); dropped == 0 || int(dropped)+len(received) != total {
+		t.Errorf("丢弃 %d 根 + 收到 %d 根, want 丢弃 > 0 且合计 %d", dropped, len(received), total)
+	}
+}
+
+// 持续超出阈值时只在第一次和每过一个冷却期告警，其余被抑制；回到阈值以内时恢复一次；
+// 恢复后冷却期内再次超出仍被抑制，不同的 (交易对, 窗口) 互不影响
+func TestAlertManagerCooldown(t *testing.T) {
+	manager := newAlertManager(3, 15*time.Minute)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minute int) time.Time { return start.Add(time.Duration(minute) * time.Minute) }
+
+	var fired []int
+	for minute := 0; minute < 20; minute++ {
+		switch action := manager.Observe("ETHUSDT", 60, 4, at(minute)); action {
+		case AlertFired:
+			fired = append(fired, minute)
+		case AlertSuppressed:
+		default:
+			t.Fatalf("第 %d 分钟持续超出阈值, action = %d", minute, action)
+		}
+	}
+	if fmt.Sprint(fired) != "[0 15]" {
+		t.Errorf("告警时间 = %v, want [0 15]", fired)
+	}
+
+	for _, tc := range []struct {
+		minute int
+		z      float64
+		want   AlertAction
+	}{
+		{20, math.NaN(), AlertNone},
+		{20, 1, AlertRecovered},
+		{21, -1, AlertNone},
+		{22, -5, AlertSuppressed}, // 距上次告警 7 分钟，仍在冷却期内
+		{23, 0, AlertNone},        // 这次超出没有告警，不发恢复通知
+		{40, -5, AlertFired},
+		{41, 2, AlertRecovered},
+	} {
+		if got := manager.Observe("ETHUSDT", 60, tc.z, at(tc.minute)); got != tc.want {
+			t.Errorf("第 %d 分钟 z=%v: action = %d, want %d", tc.minute, tc.z, got, tc.want)
+		}
+	}
+
+	if got := manager.Observe("ETHUSDT", 5, 4, at(22)); got != AlertFired {
+		t.Errorf("其他窗口第一次超出: action = %d, want AlertFired", got)
+	}
+	if got := manager.Observe("BTCUSDT", 60, 4, at(22)); got != AlertFired {
+		t.Errorf("其他交易对第一次超出: action = %d, want AlertFired", got)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testBars = 200
+
+// 编译本工具，在临时目录中写入 testBars 根K线和波动率表（skip 中的窗口不写），返回可执行文件和目录
+func setupZScoreTool(t *testing.T, skip ...int) (string, string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("-short 时跳过编译工具")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "calculate_zscore")
+	if out, err := exec.Command("go", "build", "-o", binary, "calculate_zscore.go").CombinedOutput(); err != nil {
+		t.Fatalf("编译失败: %v\n%s", err, out)
+	}
+
+	klines := []string{"Open Time,Open Time (UTC),Open,High,Low,Close,Volume"}
+	for i := 0; i < testBars; i++ {
+		price := 2000 + 5*math.Sin(float64(i)/9)
+		klines = append(klines, fmt.Sprintf("%d,2026-01-01 00:00:00,%f,%f,%f,%f,1", 1767225600000+int64(i)*60000, price, price, price, price))
+	}
+	skipped := make(map[int]bool)
+	for _, window := range skip {
+		skipped[window] = true
+	}
+	volatility := []string{"# schema=3", "Window_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count,VaR_Pct,Realized_Vol_Pct"}
+	for window := 1; window < testBars; window++ {
+		if !skipped[window] {
+			volatility = append(volatility, fmt.Sprintf("%d,0,0,0.1,%d,0,0", window, testBars-window))
+		}
+	}
+	for name, lines := range map[string][]string{
+		"ETHUSDT_minute_klines.csv":      klines,
+		"multi_timeframe_volatility.csv": volatility,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return binary, dir
+}
+
+// 波动率表缺少 7 和 50 分钟窗口：这两行的均值、标准差和 z-score 写为 NaN 并计数，相邻窗口照常计算
+func TestMissingVolatilityWindow(t *testing.T) {
+	binary, dir := setupZScoreTool(t, 7, 50)
+	cmd := exec.Command(binary, "-input-dir", dir, "-output-dir", dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("运行失败: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "警告: 2 个窗口缺少波动率数据，z-score 写为 NaN") {
+		t.Errorf("输出中没有缺少窗口的计数:\n%s", out)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "zscore_results.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string][]string)
+	for _, line := range strings.Split(string(data), "\n")[2:] {
+		if fields := strings.Split(line, ","); len(fields) >= 6 {
+			rows[fields[0]] = fields
+		}
+	}
+	if len(rows) != testBars-1 {
+		t.Fatalf("写入 %d 个窗口, want %d（缺少数据的窗口不能跳过）", len(rows), testBars-1)
+	}
+	for _, window := range []string{"7", "50"} {
+		row := rows[window]
+		if row[3] != "NaN" || row[4] != "NaN" || row[5] != "NaN" {
+			t.Errorf("%s 分钟: Mean/StdDev/Z = %s/%s/%s, want NaN", window, row[3], row[4], row[5])
+		}
+	}
+	for _, window := range []string{"6", "8", "49", "51"} {
+		if z := rows[window][5]; z == "NaN" || z == "" {
+			t.Errorf("%s 分钟的 z-score = %q", window, z)
+		}
+	}
+}
+
+// 关键时间窗口的输出按 -windows 指定的窗口和顺序逐行列出，超过数据长度的窗口不输出
+func TestCustomWindowsDriveOutput(t *testing.T) {
+	binary, dir := setupZScoreTool(t)
+	for _, tc := range []struct {
+		windows string
+		want    []string
+	}{
+		{"60,3,17,500", []string{"60", "3", "17"}},
+		{"", []string{"1", "5", "15", "30", "60", "120"}},
+	} {
+		args := []string{"-input-dir", dir, "-output-dir", dir}
+		if tc.windows != "" {
+			args = append(args, "-windows", tc.windows)
+		}
+		out, err := exec.Command(binary, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("-windows %q 运行失败: %v\n%s", tc.windows, err, out)
+		}
+
+		_, section, ok := strings.Cut(string(out), "关键时间窗口的z-score:\n")
+		if !ok {
+			t.Fatalf("输出中没有关键时间窗口:\n%s", out)
+		}
+		var got []string
+		for _, line := range strings.Split(section, "\n") {
+			window, _, ok := strings.Cut(line, " 分钟 (")
+			if !ok {
+				break
+			}
+			got = append(got, window)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("-windows %q: 输出的窗口 %v, want %v", tc.windows, got, tc.want)
+		}
+	}
+}
+
+// 价格每根K线涨 2：每个位置的斜率都是 2 除以窗口内的平均价格；价格不变时斜率为0；前 window 个位置为 NaN
+func TestTrendSlope(t *testing.T) {
+	const window = 30
+	rising := make([]float64, 200)
+	flat := make([]float64, 200)
+	for i := range rising {
+		rising[i] = 1000 + 2*float64(i)
+		flat[i] = 1000
+	}
+
+	slopes := trendSlope(rising, window)
+	for i, slope := range slopes {
+		if i < window {
+			if !math.IsNaN(slope) {
+				t.Fatalf("第 %d 个 = %v, 数据不足应为 NaN", i, slope)
+			}
+			continue
+		}
+		meanPrice := 1000 + 2*(float64(i)-window/2.0)
+		if want := 2 / meanPrice * 100; math.Abs(slope-want) > 1e-9 {
+			t.Errorf("上涨序列第 %d 个 = %v, want %v", i, slope, want)
+		}
+	}
+
+	for i, slope := range trendSlope(flat, window)[window:] {
+		if math.Abs(slope) > 1e-12 {
+			t.Errorf("不变序列第 %d 个 = %v, want 0", i+window, slope)
+		}
+	}
+}
+
+// 波动率表基于两倍长的K线计算（价格数据已更新而波动率表没有重新生成）：默认给出警告并提示重新运行
+// calculate_volatility，-strict 时报错退出；两者一致时没有警告
+func TestStaleVolatilityWarning(t *testing.T) {
+	binary, dir := setupZScoreTool(t)
+	args := []string{"-input-dir", dir, "-output-dir", dir}
+	out, err := exec.Command(binary, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("运行失败: %v\n%s", err, out)
+	}
+	if strings.Contains(string(out), "波动率表可能已过期") {
+		t.Errorf("K线数一致时不应警告:\n%s", out)
+	}
+
+	volatility := []string{"# schema=3", "Window_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count,VaR_Pct,Realized_Vol_Pct"}
+	for window := 1; window < testBars; window++ {
+		volatility = append(volatility, fmt.Sprintf("%d,0,0,0.1,%d,0,0", window, 2*testBars-window))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "multi_timeframe_volatility.csv"), []byte(strings.Join(volatility, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = exec.Command(binary, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("运行失败: %v\n%s", err, out)
+	}
+	want := fmt.Sprintf("警告: 波动率表基于 %d 根K线计算，当前价格数据有 %d 根", 2*testBars, testBars)
+	if !strings.Contains(string(out), want) || !strings.Contains(string(out), "请重新运行 calculate_volatility") {
+		t.Errorf("输出中没有过期警告:\n%s", out)
+	}
+
+	if out, err := exec.Command(binary, append(args, "-strict")...).CombinedOutput(); err == nil {
+		t.Errorf("-strict 应报错退出:\n%s", out)
+	}
+}
+
+// 厚尾收益率经秩变换后近似标准正态：均值约 0，标准差约 1，且不再有极端值
+func TestRankNormalizeStandardNormal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1151))
+	returns := make([]float64, 2000)
+	for i := range returns {
+		r := rng.NormFloat64() * 0.001
+		if rng.Float64() < 0.05 {
+			r *= 10 // 少量跳变制造厚尾
+		}
+		returns[i] = r
+	}
+	returns[7] = math.NaN()
+
+	var scores []float64
+	for _, r := range returns {
+		if s := rankNormalScore(returns, r); !math.IsNaN(s) {
+			scores = append(scores, s)
+		}
+	}
+	if len(scores) != len(returns)-1 {
+		t.Fatalf("有效分数 %d 个，期望 %d 个", len(scores), len(returns)-1)
+	}
+	mean, std := 0.0, 0.0
+	maxAbs := 0.0
+	for _, s := range scores {
+		mean += s
+		maxAbs = math.Max(maxAbs, math.Abs(s))
+	}
+	mean /= float64(len(scores))
+	for _, s := range scores {
+		std += (s - mean) * (s - mean)
+	}
+	std = math.Sqrt(std / float64(len(scores)))
+	if math.Abs(mean) > 0.05 || math.Abs(std-1) > 0.05 {
+		t.Errorf("秩变换后 mean=%.4f std=%.4f，期望约 0 和 1", mean, std)
+	}
+	// n=1999 时最大分数为 normalQuantile(1999/2000)≈3.48
+	if maxAbs > 3.6 {
+		t.Errorf("秩变换后最大 |score|=%.3f，厚尾没有被压平", maxAbs)
+	}
+}
+
+// 正态分位数在已知点上的取值，越界返回 NaN
+func TestNormalQuantile(t *testing.T) {
+	for _, c := range []struct{ p, want float64 }{
+		{0.5, 0},
+		{0.975, 1.959964},
+		{0.025, -1.959964},
+		{0.8413447, 1},
+		{0.001, -3.090232},
+	} {
+		if got := normalQuantile(c.p); math.Abs(got-c.want) > 1e-5 {
+			t.Errorf("normalQuantile(%v) = %v, 期望 %v", c.p, got, c.want)
+		}
+	}
+	if !math.IsInf(normalQuantile(0), -1) || !math.IsInf(normalQuantile(1), 1) {
+		t.Error("p=0/1 应返回 ∓Inf")
+	}
+	for _, p := range []float64{-0.1, 1.1, math.NaN()} {
+		if !math.IsNaN(normalQuantile(p)) {
+			t.Errorf("normalQuantile(%v) 应返回 NaN", p)
+		}
+	}
+}
+
+// 秩分数的边界：空历史或 NaN 返回 NaN，中位数为 0，并列取平均秩
+func TestRankNormalScoreEdges(t *testing.T) {
+	if !math.IsNaN(rankNormalScore(nil, 1)) || !math.IsNaN(rankNormalScore([]float64{1, 2}, math.NaN())) {
+		t.Error("空历史或 NaN 值应返回 NaN")
+	}
+	history := []float64{1, 2, 3, 4, 5}
+	if s := rankNormalScore(history, 3); math.Abs(s) > 1e-9 {
+		t.Errorf("中位数的分数 = %v, 期望 0", s)
+	}
+	if s := rankNormalScore([]float64{2, 2, 2}, 2); math.Abs(s) > 1e-9 {
+		t.Errorf("全部并列时分数 = %v, 期望 0", s)
+	}
+	if lo, hi := rankNormalScore(history, 0), rankNormalScore(history, 9); lo >= 0 || hi <= 0 || math.Abs(lo+hi) > 1e-9 {
+		t.Errorf("历史之外的值分数 = %v/%v，期望对称且一负一正", lo, hi)
+	}
+}
+
+// 波动率在中途放大 10 倍：按跳变前的标准差计算，跳变后大部分收益率都被当作极端值；
+// EWMA z-score 只在跳变刚发生时报告极端值，EWMA 波动率追上之后极端值的比例回到正常水平
+func TestEWMAZScoreAdaptsToVolatilityJump(t *testing.T) {
+	const before, after, lambda = 1000, 500, 0.94
+	rng := rand.New(rand.NewSource(1170))
+	returns := make([]float64, before+after)
+	for i := range returns {
+		sigma := 0.1
+		if i >= before {
+			sigma = 1
+		}
+		returns[i] = rng.NormFloat64() * sigma
+	}
+
+	zScores := ewmaZScore(returns, lambda)
+	if !math.IsNaN(zScores[0]) {
+		t.Errorf("第一个点没有历史, z = %v, want NaN", zScores[0])
+	}
+	extreme := func(from, to int, z func(i int) float64) float64 {
+		count := 0
+		for i := from; i < to; i++ {
+			if math.Abs(z(i)) > 3 {
+				count++
+			}
+		}
+		return float64(count) / float64(to-from)
+	}
+
+	static := extreme(before, before+after, func(i int) float64 { return returns[i] / 0.1 })
+	if static < 0.5 {
+		t.Fatalf("按固定标准差，跳变后 %.0f%% 的点为极端值，测试数据没有形成跳变", static*100)
+	}
+	if first := extreme(before, before+5, func(i int) float64 { return zScores[i] }); first == 0 {
+		t.Error("跳变刚发生时 EWMA z-score 应报告极端值")
+	}
+	// 1-0.94^100 接近 1，100 个点之后 EWMA 已基本只反映新的波动率
+	if caughtUp := extreme(before+100, before+after, func(i int) float64 { return zScores[i] }); caughtUp > 0.03 {
+		t.Errorf("EWMA 追上之后仍有 %.1f%% 的点为极端值（固定标准差时 %.0f%%）", caughtUp*100, static*100)
+	}
+	if calm := extreme(100, before, func(i int) float64 { return zScores[i] }); calm > 0.03 {
+		t.Errorf("跳变之前 %.1f%% 的点为极端值", calm*100)
+	}
+
+	// NaN 收益率的 z-score 为 NaN，且不影响之后的波动率
+	withGap := append([]float64(nil), returns[:200]...)
+	withGap[100] = math.NaN()
+	gapScores := ewmaZScore(withGap, lambda)
+	sigmas := ewmaVolatility(withGap, lambda)
+	if !math.IsNaN(gapScores[100]) || sigmas[101] != sigmas[100] {
+		t.Errorf("NaN 收益率: z = %v, 之后的波动率 %v, want NaN 和沿用 %v", gapScores[100], sigmas[101], sigmas[100])
+	}
+	// 价格一直不变时波动率为 0，z-score 为 NaN 而不是看起来正常的 0（接近均值）
+	for i, z := range ewmaZScore([]float64{0, 0, 0, 0}, lambda) {
+		if !math.IsNaN(z) {
+			t.Errorf("平稳序列第 %d 个点 z = %v, want NaN", i, z)
+		}
+	}
+	// 有过波动之后价格不变，波动率仍大于 0，z-score 为真实的 0
+	if flat := ewmaZScore([]float64{1, -1, 0, 0}, lambda); flat[2] != 0 || flat[3] != 0 {
+		t.Errorf("波动之后的平稳段 z = %v, want 0", flat[2:])
+	}
+}
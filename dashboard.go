@@ -0,0 +1,1270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func main() {
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&inputFormat, "input-format", "csv", "K线输入格式：csv，或 json（/api/v3/klines 返回的原始数组，读取同名 .json 文件）")
+	flag.BoolVar(&dropDuplicateKlines, "drop-duplicates", false, "去掉重复的分钟（同一开盘时间的K线只保留第一根）；默认只报告，-strict 时报错")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Func("symbol-suffix", "K线文件的计价币（交易对后缀），如 USDC 时读取 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	symbol := flag.String("symbol", "", "交易对，读取 <symbol>_minute_klines.csv；默认为 ETH 加上 -symbol-suffix")
+	returnMode := flag.String("return-mode", "simple", "收益率计算方式: simple 或 log（z-score 需与 calculate_volatility 使用同一方式）")
+	priceModeName := flag.String("price-mode", "close", "价格取法: close、mid、typical 或 ohlc4（需与 calculate_volatility 使用同一取法）")
+	windowsFlag := flag.String("windows", "", "关键时间窗口（分钟，逗号分隔），默认 1,5,15,30,60,120,240,1440,2880,4320,10080")
+	rsiPeriod := flag.Int("rsi-period", 14, "RSI 的周期（K线数）")
+	drawdownDays := flag.Int("drawdown-days", 7, "回撤的统计天数，从这段时间内的最高价算起")
+	threshold := flag.Float64("threshold", zScoreSignificant, "|z| 达到该值的窗口列为极端行情提醒")
+	maxAlerts := flag.Int("max-alerts", 10, "最多列出的极端行情提醒数（按 |z| 从大到小），0 表示全部列出")
+	strict := flag.Bool("strict", false, "严格模式：数据有问题（无法解析的行、缺少波动率窗口、数据不足）时直接报错，而不是跳过继续")
+	tz := flag.String("tz", "UTC", "报告中时间的显示时区（IANA 时区名，如 Asia/Shanghai、Local），JSON 中的时间始终为 UTC")
+	format := flag.String("format", "text", "报告的格式: text（对齐的文本）、tsv（可粘贴到电子表格）、markdown（可粘贴到 GitHub issue）或 json")
+	flag.Parse()
+	if *symbol == "" {
+		*symbol = composeSymbol(baseAsset, symbolSuffix)
+	}
+	if *returnMode != "simple" && *returnMode != "log" {
+		log.Fatalf("未知的收益率计算方式: %s（可选 simple 或 log）", *returnMode)
+	}
+	if *rsiPeriod < 1 {
+		log.Fatalf("-rsi-period 必须大于等于 1: %d", *rsiPeriod)
+	}
+	if *drawdownDays < 1 {
+		log.Fatalf("-drawdown-days 必须大于等于 1: %d", *drawdownDays)
+	}
+	if *threshold < 0 {
+		log.Fatalf("-threshold 必须是非负数: %v", *threshold)
+	}
+	if *maxAlerts < 0 {
+		log.Fatalf("-max-alerts 必须大于等于 0: %d", *maxAlerts)
+	}
+	if *format != "json" {
+		if err := setOutputFormat(*format); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := setDisplayTimezone(*tz); err != nil {
+		log.Fatal(err)
+	}
+	priceMode, err := parsePriceMode(*priceModeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	windows, err := parseWindows(*windowsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 读取时的提示打印在标准输出上，JSON 模式下改到标准错误，保证标准输出只有JSON
+	stdout := os.Stdout
+	if *format == "json" {
+		os.Stdout = os.Stderr
+	}
+	klines, rowErrors, err := loadKlines(inputPath(*symbol+"_minute_klines.csv"), *strict)
+	if err != nil {
+		log.Fatal("读取价格数据失败:", err)
+	}
+	volatility, err := loadVolatilityData(inputPath("multi_timeframe_volatility.csv"), *strict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout = stdout
+
+	report, err := buildDashboard(klines, volatility, DashboardConfig{
+		Symbol:         *symbol,
+		ReturnMode:     *returnMode,
+		PriceMode:      priceMode,
+		Windows:        windows,
+		RSIPeriod:      *rsiPeriod,
+		DrawdownWindow: *drawdownDays * 1440,
+		Threshold:      *threshold,
+		MaxAlerts:      *maxAlerts,
+		Strict:         *strict,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(rowErrors) > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("跳过了 %d 行无法解析的K线", len(rowErrors)))
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printDashboard(report)
+}
+
+// RSI 超买、超卖的常用阈值
+const (
+	rsiOverbought = 70.0
+	rsiOversold   = 30.0
+)
+
+type DashboardConfig struct {
+	Symbol         string
+	ReturnMode     string
+	PriceMode      PriceMode
+	Windows        []int
+	RSIPeriod      int
+	DrawdownWindow int // K线数
+	Threshold      float64
+	MaxAlerts      int
+	Strict         bool
+}
+
+// 一个窗口当前的z-score，与 calculate_zscore / zscore_server 的计算方式相同
+type WindowZScore struct {
+	Window         int     `json:"window"`
+	ReturnPct      float64 `json:"returnPct"`
+	ZScore         float64 `json:"zscore"`
+	PValue         float64 `json:"pvalue"` // 双侧概率 P(|Z| >= |z|)
+	Interpretation string  `json:"interpretation"`
+}
+
+// 最近 Window 根K线内的回撤（百分比，<= 0）
+type DrawdownStats struct {
+	Window     int     `json:"window"`
+	Peak       float64 `json:"peak"`
+	PeakTime   string  `json:"peakTime"`
+	CurrentPct float64 `json:"currentPct"` // 当前价格相对区间最高价
+	MaxPct     float64 `json:"maxPct"`     // 区间内的最大回撤
+	MaxTime    string  `json:"maxTime"`    // 最大回撤的谷底时间
+}
+
+type DashboardReport struct {
+	Symbol      string         `json:"symbol"`
+	Time        string         `json:"time"`
+	Price       float64        `json:"price"`
+	Bars        int            `json:"bars"`
+	ZScores     []WindowZScore `json:"zscores"`
+	RSIPeriod   int            `json:"rsiPeriod"`
+	RSI         float64        `json:"rsi"`
+	Drawdown    DrawdownStats  `json:"drawdown"`
+	Threshold   float64        `json:"threshold"`
+	AlertCount  int            `json:"alertCount"` // 超过阈值的窗口总数，Alerts 可能按 -max-alerts 截断
+	Alerts      []WindowZScore `json:"alerts"`
+	Warnings    []string       `json:"warnings,omitempty"`
+	MissingVols []int          `json:"missingVolatilityWindows,omitempty"`
+}
+
+// 汇总当前价格、关键窗口z-score、RSI、回撤和极端行情提醒
+func buildDashboard(klines []Kline, volatility map[int]VolatilityData, cfg DashboardConfig) (DashboardReport, error) {
+	prices := klinePrices(klines, cfg.PriceMode)
+	if len(prices) <= cfg.RSIPeriod {
+		return DashboardReport{}, fmt.Errorf("数据不足，RSI(%d) 需要至少 %d 条，实际只有 %d 条", cfg.RSIPeriod, cfg.RSIPeriod+1, len(prices))
+	}
+
+	last := len(prices) - 1
+	report := DashboardReport{
+		Symbol:    cfg.Symbol,
+		Time:      klines[last].Time,
+		Price:     prices[last],
+		Bars:      len(prices),
+		ZScores:   make([]WindowZScore, 0, len(cfg.Windows)),
+		RSIPeriod: cfg.RSIPeriod,
+		RSI:       wilderRSI(prices, cfg.RSIPeriod),
+		Threshold: cfg.Threshold,
+		Alerts:    make([]WindowZScore, 0),
+	}
+	if err := checkVolatilityFreshness(volatility, len(prices)); err != nil {
+		if cfg.Strict {
+			return DashboardReport{}, err
+		}
+		report.Warnings = append(report.Warnings, err.Error())
+	}
+
+	for _, window := range cfg.Windows {
+		if window > last {
+			continue // 超出数据范围的窗口不输出
+		}
+		result, ok := windowZScore(prices, window, volatility, cfg.ReturnMode)
+		if !ok {
+			if cfg.Strict {
+				return DashboardReport{}, fmt.Errorf("%d 分钟窗口缺少波动率数据或标准差为0（-strict）", window)
+			}
+			report.MissingVols = append(report.MissingVols, window)
+			continue
+		}
+		report.ZScores = append(report.ZScores, result)
+	}
+
+	// 与 zscore_server 的 /extremes 一样扫描波动率表中的全部窗口
+	for window := range volatility {
+		if window > last {
+			continue
+		}
+		result, ok := windowZScore(prices, window, volatility, cfg.ReturnMode)
+		if ok && math.Abs(result.ZScore) >= cfg.Threshold {
+			report.Alerts = append(report.Alerts, result)
+		}
+	}
+	sort.Slice(report.Alerts, func(i, j int) bool {
+		zi, zj := math.Abs(report.Alerts[i].ZScore), math.Abs(report.Alerts[j].ZScore)
+		if zi != zj {
+			return zi > zj
+		}
+		return report.Alerts[i].Window < report.Alerts[j].Window
+	})
+	report.AlertCount = len(report.Alerts)
+	if cfg.MaxAlerts > 0 && len(report.Alerts) > cfg.MaxAlerts {
+		report.Alerts = report.Alerts[:cfg.MaxAlerts]
+	}
+
+	drawdownStart := len(prices) - cfg.DrawdownWindow
+	if drawdownStart < 0 {
+		drawdownStart = 0
+	}
+	report.Drawdown = drawdown(prices, klines, drawdownStart)
+	return report, nil
+}
+
+// 最后时刻相对于 window 分钟前的z-score，波动率表中没有该窗口或标准差为0（z-score 没有意义）时返回 false
+func windowZScore(prices []float64, window int, volatility map[int]VolatilityData, returnMode string) (WindowZScore, bool) {
+	volData, exists := volatility[window]
+	if !exists || !(volData.StdDev > 0) {
+		return WindowZScore{}, false
+	}
+	last := len(prices) - 1
+	returnPct := calculateReturn(prices[last-window], prices[last], returnMode)
+	zScore := (returnPct - volData.Mean) / volData.StdDev
+	return WindowZScore{
+		Window:         window,
+		ReturnPct:      returnPct,
+		ZScore:         zScore,
+		PValue:         2 * (1 - normalCDF(math.Abs(zScore))),
+		Interpretation: interpretZScore(zScore),
+	}, true
+}
+
+// Wilder 平滑的 RSI：前 period 个涨跌幅取简单平均作为初值，之后按 (avg*(period-1)+x)/period 递推
+// 整段没有下跌时为 100，价格完全不变时为 50
+func wilderRSI(prices []float64, period int) float64 {
+	var avgGain, avgLoss float64
+	for i := 1; i < len(prices); i++ {
+		change := prices[i] - prices[i-1]
+		gain, loss := math.Max(change, 0), math.Max(-change, 0)
+		if i <= period {
+			avgGain += gain / float64(period)
+			avgLoss += loss / float64(period)
+			continue
+		}
+		avgGain = (avgGain*float64(period-1) + gain) / float64(period)
+		avgLoss = (avgLoss*float64(period-1) + loss) / float64(period)
+	}
+	if avgLoss == 0 {
+		if avgGain == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+avgGain/avgLoss)
+}
+
+// 从 start 开始的回撤：当前价格相对区间最高价，以及区间内任一时刻相对此前最高价的最大跌幅
+func drawdown(prices []float64, klines []Kline, start int) DrawdownStats {
+	peakIdx, runningPeak := start, start
+	stats := DrawdownStats{Window: len(prices) - start}
+	maxIdx := start
+	for i := start; i < len(prices); i++ {
+		if prices[i] > prices[runningPeak] {
+			runningPeak = i
+		}
+		if prices[i] > prices[peakIdx] {
+			peakIdx = i
+		}
+		if pct := (prices[i]/prices[runningPeak] - 1) * 100; pct < stats.MaxPct {
+			stats.MaxPct = pct
+			maxIdx = i
+		}
+	}
+	stats.Peak = prices[peakIdx]
+	stats.PeakTime = klines[peakIdx].Time
+	stats.CurrentPct = (prices[len(prices)-1]/stats.Peak - 1) * 100
+	stats.MaxTime = klines[maxIdx].Time
+	return stats
+}
+
+// 按 -format 输出控制台报告
+func printDashboard(report DashboardReport) {
+	fmt.Printf("%s 综合报告（%s）\n", report.Symbol, formatTimestamp(report.Time))
+	fmt.Printf("当前价格: %.2f（共 %d 根K线）\n", report.Price, report.Bars)
+	for _, warning := range report.Warnings {
+		fmt.Printf("警告: %s\n", warning)
+	}
+
+	fmt.Println("\n关键时间窗口的z-score:")
+	zTable := newTable("窗口(分钟)", "收益率%", "z-score", "p值", "说明")
+	for _, z := range report.ZScores {
+		zTable.Add(fmt.Sprintf("%d", z.Window), fmt.Sprintf("%.4f", z.ReturnPct), fmt.Sprintf("%.4f", z.ZScore),
+			fmt.Sprintf("%.4f", z.PValue), z.Interpretation)
+	}
+	zTable.Print()
+	if len(report.MissingVols) > 0 {
+		fmt.Printf("窗口 %v 缺少波动率数据或标准差为0，已跳过\n", report.MissingVols)
+	}
+
+	rsiNote := "中性"
+	switch {
+	case report.RSI >= rsiOverbought:
+		rsiNote = "超买"
+	case report.RSI <= rsiOversold:
+		rsiNote = "超卖"
+	}
+	fmt.Printf("\nRSI(%d): %.2f（%s）\n", report.RSIPeriod, report.RSI, rsiNote)
+
+	dd := report.Drawdown
+	fmt.Printf("\n最近 %.1f 天的回撤:\n", float64(dd.Window)/1440)
+	fmt.Printf("  区间最高价: %.2f（%s）\n", dd.Peak, formatTimestamp(dd.PeakTime))
+	fmt.Printf("  当前回撤: %.2f%%\n", dd.CurrentPct)
+	fmt.Printf("  最大回撤: %.2f%%（谷底 %s）\n", dd.MaxPct, formatTimestamp(dd.MaxTime))
+
+	fmt.Printf("\n极端行情提醒（|z| >= %.2f）:\n", report.Threshold)
+	if report.AlertCount == 0 {
+		fmt.Println("  无")
+		return
+	}
+	alertTable := newTable("窗口(分钟)", "收益率%", "z-score", "p值", "说明")
+	for _, z := range report.Alerts {
+		alertTable.Add(fmt.Sprintf("%d", z.Window), fmt.Sprintf("%.4f", z.ReturnPct), fmt.Sprintf("%.4f", z.ZScore),
+			fmt.Sprintf("%.4f", z.PValue), z.Interpretation)
+	}
+	alertTable.Print()
+	if report.AlertCount > len(report.Alerts) {
+		fmt.Printf("另有 %d 个窗口超过阈值未列出（-max-alerts）\n", report.AlertCount-len(report.Alerts))
+	}
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 价格序列的取法，默认用收盘价
+type PriceMode int
+
+const (
+	PriceClose   PriceMode = iota // 收盘价 C
+	PriceMid                      // 中间价 (H+L)/2
+	PriceTypical                  // 典型价 (H+L+C)/3
+	PriceOHLC4                    // (O+H+L+C)/4
+)
+
+func parsePriceMode(name string) (PriceMode, error) {
+	switch name {
+	case "close":
+		return PriceClose, nil
+	case "mid":
+		return PriceMid, nil
+	case "typical":
+		return PriceTypical, nil
+	case "ohlc4":
+		return PriceOHLC4, nil
+	}
+	return PriceClose, fmt.Errorf("未知的价格取法: %s（可选 close、mid、typical 或 ohlc4）", name)
+}
+
+// 按 mode 取一根K线的价格
+func (k Kline) Price(mode PriceMode) float64 {
+	switch mode {
+	case PriceMid:
+		return (k.High + k.Low) / 2
+	case PriceTypical:
+		return (k.High + k.Low + k.Close) / 3
+	case PriceOHLC4:
+		return (k.Open + k.High + k.Low + k.Close) / 4
+	}
+	return k.Close
+}
+
+// 按 mode 取出整段K线的价格序列
+func klinePrices(klines []Kline, mode PriceMode) []float64 {
+	prices := make([]float64, len(klines))
+	for i, k := range klines {
+		prices[i] = k.Price(mode)
+	}
+	return prices
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+type VolatilityData struct {
+	Mean        float64
+	StdDev      float64
+	SampleCount int
+}
+
+// 读取 calculate_volatility 输出的波动率表
+// 无法解析的行默认跳过，strict 为 true 时返回错误
+func loadVolatilityData(path string, strict bool) (map[int]VolatilityData, error) {
+	version, volRecords, err := readSchemaCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取波动率CSV失败: %v", err)
+	}
+	if err := checkSchema("multi_timeframe_volatility.csv", version, volatilitySchemaVersion, volatilitySchemaMigrations, volRecords, volatilityRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	// 解析波动率数据（跳过标题行）
+	volatilityData := make(map[int]VolatilityData)
+	for i := 1; i < len(volRecords); i++ {
+		data, window, err := parseVolatilityRow(volRecords[i])
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("multi_timeframe_volatility.csv 第 %d 条数据解析失败: %v", i, err)
+			}
+			continue
+		}
+		volatilityData[window] = data
+	}
+	return volatilityData, nil
+}
+
+// 解析波动率表的一行: Window_Minutes, Window_Days, Mean_Pct, StdDev_Pct, Sample_Count, ...
+func parseVolatilityRow(record []string) (VolatilityData, int, error) {
+	if len(record) < 5 {
+		return VolatilityData{}, 0, fmt.Errorf("列数不足: 需要至少 5 列，实际 %d 列", len(record))
+	}
+	window, err := strconv.Atoi(record[0])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Window_Minutes 格式错误: %v", err)
+	}
+	mean, err := strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Mean_Pct 格式错误: %v", err)
+	}
+	stdDev, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("StdDev_Pct 格式错误: %v", err)
+	}
+	sampleCount, err := strconv.Atoi(record[4])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Sample_Count 格式错误: %v", err)
+	}
+	return VolatilityData{Mean: mean, StdDev: stdDev, SampleCount: sampleCount}, window, nil
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 旧版本文件的迁移说明，Compatible 表示当前读取方仍能正确解析（例如只在末尾追加过列）
+type SchemaMigration struct {
+	Compatible bool
+	Note       string
+}
+
+// 读取带版本行的CSV，返回版本号和全部记录（含标题行）
+func readSchemaCSV(path string) (int, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	br, err := newCSVInput(path, file)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	if prefix, err := br.Peek(len(schemaPrefix)); err == nil && string(prefix) == schemaPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaPrefix)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s 的版本行格式错误: %q", path, strings.TrimSpace(line))
+		}
+	}
+
+	records, err := newCSVReader(br).ReadAll()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, records, nil
+}
+
+// 校验版本和标题：当前版本直接通过，兼容的旧版本打印迁移说明后继续，其余情况报错
+// expected 为读取方依赖的前几列
+func checkSchema(name string, version, current int, migrations map[int]SchemaMigration, records [][]string, expected []string) error {
+	if version != current {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%s 的版本 schema=%d 无法识别（当前为 schema=%d），请用当前版本的工具重新生成", name, version, current)
+		}
+		if !m.Compatible {
+			return fmt.Errorf("%s 是不兼容的旧版本 schema=%d: %s", name, version, m.Note)
+		}
+		fmt.Printf("注意: %s 是旧版本 schema=%d: %s\n", name, version, m.Note)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("%s 缺少标题行", name)
+	}
+	header := records[0]
+	if len(header) < len(expected) {
+		return fmt.Errorf("%s 的标题只有 %d 列，至少需要 %d 列", name, len(header), len(expected))
+	}
+	for i, col := range expected {
+		if header[i] != col {
+			return fmt.Errorf("%s 第 %d 列应为 %s，实际为 %s", name, i+1, col, header[i])
+		}
+	}
+	return nil
+}
+
+// 波动率表的版本，列有变化时加1，并在 volatilitySchemaMigrations 中说明旧版本如何处理
+const volatilitySchemaVersion = 3
+
+// 读取波动率表时依赖的列
+var volatilityRequiredHeader = []string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count"}
+
+// 旧版本波动率表的迁移说明
+var volatilitySchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有 VaR_Pct 列（由旧版 calculate_volatility 或 calculate_multi_timeframe_volatility.py 生成），不影响z-score计算，需要 VaR 时请重新运行 calculate_volatility"},
+	2: {Compatible: true, Note: "没有 Realized_Vol_Pct 列，不影响z-score计算"},
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// 控制台表格的输出格式，由 -format 指定
+var outputFormat = "text"
+
+// 设置 -format：text（对齐的文本）、tsv（可粘贴到电子表格）或 markdown（可粘贴到 GitHub issue）
+func setOutputFormat(name string) error {
+	switch name {
+	case "text", "tsv", "markdown":
+		outputFormat = name
+		return nil
+	}
+	return fmt.Errorf("未知的输出格式: %s（可选 text、tsv 或 markdown）", name)
+}
+
+// 控制台报告中的表格，所有表格都经 Render 按 -format 输出，不再手工用 \t 对齐
+type Table struct {
+	header []string
+	rows   [][]string
+}
+
+func newTable(header ...string) *Table {
+	return &Table{header: header}
+}
+
+func (t *Table) Add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// 按 outputFormat 输出表格
+func (t *Table) Render(w io.Writer) error {
+	switch outputFormat {
+	case "tsv":
+		return t.renderTSV(w)
+	case "markdown":
+		return t.renderMarkdown(w)
+	}
+	return t.renderText(w)
+}
+
+// 输出到标准输出，与 fmt.Printf 一样忽略写入错误
+func (t *Table) Print() {
+	t.Render(os.Stdout)
+}
+
+// 按终端显示宽度对齐；text/tabwriter 按字符数计算宽度，中文表头和说明会错位，所以这里自己补空格
+func (t *Table) renderText(w io.Writer) error {
+	widths := make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = displayWidth(h)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i < len(widths) && displayWidth(cell) > widths[i] {
+				widths[i] = displayWidth(cell)
+			}
+		}
+	}
+
+	// 列之间空两格，最后一列不补空格
+	writeRow := func(cells []string) error {
+		var b strings.Builder
+		for i, cell := range cells {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(cell)
+			if i < len(cells)-1 && i < len(widths) {
+				b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)))
+			}
+		}
+		b.WriteString("\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(widths))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderTSV(w io.Writer) error {
+	// 单元格里的制表符和换行会打乱列，换成空格
+	clean := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = clean.Replace(cell)
+		}
+		_, err := io.WriteString(w, strings.Join(escaped, "\t")+"\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) renderMarkdown(w io.Writer) error {
+	clean := strings.NewReplacer("|", `\|`, "\n", " ", "\r", " ")
+	writeRow := func(cells []string) error {
+		// 列数不足的行补空单元格，GitHub 要求每行列数与标题一致
+		escaped := make([]string, len(t.header))
+		for i := range escaped {
+			if i < len(cells) {
+				escaped[i] = clean.Replace(cells[i])
+			}
+		}
+		_, err := io.WriteString(w, "| "+strings.Join(escaped, " | ")+" |\n")
+		return err
+	}
+	if err := writeRow(t.header); err != nil {
+		return err
+	}
+	separator := make([]string, len(t.header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 终端显示宽度：中日韩字符和全角符号占两列
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r >= 0x1100 && (r <= 0x115f || (r >= 0x2e80 && r <= 0xa4cf) || (r >= 0xac00 && r <= 0xd7a3) ||
+			(r >= 0xf900 && r <= 0xfaff) || (r >= 0xfe30 && r <= 0xfe4f) || (r >= 0xff00 && r <= 0xff60) || (r >= 0xffe0 && r <= 0xffe6)) {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// z-score 的解释阈值：|z| 超过 zScoreSignificant 为显著偏离，超过 zScoreNotable 为偏离
+const (
+	zScoreSignificant = 2.0
+	zScoreNotable     = 1.0
+)
+
+// z-score 所在区间的说明，控制台报告和 zscore_results.csv 使用同一套阈值
+func interpretZScore(zscore float64) string {
+	switch {
+	case math.IsNaN(zscore):
+		return "无法计算（缺少波动率数据或标准差为0）"
+	case zscore > zScoreSignificant:
+		return "显著高于均值"
+	case zscore > zScoreNotable:
+		return "高于均值"
+	case zscore < -zScoreSignificant:
+		return "显著低于均值"
+	case zscore < -zScoreNotable:
+		return "低于均值"
+	}
+	return "接近均值"
+}
+
+// 标准正态分布的累积分布函数(CDF)
+// 使用误差函数的近似公式
+func normalCDF(z float64) float64 {
+	// 使用Abramowitz and Stegun近似公式
+	// 对于负值，使用对称性: P(Z <= -z) = 1 - P(Z <= z)
+	if z < 0 {
+		return 1 - normalCDF(-z)
+	}
+
+	// 对于z >= 0的情况
+	t := 1.0 / (1.0 + 0.2316419*z)
+	d := 0.3989423 * math.Exp(-z*z/2)
+	p := d * t * (0.3193815 + t*(-0.3565638+t*(1.781478+t*(-1.821256+t*1.330274))))
+
+	return 1 - p
+}
+
+// CSV 中 Open Time (UTC) 列的时间格式
+const timestampLayout = "2006-01-02 15:04:05"
+
+// 报告中显示时间使用的时区，由 -tz 指定，默认 UTC
+var displayLocation = time.UTC
+
+// 解析 -tz 参数（IANA 时区名，如 Asia/Shanghai，或 UTC、Local）
+func setDisplayTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("未知的时区: %s（请使用 IANA 时区名，如 Asia/Shanghai、UTC、Local）", name)
+	}
+	displayLocation = loc
+	return nil
+}
+
+// 解析CSV中的时间字符串，CSV中的时间都是 UTC
+func parseTimestamp(value string) (time.Time, error) {
+	return time.ParseInLocation(timestampLayout, value, time.UTC)
+}
+
+// 把CSV中的时间转换到 -tz 时区显示，并带上时区缩写；无法解析时原样返回
+func formatTimestamp(value string) string {
+	t, err := parseTimestamp(value)
+	if err != nil {
+		return value
+	}
+	return t.In(displayLocation).Format(timestampLayout + " MST")
+}
+
+// 波动率表记录的K线数与当前价格数据相差超过该比例时，认为波动率表已过期
+const volatilityStaleTolerance = 0.01
+
+// 由波动率表的样本数反推计算时用了多少根K线：窗口 w 的样本数为 K线数 - w
+func volatilityBarCount(volatilityData map[int]VolatilityData) int {
+	smallest := 0
+	for window := range volatilityData {
+		if smallest == 0 || window < smallest {
+			smallest = window
+		}
+	}
+	if smallest == 0 {
+		return 0
+	}
+	return volatilityData[smallest].SampleCount + smallest
+}
+
+// 检查波动率表是否基于当前的价格数据计算，K线数相差超过容差时返回错误
+func checkVolatilityFreshness(volatilityData map[int]VolatilityData, bars int) error {
+	volBars := volatilityBarCount(volatilityData)
+	if volBars == 0 || bars == 0 {
+		return nil
+	}
+	diff := math.Abs(float64(bars-volBars)) / float64(bars)
+	if diff > volatilityStaleTolerance {
+		return fmt.Errorf("波动率表基于 %d 根K线计算，当前价格数据有 %d 根（相差 %.1f%%，超过 %.0f%% 的容差），波动率表可能已过期，请重新运行 calculate_volatility",
+			volBars, bars, diff*100, volatilityStaleTolerance*100)
+	}
+	return nil
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 合成行情：前 150 根每分钟涨 1，之后每分钟跌 2，最高价在第 149 根
+func dashboardKlines() []Kline {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	klines := make([]Kline, 200)
+	for i := range klines {
+		price := 2000 + float64(i)
+		if i >= 150 {
+			price = 2149 - 2*float64(i-149)
+		}
+		at := start.Add(time.Duration(i) * time.Minute)
+		klines[i] = Kline{OpenTime: at.UnixMilli(), Time: at.Format("2006-01-02 15:04:05"), Open: price, High: price, Low: price, Close: price, Volume: 1}
+	}
+	return klines
+}
+
+// 报告的各项都由合成数据填充：当前价格、各窗口z-score和p值、RSI、回撤、超过阈值的提醒，
+// 缺少波动率的窗口单独列出，超出数据范围的窗口不输出
+func TestBuildDashboardReport(t *testing.T) {
+	klines := dashboardKlines()
+	volatility := map[int]VolatilityData{
+		1:  {Mean: 0, StdDev: 0.01, SampleCount: 199},
+		5:  {Mean: 0, StdDev: 1, SampleCount: 195},
+		60: {Mean: 0, StdDev: 10, SampleCount: 140},
+	}
+	cfg := DashboardConfig{
+		Symbol:         "ETHUSDT",
+		ReturnMode:     "simple",
+		Windows:        []int{1, 5, 15, 60, 1440},
+		RSIPeriod:      14,
+		DrawdownWindow: 100,
+		Threshold:      3,
+	}
+	report, err := buildDashboard(klines, volatility, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Symbol != "ETHUSDT" || report.Price != 2049 || report.Bars != 200 || report.Time != "2026-01-01 03:19:00" {
+		t.Errorf("报告头部 = %s %v %d %s", report.Symbol, report.Price, report.Bars, report.Time)
+	}
+	if len(report.Warnings) != 0 {
+		t.Errorf("波动率表与数据一致，不应有警告: %v", report.Warnings)
+	}
+
+	var windows []int
+	for _, z := range report.ZScores {
+		windows = append(windows, z.Window)
+		want := calculateReturn(klines[199-z.Window].Close, 2049, "simple") / volatility[z.Window].StdDev
+		if math.Abs(z.ZScore-want) > 1e-9 {
+			t.Errorf("%d 分钟 z-score = %v, want %v", z.Window, z.ZScore, want)
+		}
+		if wantP := 2 * (1 - normalCDF(math.Abs(want))); math.Abs(z.PValue-wantP) > 1e-12 || z.Interpretation == "" {
+			t.Errorf("%d 分钟 p值 = %v (want %v), 解读 = %q", z.Window, z.PValue, wantP, z.Interpretation)
+		}
+	}
+	if !reflect.DeepEqual(windows, []int{1, 5, 60}) || !reflect.DeepEqual(report.MissingVols, []int{15}) {
+		t.Errorf("z-score 窗口 = %v, 缺少波动率 = %v, want [1 5 60] 和 [15]", windows, report.MissingVols)
+	}
+
+	// 最近 50 分钟持续下跌，Wilder 平滑后只剩早先上涨的少量残留
+	if report.RSIPeriod != 14 || !(report.RSI > 0 && report.RSI < rsiOversold) {
+		t.Errorf("RSI(%d) = %v, want 低于超卖线 %v", report.RSIPeriod, report.RSI, rsiOversold)
+	}
+
+	wantDrawdown := (2049.0/2149 - 1) * 100
+	dd := report.Drawdown
+	if dd.Window != 100 || dd.Peak != 2149 || dd.PeakTime != klines[149].Time ||
+		math.Abs(dd.CurrentPct-wantDrawdown) > 1e-9 || math.Abs(dd.MaxPct-wantDrawdown) > 1e-9 || dd.MaxTime != klines[199].Time {
+		t.Errorf("回撤 = %+v, want 最高 2149 于 %s，当前和最大回撤 %.4f%%", dd, klines[149].Time, wantDrawdown)
+	}
+
+	// 只有 1 分钟窗口（z 约 -9.75）超过阈值
+	if report.AlertCount != 1 || len(report.Alerts) != 1 || report.Alerts[0].Window != 1 {
+		t.Errorf("提醒 = %d 个 %+v, want 只有 1 分钟窗口", report.AlertCount, report.Alerts)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"rsi":`, `"zscores":[`, `"drawdown":{`, `"alerts":[`, `"missingVolatilityWindows":[15]`} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("JSON 输出缺少 %s: %s", key, data)
+		}
+	}
+
+	if _, err := buildDashboard(klines[:10], volatility, cfg); err == nil {
+		t.Error("K线不足 RSI 周期时应返回错误")
+	}
+}
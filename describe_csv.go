@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// 只读取CSV的前几行，打印识别出的标题、各列类型，以及收盘价/时间戳/成交量会使用哪一列
+// 用于在完整运行之前排查列布局不一致导致的加载错误：go run describe_csv.go -input file.csv
+
+// 列的推断类型，按从严到宽的顺序判断
+const (
+	columnEmpty     = "empty"
+	columnTimestamp = "timestamp" // 整数时间戳（秒/毫秒/微秒）
+	columnInt       = "int"
+	columnFloat     = "float"
+	columnDatetime  = "datetime" // 可解析的日期时间字符串
+	columnString    = "string"
+)
+
+// 合理的时间戳范围：2000-01-01 到 2100-01-01
+var timestampMin, timestampMax = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var datetimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// 下载脚本输出格式中各用途所在的列，loadKlines 按这些固定位置读取
+var klineLoaderColumns = map[string]int{"timestamp": 0, "close": 5, "volume": 6}
+
+// 一列的识别结果
+type ColumnInfo struct {
+	Index  int
+	Name   string
+	Type   string
+	Sample string
+}
+
+// 整个文件的识别结果，Mapping 为用途（timestamp/close/volume）到列下标的映射，找不到时为 -1
+type CSVDescription struct {
+	HasHeader bool
+	Schema    int // "# schema=N" 注释中的版本，没有时为 0
+	Rows      int // 参与推断的数据行数
+	Columns   []ColumnInfo
+	Mapping   map[string]int
+}
+
+func main() {
+	input := flag.String("input", "", "要识别的CSV文件，默认 <交易对>_minute_klines.csv")
+	flag.Func("symbol-suffix", "默认输入文件名中的计价币（交易对后缀），如 USDC 时为 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	rows := flag.Int("rows", 20, "用于推断类型的数据行数")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Parse()
+	if *input == "" {
+		*input = klinesFileName("minute_klines")
+	}
+	if *rows < 1 {
+		log.Fatalf("-rows 必须大于 0: %d", *rows)
+	}
+
+	file, err := os.Open(*input)
+	if err != nil {
+		log.Fatalf("打开文件失败: %v", err)
+	}
+	defer file.Close()
+
+	r, err := newCSVInput(*input, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	desc, err := describeCSV(r, *rows)
+	if err != nil {
+		log.Fatalf("%s: %v", *input, err)
+	}
+	printDescription(*input, desc)
+}
+
+// 读取 CSV 的前 maxRows 行数据并推断列布局
+func describeCSV(r io.Reader, maxRows int) (*CSVDescription, error) {
+	reader := newCSVReader(r)
+	reader.FieldsPerRecord = -1
+
+	desc := &CSVDescription{}
+	var records [][]string
+	for len(records) < maxRows+1 {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) > 0 && strings.HasPrefix(record[0], "#") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(record[0]), "# schema="); ok {
+				desc.Schema, _ = strconv.Atoi(v)
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("文件中没有数据")
+	}
+
+	// 第一行里有任何一列与第二行的类型不一致且是字符串，就当作标题行
+	desc.HasHeader = isHeaderRow(records)
+	var header []string
+	if desc.HasHeader {
+		header, records = records[0], records[1:]
+	}
+	if len(records) > maxRows {
+		records = records[:maxRows]
+	}
+	desc.Rows = len(records)
+
+	width := len(header)
+	for _, record := range records {
+		if len(record) > width {
+			width = len(record)
+		}
+	}
+	for i := 0; i < width; i++ {
+		var values []string
+		for _, record := range records {
+			if i < len(record) {
+				values = append(values, strings.TrimSpace(record[i]))
+			}
+		}
+		column := ColumnInfo{Index: i, Name: fmt.Sprintf("列%d", i+1), Type: inferColumnType(values)}
+		if i < len(header) {
+			column.Name = strings.TrimSpace(header[i])
+		}
+		if len(values) > 0 {
+			column.Sample = values[0]
+		}
+		desc.Columns = append(desc.Columns, column)
+	}
+
+	desc.Mapping = map[string]int{
+		"timestamp": detectColumn(desc.Columns, []string{"open time", "open_time", "timestamp", "time", "date"}, columnTimestamp, columnDatetime),
+		"close":     detectColumn(desc.Columns, []string{"close", "close price", "price"}, columnFloat, columnInt),
+		"volume":    detectColumn(desc.Columns, []string{"volume", "vol"}, columnFloat, columnInt),
+	}
+	return desc, nil
+}
+
+// 第一行中存在字符串列、而后续行同一列不是字符串时，认为第一行是标题
+func isHeaderRow(records [][]string) bool {
+	if len(records) < 2 {
+		for _, v := range records[0] {
+			if inferColumnType([]string{strings.TrimSpace(v)}) == columnString {
+				return true
+			}
+		}
+		return false
+	}
+	for i, v := range records[0] {
+		if inferColumnType([]string{strings.TrimSpace(v)}) != columnString {
+			continue
+		}
+		var rest []string
+		for _, record := range records[1:] {
+			if i < len(record) {
+				rest = append(rest, strings.TrimSpace(record[i]))
+			}
+		}
+		if t := inferColumnType(rest); t != columnString && t != columnEmpty {
+			return true
+		}
+	}
+	return false
+}
+
+// 推断一列的类型：所有非空值都满足才算该类型
+func inferColumnType(values []string) string {
+	allInt, allFloat, allTimestamp, allDatetime := true, true, true, true
+	nonEmpty := 0
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		nonEmpty++
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			allInt, allTimestamp = false, false
+		} else if !isPlausibleTimestamp(n) {
+			allTimestamp = false
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			allFloat = false
+		}
+		if !isDatetime(v) {
+			allDatetime = false
+		}
+	}
+	switch {
+	case nonEmpty == 0:
+		return columnEmpty
+	case allTimestamp:
+		return columnTimestamp
+	case allInt:
+		return columnInt
+	case allFloat:
+		return columnFloat
+	case allDatetime:
+		return columnDatetime
+	}
+	return columnString
+}
+
+// 按秒、毫秒、微秒依次尝试，落在合理范围内即认为是时间戳
+func isPlausibleTimestamp(n int64) bool {
+	for _, t := range []time.Time{time.Unix(n, 0), time.UnixMilli(n), time.UnixMicro(n)} {
+		if t.After(timestampMin) && t.Before(timestampMax) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDatetime(v string) bool {
+	for _, layout := range datetimeLayouts {
+		if _, err := time.Parse(layout, v); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// 先按列名匹配（名称列表按优先级排列，且类型必须符合），再退回到第一个类型符合的列
+// 退回时时间戳只接受 timestamp 类型，价格和成交量没有标题时无法区分，返回 -1
+func detectColumn(columns []ColumnInfo, names []string, types ...string) int {
+	typeOK := func(c ColumnInfo) bool {
+		for _, t := range types {
+			if c.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range names {
+		for _, c := range columns {
+			if strings.EqualFold(c.Name, name) && typeOK(c) {
+				return c.Index
+			}
+		}
+	}
+	if types[0] == columnTimestamp {
+		for _, c := range columns {
+			if c.Type == columnTimestamp {
+				return c.Index
+			}
+		}
+	}
+	return -1
+}
+
+func printDescription(path string, desc *CSVDescription) {
+	fmt.Printf("文件: %s\n", path)
+	if desc.Schema > 0 {
+		fmt.Printf("Schema 版本: %d\n", desc.Schema)
+	}
+	if desc.HasHeader {
+		fmt.Println("标题行: 有")
+	} else {
+		fmt.Println("标题行: 无")
+	}
+	fmt.Printf("用于推断的数据行: %d\n\n", desc.Rows)
+
+	fmt.Printf("%-4s %-32s %-10s %s\n", "列", "名称", "类型", "示例")
+	fmt.Println(strings.Repeat("-", 70))
+	for _, c := range desc.Columns {
+		fmt.Printf("%-4d %-32s %-10s %s\n", c.Index+1, c.Name, c.Type, c.Sample)
+	}
+
+	fmt.Println("\n识别出的列:")
+	mismatches := 0
+	for _, use := range []string{"timestamp", "close", "volume"} {
+		idx := desc.Mapping[use]
+		if idx < 0 {
+			fmt.Printf("  %-10s 未找到\n", use)
+		} else {
+			fmt.Printf("  %-10s 第 %d 列 (%s)\n", use, idx+1, desc.Columns[idx].Name)
+		}
+		if idx != klineLoaderColumns[use] {
+			mismatches++
+		}
+	}
+
+	// K线分析程序按下载脚本的固定列位置读取，位置不一致时完整运行会解析失败或读到错误的列
+	if mismatches == 0 {
+		fmt.Println("\n列布局与K线加载器一致")
+		return
+	}
+	fmt.Println("\n警告: 列布局与K线加载器期望的不一致（时间戳第 1 列、收盘价第 6 列、成交量第 7 列）")
+	if !desc.HasHeader {
+		fmt.Println("没有标题行的文件可能来自 data.binance.vision，可以先用 import_vision_klines.go 转换")
+	}
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
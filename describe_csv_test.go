@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 下载脚本格式（带版本行和标题）、没有标题的 data.binance.vision 格式，以及列顺序不同的自定义文件
+func TestDescribeCSVMapping(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		content   string
+		hasHeader bool
+		schema    int
+		types     []string
+		mapping   map[string]int
+	}{
+		{
+			name: "下载脚本格式",
+			content: "# schema=2\n" +
+				"Open Time,Open Time (UTC),Open,High,Low,Close,Volume,Close Time,Number of Trades\n" +
+				"1767225600000,2026-01-01 00:00:00,2000.5,2001,1999.25,2000.75,12.5,1767225659999,42\n" +
+				"1767225660000,2026-01-01 00:01:00,2000.75,2002,2000,2001.5,8,1767225719999,30\n",
+			hasHeader: true,
+			schema:    2,
+			types:     []string{columnTimestamp, columnDatetime, columnFloat, columnInt, columnFloat, columnFloat, columnFloat, columnTimestamp, columnInt},
+			mapping:   map[string]int{"timestamp": 0, "close": 5, "volume": 6},
+		},
+		{
+			name: "没有标题",
+			content: "1767225600000000,2000.5,2001,1999.25,2000.75,12.5\n" +
+				"1767225660000000,2000.75,2002,2000,2001.5,8\n",
+			types:   []string{columnTimestamp, columnFloat, columnInt, columnFloat, columnFloat, columnFloat},
+			mapping: map[string]int{"timestamp": 0, "close": -1, "volume": -1},
+		},
+		{
+			name:      "自定义列顺序",
+			content:   "symbol,vol,price,date\nETHUSDT,3.5,2000.1,2026-01-01 00:00\nETHUSDT,,2001,2026-01-01 00:01\n",
+			hasHeader: true,
+			types:     []string{columnString, columnFloat, columnFloat, columnDatetime},
+			mapping:   map[string]int{"timestamp": 3, "close": 2, "volume": 1},
+		},
+	} {
+		desc, err := describeCSV(strings.NewReader(tc.content), 20)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if desc.HasHeader != tc.hasHeader || desc.Schema != tc.schema || desc.Rows != 2 {
+			t.Errorf("%s: HasHeader=%v Schema=%d Rows=%d", tc.name, desc.HasHeader, desc.Schema, desc.Rows)
+		}
+		var types []string
+		for _, c := range desc.Columns {
+			types = append(types, c.Type)
+		}
+		if !reflect.DeepEqual(types, tc.types) {
+			t.Errorf("%s: 列类型 = %v, want %v", tc.name, types, tc.types)
+		}
+		if !reflect.DeepEqual(desc.Mapping, tc.mapping) {
+			t.Errorf("%s: 映射 = %v, want %v", tc.name, desc.Mapping, tc.mapping)
+		}
+	}
+
+	if _, err := describeCSV(strings.NewReader("# schema=2\n"), 20); err == nil {
+		t.Error("没有数据的文件应返回错误")
+	}
+}
+
+// -rows 限制参与推断的行数，后面的行不影响类型
+func TestDescribeCSVMaxRows(t *testing.T) {
+	desc, err := describeCSV(strings.NewReader("a,b\n1,2\n3,4\nx,5\n"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Rows != 2 || desc.Columns[0].Type != columnInt || desc.Columns[0].Sample != "1" {
+		t.Errorf("desc = %+v", desc)
+	}
+}
@@ -3,6 +3,18 @@ import datetime
 import time
 import csv
 
+_valid_symbols = None
+
+def validate_symbol(symbol: str):
+    """校验交易对是否存在于 exchangeInfo（结果缓存）"""
+    global _valid_symbols
+    if _valid_symbols is None:
+        resp = requests.get("https://api.binance.com/api/v3/exchangeInfo")
+        resp.raise_for_status()
+        _valid_symbols = {s["symbol"] for s in resp.json()["symbols"] if s["status"] == "TRADING"}
+    if symbol not in _valid_symbols:
+        raise ValueError(f"无效的交易对: {symbol}")
+
 def fetch_minute_klines(symbol: str, start_date: str, end_date: str = None):
     """下载币安分钟K线数据"""
     base_url = "https://api.binance.com/api/v3/klines"
@@ -87,7 +99,9 @@ def save_to_csv(data, filename):
     print(f"总记录数: {len(data)}")
 
 if __name__ == "__main__":
-    symbol = "ETHUSDT"
+    base_asset = "ETH"
+    quote_asset = "USDT"  # 计价币（交易对后缀），如 USDC 时为 ETHUSDC，Go 程序需加 -symbol-suffix USDC
+    symbol = base_asset + quote_asset
     end_date = datetime.datetime.now()
     start_date = end_date - datetime.timedelta(days=14)
     
@@ -97,10 +111,16 @@ if __name__ == "__main__":
     print(f"下载最近14天的数据...")
     print(f"时间段: {start_date_str} 到 {end_date_str}\n")
     
+    try:
+        validate_symbol(symbol)
+    except (ValueError, requests.exceptions.RequestException) as e:
+        print(f"交易对校验失败: {e}")
+        raise SystemExit(1)
+    
     data = fetch_minute_klines(symbol, start_date_str, end_date_str)
     
     if data:
-        save_to_csv(data, "ETHUSDT_latest_14days.csv")
+        save_to_csv(data, f"{symbol}_latest_14days.csv")
         print(f"\n成功下载 {len(data)} 条分钟K线数据")
     else:
         print("没有下载到数据")
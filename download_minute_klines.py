@@ -2,9 +2,57 @@ import requests
 import datetime
 import time
 import csv
-from typing import List
+from enum import Enum
+from typing import Dict, List, Set
 
-def fetch_minute_klines(symbol: str, start_date: str, end_date: str = None) -> List[List]:
+class Market(Enum):
+    """Which Binance market to download from"""
+    SPOT = "spot"
+    USDM_FUTURES = "usdm_futures"
+
+# Base URL and endpoint paths per market. Kline rows have the same layout on both,
+# so pagination and CSV output are identical.
+MARKET_ENDPOINTS = {
+    Market.SPOT: {
+        "base_url": "https://api.binance.com",
+        "klines": "/api/v3/klines",
+        "exchange_info": "/api/v3/exchangeInfo",
+    },
+    Market.USDM_FUTURES: {
+        "base_url": "https://fapi.binance.com",
+        "klines": "/fapi/v1/klines",
+        "exchange_info": "/fapi/v1/exchangeInfo",
+    },
+}
+
+_valid_symbols: Dict[Market, Set[str]] = {}
+
+def fetch_exchange_symbols(market: Market = Market.SPOT) -> Set[str]:
+    """
+    Fetch the set of trading symbols from Binance exchangeInfo (cached after the first call)
+    
+    Args:
+        market: Market whose exchangeInfo is queried
+    
+    Returns:
+        Set of symbols whose status is TRADING
+    """
+    if market not in _valid_symbols:
+        endpoints = MARKET_ENDPOINTS[market]
+        resp = requests.get(endpoints["base_url"] + endpoints["exchange_info"])
+        resp.raise_for_status()
+        _valid_symbols[market] = {s["symbol"] for s in resp.json()["symbols"] if s["status"] == "TRADING"}
+    return _valid_symbols[market]
+
+def validate_symbol(symbol: str, market: Market = Market.SPOT):
+    """
+    Raise ValueError if symbol is not a valid Binance trading pair (e.g. a typo like 'ETHUST')
+    """
+    if symbol not in fetch_exchange_symbols(market):
+        raise ValueError(f"Invalid symbol for {market.value}: {symbol}")
+
+def fetch_minute_klines(symbol: str, start_date: str, end_date: str = None,
+                        market: Market = Market.SPOT) -> List[List]:
     """
     Fetch 1-minute K-line data from Binance API
     
@@ -12,11 +60,13 @@ def fetch_minute_klines(symbol: str, start_date: str, end_date: str = None) -> L
         symbol: Trading pair symbol (e.g., 'BTCUSDT', 'ETHUSDT')
         start_date: Start date in 'YYYY-MM-DD' format
         end_date: End date in 'YYYY-MM-DD' format (default: today)
+        market: Market.SPOT (api.binance.com) or Market.USDM_FUTURES (fapi.binance.com)
     
     Returns:
         List of K-line data [timestamp, open, high, low, close, volume, ...]
     """
-    base_url = "https://api.binance.com/api/v3/klines"
+    endpoints = MARKET_ENDPOINTS[market]
+    base_url = endpoints["base_url"] + endpoints["klines"]
     interval = "1m"  # 1-minute interval
     
     # Parse dates
@@ -34,7 +84,7 @@ def fetch_minute_klines(symbol: str, start_date: str, end_date: str = None) -> L
     current_start = start_ms
     limit = 1000  # Binance API limit per request
     
-    print(f"Downloading 1-minute K-line data for {symbol} from {start_date} to {end_dt.strftime('%Y-%m-%d')}...")
+    print(f"Downloading 1-minute {market.value} K-line data for {symbol} from {start_date} to {end_dt.strftime('%Y-%m-%d')}...")
     
     while current_start < end_ms:
         # Calculate end time for this batch (1000 minutes max per request)
@@ -154,7 +204,10 @@ def save_to_csv(data: List[List], symbol: str, filename: str = None):
 
 def main():
     # Configuration
-    symbol = "ETHUSDT"  # Change this to your desired trading pair (e.g., ETHUSDT, BTCUSDT)
+    base_asset = "ETH"
+    quote_asset = "USDT"  # Quote asset / symbol suffix, e.g. USDC for ETHUSDC (pass -symbol-suffix USDC to the Go tools)
+    symbol = base_asset + quote_asset
+    market = Market.SPOT  # Market.USDM_FUTURES for perpetual futures on fapi.binance.com
     
     # Calculate start date as 1 year ago from today
     end_date = datetime.datetime.now()
@@ -166,8 +219,14 @@ def main():
     print(f"Downloading 1-minute K-line data for the last year...")
     print(f"Period: {start_date_str} to {end_date_str}")
     
+    try:
+        validate_symbol(symbol, market)
+    except (ValueError, requests.exceptions.RequestException) as e:
+        print(f"Symbol check failed: {e}")
+        return
+    
     # Download data
-    data = fetch_minute_klines(symbol, start_date_str, end_date_str)
+    data = fetch_minute_klines(symbol, start_date_str, end_date_str, market)
     
     if data:
         # Save to CSV
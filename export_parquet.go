@@ -0,0 +1,462 @@
+//go:build parquet
+
+// 把 zscore_results.csv 和 multi_timeframe_volatility.csv 导出为 Parquet，方便用 pandas / DuckDB 等工具分析
+// 依赖 parquet-go，默认构建不包含，需要加构建标签: go run -tags parquet,purego export_parquet.go
+// purego 让 parquet-go 不使用汇编和 runtime 内部符号，Go 1.23 及以后的版本不加会链接失败
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// zscore_results.parquet 的一行，旧版本CSV中没有的列写为 null
+type ZScoreParquetRow struct {
+	WindowMinutes  int64    `parquet:"Window_Minutes"`
+	WindowDays     float64  `parquet:"Window_Days"`
+	ReturnPct      float64  `parquet:"Return_Pct"`
+	Mean           float64  `parquet:"Mean_Pct"`
+	StdDev         float64  `parquet:"StdDev_Pct"`
+	ZScore         float64  `parquet:"Z_Score"`
+	TrendSlopePct  *float64 `parquet:"Trend_Slope_Pct,optional"`
+	Interpretation *string  `parquet:"Interpretation,optional"`
+}
+
+// multi_timeframe_volatility.parquet 的一行，旧版本CSV中没有的列写为 null
+type VolatilityParquetRow struct {
+	WindowMinutes  int64    `parquet:"Window_Minutes"`
+	WindowDays     float64  `parquet:"Window_Days"`
+	Mean           float64  `parquet:"Mean_Pct"`
+	StdDev         float64  `parquet:"StdDev_Pct"`
+	SampleCount    int64    `parquet:"Sample_Count"`
+	VaRPct         *float64 `parquet:"VaR_Pct,optional"`
+	RealizedVolPct *float64 `parquet:"Realized_Vol_Pct,optional"`
+}
+
+func main() {
+	flag.StringVar(&inputDir, "input-dir", ".", "输入文件所在目录")
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	flag.Func("csv-delimiter", "CSV 分隔符：单个字符，或 tab 表示制表符（默认 ,）", setCSVDelimiter)
+	flag.Func("csv-encoding", "CSV 编码：auto（按 BOM 识别 UTF-8/UTF-16，默认）、utf-8、utf-16le、utf-16be", setCSVEncoding)
+	flag.Parse()
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
+	zscoreRows, err := loadZScoreRows(inputPath("zscore_results.csv"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeParquetAtomic(outputPath("zscore_results.parquet"), zscoreRows); err != nil {
+		log.Fatal("保存 zscore_results.parquet 失败:", err)
+	}
+	fmt.Printf("已导出 %d 行到 %s\n", len(zscoreRows), outputPath("zscore_results.parquet"))
+
+	volRows, err := loadVolatilityRows(inputPath("multi_timeframe_volatility.csv"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeParquetAtomic(outputPath("multi_timeframe_volatility.parquet"), volRows); err != nil {
+		log.Fatal("保存 multi_timeframe_volatility.parquet 失败:", err)
+	}
+	fmt.Printf("已导出 %d 行到 %s\n", len(volRows), outputPath("multi_timeframe_volatility.parquet"))
+}
+
+// 读取 zscore_results.csv，数值列解析失败时报错并指出行号
+func loadZScoreRows(path string) ([]ZScoreParquetRow, error) {
+	version, records, err := readSchemaCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %v", path, err)
+	}
+	if err := checkSchema("zscore_results.csv", version, zscoreResultsSchemaVersion, zscoreResultsSchemaMigrations, records, zscoreResultsRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	trendCol := columnIndex(records[0], "Trend_Slope_Pct")
+	interpretationCol := columnIndex(records[0], "Interpretation")
+	rows := make([]ZScoreParquetRow, 0, len(records)-1)
+	for i, record := range records[1:] {
+		p := recordParser{record: record, line: i + 2}
+		row := ZScoreParquetRow{
+			WindowMinutes: p.int(0),
+			WindowDays:    p.float(1),
+			ReturnPct:     p.float(2),
+			Mean:          p.float(3),
+			StdDev:        p.float(4),
+			ZScore:        p.float(5),
+			TrendSlopePct: p.optionalFloat(trendCol),
+		}
+		if interpretationCol >= 0 && interpretationCol < len(record) {
+			row.Interpretation = &record[interpretationCol]
+		}
+		if p.err != nil {
+			return nil, fmt.Errorf("%s: %v", path, p.err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 读取 multi_timeframe_volatility.csv，数值列解析失败时报错并指出行号
+func loadVolatilityRows(path string) ([]VolatilityParquetRow, error) {
+	version, records, err := readSchemaCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %v", path, err)
+	}
+	if err := checkSchema("multi_timeframe_volatility.csv", version, volatilitySchemaVersion, volatilitySchemaMigrations, records, volatilityRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	varCol := columnIndex(records[0], "VaR_Pct")
+	realizedCol := columnIndex(records[0], "Realized_Vol_Pct")
+	rows := make([]VolatilityParquetRow, 0, len(records)-1)
+	for i, record := range records[1:] {
+		p := recordParser{record: record, line: i + 2}
+		row := VolatilityParquetRow{
+			WindowMinutes:  p.int(0),
+			WindowDays:     p.float(1),
+			Mean:           p.float(2),
+			StdDev:         p.float(3),
+			SampleCount:    p.int(4),
+			VaRPct:         p.optionalFloat(varCol),
+			RealizedVolPct: p.optionalFloat(realizedCol),
+		}
+		if p.err != nil {
+			return nil, fmt.Errorf("%s: %v", path, p.err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 标题中列的位置，不存在时返回 -1
+func columnIndex(header []string, name string) int {
+	for i, col := range header {
+		if col == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 逐列解析一行CSV，记住第一个错误，调用方在整行解析完后检查 err
+type recordParser struct {
+	record []string
+	line   int
+	err    error
+}
+
+func (p *recordParser) field(col int) (string, bool) {
+	if p.err != nil {
+		return "", false
+	}
+	if col >= len(p.record) {
+		p.err = fmt.Errorf("第 %d 行只有 %d 列", p.line, len(p.record))
+		return "", false
+	}
+	return p.record[col], true
+}
+
+func (p *recordParser) int(col int) int64 {
+	s, ok := p.field(col)
+	if !ok {
+		return 0
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		p.err = fmt.Errorf("第 %d 行第 %d 列不是整数: %q", p.line, col+1, s)
+	}
+	return v
+}
+
+func (p *recordParser) float(col int) float64 {
+	s, ok := p.field(col)
+	if !ok {
+		return math.NaN()
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		p.err = fmt.Errorf("第 %d 行第 %d 列不是数字: %q", p.line, col+1, s)
+	}
+	return v
+}
+
+// 可选列：列不存在（旧版本文件）时返回 nil，写入 Parquet 为 null
+func (p *recordParser) optionalFloat(col int) *float64 {
+	if col < 0 {
+		return nil
+	}
+	v := p.float(col)
+	return &v
+}
+
+// 先写临时文件再改名，与CSV输出一样保证读取方不会看到写了一半的文件
+func writeParquetAtomic[T any](path string, rows []T) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		writer := parquet.NewGenericWriter[T](w)
+		if _, err := writer.Write(rows); err != nil {
+			return err
+		}
+		return writer.Close()
+	})
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 旧版本文件的迁移说明，Compatible 表示当前读取方仍能正确解析（例如只在末尾追加过列）
+type SchemaMigration struct {
+	Compatible bool
+	Note       string
+}
+
+// 读取带版本行的CSV，返回版本号和全部记录（含标题行）
+func readSchemaCSV(path string) (int, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	br, err := newCSVInput(path, file)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	if prefix, err := br.Peek(len(schemaPrefix)); err == nil && string(prefix) == schemaPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaPrefix)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s 的版本行格式错误: %q", path, strings.TrimSpace(line))
+		}
+	}
+
+	records, err := newCSVReader(br).ReadAll()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, records, nil
+}
+
+// 校验版本和标题：当前版本直接通过，兼容的旧版本打印迁移说明后继续，其余情况报错
+// expected 为读取方依赖的前几列
+func checkSchema(name string, version, current int, migrations map[int]SchemaMigration, records [][]string, expected []string) error {
+	if version != current {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%s 的版本 schema=%d 无法识别（当前为 schema=%d），请用当前版本的工具重新生成", name, version, current)
+		}
+		if !m.Compatible {
+			return fmt.Errorf("%s 是不兼容的旧版本 schema=%d: %s", name, version, m.Note)
+		}
+		fmt.Printf("注意: %s 是旧版本 schema=%d: %s\n", name, version, m.Note)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("%s 缺少标题行", name)
+	}
+	header := records[0]
+	if len(header) < len(expected) {
+		return fmt.Errorf("%s 的标题只有 %d 列，至少需要 %d 列", name, len(header), len(expected))
+	}
+	for i, col := range expected {
+		if header[i] != col {
+			return fmt.Errorf("%s 第 %d 列应为 %s，实际为 %s", name, i+1, col, header[i])
+		}
+	}
+	return nil
+}
+
+// 输入文件目录，由 -input-dir 指定
+var inputDir string
+
+// 输入文件路径（相对 -input-dir）
+func inputPath(name string) string {
+	return filepath.Join(inputDir, name)
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// zscore_results.csv 的版本，列有变化时加1
+// v3: 缺少波动率数据的窗口不再跳过，写为 NaN
+// v4: 增加 Trend_Slope_Pct 列（窗口内回归斜率，每根K线百分比）
+// v5: 增加 Interpretation 列（z-score 所在区间的说明）
+const zscoreResultsSchemaVersion = 5
+
+// 读取 zscore_results.csv 时依赖的列
+var zscoreResultsRequiredHeader = []string{"Window_Minutes", "Window_Days", "Return_Pct", "Mean_Pct", "StdDev_Pct", "Z_Score"}
+
+// 旧版本 zscore_results.csv 的迁移说明
+var zscoreResultsSchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有版本行，缺少波动率数据的窗口被跳过，也没有 Trend_Slope_Pct 和 Interpretation 列"},
+	2: {Compatible: true, Note: "缺少波动率数据的窗口被跳过，也没有 Trend_Slope_Pct 和 Interpretation 列"},
+	3: {Compatible: true, Note: "没有 Trend_Slope_Pct 和 Interpretation 列，导出时写为 null"},
+	4: {Compatible: true, Note: "没有 Interpretation 列，导出时写为 null"},
+}
+
+// 波动率表的版本，列有变化时加1，并在 volatilitySchemaMigrations 中说明旧版本如何处理
+const volatilitySchemaVersion = 3
+
+// 读取波动率表时依赖的列
+var volatilityRequiredHeader = []string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count"}
+
+// 旧版本波动率表的迁移说明
+var volatilitySchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有 VaR_Pct 列（由旧版 calculate_volatility 或 calculate_multi_timeframe_volatility.py 生成），不影响z-score计算，需要 VaR 时请重新运行 calculate_volatility"},
+	2: {Compatible: true, Note: "没有 Realized_Vol_Pct 列，不影响z-score计算"},
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
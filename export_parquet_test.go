@@ -0,0 +1,109 @@
+//go:build parquet
+
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// 导出后读回：值与CSV一致（包括 NaN），窗口为 INT64，数值为 DOUBLE，新增的列可以为 null
+func TestExportParquetRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "zscore_results.csv")
+	content := "# schema=5\n" +
+		"Window_Minutes,Window_Days,Return_Pct,Mean_Pct,StdDev_Pct,Z_Score,Trend_Slope_Pct,Interpretation\n" +
+		"1,0.0007,0.120000,0.000100,0.050000,2.3980,0.001000,显著偏离\n" +
+		"60,0.0417,-0.500000,0.001000,0.400000,-1.2525,-0.000200,正常\n" +
+		"1440,1.0000,0.000000,NaN,NaN,NaN,0.000000,缺少数据\n"
+	if err := os.WriteFile(csvPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := loadZScoreRows(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parquetPath := filepath.Join(dir, "zscore_results.parquet")
+	if err := writeParquetAtomic(parquetPath, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parquet.ReadFile[ZScoreParquetRow](parquetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("读回 %d 行, want 3", len(got))
+	}
+	if got[0].WindowMinutes != 1 || got[1].WindowMinutes != 60 || got[2].WindowMinutes != 1440 {
+		t.Errorf("窗口 = %d, %d, %d", got[0].WindowMinutes, got[1].WindowMinutes, got[2].WindowMinutes)
+	}
+	if got[0].ZScore != 2.398 || got[1].ZScore != -1.2525 || got[1].ReturnPct != -0.5 || got[1].WindowDays != 0.0417 {
+		t.Errorf("数值列 = %+v, %+v", got[0], got[1])
+	}
+	if !math.IsNaN(got[2].ZScore) || !math.IsNaN(got[2].StdDev) {
+		t.Errorf("NaN 没有保留: %+v", got[2])
+	}
+	if got[1].TrendSlopePct == nil || *got[1].TrendSlopePct != -0.0002 || got[0].Interpretation == nil || *got[0].Interpretation != "显著偏离" {
+		t.Errorf("可选列 = %v, %v", got[1].TrendSlopePct, got[0].Interpretation)
+	}
+
+	file, err := os.Open(parquetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		column   string
+		kind     parquet.Kind
+		optional bool
+	}{
+		{"Window_Minutes", parquet.Int64, false},
+		{"Z_Score", parquet.Double, false},
+		{"Trend_Slope_Pct", parquet.Double, true},
+		{"Interpretation", parquet.ByteArray, true},
+	} {
+		var field parquet.Field
+		for _, f := range pf.Schema().Fields() {
+			if f.Name() == tc.column {
+				field = f
+			}
+		}
+		if field == nil {
+			t.Errorf("没有 %s 列", tc.column)
+			continue
+		}
+		if kind := field.Type().Kind(); kind != tc.kind || field.Optional() != tc.optional {
+			t.Errorf("%s: 类型 %v optional=%v, want %v optional=%v", tc.column, kind, field.Optional(), tc.kind, tc.optional)
+		}
+	}
+}
+
+// 旧版本（schema=3，没有 Trend_Slope_Pct 和 Interpretation）的文件导出时这两列为 null
+func TestExportParquetOldSchema(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "zscore_results.csv")
+	content := "# schema=3\nWindow_Minutes,Window_Days,Return_Pct,Mean_Pct,StdDev_Pct,Z_Score\n5,0.0035,0.1,0,0.1,1.0\n"
+	if err := os.WriteFile(csvPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := loadZScoreRows(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].TrendSlopePct != nil || rows[0].Interpretation != nil {
+		t.Errorf("rows = %+v", rows)
+	}
+}
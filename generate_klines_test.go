@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testGBMParams() GBMParams {
+	return GBMParams{
+		StartPrice: 2000,
+		StartTime:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Volatility: 0.001,
+		JumpIndex:  -1,
+	}
+}
+
+// 相同种子和参数生成完全相同的K线，不同种子不同；写出的CSV读回后与生成的一致
+func TestGenerateKlinesReproducible(t *testing.T) {
+	params := testGBMParams()
+	a := generateKlines(2000, 42, params)
+	if b := generateKlines(2000, 42, params); !reflect.DeepEqual(a, b) {
+		t.Fatal("相同种子生成的K线不同")
+	}
+	if c := generateKlines(2000, 43, params); reflect.DeepEqual(a, c) {
+		t.Fatal("不同种子生成的K线相同")
+	}
+	for i, k := range a {
+		if !(k.Low <= math.Min(k.Open, k.Close) && k.High >= math.Max(k.Open, k.Close)) {
+			t.Fatalf("第 %d 根K线的高低价不包含开收盘价: %+v", i, k)
+		}
+		if i > 0 && (k.Open != a[i-1].Close || k.OpenTime-a[i-1].OpenTime != 60000) {
+			t.Fatalf("第 %d 根K线与上一根不连续: %+v", i, k)
+		}
+	}
+	if a[0].Time != "2026-01-01 00:00:00" {
+		t.Errorf("第一根K线时间 = %s", a[0].Time)
+	}
+
+	path := filepath.Join(t.TempDir(), "ETHUSDT_minute_klines.csv")
+	if err := writeSyntheticKlinesCSV(path, a); err != nil {
+		t.Fatal(err)
+	}
+	loaded, rowErrors, err := loadKlines(path, true)
+	if err != nil || len(rowErrors) != 0 {
+		t.Fatalf("读回失败: %v %v", err, rowErrors)
+	}
+	if !reflect.DeepEqual(loaded, a) {
+		t.Error("读回的K线与生成的不同")
+	}
+}
+
+// 插入的 -8 倍标准差跳跃在1分钟收益率的 z-score 中是唯一的极端值
+func TestGenerateKlinesJumpIsExtreme(t *testing.T) {
+	params := testGBMParams()
+	params.JumpIndex = 1500
+	params.JumpSigma = -8
+	klines := generateKlines(3000, 7, params)
+
+	returns := make([]float64, len(klines)-1)
+	mean := 0.0
+	for i := range returns {
+		returns[i] = (klines[i+1].Close - klines[i].Close) / klines[i].Close * 100
+		mean += returns[i]
+	}
+	mean /= float64(len(returns))
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	stdDev := math.Sqrt(variance / float64(len(returns)-1))
+
+	for i, r := range returns {
+		z := (r - mean) / stdDev
+		if i+1 == params.JumpIndex {
+			if z > -6 {
+				t.Errorf("跳跃处的 z-score = %.2f, want < -6", z)
+			}
+		} else if math.Abs(z) > 5 {
+			t.Errorf("第 %d 根K线的 z-score = %.2f，不是跳跃却超过 5", i+1, z)
+		}
+	}
+}
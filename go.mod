@@ -2,6 +2,20 @@ module main
 
 go 1.20
 
-require github.com/natefinch/lumberjack v2.0.0+incompatible
+require (
+	github.com/gorilla/websocket v1.5.0
+	github.com/natefinch/lumberjack v2.0.0+incompatible
+	github.com/parquet-go/parquet-go v0.20.1
+)
 
-require gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
+require (
+	github.com/andybalholm/brotli v1.0.5 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/klauspost/compress v1.16.7 // indirect
+	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.18 // indirect
+	github.com/segmentio/encoding v0.3.6 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
+)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 在内存中生成一个 data.binance.vision 格式的ZIP（只含一个CSV）并写到 dir/name
+func writeVisionZip(t *testing.T, dir, name, csvName, content string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	w, err := archive.Create(csvName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 现货文件没有标题行、时间戳为微秒；合约文件有标题行、时间戳为毫秒，两者解析结果相同
+func TestLoadKlinesFromZip(t *testing.T) {
+	dir := t.TempDir()
+	spot := writeVisionZip(t, dir, "ETHUSDT-1m-2026-01-01.zip", "ETHUSDT-1m-2026-01-01.csv",
+		"1767225600000000,2000.5,2001,1999.25,2000.75,12.5,1767225659999999,25000.1,42,6.25,12500.05,0\n"+
+			"1767225660000000,2000.75,2002,2000,2001.5,8,1767225719999999,16008,30,4,8004,0\n")
+	futures := writeVisionZip(t, dir, "futures.zip", "ETHUSDT-1m-2026-01-01.csv",
+		"open_time,open,high,low,close,volume,close_time,quote_volume,count,taker_buy_volume,taker_buy_quote_volume,ignore\n"+
+			"1767225600000,2000.5,2001,1999.25,2000.75,12.5,1767225659999,25000.1,42,6.25,12500.05,0\n"+
+			"1767225660000,2000.75,2002,2000,2001.5,8,1767225719999,16008,30,4,8004,0\n")
+
+	want := VisionKline{
+		Kline: Kline{
+			OpenTime: 1767225600000,
+			Time:     "2026-01-01 00:00:00",
+			Open:     2000.5,
+			High:     2001,
+			Low:      1999.25,
+			Close:    2000.75,
+			Volume:   12.5,
+		},
+		CloseTime: 1767225659999,
+		Extra:     []string{"25000.1", "42", "6.25", "12500.05"},
+	}
+	for _, path := range []string{spot, futures} {
+		klines, err := loadKlinesFromZip(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(klines) != 2 {
+			t.Fatalf("%s: %d 根K线, want 2", filepath.Base(path), len(klines))
+		}
+		if !reflect.DeepEqual(klines[0], want) {
+			t.Errorf("%s: 第一根 = %+v, want %+v", filepath.Base(path), klines[0], want)
+		}
+		if klines[1].OpenTime != 1767225660000 || klines[1].Time != "2026-01-01 00:01:00" || klines[1].Close != 2001.5 {
+			t.Errorf("%s: 第二根 = %+v", filepath.Base(path), klines[1])
+		}
+	}
+
+	bad := writeVisionZip(t, dir, "bad.zip", "bad.csv", "1767225600000,2000.5,2001\n")
+	if _, err := loadKlinesFromZip(bad); err == nil {
+		t.Error("列数不足的文件应返回错误")
+	}
+}
+
+// 目录中的多个ZIP按开盘时间合并，时间范围重叠的同一分钟只保留一根
+func TestLoadKlinesFromZipDir(t *testing.T) {
+	dir := t.TempDir()
+	row := func(openTime string) string {
+		return openTime + ",2000,2001,1999,2000,1," + openTime[:len(openTime)-1] + "9,2000,1,0.5,1000,0\n"
+	}
+	writeVisionZip(t, dir, "b.zip", "b.csv", row("1767225720000")+row("1767225780000"))
+	writeVisionZip(t, dir, "a.zip", "a.csv", row("1767225600000")+row("1767225660000")+row("1767225720000"))
+
+	klines, err := loadKlinesFromZipDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var times []int64
+	for _, k := range klines {
+		times = append(times, k.OpenTime)
+	}
+	if want := []int64{1767225600000, 1767225660000, 1767225720000, 1767225780000}; !reflect.DeepEqual(times, want) {
+		t.Errorf("合并后的开盘时间 = %v, want %v", times, want)
+	}
+}
@@ -0,0 +1,967 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+func main() {
+	symbol := flag.String("symbol", "", "交易对，默认为 ETH 加上 -symbol-suffix")
+	flag.Func("symbol-suffix", "计价币（交易对后缀），如 USDC 时为 ETHUSDC，写入 ETHUSDC_minute_klines.csv（默认 USDT）", setSymbolSuffix)
+	marketName := flag.String("market", "spot", "行情市场: spot（现货）或 usdm（U本位合约）")
+	output := flag.String("output", "", "追加写入的K线CSV文件名（与下载脚本的格式相同），默认 <symbol>_minute_klines.csv")
+	flag.StringVar(&outputDir, "output-dir", ".", "输出文件目录（不存在时自动创建）")
+	flushInterval := flag.Duration("flush-interval", time.Minute, "把缓冲的K线写入文件的间隔，退出时也会写入")
+	noBackfill := flag.Bool("no-backfill", false, "连接和重连时不再用 REST 接口补齐断线期间缺少的K线")
+	flag.Parse()
+	if *flushInterval <= 0 {
+		log.Fatalf("-flush-interval 必须大于 0: %v", *flushInterval)
+	}
+	market, err := parseMarket(*marketName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *symbol == "" {
+		*symbol = composeSymbol(baseAsset, symbolSuffix)
+	}
+	*symbol = strings.ToUpper(*symbol)
+	// 交易对拼错（如 ETHUSD）时 WebSocket 不会报错，只是一直没有数据，启动时先用 exchangeInfo 校验
+	if ok, err := checkSymbol(market, *symbol); err != nil {
+		fmt.Printf("警告: 无法从 exchangeInfo 校验交易对 %s: %v\n", *symbol, err)
+	} else if !ok {
+		log.Fatalf("%s 市场没有交易对 %s，请检查 -symbol 或 -symbol-suffix", *marketName, *symbol)
+	}
+	if *output == "" {
+		*output = *symbol + "_minute_klines.csv"
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+	appender, err := newKlineAppender(outputPath(*output))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if last := appender.LastOpenTime(); last > 0 {
+		fmt.Printf("%s 最后一根K线: %s UTC，从下一根开始追加\n", outputPath(*output), formatOpenTime(last))
+	} else {
+		fmt.Printf("%s 还没有K线，从实时行情开始写入\n", outputPath(*output))
+	}
+
+	candles := make(chan StreamKline, 256)
+	go runKlineStream(market, *symbol, appender, !*noBackfill, candles)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(*flushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		n, err := appender.Flush()
+		if err != nil {
+			log.Println("写入K线失败，下次重试:", err)
+			return
+		}
+		if n > 0 {
+			fmt.Printf("已追加 %d 根K线，最新 %s UTC\n", n, formatOpenTime(appender.LastOpenTime()))
+		}
+	}
+	for {
+		select {
+		case k := <-candles:
+			last := appender.LastOpenTime()
+			switch appender.Add(k) {
+			case AppendAccepted:
+				if gap := (k.OpenTime-last)/60000 - 1; last > 0 && gap > 0 {
+					log.Printf("警告: %s UTC 之前缺少 %d 根K线", formatOpenTime(k.OpenTime), gap)
+				}
+			case AppendOutOfOrder:
+				log.Printf("忽略乱序的K线 %s UTC（已写到 %s UTC）", formatOpenTime(k.OpenTime), formatOpenTime(last))
+			}
+		case <-ticker.C:
+			flush()
+		case sig := <-stop:
+			fmt.Printf("收到 %v，写入缓冲的K线后退出\n", sig)
+			flush()
+			return
+		}
+	}
+}
+
+// 一根已收盘的1分钟K线，价格等字段保留交易所返回的原文，写出时不损失精度
+type StreamKline struct {
+	OpenTime  int64
+	CloseTime int64
+	Values    [5]string // Open, High, Low, Close, Volume
+	Extra     [4]string // Quote Asset Volume, Number of Trades, Taker Buy Base Asset Volume, Taker Buy Quote Asset Volume
+}
+
+// 下载脚本输出的CSV列
+var klineCSVHeader = []string{"Open Time", "Open Time (UTC)", "Open", "High", "Low", "Close", "Volume",
+	"Close Time", "Close Time (UTC)", "Quote Asset Volume", "Number of Trades",
+	"Taker Buy Base Asset Volume", "Taker Buy Quote Asset Volume"}
+
+// 按 klineCSVHeader 的列顺序输出一行
+func (k StreamKline) Record() []string {
+	record := []string{strconv.FormatInt(k.OpenTime, 10), formatOpenTime(k.OpenTime)}
+	record = append(record, k.Values[:]...)
+	record = append(record, strconv.FormatInt(k.CloseTime, 10), formatOpenTime(k.CloseTime))
+	return append(record, k.Extra[:]...)
+}
+
+// 用 parseKline 校验价格和成交量，与读取CSV时的规则相同，避免写入之后读不出来
+func (k StreamKline) Validate() error {
+	_, err := parseKline(k.Record()[:7])
+	return err
+}
+
+func formatOpenTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02 15:04:05")
+}
+
+// Add 的结果
+type AppendResult int
+
+const (
+	AppendAccepted   AppendResult = iota
+	AppendDuplicate               // 与最后一根的开盘时间相同（重连补数据时的重叠部分）
+	AppendOutOfOrder              // 比最后一根更早
+)
+
+// 把K线追加到CSV末尾：按开盘时间去重，攒在内存里由 Flush 批量写入
+// 最后一根的开盘时间包括已写入文件和还在缓冲中的K线
+type KlineAppender struct {
+	path string
+
+	mu           sync.Mutex
+	lastOpenTime int64
+	pending      []StreamKline
+}
+
+// 读取已有文件的最后一根K线；文件不存在时从空文件开始
+func newKlineAppender(path string) (*KlineAppender, error) {
+	last, err := lastKlineOpenTime(path)
+	if err != nil {
+		return nil, err
+	}
+	return &KlineAppender{path: path, lastOpenTime: last}, nil
+}
+
+func (a *KlineAppender) LastOpenTime() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastOpenTime
+}
+
+func (a *KlineAppender) Add(k StreamKline) AppendResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case k.OpenTime == a.lastOpenTime:
+		return AppendDuplicate
+	case k.OpenTime < a.lastOpenTime:
+		return AppendOutOfOrder
+	}
+	a.lastOpenTime = k.OpenTime
+	a.pending = append(a.pending, k)
+	return AppendAccepted
+}
+
+// 把缓冲的K线追加到文件并同步到磁盘，返回写入的条数；写入失败时保留缓冲，下次再写
+// 新文件先写标题行；上次写到一半（最后一行没有换行）时先补一个换行，不和新的行粘在一起
+func (a *KlineAppender) Flush() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.pending) == 0 {
+		return 0, nil
+	}
+
+	file, err := os.OpenFile(a.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	writer := csv.NewWriter(file)
+	if info.Size() == 0 {
+		writer.Write(klineCSVHeader)
+	} else {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+			return 0, err
+		}
+		if last[0] != '\n' {
+			if _, err := file.WriteString("\n"); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for _, k := range a.pending {
+		writer.Write(k.Record())
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+
+	n := len(a.pending)
+	a.pending = a.pending[:0]
+	return n, nil
+}
+
+// 已有CSV中最大的开盘时间，文件不存在或没有数据行时返回 0；无法解析的行跳过
+func lastKlineOpenTime(path string) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	var last int64
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*csv.ParseError); ok || header {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if openTime, err := strconv.ParseInt(record[0], 10, 64); err == nil && openTime > last {
+			last = openTime
+		}
+	}
+	return last, nil
+}
+
+// 连接断开后的重试间隔，从 streamRetryMin 开始每次翻倍，最长 streamRetryMax
+const (
+	streamRetryMin = 5 * time.Second
+	streamRetryMax = time.Minute
+)
+
+// 行情每秒都有推送，超过这么久没有收到消息就认为连接已经断了
+const klineStreamReadTimeout = time.Minute
+
+// REST 接口一次最多返回的K线数
+const klinesPageLimit = 1000
+
+// 订阅K线流，断线后按退避间隔重连；每次连上之后先用 REST 接口补齐断线期间的K线
+func runKlineStream(market Market, symbol string, appender *KlineAppender, backfill bool, candles chan<- StreamKline) {
+	backoff := streamRetryMin
+	for {
+		connected, err := streamKlines(market, symbol, appender, backfill, candles)
+		if connected {
+			backoff = streamRetryMin
+		}
+		log.Printf("K线流中断，%v 后重连: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > streamRetryMax {
+			backoff = streamRetryMax
+		}
+	}
+}
+
+// 读取K线流直到连接断开，只转发已收盘的K线；connected 表示是否成功连上过
+func streamKlines(market Market, symbol string, appender *KlineAppender, backfill bool, candles chan<- StreamKline) (connected bool, err error) {
+	conn, _, err := websocket.DefaultDialer.Dial(market.StreamURL(symbol), nil)
+	if err != nil {
+		return false, fmt.Errorf("连接K线流失败: %v", err)
+	}
+	defer conn.Close()
+	log.Printf("%s K线流已连接", symbol)
+
+	// 先连上再补数据：补数据期间收盘的K线会留在连接的缓冲里，不会漏掉
+	if last := appender.LastOpenTime(); backfill && last > 0 {
+		missed, err := fetchClosedKlinesSince(market, symbol, last+1)
+		if err != nil {
+			log.Println("补齐缺少的K线失败:", err)
+		}
+		if len(missed) > 0 {
+			log.Printf("补齐 %d 根K线", len(missed))
+		}
+		for _, k := range missed {
+			candles <- k
+		}
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(klineStreamReadTimeout))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		k, closed, err := parseKlineEvent(message)
+		if err != nil {
+			log.Println("解析K线事件失败:", err)
+			continue
+		}
+		if closed {
+			candles <- k
+		}
+	}
+}
+
+// K线流推送的事件，字段名见 Binance 文档 <symbol>@kline_<interval>
+// 大小写不同的字段（e/E、t/T、l/L、v/V、q/Q）是不同的含义：encoding/json 没有精确匹配的字段时
+// 会按不区分大小写匹配，所以 E 和 L 即使用不到也要声明，否则会覆盖 e 和 l
+type klineEvent struct {
+	EventType string `json:"e"`
+	EventTime int64  `json:"E"`
+	Kline     struct {
+		OpenTime    int64  `json:"t"`
+		CloseTime   int64  `json:"T"`
+		Interval    string `json:"i"`
+		Open        string `json:"o"`
+		Close       string `json:"c"`
+		High        string `json:"h"`
+		Low         string `json:"l"`
+		LastTradeID int64  `json:"L"`
+		Volume      string `json:"v"`
+		Trades      int64  `json:"n"`
+		Closed      bool   `json:"x"`
+		QuoteVolume string `json:"q"`
+		TakerBase   string `json:"V"`
+		TakerQuote  string `json:"Q"`
+	} `json:"k"`
+}
+
+// 解析一条K线流消息，closed 为 false 表示这根K线还没收盘（每秒推送的中间状态）
+func parseKlineEvent(message []byte) (StreamKline, bool, error) {
+	var event klineEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		return StreamKline{}, false, err
+	}
+	if event.EventType != "kline" {
+		return StreamKline{}, false, fmt.Errorf("不是K线事件: %s", message)
+	}
+	e := event.Kline
+	if e.Interval != "1m" {
+		return StreamKline{}, false, fmt.Errorf("K线周期是 %s，不是 1m", e.Interval)
+	}
+	k := StreamKline{
+		OpenTime:  e.OpenTime,
+		CloseTime: e.CloseTime,
+		Values:    [5]string{e.Open, e.High, e.Low, e.Close, e.Volume},
+		Extra:     [4]string{e.QuoteVolume, strconv.FormatInt(e.Trades, 10), e.TakerBase, e.TakerQuote},
+	}
+	if err := k.Validate(); err != nil {
+		return StreamKline{}, false, err
+	}
+	return k, e.Closed, nil
+}
+
+// 用 REST 接口拉取 startTime 之后所有已收盘的1分钟K线，每次最多 klinesPageLimit 根，直到追上当前时间
+func fetchClosedKlinesSince(market Market, symbol string, startTime int64) ([]StreamKline, error) {
+	var result []StreamKline
+	for {
+		url := fmt.Sprintf("%s%s?symbol=%s&interval=1m&startTime=%d&limit=%d",
+			market.BaseURL(), market.KlinesPath(), symbol, startTime, klinesPageLimit)
+		resp, err := http.Get(url)
+		if err != nil {
+			return result, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return result, err
+		}
+
+		page, err := parseRESTKlines(body)
+		if err != nil {
+			return result, err
+		}
+		now := time.Now().UnixMilli()
+		for _, k := range page {
+			if k.CloseTime < now {
+				result = append(result, k)
+			}
+		}
+		if len(page) < klinesPageLimit {
+			return result, nil
+		}
+		startTime = page[len(page)-1].OpenTime + 1
+	}
+}
+
+// 解析 /api/v3/klines 的返回: [[openTime, "open", "high", "low", "close", "volume", closeTime,
+// "quoteVolume", trades, "takerBase", "takerQuote", "ignore"], ...]
+func parseRESTKlines(body []byte) ([]StreamKline, error) {
+	var rows [][]json.RawMessage
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, fmt.Errorf("解析K线失败: %v, 原始数据: %s", err, body)
+	}
+
+	klines := make([]StreamKline, 0, len(rows))
+	for i, row := range rows {
+		if len(row) < 11 {
+			return nil, fmt.Errorf("第 %d 根K线只有 %d 个元素，需要至少 11 个", i+1, len(row))
+		}
+		var k StreamKline
+		var trades int64
+		targets := []interface{}{&k.OpenTime, &k.Values[0], &k.Values[1], &k.Values[2], &k.Values[3], &k.Values[4],
+			&k.CloseTime, &k.Extra[0], &trades, &k.Extra[2], &k.Extra[3]}
+		for j, target := range targets {
+			if err := json.Unmarshal(row[j], target); err != nil {
+				return nil, fmt.Errorf("第 %d 根K线第 %d 个元素格式错误: %v", i+1, j+1, err)
+			}
+		}
+		k.Extra[1] = strconv.FormatInt(trades, 10)
+		if err := k.Validate(); err != nil {
+			return nil, fmt.Errorf("第 %d 根K线: %v", i+1, err)
+		}
+		klines = append(klines, k)
+	}
+	return klines, nil
+}
+
+// 行情市场，决定请求的域名和接口路径
+type Market int
+
+const (
+	Spot Market = iota
+	USDMFutures
+)
+
+func parseMarket(name string) (Market, error) {
+	switch name {
+	case "spot":
+		return Spot, nil
+	case "usdm":
+		return USDMFutures, nil
+	}
+	return Spot, fmt.Errorf("未知的市场: %s（可选 spot 或 usdm）", name)
+}
+
+// 各市场的 REST 接口地址，测试中指向 httptest 服务器
+var marketBaseURLs = map[Market]string{
+	Spot:        "https://api.binance.com",
+	USDMFutures: "https://fapi.binance.com",
+}
+
+func (m Market) BaseURL() string {
+	return marketBaseURLs[m]
+}
+
+// 两个市场的K线返回格式相同，只有路径不同
+func (m Market) KlinesPath() string {
+	if m == USDMFutures {
+		return "/fapi/v1/klines"
+	}
+	return "/api/v3/klines"
+}
+
+// 交易规则接口，返回该市场全部交易对
+func (m Market) ExchangeInfoPath() string {
+	if m == USDMFutures {
+		return "/fapi/v1/exchangeInfo"
+	}
+	return "/api/v3/exchangeInfo"
+}
+
+// 用 exchangeInfo 检查交易对是否存在；请求失败（网络、限频等）时返回错误，由调用方决定是否继续
+func checkSymbol(market Market, symbol string) (bool, error) {
+	resp, err := http.Get(market.BaseURL() + market.ExchangeInfoPath())
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	var info struct {
+		Symbols []struct {
+			Symbol string `json:"symbol"`
+		} `json:"symbols"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil || len(info.Symbols) == 0 {
+		return false, fmt.Errorf("无法解析 exchangeInfo（HTTP %d）", resp.StatusCode)
+	}
+	for _, s := range info.Symbols {
+		if s.Symbol == symbol {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 1分钟K线流的地址，流名称中的交易对必须小写
+func (m Market) StreamURL(symbol string) string {
+	base := "wss://stream.binance.com:9443/ws/"
+	if m == USDMFutures {
+		base = "wss://fstream.binance.com/ws/"
+	}
+	return base + strings.ToLower(symbol) + "@kline_1m"
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
+
+// K线文件的交易对 = 基础币 + 计价币，计价币（交易对后缀）由 -symbol-suffix 指定
+// 文件名为 <交易对>_minute_klines.csv、<交易对>_latest_14days.csv，与下载脚本一致
+const baseAsset = "ETH"
+
+var symbolSuffix = "USDT"
+
+// 解析 -symbol-suffix，只允许字母和数字（如 USDT、USDC、FDUSD）
+func setSymbolSuffix(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return fmt.Errorf("计价币不能为空")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("无效的计价币: %q（只能包含字母和数字，如 USDT、USDC）", value)
+		}
+	}
+	symbolSuffix = value
+	return nil
+}
+
+// 拼接交易对，如 ETH + USDC → ETHUSDC
+func composeSymbol(base, quote string) string {
+	return strings.ToUpper(strings.TrimSpace(base)) + strings.ToUpper(strings.TrimSpace(quote))
+}
+
+// 按 -symbol-suffix 得到的K线文件名，kind 为 minute_klines 或 latest_14days
+func klinesFileName(kind string) string {
+	return composeSymbol(baseAsset, symbolSuffix) + "_" + kind + ".csv"
+}
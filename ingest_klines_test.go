@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 模拟K线接口：从 first 开始共 total 根已收盘的1分钟K线，按 startTime 和 limit 分页返回，记录每次请求的路径和 startTime
+type klinesServer struct {
+	first int64
+	total int
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *klinesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	startTime, _ := strconv.ParseInt(query.Get("startTime"), 10, 64)
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.Path+"?startTime="+query.Get("startTime"))
+	s.mu.Unlock()
+
+	var rows []string
+	for i := 0; i < s.total && len(rows) < limit; i++ {
+		openTime := s.first + int64(i)*60000
+		if openTime < startTime {
+			continue
+		}
+		rows = append(rows, fmt.Sprintf(`[%d,"2000.0","2001.0","1999.0","2000.5","1.5",%d,"3000.75",12,"0.7","1400.35","0"]`,
+			openTime, openTime+59999))
+	}
+	fmt.Fprint(w, "["+strings.Join(rows, ",")+"]")
+}
+
+// 把 market 的接口地址指向模拟服务器，测试结束后恢复
+func startKlinesServer(t *testing.T, market Market, total int) *klinesServer {
+	t.Helper()
+	handler := &klinesServer{first: 1767225600000, total: total}
+	server := httptest.NewServer(handler)
+	oldURL := marketBaseURLs[market]
+	marketBaseURLs[market] = server.URL
+	t.Cleanup(func() {
+		server.Close()
+		marketBaseURLs[market] = oldURL
+	})
+	return handler
+}
+
+// 合约市场请求 /fapi/v1/klines，分页方式与现货相同：每页 klinesPageLimit 根，下一页从最后一根之后开始
+func TestFetchClosedKlinesSinceFutures(t *testing.T) {
+	for _, tc := range []struct {
+		market Market
+		path   string
+	}{
+		{USDMFutures, "/fapi/v1/klines"},
+		{Spot, "/api/v3/klines"},
+	} {
+		server := startKlinesServer(t, tc.market, 2*klinesPageLimit+5)
+		klines, err := fetchClosedKlinesSince(tc.market, "ETHUSDT", server.first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(klines) != 2*klinesPageLimit+5 {
+			t.Fatalf("%s: 拉取了 %d 根K线, want %d", tc.path, len(klines), 2*klinesPageLimit+5)
+		}
+		for i, k := range klines {
+			if want := server.first + int64(i)*60000; k.OpenTime != want {
+				t.Fatalf("%s: 第 %d 根开盘时间 %d, want %d", tc.path, i, k.OpenTime, want)
+			}
+		}
+
+		var want []string
+		for page := 0; page < 3; page++ {
+			startTime := server.first
+			if page > 0 {
+				startTime += int64(page*klinesPageLimit-1)*60000 + 1
+			}
+			want = append(want, fmt.Sprintf("%s?startTime=%d", tc.path, startTime))
+		}
+		if got := strings.Join(server.requests, " "); got != strings.Join(want, " ") {
+			t.Errorf("请求 = %s\nwant %s", got, strings.Join(want, " "))
+		}
+	}
+}
+
+// 一条已收盘的1分钟K线流消息
+func klineMessage(openTime int64, close string) []byte {
+	return []byte(fmt.Sprintf(`{"e":"kline","E":%d,"k":{"t":%d,"T":%d,"i":"1m","o":"2000","c":"%s","h":"2010","l":"1990","v":"1.5","n":3,"x":true,"q":"3000","V":"1","Q":"2000"}}`,
+		openTime+60000, openTime, openTime+59999, close))
+}
+
+// 接着已有文件（最后一行没有换行）追加流中的K线：与最后一根开盘时间相同的重复K线和更早的乱序K线被丢弃，
+// Flush 只写入新的K线；重新打开时从文件中最后一根继续去重
+func TestKlineAppenderDedup(t *testing.T) {
+	const t0 = int64(1767225600000)
+	path := filepath.Join(t.TempDir(), "ETHUSDT_minute_klines.csv")
+	existing := strings.Join(klineCSVHeader, ",") + "\n" +
+		strings.Join(StreamKline{OpenTime: t0, CloseTime: t0 + 59999, Values: [5]string{"2000", "2010", "1990", "2005", "1"}, Extra: [4]string{"0", "0", "0", "0"}}.Record(), ",")
+	if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	appender, err := newKlineAppender(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appender.LastOpenTime() != t0 {
+		t.Fatalf("LastOpenTime = %d, want %d", appender.LastOpenTime(), t0)
+	}
+
+	for _, tc := range []struct {
+		openTime int64
+		close    string
+		want     AppendResult
+	}{
+		{t0, "2005", AppendDuplicate},
+		{t0 + 60000, "2006", AppendAccepted},
+		{t0 + 60000, "2099", AppendDuplicate},
+		{t0 - 60000, "1999", AppendOutOfOrder},
+		{t0 + 120000, "2007", AppendAccepted},
+		{t0 + 60000, "2098", AppendOutOfOrder},
+	} {
+		k, closed, err := parseKlineEvent(klineMessage(tc.openTime, tc.close))
+		if err != nil || !closed {
+			t.Fatalf("parseKlineEvent: closed = %v, err = %v", closed, err)
+		}
+		if got := appender.Add(k); got != tc.want {
+			t.Errorf("Add(%d) = %v, want %v", tc.openTime, got, tc.want)
+		}
+	}
+
+	if n, err := appender.Flush(); n != 2 || err != nil {
+		t.Fatalf("Flush() = %d, %v, want 2 条", n, err)
+	}
+	if n, err := appender.Flush(); n != 0 || err != nil {
+		t.Errorf("缓冲已清空，再次 Flush() = %d, %v", n, err)
+	}
+
+	klines, rowErrors, err := loadKlines(path, true)
+	if err != nil || len(rowErrors) != 0 {
+		t.Fatalf("读取追加后的文件: err = %v, rowErrors = %v", err, rowErrors)
+	}
+	var got []string
+	for _, k := range klines {
+		got = append(got, fmt.Sprintf("%d:%g", k.OpenTime, k.Close))
+	}
+	want := []string{fmt.Sprintf("%d:2005", t0), fmt.Sprintf("%d:2006", t0+60000), fmt.Sprintf("%d:2007", t0+120000)}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("文件中的K线 = %v, want %v", got, want)
+	}
+
+	reopened, err := newKlineAppender(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, _ := parseKlineEvent(klineMessage(t0+120000, "2007"))
+	if got := reopened.Add(k); got != AppendDuplicate {
+		t.Errorf("重新打开后追加最后一根: %v, want AppendDuplicate", got)
+	}
+}
+
+// 非 USDT 计价的交易对用对应市场的 exchangeInfo 校验：存在的返回 true，不存在的返回 false，
+// 接口返回无法解析的内容时返回错误，由调用方决定是否继续
+func TestCheckSymbolNonUSDTQuote(t *testing.T) {
+	for _, tc := range []struct {
+		market Market
+		path   string
+	}{
+		{Spot, "/api/v3/exchangeInfo"},
+		{USDMFutures, "/fapi/v1/exchangeInfo"},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != tc.path {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT"},{"symbol":"ETHUSDC"}]}`)
+		}))
+		oldURL := marketBaseURLs[tc.market]
+		marketBaseURLs[tc.market] = server.URL
+
+		for symbol, want := range map[string]bool{composeSymbol("eth", "usdc"): true, composeSymbol("ETH", "BUSD"): false} {
+			if ok, err := checkSymbol(tc.market, symbol); err != nil || ok != want {
+				t.Errorf("%s: checkSymbol(%s) = %v, %v, want %v", tc.path, symbol, ok, err, want)
+			}
+		}
+		server.Close()
+
+		broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+			fmt.Fprint(w, "<html></html>")
+		}))
+		marketBaseURLs[tc.market] = broken.URL
+		if _, err := checkSymbol(tc.market, "ETHUSDC"); err == nil {
+			t.Errorf("%s: 无法解析 exchangeInfo 时应返回错误", tc.path)
+		}
+		broken.Close()
+		marketBaseURLs[tc.market] = oldURL
+	}
+}
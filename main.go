@@ -1,34 +1,208 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// 配置 lumberjack 日志滚动
-func setupLogger() {
-	log.SetOutput(&lumberjack.Logger{
-		Filename:   "binance.log",
-		MaxSize:    100,   // 每个日志文件最大 10MB
-		MaxBackups: 10000, //
-		MaxAge:     30,    // 最多保留30天
-		Compress:   true,
-	})
+// 输出文件目录，由 -output-dir 指定
+var outputDir string
+
+// 输出文件路径（相对 -output-dir）
+func outputPath(name string) string {
+	return filepath.Join(outputDir, name)
+}
+
+// 日志滚动配置，对应 lumberjack.Logger 的同名字段
+type LoggerConfig struct {
+	Tee        bool // 同时输出到终端
+	MaxSize    int  // 单个日志文件的大小上限（MB），超过后滚动
+	MaxBackups int  // 最多保留的滚动备份数
+	Compress   bool // 滚动后的备份用 gzip 压缩；压缩后无法直接 tail，调试时可以关闭
+}
+
+// 日志滚动相关的命令行参数，主程序和 userstream 子命令共用
+type logFlags struct {
+	maxSize    *int
+	maxBackups *int
+	noCompress *bool
+}
+
+func registerLogFlags(fs *flag.FlagSet) logFlags {
+	return logFlags{
+		maxSize:    fs.Int("log-max-size", 100, "单个日志文件的大小上限（MB），超过后滚动"),
+		maxBackups: fs.Int("log-max-backups", 10000, "最多保留的滚动备份数（另外最多保留30天），0 表示不限"),
+		noCompress: fs.Bool("no-compress", false, "滚动后的备份不压缩，方便直接查看或 tail"),
+	}
+}
+
+// 解析完参数后生成日志配置
+func (f logFlags) Config(tee bool) (LoggerConfig, error) {
+	if *f.maxSize < 1 {
+		return LoggerConfig{}, fmt.Errorf("-log-max-size 必须大于 0: %d", *f.maxSize)
+	}
+	if *f.maxBackups < 0 {
+		return LoggerConfig{}, fmt.Errorf("-log-max-backups 不能为负数: %d", *f.maxBackups)
+	}
+	return LoggerConfig{Tee: tee, MaxSize: *f.maxSize, MaxBackups: *f.maxBackups, Compress: !*f.noCompress}, nil
+}
+
+// 按配置创建滚动日志文件（name 相对 -output-dir），不压缩时备份保留为 <name>-<时间>.<扩展名>
+func newLogFile(cfg LoggerConfig, name string) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   outputPath(name),
+		MaxSize:    cfg.MaxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     30, // 最多保留30天
+		Compress:   cfg.Compress,
+	}
+}
+
+// 配置 lumberjack 日志滚动，cfg.Tee 为 true 时同时输出到终端
+func setupLogger(cfg LoggerConfig) {
+	var stdout io.Writer
+	if cfg.Tee {
+		stdout = os.Stdout
+	}
+	log.SetOutput(logWriter(newLogFile(cfg, "binance.log"), stdout))
 	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
 }
 
+// 按 (币种, 期权类型) 分文件输出产品，每个文件各自滚动，例如 BTC_CALL.jsonl
+// 文件在第一次写入时创建，滚动配置与 binance.log 相同
+type PairWriters struct {
+	mu      sync.Mutex
+	cfg     LoggerConfig
+	writers map[string]*lumberjack.Logger
+}
+
+func newPairWriters(cfg LoggerConfig) *PairWriters {
+	return &PairWriters{cfg: cfg, writers: make(map[string]*lumberjack.Logger)}
+}
+
+func pairFileName(coin, optionType string) string {
+	return coin + "_" + optionType + ".jsonl"
+}
+
+// 返回该交易对的输出文件
+func (w *PairWriters) Writer(coin, optionType string) io.Writer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	name := pairFileName(coin, optionType)
+	if _, ok := w.writers[name]; !ok {
+		w.writers[name] = newLogFile(w.cfg, name)
+	}
+	return w.writers[name]
+}
+
+// 把一页响应中的产品逐个写入对应交易对的文件，每行一个产品，保持接口返回的原始 JSON
+func (w *PairWriters) WritePage(coin, optionType, rawData string) error {
+	var page struct {
+		List []json.RawMessage `json:"list"`
+	}
+	if err := json.Unmarshal([]byte(rawData), &page); err != nil {
+		return err
+	}
+	if len(page.List) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, product := range page.List {
+		buf.Write(product)
+		buf.WriteByte('\n')
+	}
+	// 一页一次写入，避免滚动把同一页拆到两个文件
+	_, err := w.Writer(coin, optionType).Write(buf.Bytes())
+	return err
+}
+
+// 不为 nil 时抓取到的产品除了写入 binance.log，还按交易对写入单独的文件（-split-output）
+var pairOutputs *PairWriters
+
+// 每页上一轮抓取到的内容的哈希（-skip-unchanged）：产品列表通常几分钟才变一次，
+// 每 5 秒把相同的内容再写一遍只会让日志膨胀，内容没变的页不再写入 binance.log 和 -split-output 的文件。
+// 被看门狗放弃的上一轮可能还在和本轮同时运行，所有方法都可以并发调用
+type PageCache struct {
+	mu     sync.Mutex
+	hashes map[string]uint64
+	hits   int // 内容与上一轮相同、跳过写入的页数
+	misses int
+}
+
+func newPageCache() *PageCache {
+	return &PageCache{hashes: make(map[string]uint64)}
+}
+
+// 缓存的键：同一个 (稳定币, 币种, 期权类型, 页码) 与上一轮比较
+func pageCacheKey(stableCoin, coin, optionType string, page int) string {
+	return fmt.Sprintf("%s/%s/%s/%d", stableCoin, coin, optionType, page)
+}
+
+// 记录该页本轮的内容，返回是否与上一轮相同
+func (c *PageCache) Unchanged(key, rawData string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(rawData))
+	sum := h.Sum64()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.hashes[key]; ok && prev == sum {
+		c.hits++
+		return true
+	}
+	c.hashes[key] = sum
+	c.misses++
+	return false
+}
+
+// 累计的命中（内容未变化）和未命中次数
+func (c *PageCache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// 不为 nil 时跳过内容与上一轮相同的页（-skip-unchanged）
+var pageCache *PageCache
+
+// 日志写入 file，stdout 不为 nil 时同时写一份到 stdout
+// 滚动仍由 file（lumberjack）自己完成，MultiWriter 只是把同一份内容写两次
+func logWriter(file, stdout io.Writer) io.Writer {
+	if stdout == nil {
+		return file
+	}
+	return io.MultiWriter(file, stdout)
+}
+
 // 定义响应数据结构
 type Product struct {
 	ID                   string   `json:"id"`
@@ -50,164 +224,2104 @@ type Product struct {
 	AutoCompoundPlanList []string `json:"autoCompoundPlanList"`
 }
 
+// 数值字段在接口中是字符串，以下方法解析为 float64 方便计算和比较
+// 注意 float64 只有约15位有效数字：用于统计、排序没有问题，
+// 但下单金额等需要精确到最小单位的场景应直接使用原始字符串（或十进制库），避免舍入误差
+func (p Product) APRFloat() (float64, error) {
+	return strconv.ParseFloat(p.APR, 64)
+}
+
+func (p Product) StrikePriceFloat() (float64, error) {
+	return strconv.ParseFloat(p.StrikePrice, 64)
+}
+
+func (p Product) MinAmountFloat() (float64, error) {
+	return strconv.ParseFloat(p.MinAmount, 64)
+}
+
+func (p Product) MaxAmountFloat() (float64, error) {
+	return strconv.ParseFloat(p.MaxAmount, 64)
+}
+
 type Response struct {
 	Total int       `json:"total"`
 	List  []Product `json:"list"`
 }
 
-// 签名生成
-func getSignedQueryString(params map[string]string, secretKey string) string {
+// Binance 返回的错误，例如 {"code":-1022,"msg":"Signature for this request is not valid."}
+type APIError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("binance 返回错误 %d: %s", e.Code, e.Msg)
+}
+
+// 如果返回内容是 Binance 的错误格式，解析为 *APIError，否则返回 nil
+func parseAPIError(body []byte) error {
+	var apiErr APIError
+	if err := json.Unmarshal(body, &apiErr); err != nil || apiErr.Code == 0 {
+		return nil
+	}
+	return &apiErr
+}
+
+// 签名生成；Ed25519 的签名是 base64，含有 + / =，需要URL编码
+func getSignedQueryString(params map[string]string, signer Signer) string {
 	values := url.Values{}
 	for k, v := range params {
 		values.Set(k, v)
 	}
 
 	queryString := values.Encode()
+	return queryString + "&signature=" + url.QueryEscape(signer.Sign(queryString))
+}
+
+// 请求签名方式，由环境变量 BINANCE_KEY_TYPE 选择
+type Signer interface {
+	// 对查询字符串签名，返回 signature 参数的值（未做URL编码）
+	Sign(payload string) string
+}
+
+// HMAC SHA256 签名，对应 Binance 生成的 API Key + Secret Key
+type HMACSigner struct {
+	secret string
+}
+
+func (s HMACSigner) Sign(payload string) string {
+	return hmacSignature(payload, s.secret)
+}
+
+// Ed25519 签名（base64），对应上传自己的公钥生成的 API Key
+type Ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s Ed25519Signer) Sign(payload string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, []byte(payload)))
+}
+
+// 解析 PKCS#8 PEM 格式的 Ed25519 私钥（Binance 密钥生成工具输出的格式）
+func parseEd25519PrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("不是 PEM 格式的私钥")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析私钥失败: %v", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("私钥类型是 %T，不是 Ed25519", key)
+	}
+	return edKey, nil
+}
+
+// 从环境变量读取 API Key 和签名方式：
+// BINANCE_KEY_TYPE 为 hmac（默认）时用 BINANCE_SECRET_KEY，
+// 为 ed25519 时用 BINANCE_PRIVATE_KEY_FILE 指向的 PEM 私钥文件
+func loadCredentials() (string, Signer, error) {
+	key := os.Getenv("BINANCE_API_KEY")
+	if key == "" {
+		return "", nil, errors.New("请设置环境变量 BINANCE_API_KEY")
+	}
+	switch keyType := strings.ToLower(os.Getenv("BINANCE_KEY_TYPE")); keyType {
+	case "", "hmac":
+		secret := os.Getenv("BINANCE_SECRET_KEY")
+		if secret == "" {
+			return "", nil, errors.New("请设置环境变量 BINANCE_SECRET_KEY")
+		}
+		return key, HMACSigner{secret: secret}, nil
+	case "ed25519":
+		path := os.Getenv("BINANCE_PRIVATE_KEY_FILE")
+		if path == "" {
+			return "", nil, errors.New("BINANCE_KEY_TYPE=ed25519 时请设置环境变量 BINANCE_PRIVATE_KEY_FILE（PEM 私钥文件）")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", nil, fmt.Errorf("读取私钥文件失败: %v", err)
+		}
+		edKey, err := parseEd25519PrivateKey(data)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return key, Ed25519Signer{key: edKey}, nil
+	default:
+		return "", nil, fmt.Errorf("未知的 BINANCE_KEY_TYPE: %s（可选 hmac 或 ed25519）", keyType)
+	}
+}
+
+// 查询字符串的 HMAC SHA256 签名（十六进制）
+func hmacSignature(queryString, secretKey string) string {
 	mac := hmac.New(sha256.New, []byte(secretKey))
 	mac.Write([]byte(queryString))
-	signature := hex.EncodeToString(mac.Sum(nil))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// REST 接口地址，测试中指向 httptest 服务器
+var apiBaseURL = "https://api.binance.com"
 
-	return queryString + "&signature=" + signature
+// 签名请求的 recvWindow（毫秒）：服务器收到请求时若已超过 timestamp + recvWindow 就拒绝（-1021）。
+// Binance 规定最大 60000，默认 5000；网络延迟高时可用 -recv-window 调大
+const (
+	defaultRecvWindow = 5000
+	maxRecvWindow     = 60000
+)
+
+// 签名请求使用的 recvWindow，由 -recv-window 设置
+var recvWindow = defaultRecvWindow
+
+// 个别接口单独设置的 recvWindow（路径 -> 毫秒），由 -recv-window-endpoint 设置
+var endpointRecvWindows = map[string]int{}
+
+// 接口的 recvWindow，没有单独设置的接口使用 recvWindow
+func endpointRecvWindow(path string) int {
+	if window, ok := endpointRecvWindows[path]; ok {
+		return window
+	}
+	return recvWindow
 }
 
-// 请求一页数据，返回原始字符串
-func fetchPageRaw(apiKey, secretKey, optionType, coin string, pageIndex int) (string, error) {
-	endpoint := "https://api.binance.com/sapi/v1/dci/product/list"
-
-	// 按题意，optionType 是 PUT 或 CALL
-	// exercisedCoin 和 investCoin 规则（根据你之前说的）
-	// CALL: exercisedCoin=USDT, investCoin=coin
-	// PUT:  exercisedCoin=coin, investCoin=USDT
-	var exercisedCoin, investCoin string
-	if optionType == "CALL" {
-		exercisedCoin = "USDT"
-		investCoin = coin
-	} else {
-		exercisedCoin = coin
-		investCoin = "USDT"
+func validateRecvWindow(window int) error {
+	if window < 1 || window > maxRecvWindow {
+		return fmt.Errorf("recvWindow 必须在 1 到 %d 毫秒之间: %d", maxRecvWindow, window)
 	}
+	return nil
+}
 
-	params := map[string]string{
-		"optionType":    optionType,
-		"exercisedCoin": exercisedCoin,
-		"investCoin":    investCoin,
-		"pageSize":      "100",
-		"pageIndex":     strconv.Itoa(pageIndex),
-		"recvWindow":    "5000",
-		"timestamp":     strconv.FormatInt(time.Now().UnixMilli(), 10),
+// recvWindow 相关的命令行参数，所有发签名请求的命令共用
+type recvWindowFlags struct {
+	window    *int
+	endpoints *string
+}
+
+func registerRecvWindowFlags(fs *flag.FlagSet) recvWindowFlags {
+	return recvWindowFlags{
+		window:    fs.Int("recv-window", defaultRecvWindow, "签名请求的 recvWindow（毫秒），网络延迟高、经常返回 -1021 时调大；最大 60000"),
+		endpoints: fs.String("recv-window-endpoint", "", "个别接口单独的 recvWindow（逗号分隔的 路径=毫秒，如 /sapi/v1/dci/product/list=10000），未列出的接口使用 -recv-window"),
 	}
+}
 
-	query := getSignedQueryString(params, secretKey)
-	req, err := http.NewRequest("GET", endpoint+"?"+query, nil)
-	if err != nil {
-		return "", err
+// 解析完参数后检查范围并设置 recvWindow 和 endpointRecvWindows
+func (f recvWindowFlags) Apply() error {
+	if err := validateRecvWindow(*f.window); err != nil {
+		return fmt.Errorf("-recv-window: %v", err)
 	}
-	req.Header.Set("X-MBX-APIKEY", apiKey)
+	endpoints := map[string]int{}
+	if *f.endpoints != "" {
+		for _, field := range strings.Split(*f.endpoints, ",") {
+			path, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+			window, err := strconv.Atoi(value)
+			if !ok || !strings.HasPrefix(path, "/") || err != nil {
+				return fmt.Errorf("无效的 -recv-window-endpoint: %q（需要 路径=毫秒）", field)
+			}
+			if err := validateRecvWindow(window); err != nil {
+				return fmt.Errorf("-recv-window-endpoint %s: %v", path, err)
+			}
+			endpoints[path] = window
+		}
+	}
+	recvWindow = *f.window
+	endpointRecvWindows = endpoints
+	return nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+// 每分钟请求权重的统计和预算：Binance 按 IP 统计每分钟的权重，超过上限会返回 429 甚至封禁 IP。
+// /api 和 /sapi 的权重分开统计，各用一个 WeightTracker。发请求前按已知权重预扣，
+// 预算不够时阻塞到下一分钟；收到响应后用响应头里服务端统计的值校准（同一 IP 上其他程序的请求也会计入）
+// 并发抓取时所有协程共用同一个 WeightTracker，状态都由 mu 保护，所有方法都可以并发调用
+type WeightTracker struct {
+	mu          sync.Mutex
+	header      string // 服务端返回已用权重的响应头
+	limit       int    // 官方的每分钟上限
+	budget      int    // 允许使用的权重，由 -weight-budget-pct 决定
+	used        int
+	windowStart time.Time // 当前统计周期（整分钟）的起点
+}
+
+func newWeightTracker(header string, limit int) *WeightTracker {
+	return &WeightTracker{header: header, limit: limit, budget: limit}
+}
+
+// 按官方上限的百分比设置预算
+func (t *WeightTracker) SetBudgetPct(pct int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.budget = t.limit * pct / 100
+}
+
+// 权重按整分钟重置，进入新的一分钟时清零
+func (t *WeightTracker) roll(now time.Time) {
+	if window := now.Truncate(time.Minute); window.After(t.windowStart) {
+		t.windowStart = window
+		t.used = 0
 	}
-	defer resp.Body.Close()
+}
 
-	body, err := io.ReadAll(resp.Body)
+// 预扣 weight，本分钟剩余预算不够时等到下一分钟；等待期间 ctx 取消（如收到退出信号）时返回 ctx.Err()，不预扣
+// 单个请求的权重超过整个预算时在新的一分钟直接放行，否则会永远等待
+func (t *WeightTracker) Acquire(ctx context.Context, weight int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for {
+		now := time.Now()
+		t.roll(now)
+		if t.used+weight <= t.budget || t.used == 0 {
+			t.used += weight
+			return nil
+		}
+		wait := t.windowStart.Add(time.Minute).Sub(now)
+		log.Printf("请求权重 %d/%d 已用完，等待 %.1f 秒\n", t.used, t.budget, wait.Seconds())
+		t.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			t.mu.Lock()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		t.mu.Lock()
+	}
+}
+
+// 用响应头中服务端统计的已用权重校准
+func (t *WeightTracker) Update(header http.Header) {
+	used, err := strconv.Atoi(header.Get(t.header))
 	if err != nil {
-		return "", err
+		return
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(time.Now())
+	t.used = used
+}
 
-	return string(body), nil
+// 各接口的请求权重（见 Binance 接口文档）
+var endpointWeights = map[string]int{
+	"/sapi/v1/dci/product/list": 1,
+	"/api/v3/ticker/price":      2,
+	"/api/v3/exchangeInfo":      20,
+	"/api/v3/userDataStream":    2,
+}
+
+// 接口权重，未登记的接口按 1 计
+func endpointWeight(path string) int {
+	if weight, ok := endpointWeights[path]; ok {
+		return weight
+	}
+	return 1
+}
+
+// /api 和 /sapi 的权重统计，上限分别为每分钟 6000 和 12000
+var (
+	apiWeights  = newWeightTracker("X-MBX-USED-WEIGHT-1M", 6000)
+	sapiWeights = newWeightTracker("X-SAPI-USED-IP-WEIGHT-1M", 12000)
+)
+
+// 熔断器状态
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常请求
+	breakerOpen                         // 连续失败过多，冷却期内直接拒绝请求
+	breakerHalfOpen                     // 冷却期已过，放行一个探测请求
+)
+
+// 熔断器打开期间请求被直接拒绝时返回的错误
+type CircuitOpenError struct {
+	Until time.Time // 冷却结束、可以再次探测的时间
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("熔断器打开，%s 前不再请求", e.Until.Format("15:04:05"))
+}
+
+// 整个抓取周期共用的熔断器：单个请求失败只影响自己，但交易所整体不可用时每个请求都会失败重试，
+// 既浪费时间又持续请求接口。连续失败 threshold 次后打开，冷却期内的请求直接返回 CircuitOpenError；
+// 冷却结束后半开，放行一个探测请求，成功则恢复，失败则重新打开。threshold<=0 表示不熔断
+// 网络错误和 5xx 计为失败；4xx（参数错误、签名错误等）说明接口可达，不计入
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int // 连续失败次数
+	state     breakerState
+	openedAt  time.Time
+	probing   bool // 半开状态下是否已有探测请求在进行
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
 }
 
-func fetchPrice(symbol string) (string, error) {
-	url := fmt.Sprintf("https://api.binance.com/api/v3/ticker/price?symbol=%s", symbol)
+// 设置阈值和冷却时间（由 -breaker-failures 和 -breaker-cooldown 决定）
+func (b *CircuitBreaker) Configure(threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = threshold
+	b.cooldown = cooldown
+}
+
+// 请求前调用，熔断器打开时返回 CircuitOpenError
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		until := b.openedAt.Add(b.cooldown)
+		if time.Now().Before(until) {
+			return &CircuitOpenError{Until: until}
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		log.Println("熔断器半开，发送探测请求")
+	case breakerHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{Until: time.Now()}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// 请求完成后调用，记录成功或失败
+func (b *CircuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		if b.state != breakerClosed {
+			log.Println("熔断器恢复，继续正常请求")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.threshold > 0 && (b.state == breakerHalfOpen || b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		log.Printf("circuit open: 连续 %d 次请求失败，熔断器打开，%v 内不再请求\n", b.failures, b.cooldown)
+	}
+}
+
+// 请求被调用方取消时调用，只结束半开状态下的探测，不影响失败计数
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// 经过熔断器发送请求，5xx 响应照常返回给调用方，但计为失败
+// reserve 不为 nil 时在熔断器放行之后、发送之前调用（预留请求权重），熔断器打开时被拒绝的请求不占用权重；
+// reserve 出错时不发送请求，也不计入失败
+func (b *CircuitBreaker) Do(req *http.Request, reserve func() error) (*http.Response, error) {
+	if err := b.Allow(); err != nil {
+		return nil, err
+	}
+	if reserve != nil {
+		if err := reserve(); err != nil {
+			b.Release()
+			return nil, err
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil && req.Context().Err() != nil {
+		// 调用方取消（如抓取超时）不代表交易所不可用，不计入失败
+		b.Release()
+		return nil, err
+	}
+	if err != nil {
+		b.Record(false)
+		return nil, err
+	}
+	b.Record(resp.StatusCode < 500)
+	return resp, nil
+}
+
+// 抓取周期中所有请求共用的熔断器
+var scrapeBreaker = newCircuitBreaker(5, time.Minute)
+
+// 不需要签名的公开接口（行情、交易对列表等）的 GET 请求，不带签名和 API Key 请求头，避免不必要地发送凭证
+// 与 signedGet 一样经过权重限制和熔断器，返回原始响应内容；带 code/msg 的 JSON 错误照常返回，由调用方处理
+func publicGet(ctx context.Context, path string, params map[string]string) ([]byte, error) {
+	target := apiBaseURL + path
+	if len(params) > 0 {
+		values := url.Values{}
+		for k, v := range params {
+			values.Set(k, v)
+		}
+		target += "?" + values.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := http.Get(url)
+	resp, err := scrapeBreaker.Do(req, func() error {
+		return apiWeights.Acquire(ctx, endpointWeight(path))
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
+	apiWeights.Update(resp.Header)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if err := checkResponseBody(resp.StatusCode, body); err != nil {
+		return nil, err
 	}
+	return body, nil
+}
 
-	return string(body), nil
+// 签名请求失败后最多尝试的次数（含第一次）和第一次重试前的等待时间，之后每次翻倍
+const (
+	signedRequestAttempts   = 3
+	signedRequestRetryDelay = 2 * time.Second
+)
+
+// 时间戳超出 recvWindow 的错误码，重试时重新生成时间戳即可
+const codeTimestampOutsideRecvWindow = -1021
+
+// 两次同步服务器时间之间至少间隔多久：并发的请求同时收到 -1021 时只同步一次
+const serverClockSyncInterval = 10 * time.Second
+
+// 缓存的本机与服务器的时间差，签名请求用它校正 timestamp，收到 -1021 时重新同步。
+// 多个抓取协程会同时读取和同步，所有方法都可以并发调用
+type ServerClock struct {
+	mu       sync.Mutex
+	skew     time.Duration // 本机时间减服务器时间，同步之前为 0
+	syncedAt time.Time
+	syncing  bool // 是否已有协程在请求服务器时间
+}
+
+// 按缓存的时间差校正后的当前时间
+func (c *ServerClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(-c.skew)
 }
 
-var apiKey, secretKey string
+// 当前缓存的时间差
+func (c *ServerClock) Skew() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skew
+}
 
-func runFullScrape() {
+// 请求 /api/v3/time 更新时间差；已有协程在同步或距上次同步不足 serverClockSyncInterval 时直接返回
+// 请求期间不持有锁，其他协程照常用旧的时间差签名
+func (c *ServerClock) Sync(ctx context.Context) error {
+	c.mu.Lock()
+	if c.syncing || time.Since(c.syncedAt) < serverClockSyncInterval {
+		c.mu.Unlock()
+		return nil
+	}
+	c.syncing = true
+	c.mu.Unlock()
 
-	coins := []string{"BTC", "ETH", "WBETH"}
-	optionTypes := []string{"PUT", "CALL"}
-	symbols := []string{"BTCUSDT", "ETHUSDT", "WBETHUSDT"}
+	skew, err := serverClockSkew(ctx)
 
-	for _, sym := range symbols {
-		rawData, err := fetchPrice(sym)
-		if err != nil {
-			log.Printf("获取 %s 价格失败: %v\n", sym, err)
-			continue
-		}
-		log.Printf("获取 %s 价格成功: %s\n", sym, rawData)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncing = false
+	if err != nil {
+		return err
 	}
+	c.skew = skew
+	c.syncedAt = time.Now()
+	return nil
+}
 
-	for _, coin := range coins {
-		for _, optionType := range optionTypes {
-			for page := 1; ; page++ {
-				rawData, err := fetchPageRaw(apiKey, secretKey, optionType, coin, page)
-				if err != nil {
-					fmt.Println("请求失败:", err)
-					break
-				}
+// 签名请求共用的服务器时间
+var serverClock = &ServerClock{}
 
-				if strings.Contains(rawData, "code") {
-					fmt.Println("请求失败，可能是参数错误或其他问题:", rawData)
-					break
-				}
+// 带签名的 GET 请求，path 为接口路径（如 /sapi/v1/dci/product/list）
+// 自动加上 timestamp 和 recvWindow、计算签名、设置 API Key 请求头，返回原始响应内容
+// 网络错误、非 JSON 响应和 -1021 会重试；每次重试都重新生成时间戳并签名，
+// 不能重放旧的签名 URL：等待之后旧时间戳可能已经超出 recvWindow，重试必然返回 -1021
+// 维护、熔断和其他接口错误不重试，直接返回给调用方
+func signedGet(ctx context.Context, apiKey string, signer Signer, path string, params map[string]string) ([]byte, error) {
+	return signedRequest(ctx, http.MethodGet, apiKey, signer, path, params)
+}
 
-				log.Println(rawData)
+// 带签名的 POST 请求（申购、下单等），参数和签名放在 application/x-www-form-urlencoded 请求体中
+// 这类请求不是幂等的：网络错误时不知道服务器是否已经处理，重试可能重复申购，所以只重试服务器明确拒绝的 -1021
+func signedPost(ctx context.Context, apiKey string, signer Signer, path string, params map[string]string) ([]byte, error) {
+	return signedRequest(ctx, http.MethodPost, apiKey, signer, path, params)
+}
 
-				// 假设返回的 JSON 数据中有一个字段表示是否还有下一页
-				if !strings.Contains(rawData, `id`) {
-					break
-				}
+// 带签名的 DELETE 请求（撤单等），参数和签名放在查询字符串中，重试规则与 GET 相同
+func signedDelete(ctx context.Context, apiKey string, signer Signer, path string, params map[string]string) ([]byte, error) {
+	return signedRequest(ctx, http.MethodDelete, apiKey, signer, path, params)
+}
 
+// 按 retryableSignedRequest 的规则重试的签名请求，每次重试都重新签名
+func signedRequest(ctx context.Context, method, apiKey string, signer Signer, path string, params map[string]string) ([]byte, error) {
+	delay := signedRequestRetryDelay
+	for attempt := 1; ; attempt++ {
+		body, err := signedRequestOnce(ctx, method, apiKey, signer, path, params)
+		if attempt == signedRequestAttempts || !retryableSignedRequest(method, body, err) {
+			return body, err
+		}
+		if err == nil {
+			err = parseAPIError(body)
+			// -1021 说明本机时间不准，同步服务器时间后再签名，否则重试大概率还是失败
+			if syncErr := serverClock.Sync(ctx); syncErr != nil {
+				log.Printf("同步服务器时间失败: %v\n", syncErr)
 			}
 		}
-	}
-
-	for _, sym := range symbols {
-		rawData, err := fetchPrice(sym)
-		if err != nil {
-			log.Printf("获取 %s 价格失败: %v\n", sym, err)
-			continue
+		log.Printf("%s %s 请求失败（第 %d 次）: %v，%v 后重新签名重试\n", method, path, attempt, err, delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
 		}
-		log.Printf("获取 %s 价格成功: %s\n", sym, rawData)
+		delay *= 2
 	}
 }
 
-func main() {
-	setupLogger()
-	apiKey = os.Getenv("BINANCE_API_KEY")
-	secretKey = os.Getenv("BINANCE_SECRET_KEY")
+// 判断一次签名请求的结果是否值得重试；POST 只重试 -1021
+func retryableSignedRequest(method string, body []byte, err error) bool {
+	if err == nil {
+		var apiErr *APIError
+		return errors.As(parseAPIError(body), &apiErr) && apiErr.Code == codeTimestampOutsideRecvWindow
+	}
+	if method == http.MethodPost {
+		return false
+	}
+	var maintenance *MaintenanceError
+	var circuitOpen *CircuitOpenError
+	if errors.As(err, &maintenance) || errors.As(err, &circuitOpen) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return true
+}
 
-	if apiKey == "" || secretKey == "" {
-		log.Println("请设置环境变量 BINANCE_API_KEY 和 BINANCE_SECRET_KEY")
-		return
+// 发送一次签名请求，每次调用都用校正后的当前时间生成 timestamp 并重新签名
+// POST 的参数和签名在请求体中，GET 和 DELETE 的在查询字符串中；两种方式签名的都是同一个编码后的参数串
+func signedRequestOnce(ctx context.Context, method, apiKey string, signer Signer, path string, params map[string]string) ([]byte, error) {
+	signed := make(map[string]string, len(params)+2)
+	for k, v := range params {
+		signed[k] = v
 	}
+	signed["recvWindow"] = strconv.Itoa(endpointRecvWindow(path))
+	signed["timestamp"] = strconv.FormatInt(serverClock.Now().UnixMilli(), 10)
 
-	var ticker *time.Ticker
-	//每5s抓取一次
-	ticker = time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			runFullScrape()
+	query := getSignedQueryString(signed, signer)
+	var req *http.Request
+	var err error
+	if method == http.MethodPost {
+		req, err = http.NewRequestWithContext(ctx, method, apiBaseURL+path, strings.NewReader(query))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, apiBaseURL+path+"?"+query, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-MBX-APIKEY", apiKey)
+
+	resp, err := scrapeBreaker.Do(req, func() error {
+		return sapiWeights.Acquire(ctx, endpointWeight(path))
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	sapiWeights.Update(resp.Header)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponseBody(resp.StatusCode, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// 交易所维护期间接口返回 503 和 HTML 页面，和参数错误、解析失败区分开，调用方应等待更长时间再重试
+type MaintenanceError struct {
+	StatusCode int
+}
+
+func (e *MaintenanceError) Error() string {
+	return fmt.Sprintf("交易所维护中（HTTP %d）", e.StatusCode)
+}
+
+// 维护后等待多久再重新抓取
+const maintenanceBackoff = 5 * time.Minute
+
+// 检查响应是否为 JSON：503 或返回 HTML 的 5xx 视为维护，其余非 JSON 响应返回普通错误
+// 带 code/msg 的 JSON 错误照常返回，由调用方处理
+func checkResponseBody(statusCode int, body []byte) error {
+	trimmed := bytes.TrimSpace(body)
+	isHTML := bytes.HasPrefix(trimmed, []byte("<"))
+	if statusCode == http.StatusServiceUnavailable || (statusCode >= 500 && isHTML) {
+		return &MaintenanceError{StatusCode: statusCode}
+	}
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		if len(trimmed) > 200 {
+			trimmed = trimmed[:200]
+		}
+		return fmt.Errorf("返回的不是 JSON（HTTP %d）: %s", statusCode, trimmed)
+	}
+	return nil
+}
+
+// 请求一页数据，返回原始字符串
+func fetchPageRaw(ctx context.Context, apiKey string, signer Signer, optionType, coin, stableCoin string, pageIndex int) (string, error) {
+	exercisedCoin, investCoin := dciCoins(optionType, coin, stableCoin)
+
+	params := map[string]string{
+		"optionType":    optionType,
+		"exercisedCoin": exercisedCoin,
+		"investCoin":    investCoin,
+		"pageSize":      "100",
+		"pageIndex":     strconv.Itoa(pageIndex),
+	}
+
+	body, err := signedGet(ctx, apiKey, signer, "/sapi/v1/dci/product/list", params)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// 按题意，optionType 是 PUT 或 CALL
+// exercisedCoin 和 investCoin 规则（根据你之前说的）
+// CALL: exercisedCoin=稳定币, investCoin=coin
+// PUT:  exercisedCoin=coin, investCoin=稳定币
+func dciCoins(optionType, coin, stableCoin string) (exercisedCoin, investCoin string) {
+	if optionType == "CALL" {
+		return stableCoin, coin
+	}
+	return coin, stableCoin
+}
+
+func fetchPrice(ctx context.Context, symbol string) (string, error) {
+	body, err := publicGet(ctx, "/api/v3/ticker/price", map[string]string{"symbol": symbol})
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// 交易所支持的交易对缓存，只在第一次成功请求 exchangeInfo 后填充
+var (
+	validSymbols   map[string]bool
+	validSymbolsMu sync.Mutex
+)
+
+type ExchangeInfo struct {
+	Symbols []struct {
+		Symbol string `json:"symbol"`
+		Status string `json:"status"`
+	} `json:"symbols"`
+}
+
+// 请求 /api/v3/exchangeInfo 获取所有处于交易状态的交易对，结果缓存
+func fetchExchangeInfo(ctx context.Context) (map[string]bool, error) {
+	validSymbolsMu.Lock()
+	defer validSymbolsMu.Unlock()
+	if validSymbols != nil {
+		return validSymbols, nil
+	}
+
+	body, err := publicGet(ctx, "/api/v3/exchangeInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseAPIError(body); err != nil {
+		return nil, err
+	}
+
+	var info ExchangeInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("解析 exchangeInfo 失败: %v", err)
+	}
+
+	symbols := make(map[string]bool, len(info.Symbols))
+	for _, s := range info.Symbols {
+		if s.Status == "TRADING" {
+			symbols[s.Symbol] = true
+		}
+	}
+	validSymbols = symbols
+	return validSymbols, nil
+}
+
+// 校验交易对是否存在，避免拼写错误（如 ETHUST）导致请求返回空结果
+func validateSymbol(ctx context.Context, symbol string) error {
+	symbols, err := fetchExchangeInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("无法获取交易对列表: %v", err)
+	}
+	if !symbols[symbol] {
+		return fmt.Errorf("无效的交易对: %s", symbol)
+	}
+	return nil
+}
+
+var (
+	apiKey string
+	signer Signer
+)
+
+// 抓取的币种和期权类型
+var (
+	coins       = []string{"BTC", "ETH", "WBETH"}
+	optionTypes = []string{"PUT", "CALL"}
+)
+
+// 解析 -product-type，返回要抓取的期权类型列表
+func parseProductType(value string) ([]string, error) {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "PUT":
+		return []string{"PUT"}, nil
+	case "CALL":
+		return []string{"CALL"}, nil
+	case "BOTH":
+		return []string{"PUT", "CALL"}, nil
+	}
+	return nil, fmt.Errorf("未知的期权类型: %s（可选 PUT、CALL 或 BOTH）", value)
+}
+
+// 读取币种列表文件：每行一个币种，忽略空行和 # 开头的注释（行尾注释也会去掉）
+func loadCoinsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			list = append(list, line)
+		}
+	}
+	return list, nil
+}
+
+// 合并多个币种列表，统一转成大写并去重，保持首次出现的顺序
+func mergeCoins(lists ...[]string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, coin := range list {
+			coin = strings.ToUpper(strings.TrimSpace(coin))
+			if coin == "" || seen[coin] {
+				continue
+			}
+			seen[coin] = true
+			merged = append(merged, coin)
+		}
+	}
+	return merged
+}
+
+// 计价稳定币，由 -stable-coin 指定，默认 USDT
+var stableCoin = "USDT"
+
+// 支持的计价稳定币
+var stableCoins = []string{"USDT", "USDC", "FDUSD"}
+
+// 校验稳定币是否支持
+func validateStableCoin(coin string) error {
+	if !contains(stableCoins, coin) {
+		return fmt.Errorf("不支持的稳定币: %s（可选 %s）", coin, strings.Join(stableCoins, "、"))
+	}
+	return nil
+}
+
+// 断点文件：记录最后一次成功抓取的位置，进程被杀后重启时可从断点继续
+const checkpointFile = "scrape_checkpoint.json"
+
+// 断点超过这个时间就视为过期，重启后重新完整抓取
+const checkpointMaxAge = time.Minute
+
+type Checkpoint struct {
+	StableCoin string `json:"stableCoin"`
+	Coin       string `json:"coin"`
+	OptionType string `json:"optionType"`
+	Page       int    `json:"page"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// 读取断点文件，文件不存在时返回 nil
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("解析断点文件失败: %v", err)
+	}
+	return &cp, nil
+}
+
+// 原子写入断点：先写临时文件再重命名，避免崩溃时留下半个文件
+func saveCheckpoint(path string, cp Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resume 不为 nil 时，跳过断点之前已经抓取过的 (coin, optionType, page)
+// 交易所维护时立即停止本轮抓取，返回 *MaintenanceError；ctx 被取消时在当前请求结束后返回 ctx.Err()
+func runFullScrape(ctx context.Context, resume *Checkpoint) error {
+
+	// 只抓取交易所有 coin+稳定币 交易对的币种，例如 WBETH 没有 FDUSD 交易对时跳过
+	// 获取不到交易对列表时无法校验，照常抓取 DCI，只跳过价格查询
+	symbols := make([]string, 0, len(coins))
+	scrapeCoins := make([]string, 0, len(coins))
+	if _, err := fetchExchangeInfo(ctx); err != nil {
+		log.Printf("无法获取交易对列表，跳过价格查询: %v\n", err)
+		scrapeCoins = append(scrapeCoins, coins...)
+	} else {
+		for _, coin := range coins {
+			sym := coin + stableCoin
+			if err := validateSymbol(ctx, sym); err != nil {
+				log.Printf("跳过 %s: %v\n", sym, err)
+				continue
+			}
+			symbols = append(symbols, sym)
+			scrapeCoins = append(scrapeCoins, coin)
+		}
+	}
+
+	for _, sym := range symbols {
+		rawData, err := fetchPrice(ctx, sym)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("获取 %s 价格失败: %v\n", sym, err)
+			continue
+		}
+		log.Printf("获取 %s 价格成功: %s\n", sym, rawData)
+	}
+
+	skipping := resume != nil && resume.StableCoin == stableCoin &&
+		contains(scrapeCoins, resume.Coin) && contains(optionTypes, resume.OptionType)
+	for _, coin := range scrapeCoins {
+		for _, optionType := range optionTypes {
+			startPage := 1
+			if skipping {
+				if coin != resume.Coin || optionType != resume.OptionType {
+					continue
+				}
+				skipping = false
+				startPage = resume.Page + 1
+			}
+
+			for page := startPage; ; page++ {
+				rawData, err := fetchPageRaw(ctx, apiKey, signer, optionType, coin, stableCoin, page)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				var maintenance *MaintenanceError
+				var circuitOpen *CircuitOpenError
+				if errors.As(err, &maintenance) || errors.As(err, &circuitOpen) {
+					return err
+				}
+				if err != nil {
+					fmt.Println("请求失败:", err)
+					break
+				}
+
+				if strings.Contains(rawData, "code") {
+					fmt.Println("请求失败，可能是参数错误或其他问题:", rawData)
+					break
+				}
+
+				if pageCache == nil || !pageCache.Unchanged(pageCacheKey(stableCoin, coin, optionType, page), rawData) {
+					log.Println(rawData)
+					if pairOutputs != nil {
+						if err := pairOutputs.WritePage(coin, optionType, rawData); err != nil {
+							log.Printf("写入 %s 失败: %v\n", pairFileName(coin, optionType), err)
+						}
+					}
+				}
+
+				cp := Checkpoint{
+					StableCoin: stableCoin,
+					Coin:       coin,
+					OptionType: optionType,
+					Page:       page,
+					Timestamp:  time.Now().UnixMilli(),
+				}
+				if err := saveCheckpoint(outputPath(checkpointFile), cp); err != nil {
+					log.Println("保存断点失败:", err)
+				}
+
+				// 假设返回的 JSON 数据中有一个字段表示是否还有下一页
+				if !strings.Contains(rawData, `id`) {
+					break
+				}
+
+			}
+		}
+	}
+
+	for _, sym := range symbols {
+		rawData, err := fetchPrice(ctx, sym)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("获取 %s 价格失败: %v\n", sym, err)
+			continue
+		}
+		log.Printf("获取 %s 价格成功: %s\n", sym, rawData)
+	}
+	return nil
+}
+
+// 带截止时间运行一轮抓取（看门狗）：请求各自有超时，但个别请求仍可能卡住（如 TLS 握手无响应），
+// 超过 deadline 时取消本轮的 ctx 并立即返回 context.DeadlineExceeded，下一次定时抓取照常进行
+// 被放弃的一轮在当前请求因 ctx 取消而结束后自行退出；deadline<=0 表示不限时
+func runScrapeWithDeadline(deadline time.Duration, resume *Checkpoint) error {
+	if deadline <= 0 {
+		return runFullScrape(context.Background(), resume)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runFullScrape(ctx, resume)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 产品详情缓存目录（相对 -output-dir），缓存的是请求当时的状态，需要最新数据时用 -refresh
+const productCacheDir = "product_cache"
+
+// 按产品ID获取单个 DCI 产品
+// DCI 没有单独的详情接口，所以在列表接口中逐页查找，找到后缓存到本地
+func fetchProductDetail(apiKey string, signer Signer, productID string, refresh bool) (*Product, error) {
+	cachePath := outputPath(filepath.Join(productCacheDir, productID+".json"))
+	if !refresh {
+		if data, err := os.ReadFile(cachePath); err == nil {
+			var product Product
+			if err := json.Unmarshal(data, &product); err == nil {
+				return &product, nil
+			}
+		}
+	}
+
+	for _, coin := range coins {
+		for _, optionType := range optionTypes {
+			for page := 1; ; page++ {
+				rawData, err := fetchPageRaw(context.Background(), apiKey, signer, optionType, coin, stableCoin, page)
+				if err != nil {
+					return nil, err
+				}
+				if err := parseAPIError([]byte(rawData)); err != nil {
+					return nil, err
+				}
+
+				var resp Response
+				if err := json.Unmarshal([]byte(rawData), &resp); err != nil {
+					return nil, fmt.Errorf("解析产品列表失败: %v", err)
+				}
+				if len(resp.List) == 0 {
+					break
+				}
+
+				for _, product := range resp.List {
+					if product.ID != productID {
+						continue
+					}
+					if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+						return nil, err
+					}
+					data, err := json.Marshal(product)
+					if err != nil {
+						return nil, err
+					}
+					if err := os.WriteFile(cachePath, data, 0644); err != nil {
+						return nil, err
+					}
+					return &product, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("未找到产品 %s", productID)
+}
+
+// product 子命令：打印单个产品的详情（JSON）
+func runProductCommand(args []string) {
+	fs := newCommandFlagSet("product")
+	fs.StringVar(&outputDir, "output-dir", ".", "产品缓存所在目录")
+	refresh := fs.Bool("refresh", false, "忽略本地缓存，重新请求")
+	fs.StringVar(&stableCoin, "stable-coin", "USDT", "计价稳定币: USDT、USDC 或 FDUSD")
+	recvWindowOpts := registerRecvWindowFlags(fs)
+	fs.Parse(args)
+	if err := validateStableCoin(stableCoin); err != nil {
+		log.Fatal(err)
+	}
+	if err := recvWindowOpts.Apply(); err != nil {
+		log.Fatal(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	apiKey, signer, err = loadCredentials()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	product, err := fetchProductDetail(apiKey, signer, fs.Arg(0), *refresh)
+	if err != nil {
+		log.Fatal("获取产品详情失败: ", err)
+	}
+
+	out, err := json.MarshalIndent(product, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
+
+// 用户数据流：通过 listenKey 订阅自己账户的余额和订单变化，例如 DCI 申购扣款、到期结算入账
+// DCI 的申购扣款和到期结算都记在现货钱包，所以用现货账户的 /api/v3/userDataStream；
+// /sapi/v1/userDataStream 是全仓杠杆账户的 listenKey，推送的是杠杆账户的变化，看不到这些事件
+const (
+	userDataStreamPath = "/api/v3/userDataStream"
+
+	// 连接断开或 listenKey 失效后，等待这么久再重新创建
+	userStreamRetryDelay = 5 * time.Second
+)
+
+// 用户数据流的 WebSocket 地址和续期间隔，测试中指向 httptest 服务器并缩短间隔
+var (
+	userStreamBaseURL = "wss://stream.binance.com:9443/ws/"
+
+	// listenKey 60分钟不续期就失效，官方建议每30分钟续期一次
+	listenKeyKeepaliveInterval = 30 * time.Minute
+
+	// 创建、续期、关闭 listenKey 的单次请求超时，避免卡住的续期请求让续期协程永远等待、listenKey 悄悄失效
+	userDataStreamTimeout = 10 * time.Second
+)
+
+// 用户数据流推送的账户事件，只解析共有字段，完整内容保留在 Raw 中
+type AccountEvent struct {
+	EventType string          `json:"e"` // outboundAccountPosition、balanceUpdate、executionReport 等
+	EventTime int64           `json:"E"`
+	Raw       json.RawMessage `json:"-"`
+}
+
+// 创建 listenKey，只需要 API Key，不需要签名
+func createListenKey(apiKey string) (string, error) {
+	body, err := userDataStreamRequest(http.MethodPost, apiKey, "")
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		ListenKey string `json:"listenKey"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("解析 listenKey 失败: %v", err)
+	}
+	if resp.ListenKey == "" {
+		return "", fmt.Errorf("返回内容中没有 listenKey: %s", body)
+	}
+	return resp.ListenKey, nil
+}
+
+// 续期 listenKey，失败（例如已失效）时返回错误，由调用方重新创建
+func keepaliveListenKey(apiKey, listenKey string) error {
+	_, err := userDataStreamRequest(http.MethodPut, apiKey, listenKey)
+	return err
+}
+
+// 关闭 listenKey
+func closeListenKey(apiKey, listenKey string) error {
+	_, err := userDataStreamRequest(http.MethodDelete, apiKey, listenKey)
+	return err
+}
+
+func userDataStreamRequest(method, apiKey, listenKey string) ([]byte, error) {
+	endpoint := apiBaseURL + userDataStreamPath
+	if listenKey != "" {
+		endpoint += "?listenKey=" + url.QueryEscape(listenKey)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), userDataStreamTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-MBX-APIKEY", apiKey)
+
+	resp, err := scrapeBreaker.Do(req, func() error {
+		return apiWeights.Acquire(ctx, endpointWeight(userDataStreamPath))
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	apiWeights.Update(resp.Header)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseAPIError(body); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userDataStream 返回状态码 %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// 持续读取用户数据流，把账户事件转发到 events，不会返回
+// listenKey 失效（收到 listenKeyExpired 或续期失败）或连接断开时，重新创建 listenKey 并重连
+func runUserStream(apiKey string, events chan<- AccountEvent) {
+	for {
+		if err := streamUserData(apiKey, events); err != nil {
+			log.Println("用户数据流中断:", err)
+		}
+		time.Sleep(userStreamRetryDelay)
+	}
+}
+
+// 用一个新的 listenKey 读取用户数据流，直到连接断开或 listenKey 失效
+func streamUserData(apiKey string, events chan<- AccountEvent) error {
+	listenKey, err := createListenKey(apiKey)
+	if err != nil {
+		return fmt.Errorf("创建 listenKey 失败: %v", err)
+	}
+	defer closeListenKey(apiKey, listenKey)
+
+	conn, _, err := websocket.DefaultDialer.Dial(userStreamBaseURL+listenKey, nil)
+	if err != nil {
+		return fmt.Errorf("连接用户数据流失败: %v", err)
+	}
+	defer conn.Close()
+	log.Println("用户数据流已连接")
+
+	// 定时续期，续期失败时关闭连接，让下面的读循环退出并重新创建 listenKey
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(listenKeyKeepaliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := keepaliveListenKey(apiKey, listenKey); err != nil {
+					log.Println("续期 listenKey 失败:", err)
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var event AccountEvent
+		if err := json.Unmarshal(message, &event); err != nil {
+			log.Println("解析用户数据流事件失败:", err)
+			continue
+		}
+		if event.EventType == "listenKeyExpired" {
+			return fmt.Errorf("listenKey 已失效")
+		}
+		event.Raw = message
+		events <- event
+	}
+}
+
+// userstream 子命令：订阅用户数据流，打印并记录账户事件
+func runUserStreamCommand(args []string) {
+	fs := newCommandFlagSet("userstream")
+	fs.StringVar(&outputDir, "output-dir", ".", "日志目录（不存在时自动创建）")
+	logOpts := registerLogFlags(fs)
+	fs.Parse(args)
+	logConfig, err := logOpts.Config(false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
+	apiKey = os.Getenv("BINANCE_API_KEY")
+	if apiKey == "" {
+		log.Fatal("请设置环境变量 BINANCE_API_KEY")
+	}
+	setupLogger(logConfig)
+
+	events := make(chan AccountEvent)
+	go runUserStream(apiKey, events)
+	for event := range events {
+		log.Println(string(event.Raw))
+		fmt.Printf("%s %s\n", time.UnixMilli(event.EventTime).Format("2006-01-02 15:04:05"), event.EventType)
+	}
+}
+
+// 跟踪日志时检查新内容和滚动的间隔
+const tailPollInterval = 500 * time.Millisecond
+
+// 跟踪一个会被 lumberjack 滚动的日志文件，类似 tail -F
+type logFollower struct {
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	partial string // 还没读到换行符的半行
+}
+
+// 打开日志文件，seekEnd 为 true 时只读之后新写入的内容
+func (f *logFollower) open(seekEnd bool) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.offset = 0
+	if seekEnd {
+		if f.offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	f.file = file
+	f.reader = bufio.NewReader(file)
+	f.partial = ""
+	return nil
+}
+
+// 读出当前文件中所有完整的行，最后不完整的一行留到下次
+func (f *logFollower) readLines(lines chan<- string) error {
+	for {
+		chunk, err := f.reader.ReadString('\n')
+		f.offset += int64(len(chunk))
+		if err == io.EOF {
+			f.partial += chunk
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		lines <- strings.TrimRight(f.partial+chunk, "\r\n")
+		f.partial = ""
+	}
+}
+
+// 日志被滚动（路径指向了新文件）时读完旧文件再打开新文件，被截断时从头读
+func (f *logFollower) checkRotation(lines chan<- string) error {
+	pathInfo, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		// 滚动过程中文件可能短暂不存在，下次再检查
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fileInfo, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(pathInfo, fileInfo) {
+		if err := f.readLines(lines); err != nil {
+			return err
+		}
+		f.file.Close()
+		return f.open(false)
+	}
+	if pathInfo.Size() < f.offset {
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		f.reader.Reset(f.file)
+		f.offset = 0
+		f.partial = ""
+	}
+	return nil
+}
+
+// 持续把日志的新行发送到 lines，fromStart 为 true 时先输出已有内容
+func followLog(path string, fromStart bool, lines chan<- string) error {
+	f := &logFollower{path: path}
+	if err := f.open(!fromStart); err != nil {
+		return err
+	}
+	defer func() { f.file.Close() }()
+
+	for {
+		if err := f.readLines(lines); err != nil {
+			return err
+		}
+		time.Sleep(tailPollInterval)
+		if err := f.checkRotation(lines); err != nil {
+			return err
+		}
+	}
+}
+
+// 从日志行中取出产品：日志行以时间和代码位置开头，JSON 从第一个 { 开始
+// 抓取时记录的是整页响应，也兼容一行一个产品的格式；不是产品数据的行返回 nil
+func parseLogProducts(line string) []Product {
+	i := strings.Index(line, "{")
+	if i < 0 {
+		return nil
+	}
+	data := []byte(line[i:])
+
+	var resp Response
+	if err := json.Unmarshal(data, &resp); err == nil && len(resp.List) > 0 {
+		return resp.List
+	}
+	var product Product
+	if err := json.Unmarshal(data, &product); err == nil && product.ID != "" {
+		return []Product{product}
+	}
+	return nil
+}
+
+// tail 子命令的过滤条件，为空的条件不限制
+type TailFilter struct {
+	MinAPR     float64
+	Coin       string
+	OptionType string
+}
+
+func (f TailFilter) Match(p Product) bool {
+	apr, err := p.APRFloat()
+	if err != nil || apr < f.MinAPR {
+		return false
+	}
+	if f.Coin != "" && p.InvestCoin != f.Coin && p.ExercisedCoin != f.Coin {
+		return false
+	}
+	if f.OptionType != "" && p.OptionType != f.OptionType {
+		return false
+	}
+	return true
+}
+
+// tail 子命令：跟踪 binance.log，实时打印满足条件的产品
+// 抓取每5秒记录一次完整列表，同一个产品只在第一次出现或 APR 变化时打印
+func runTailCommand(args []string) {
+	fs := newCommandFlagSet("tail")
+	fs.StringVar(&outputDir, "output-dir", ".", "binance.log 所在目录")
+	minAPR := fs.Float64("min-apr", 0, "只显示年化收益率不低于该值的产品（接口中的小数，如 0.5 表示 50%）")
+	coin := fs.String("coin", "", "只显示该币种的产品（如 ETH），默认不限")
+	optionType := fs.String("option-type", "", "只显示 PUT 或 CALL，默认不限")
+	fromStart := fs.Bool("from-start", false, "从日志开头读，默认只显示新写入的内容")
+	fs.Parse(args)
+
+	filter := TailFilter{
+		MinAPR:     *minAPR,
+		Coin:       strings.ToUpper(*coin),
+		OptionType: strings.ToUpper(*optionType),
+	}
+	if filter.OptionType != "" && !contains(optionTypes, filter.OptionType) {
+		log.Fatalf("未知的期权类型: %s（可选 PUT 或 CALL）", *optionType)
+	}
+
+	lines := make(chan string)
+	go func() {
+		if err := followLog(outputPath("binance.log"), *fromStart, lines); err != nil {
+			log.Fatal("跟踪日志失败: ", err)
+		}
+	}()
+
+	seen := make(map[string]string) // 产品ID -> 上次打印时的 APR
+	for line := range lines {
+		for _, p := range parseLogProducts(line) {
+			if !filter.Match(p) || seen[p.ID] == p.APR {
+				continue
+			}
+			seen[p.ID] = p.APR
+			apr, _ := p.APRFloat()
+			fmt.Printf("%s %s %s %s/%s 行权价 %s APR %.2f%% %d天 结算 %s\n",
+				time.Now().Format("2006-01-02 15:04:05"), p.ID, p.OptionType, p.ExercisedCoin, p.InvestCoin,
+				p.StrikePrice, apr*100, p.Duration, time.UnixMilli(p.SettleDate).Format("2006-01-02 15:04"))
+		}
+	}
+}
+
+// ivrank 子命令：DCI 的 APR 主要来自期权的隐含波动率溢价，把当前产品的 APR 与同类产品的历史 APR 比较，
+// 得到 IV 百分位排名（IV rank），排名高说明当前定价相对历史偏贵，卖出期权更划算
+// 历史数据来自抓取日志 binance.log 及其滚动备份，同类指 (币种, 期权类型, 行权价档位, 期限) 相同
+// 行权价会随现价变化，所以按行权价相对当时现价的偏离（moneyness）分档，而不是按绝对价格
+
+// 同类产品的分组键
+type IVKey struct {
+	Coin       string
+	OptionType string
+	StrikeBand int // 行权价相对现价的偏离按 -band-pct 分档后的档位，0 表示平值附近
+	Duration   int
+}
+
+// 从日志中读出的一次产品报价
+type IVObservation struct {
+	Time    time.Time
+	Key     IVKey
+	Product Product
+	APR     float64
+}
+
+// 当前产品的排名结果
+type IVRank struct {
+	Observation IVObservation
+	Percentile  float64 // 0-100
+	HistorySize int
+}
+
+// 日志行开头的时间格式（log.LstdFlags | log.Lmicroseconds）
+const logTimeLayout = "2006/01/02 15:04:05.000000"
+
+// 现价日志行: 获取 ETHUSDT 价格成功: {"symbol":"ETHUSDT","price":"2500.00"}
+type tickerPrice struct {
+	Symbol string `json:"symbol"`
+	Price  string `json:"price"`
+}
+
+// DCI 产品对应的标的币种：PUT 的 exercisedCoin、CALL 的 investCoin（见 dciCoins）
+func productCoin(p Product) string {
+	if p.OptionType == "CALL" {
+		return p.InvestCoin
+	}
+	return p.ExercisedCoin
+}
+
+// 行权价相对现价的偏离档位，bandPct 为每档宽度（百分比）
+func strikeBand(strike, spot, bandPct float64) int {
+	return int(math.Floor((strike/spot-1)*100/bandPct + 0.5))
+}
+
+// 按时间顺序列出 binance.log 的滚动备份（lumberjack 命名为 binance-<时间>.log[.gz]）和当前文件
+func logFiles(since time.Time) ([]string, error) {
+	backups, err := filepath.Glob(outputPath("binance-*.log*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(backups)
+	var files []string
+	for _, path := range backups {
+		// 备份文件在滚动时写入完毕，修改时间早于 since 的不可能包含需要的数据
+		if info, err := os.Stat(path); err == nil && info.ModTime().Before(since) {
+			continue
+		}
+		files = append(files, path)
+	}
+	if _, err := os.Stat(outputPath("binance.log")); err == nil {
+		files = append(files, outputPath("binance.log"))
+	}
+	return files, nil
+}
+
+// 读取日志中 since 之后的产品报价，每个产品的行权价按记录前最近一次的现价分档
+// 还没有记录过现价的币种无法分档，对应的产品跳过
+func readIVObservations(paths []string, since time.Time, bandPct float64) ([]IVObservation, error) {
+	var observations []IVObservation
+	spots := make(map[string]float64) // 交易对 -> 最近一次的现价
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		var r io.Reader = file
+		if strings.HasSuffix(path, ".gz") {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			r = gz
+		}
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024) // 整页响应写在一行里
+		for scanner.Scan() {
+			line := scanner.Text()
+			if len(line) < len(logTimeLayout) {
+				continue
+			}
+			t, err := time.ParseInLocation(logTimeLayout, line[:len(logTimeLayout)], time.Local)
+			if err != nil || t.Before(since) {
+				continue
+			}
+			if strings.Contains(line, "价格成功") {
+				var tp tickerPrice
+				if i := strings.Index(line, "{"); i >= 0 && json.Unmarshal([]byte(line[i:]), &tp) == nil {
+					if price, err := strconv.ParseFloat(tp.Price, 64); err == nil && price > 0 {
+						spots[tp.Symbol] = price
+					}
+				}
+				continue
+			}
+			for _, p := range parseLogProducts(line) {
+				apr, err := p.APRFloat()
+				if err != nil {
+					continue
+				}
+				strike, err := p.StrikePriceFloat()
+				if err != nil {
+					continue
+				}
+				coin := productCoin(p)
+				spot, ok := spots[coin+stableCoin]
+				if !ok {
+					continue
+				}
+				observations = append(observations, IVObservation{
+					Time:    t,
+					Key:     IVKey{Coin: coin, OptionType: p.OptionType, StrikeBand: strikeBand(strike, spot, bandPct), Duration: p.Duration},
+					Product: p,
+					APR:     apr,
+				})
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return observations, nil
+}
+
+// 当前值在历史值中的百分位：低于它的比例，相等的算一半，历史为空时返回 NaN
+func percentileRank(history []float64, value float64) float64 {
+	if len(history) == 0 {
+		return math.NaN()
+	}
+	below, equal := 0, 0
+	for _, h := range history {
+		if h < value {
+			below++
+		} else if h == value {
+			equal++
+		}
+	}
+	return (float64(below) + 0.5*float64(equal)) / float64(len(history)) * 100
+}
+
+// 把报价分成历史和当前两部分：最后一条记录前 current 时间内的是当前产品（每个产品取最新一条），之前的是历史
+// 历史少于 minHistory 个样本的分组没有足够的参考，不参与排名；结果按百分位从高到低排序
+func rankIV(observations []IVObservation, current time.Duration, minHistory int) []IVRank {
+	if len(observations) == 0 {
+		return nil
+	}
+	latest := observations[0].Time
+	for _, o := range observations {
+		if o.Time.After(latest) {
+			latest = o.Time
+		}
+	}
+	cutoff := latest.Add(-current)
+
+	history := make(map[IVKey][]float64)
+	currentByID := make(map[string]IVObservation)
+	for _, o := range observations {
+		if o.Time.After(cutoff) {
+			if prev, ok := currentByID[o.Product.ID]; !ok || !o.Time.Before(prev.Time) {
+				currentByID[o.Product.ID] = o
+			}
+			continue
+		}
+		history[o.Key] = append(history[o.Key], o.APR)
+	}
+
+	var ranks []IVRank
+	for _, o := range currentByID {
+		h := history[o.Key]
+		if len(h) < minHistory {
+			continue
+		}
+		ranks = append(ranks, IVRank{Observation: o, Percentile: percentileRank(h, o.APR), HistorySize: len(h)})
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].Percentile != ranks[j].Percentile {
+			return ranks[i].Percentile > ranks[j].Percentile
+		}
+		return ranks[i].Observation.APR > ranks[j].Observation.APR
+	})
+	return ranks
+}
+
+func runIVRankCommand(args []string) {
+	fs := newCommandFlagSet("ivrank")
+	fs.StringVar(&outputDir, "output-dir", ".", "binance.log 所在目录")
+	fs.StringVar(&stableCoin, "stable-coin", "USDT", "抓取时使用的计价稳定币，用于匹配日志中的现价")
+	days := fs.Int("days", 30, "使用最近多少天的日志作为历史")
+	current := fs.Duration("current", time.Minute, "最后一条记录前多长时间内的产品视为当前产品")
+	bandPct := fs.Float64("band-pct", 2, "行权价相对现价偏离的分档宽度（百分比）")
+	minHistory := fs.Int("min-history", 30, "同类产品至少要有多少个历史样本才参与排名")
+	top := fs.Int("top", 20, "显示排名最高的产品数量，0 表示全部")
+	fs.Parse(args)
+	if *days < 1 {
+		log.Fatalf("-days 必须大于 0: %d", *days)
+	}
+	if *current <= 0 {
+		log.Fatalf("-current 必须大于 0: %v", *current)
+	}
+	if *bandPct <= 0 {
+		log.Fatalf("-band-pct 必须大于 0: %v", *bandPct)
+	}
+	if *minHistory < 1 {
+		log.Fatalf("-min-history 必须大于 0: %d", *minHistory)
+	}
+	if err := validateStableCoin(stableCoin); err != nil {
+		log.Fatal(err)
+	}
+
+	since := time.Now().AddDate(0, 0, -*days)
+	files, err := logFiles(since)
+	if err != nil {
+		log.Fatal("查找日志文件失败: ", err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("%s 中没有抓取日志", outputDir)
+	}
+	observations, err := readIVObservations(files, since, *bandPct)
+	if err != nil {
+		log.Fatal("读取日志失败: ", err)
+	}
+	if len(observations) == 0 {
+		log.Fatal("日志中没有可用的产品记录（需要同时记录了现价）")
+	}
+
+	ranks := rankIV(observations, *current, *minHistory)
+	fmt.Printf("读取 %d 个日志文件，%d 条产品记录，%d 个当前产品有足够的历史\n\n", len(files), len(observations), len(ranks))
+	if len(ranks) == 0 {
+		return
+	}
+	if *top > 0 && len(ranks) > *top {
+		ranks = ranks[:*top]
+	}
+	fmt.Printf("%-10s %-6s %-5s %12s %8s %6s %10s %8s %s\n", "币种", "类型", "期限", "行权价", "偏离档", "APR", "IV百分位", "历史样本", "产品ID")
+	for _, r := range ranks {
+		o := r.Observation
+		fmt.Printf("%-10s %-6s %-5d %12s %+7.0f%% %5.1f%% %9.1f%% %8d %s\n",
+			o.Key.Coin, o.Key.OptionType, o.Key.Duration, o.Product.StrikePrice, float64(o.Key.StrikeBand)**bandPct,
+			o.APR*100, r.Percentile, r.HistorySize, o.Product.ID)
+	}
+}
+
+// Binance 文档中的 HMAC SHA256 签名示例，用于确认签名代码本身没有问题
+const (
+	signatureExampleSecret    = "NhqPtmdSJYdKjVHjA7PZj4Mge3R5YNiP1e3UZjInClVN65XAbvqqM6A7H5fATj0j"
+	signatureExampleQuery     = "symbol=LTCBTC&side=BUY&type=LIMIT&timeInForce=GTC&quantity=1&price=0.1&recvWindow=5000&timestamp=1499827319559"
+	signatureExampleSignature = "c8db56825ae71d6d79447849e617115f4a920fa2acdcab2b053c4b2838bd6b71"
+)
+
+// 诊断用的签名接口：只查询账户状态，权重低，任何 API Key 都有权限
+const signatureCheckPath = "/sapi/v1/account/status"
+
+// 签名诊断结果
+type SignatureDiagnosis struct {
+	CodeOK    bool          // 签名代码能算出文档示例的签名
+	OK        bool          // 真实的签名请求通过
+	Code      int           // 接口返回的错误码，0 表示没有
+	Message   string        // 接口返回的错误信息或网络错误
+	Cause     string        // 对原因的判断
+	ClockSkew time.Duration // 返回 -1021 时本机时间减服务器时间
+}
+
+// 验证签名代码本身：HMAC 复现 Binance 文档中的示例签名，Ed25519 用私钥对应的公钥验证示例签名
+func signerSelfTest(signer Signer) bool {
+	if s, ok := signer.(Ed25519Signer); ok {
+		signature, err := base64.StdEncoding.DecodeString(s.Sign(signatureExampleQuery))
+		return err == nil && ed25519.Verify(s.key.Public().(ed25519.PublicKey), []byte(signatureExampleQuery), signature)
+	}
+	return hmacSignature(signatureExampleQuery, signatureExampleSecret) == signatureExampleSignature
+}
+
+// 区分 -1022 等签名错误是凭证问题还是代码问题：先用文档示例验证签名代码，再发一个真实的签名请求，
+// 按返回的错误码给出原因；结果中不包含 Secret Key 或私钥
+func diagnoseSignature(ctx context.Context, apiKey string, signer Signer) SignatureDiagnosis {
+	var d SignatureDiagnosis
+	d.CodeOK = signerSelfTest(signer)
+	if !d.CodeOK {
+		d.Cause = "签名代码有误：无法复现 Binance 文档中的示例签名，与凭证无关"
+		return d
+	}
+
+	body, err := signedRequestOnce(ctx, http.MethodGet, apiKey, signer, signatureCheckPath, nil)
+	if err != nil {
+		d.Message = err.Error()
+		d.Cause = "请求没有得到接口的答复（网络或交易所问题），无法判断凭证是否正确"
+		return d
+	}
+	var apiErr *APIError
+	if !errors.As(parseAPIError(body), &apiErr) {
+		d.OK = true
+		d.Cause = "签名和凭证都正常"
+		return d
+	}
+	d.Code, d.Message = apiErr.Code, apiErr.Msg
+
+	switch apiErr.Code {
+	case -1022:
+		d.Cause = "签名无效：签名代码已通过文档示例验证，问题在 Secret Key（是否与 API Key 配对、是否复制完整）"
+		if _, ok := signer.(Ed25519Signer); ok {
+			d.Cause = "签名无效：签名代码已通过验证，问题在私钥（是否与该 API Key 上传的公钥配对）"
+		}
+	case -2014:
+		d.Cause = "API Key 格式错误（是否复制完整）"
+	case -2015:
+		d.Cause = "API Key 无效、请求 IP 不在白名单中，或该 Key 没有相应权限"
+	case codeTimestampOutsideRecvWindow:
+		d.Cause = "时间戳超出 recvWindow：签名本身没有问题，本机时间不准或网络延迟过大（可用 -recv-window 调大）"
+		if skew, err := serverClockSkew(ctx); err == nil {
+			d.ClockSkew = skew
+			d.Cause += fmt.Sprintf("，本机比服务器快 %v（负数表示慢），请同步系统时间", skew.Round(time.Millisecond))
+		}
+	default:
+		d.Cause = "接口返回了其他错误"
+	}
+	hmacSigner, isHMAC := signer.(HMACSigner)
+	if strings.TrimSpace(apiKey) != apiKey || isHMAC && strings.TrimSpace(hmacSigner.secret) != hmacSigner.secret {
+		d.Cause += "；注意 API Key 或 Secret Key 首尾有空白字符"
+	}
+	return d
+}
+
+// 本机时间减服务器时间，用请求往返的中点估计发出请求时的本机时间
+func serverClockSkew(ctx context.Context) (time.Duration, error) {
+	sent := time.Now()
+	body, err := publicGet(ctx, "/api/v3/time", nil)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	var serverTime struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := json.Unmarshal(body, &serverTime); err != nil || serverTime.ServerTime == 0 {
+		return 0, fmt.Errorf("无法解析服务器时间: %s", body)
+	}
+	local := sent.Add(received.Sub(sent) / 2)
+	return local.Sub(time.UnixMilli(serverTime.ServerTime)), nil
+}
+
+// 只显示 API Key 的首尾几位，避免完整凭证出现在终端或日志中
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", len(key)-8) + key[len(key)-4:]
+}
+
+// checkkey 子命令：诊断签名错误是凭证问题还是代码问题
+func runCheckKeyCommand(args []string) {
+	fs := newCommandFlagSet("checkkey")
+	recvWindowOpts := registerRecvWindowFlags(fs)
+	fs.Parse(args)
+	if err := recvWindowOpts.Apply(); err != nil {
+		log.Fatal(err)
+	}
+
+	var err error
+	apiKey, signer, err = loadCredentials()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("API Key: %s\n", maskKey(apiKey))
+	d := diagnoseSignature(context.Background(), apiKey, signer)
+	if d.CodeOK {
+		fmt.Println("签名代码: 通过")
+	} else {
+		fmt.Println("签名代码: 失败")
+	}
+	if d.Code != 0 {
+		fmt.Printf("接口返回: %d %s\n", d.Code, d.Message)
+	} else if d.Message != "" {
+		fmt.Printf("请求失败: %s\n", d.Message)
+	}
+	fmt.Println("结论:", d.Cause)
+	if !d.OK {
+		os.Exit(1)
+	}
+}
+
+// 子命令的说明，help 和各子命令的用法输出都从这里生成
+type Command struct {
+	Name    string // 空字符串为不带子命令时的默认命令（定时抓取）
+	Args    string // 用法中子命令之后的部分
+	Summary string
+	Example string
+	Run     func(args []string)
+}
+
+var commands []Command
+
+func init() {
+	commands = []Command{
+		{"", "[参数]", "每5秒抓取一次双币投资产品列表，写入 binance.log", "go run main.go -coins BTC,ETH -stable-coin USDC -stdout", nil},
+		{"product", "[参数] <产品ID>", "打印单个产品的详情（JSON），默认使用本地缓存", "go run main.go product -refresh <产品ID>", runProductCommand},
+		{"userstream", "[参数]", "订阅用户数据流，打印并记录账户的余额和订单变化", "go run main.go userstream -output-dir logs", runUserStreamCommand},
+		{"tail", "[参数]", "跟踪 binance.log，实时打印满足条件的产品", "go run main.go tail -coin ETH -option-type PUT -min-apr 0.5", runTailCommand},
+		{"ivrank", "[参数]", "与同类产品的历史 APR 比较，给当前产品的隐含波动率排名", "go run main.go ivrank -days 30 -top 10", runIVRankCommand},
+		{"checkkey", "[参数]", "诊断签名错误是凭证问题还是代码问题", "go run main.go checkkey -recv-window 10000", runCheckKeyCommand},
+		{"help", "[子命令]", "显示全部子命令，或某个子命令的参数说明", "go run main.go help ivrank", runHelpCommand},
+	}
+}
+
+func findCommand(name string) *Command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// 用法说明的输出位置，nil 表示标准错误（参数错误时）；help 子命令改为标准输出
+var usageOutput io.Writer
+
+// 创建子命令的参数集：-h、help <子命令> 或参数错误时输出说明、示例和全部参数
+func newCommandFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.SetOutput(usageOutput)
+	setCommandUsage(fs, findCommand(name))
+	return fs
+}
+
+func setCommandUsage(fs *flag.FlagSet, cmd *Command) {
+	fs.Usage = func() {
+		w := fs.Output()
+		name := "main.go"
+		if cmd.Name != "" {
+			name += " " + cmd.Name
+		}
+		fmt.Fprintf(w, "%s\n\n用法: go run %s %s\n示例: %s\n\n参数:\n", cmd.Summary, name, cmd.Args, cmd.Example)
+		fs.PrintDefaults()
+		if cmd.Name == "" {
+			fmt.Fprintln(w)
+			printHelp(w)
+		}
+	}
+}
+
+// 列出全部子命令及示例
+func printHelp(w io.Writer) {
+	fmt.Fprintln(w, "子命令（不带子命令时定时抓取产品）:")
+	for _, cmd := range commands {
+		if cmd.Name == "" {
+			continue
+		}
+		fmt.Fprintf(w, "  %-11s%s\n  %-11s示例: %s\n", cmd.Name, cmd.Summary, "", cmd.Example)
+	}
+	fmt.Fprintln(w, "\n用 go run main.go help <子命令> 查看子命令的参数")
+}
+
+// help 子命令：不带参数时列出全部子命令，否则输出该子命令的参数说明
+func runHelpCommand(args []string) {
+	usageOutput = os.Stdout
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		fmt.Println("用法: go run main.go [子命令] [参数]")
+		fmt.Println()
+		printHelp(os.Stdout)
+		fmt.Println("用 go run main.go -h 查看默认命令（定时抓取）的参数")
+		return
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil || cmd.Run == nil {
+		fmt.Fprintf(os.Stderr, "未知的子命令: %s\n\n", args[0])
+		printHelp(os.Stderr)
+		os.Exit(2)
+	}
+	// 子命令的参数在各自的函数里定义，用 -h 调用即可输出说明（随后以状态 0 退出）
+	cmd.Run([]string{"-h"})
+}
+
+func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		cmd := findCommand(os.Args[1])
+		if cmd == nil || cmd.Run == nil {
+			fmt.Fprintf(os.Stderr, "未知的子命令: %s\n\n", os.Args[1])
+			printHelp(os.Stderr)
+			os.Exit(2)
+		}
+		cmd.Run(os.Args[2:])
+		return
+	}
+
+	flag.StringVar(&outputDir, "output-dir", ".", "日志和断点文件目录（不存在时自动创建）")
+	flag.StringVar(&stableCoin, "stable-coin", "USDT", "计价稳定币: USDT、USDC 或 FDUSD")
+	coinsFlag := flag.String("coins", "", "抓取的币种（逗号分隔），默认 BTC,ETH,WBETH；与 -coins-file 同时使用时合并去重")
+	coinsFile := flag.String("coins-file", "", "币种列表文件，每行一个币种，忽略空行和 # 注释")
+	weightBudgetPct := flag.Int("weight-budget-pct", 80, "每分钟最多使用官方请求权重上限的百分比，给同一 IP 上的其他程序留余量")
+	productType := flag.String("product-type", "BOTH", "抓取的期权类型: PUT、CALL 或 BOTH（两种都抓）")
+	breakerFailures := flag.Int("breaker-failures", 5, "连续失败多少次后打开熔断器，冷却期内不再请求；0 表示不熔断")
+	breakerCooldown := flag.Duration("breaker-cooldown", time.Minute, "熔断器打开后的冷却时间，之后放行一个探测请求")
+	teeStdout := flag.Bool("stdout", false, "日志除了写入 binance.log，同时输出到终端，方便交互运行时实时查看")
+	quiet := flag.Bool("q", false, "安静模式：不向终端输出日志（优先于 -stdout）")
+	cycleTimeout := flag.Duration("cycle-timeout", 2*time.Minute, "一轮抓取的最长时间，超过后取消本轮并在下一次定时抓取时重新开始；0 表示不限时")
+	splitOutput := flag.Bool("split-output", false, "抓取到的产品额外按币种和期权类型写入单独的文件（如 BTC_CALL.jsonl，每行一个产品），滚动设置与日志相同")
+	skipUnchanged := flag.Bool("skip-unchanged", false, "某一页的内容与上一轮完全相同时不再写入日志（按内容哈希比较），减少重复数据；依赖完整快照的下游分析不要开启")
+	logOpts := registerLogFlags(flag.CommandLine)
+	recvWindowOpts := registerRecvWindowFlags(flag.CommandLine)
+	setCommandUsage(flag.CommandLine, findCommand(""))
+	flag.Parse()
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "多余的参数: %s\n\n", strings.Join(flag.Args(), " "))
+		flag.Usage()
+		os.Exit(2)
+	}
+	logConfig, err := logOpts.Config(*teeStdout && !*quiet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := recvWindowOpts.Apply(); err != nil {
+		log.Fatal(err)
+	}
+	if *weightBudgetPct < 1 || *weightBudgetPct > 100 {
+		log.Fatalf("-weight-budget-pct 必须在 1 到 100 之间: %d", *weightBudgetPct)
+	}
+	apiWeights.SetBudgetPct(*weightBudgetPct)
+	sapiWeights.SetBudgetPct(*weightBudgetPct)
+	if *breakerFailures < 0 {
+		log.Fatalf("-breaker-failures 不能为负数: %d", *breakerFailures)
+	}
+	if *breakerCooldown <= 0 {
+		log.Fatalf("-breaker-cooldown 必须大于 0: %v", *breakerCooldown)
+	}
+	scrapeBreaker.Configure(*breakerFailures, *breakerCooldown)
+	if *cycleTimeout < 0 {
+		log.Fatalf("-cycle-timeout 不能为负数: %v", *cycleTimeout)
+	}
+	types, err := parseProductType(*productType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	optionTypes = types
+	if err := validateStableCoin(stableCoin); err != nil {
+		log.Fatal(err)
+	}
+	if *coinsFlag != "" || *coinsFile != "" {
+		var fileCoins []string
+		if *coinsFile != "" {
+			var err error
+			if fileCoins, err = loadCoinsFile(*coinsFile); err != nil {
+				log.Fatal("读取币种列表文件失败: ", err)
+			}
+		}
+		coins = mergeCoins(strings.Split(*coinsFlag, ","), fileCoins)
+		if len(coins) == 0 {
+			log.Fatal("币种列表为空")
+		}
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatal("创建输出目录失败:", err)
+	}
+
+	setupLogger(logConfig)
+	if *splitOutput {
+		pairOutputs = newPairWriters(logConfig)
+	}
+	if *skipUnchanged {
+		pageCache = newPageCache()
+	}
+	apiKey, signer, err = loadCredentials()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	resume, err := loadCheckpoint(outputPath(checkpointFile))
+	if err != nil {
+		log.Println("读取断点失败:", err)
+	}
+	if resume != nil {
+		if time.Since(time.UnixMilli(resume.Timestamp)) > checkpointMaxAge {
+			resume = nil
+		} else {
+			log.Printf("上次运行中断于 %s %s 第 %d 页，从断点继续\n", resume.Coin, resume.OptionType, resume.Page)
+		}
+	}
+
+	var ticker *time.Ticker
+	//每5s抓取一次
+	ticker = time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := runScrapeWithDeadline(*cycleTimeout, resume)
+			resume = nil
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("本轮抓取超过 %v 仍未完成，已取消，等待下一次抓取\n", *cycleTimeout)
+				continue
+			}
+			var maintenance *MaintenanceError
+			if errors.As(err, &maintenance) {
+				log.Printf("%v，%v 后重新抓取\n", err, maintenanceBackoff)
+				time.Sleep(maintenanceBackoff)
+				continue
+			}
+			var circuitOpen *CircuitOpenError
+			if errors.As(err, &circuitOpen) {
+				log.Printf("%v，本轮抓取提前结束\n", err)
+				continue
+			}
+		}
+		fmt.Println("抓取完成，等待下一次抓取...", time.Now().Format("2006-01-02 15:04:05"))
+		if pageCache != nil {
+			hits, misses := pageCache.Stats()
+			fmt.Printf("内容未变化而跳过写入的页: 累计 %d / %d\n", hits, hits+misses)
 		}
-		fmt.Println("抓取完成，等待下一次抓取...", time.Now().Format("2006-01-02 15:04:05"))
 	}
 	// 阻塞主线程
 	select {}
@@ -0,0 +1,1865 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 把 REST 接口指向本地的 httptest 服务器，并重置请求共用的状态（交易对缓存、熔断器、权重统计、服务器时间），测试结束后恢复
+func startTestAPI(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	oldURL := apiBaseURL
+	t.Cleanup(func() {
+		server.Close()
+		apiBaseURL = oldURL
+		validSymbols = nil
+	})
+	apiBaseURL = server.URL
+	validSymbols = nil
+	scrapeBreaker = newCircuitBreaker(5, time.Minute)
+	apiWeights = newWeightTracker("X-MBX-USED-WEIGHT-1M", 6000)
+	sapiWeights = newWeightTracker("X-SAPI-USED-IP-WEIGHT-1M", 12000)
+	serverClock = &ServerClock{}
+	return server
+}
+
+// 设置一轮抓取的币种、期权类型和凭证，输出目录指向临时目录，测试结束后恢复
+func useScrapeConfig(t *testing.T, scrapeCoins, scrapeOptionTypes []string) {
+	t.Helper()
+	oldDir, oldCoins, oldTypes := outputDir, coins, optionTypes
+	t.Cleanup(func() {
+		outputDir, coins, optionTypes = oldDir, oldCoins, oldTypes
+		apiKey, signer, pageCache = "", nil, nil
+	})
+	outputDir = t.TempDir()
+	coins, optionTypes = scrapeCoins, scrapeOptionTypes
+	apiKey, signer = "key", HMACSigner{secret: "secret"}
+}
+
+// 预算用完后等待下一分钟期间取消 ctx，Acquire 立即返回而不是睡到下一分钟
+func TestWeightTrackerAcquireCanceled(t *testing.T) {
+	tracker := newWeightTracker("X-MBX-USED-WEIGHT-1M", 10)
+	minute := time.Now().Truncate(time.Minute)
+	if err := tracker.Acquire(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := tracker.Acquire(ctx, 5)
+	if err == nil && time.Now().Truncate(time.Minute).After(minute) {
+		t.Skip("测试期间跨过了整分钟")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire 返回 %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("取消后等待了 %v", elapsed)
+	}
+
+	// 取消的请求不预扣权重
+	tracker.mu.Lock()
+	used := tracker.used
+	tracker.mu.Unlock()
+	if used != 10 && time.Now().Truncate(time.Minute).Equal(minute) {
+		t.Fatalf("used = %d, want 10", used)
+	}
+}
+
+// 响应头报告的已用权重接近预算时，超出预算的请求暂停等待，不超出的照常放行；
+// 服务端报告的权重下降或进入新的一分钟后恢复；无法解析的响应头不影响统计
+func TestWeightTrackerPausesOnHeader(t *testing.T) {
+	tracker := newWeightTracker("X-MBX-USED-WEIGHT-1M", 1000)
+	tracker.SetBudgetPct(80)
+	minute := time.Now().Truncate(time.Minute)
+	acquire := func(weight int) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		return tracker.Acquire(ctx, weight)
+	}
+	update := func(value string) {
+		header := http.Header{}
+		header.Set("X-MBX-USED-WEIGHT-1M", value)
+		tracker.Update(header)
+	}
+
+	update("790")
+	update("abc")
+	if err := acquire(5); err != nil {
+		t.Fatalf("795/800 应放行: %v", err)
+	}
+	err := acquire(10)
+	if err == nil && time.Now().Truncate(time.Minute).After(minute) {
+		t.Skip("测试期间跨过了整分钟")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("805/800 应暂停等待, Acquire 返回 %v", err)
+	}
+
+	update("100")
+	if err := acquire(10); err != nil {
+		t.Fatalf("服务端报告 100 后应放行: %v", err)
+	}
+
+	// 上一分钟用完的预算在新的一分钟清零
+	tracker.mu.Lock()
+	tracker.windowStart = tracker.windowStart.Add(-time.Minute)
+	tracker.used = 800
+	tracker.mu.Unlock()
+	if err := acquire(10); err != nil {
+		t.Fatalf("新的一分钟应放行: %v", err)
+	}
+	tracker.mu.Lock()
+	used := tracker.used
+	tracker.mu.Unlock()
+	if used != 10 {
+		t.Errorf("新的一分钟 used = %d, want 10", used)
+	}
+}
+
+// 多个抓取协程同时读取、同步服务器时间并扣减权重，需要在 go test -race 下通过
+func TestConcurrentClockAndWeights(t *testing.T) {
+	var timeRequests atomic.Int32
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/time" {
+			http.NotFound(w, r)
+			return
+		}
+		timeRequests.Add(1)
+		w.Header().Set("X-MBX-USED-WEIGHT-1M", "3")
+		// 服务器比本机慢 2 秒
+		fmt.Fprintf(w, `{"serverTime":%d}`, time.Now().Add(-2*time.Second).UnixMilli())
+	})
+
+	clock := &ServerClock{}
+	tracker := newWeightTracker("X-MBX-USED-WEIGHT-1M", 1000000)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				clock.Now()
+				clock.Skew()
+				if err := clock.Sync(ctx); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := tracker.Acquire(ctx, 1); err != nil {
+					t.Error(err)
+					return
+				}
+				tracker.Update(http.Header{"X-Mbx-Used-Weight-1m": []string{strconv.Itoa(j)}})
+				if j%10 == 0 {
+					tracker.SetBudgetPct(50 + i)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 同步间隔内并发的 Sync 只请求一次服务器时间
+	if n := timeRequests.Load(); n != 1 {
+		t.Errorf("请求了 %d 次 /api/v3/time, want 1", n)
+	}
+	if skew := clock.Skew(); skew < 1500*time.Millisecond || skew > 2500*time.Millisecond {
+		t.Errorf("skew = %v, want ≈ 2s", skew)
+	}
+}
+
+func TestPageCacheUnchanged(t *testing.T) {
+	cache := newPageCache()
+	for _, tc := range []struct {
+		name string
+		key  string
+		data string
+		want bool
+	}{
+		{"第一次出现", "USDT/ETH/PUT/1", `{"list":[1]}`, false},
+		{"内容相同", "USDT/ETH/PUT/1", `{"list":[1]}`, true},
+		{"内容变化", "USDT/ETH/PUT/1", `{"list":[2]}`, false},
+		{"变化后再次相同", "USDT/ETH/PUT/1", `{"list":[2]}`, true},
+		{"回到旧内容", "USDT/ETH/PUT/1", `{"list":[1]}`, false},
+		{"同样的内容、不同的页", "USDT/ETH/PUT/2", `{"list":[1]}`, false},
+		{"另一页内容相同", "USDT/ETH/PUT/2", `{"list":[1]}`, true},
+	} {
+		if got := cache.Unchanged(tc.key, tc.data); got != tc.want {
+			t.Errorf("%s: Unchanged(%q, %q) = %v, want %v", tc.name, tc.key, tc.data, got, tc.want)
+		}
+	}
+	if hits, misses := cache.Stats(); hits != 3 || misses != 4 {
+		t.Errorf("Stats() = %d, %d, want 3, 4", hits, misses)
+	}
+}
+
+// -skip-unchanged：第二轮抓到的页与第一轮相同时不再写日志，内容变化的页照常写入
+func TestRunFullScrapeSkipsUnchangedPages(t *testing.T) {
+	var mu sync.Mutex
+	pages := map[string]string{
+		"1": `{"total":2,"list":[{"id":"a1"}]}`,
+		"2": `{"total":2,"list":[]}`,
+	}
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/exchangeInfo":
+			fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT","status":"TRADING"}]}`)
+		case "/api/v3/ticker/price":
+			fmt.Fprint(w, `{"symbol":"ETHUSDT","price":"2000.00"}`)
+		case "/sapi/v1/dci/product/list":
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprint(w, pages[r.URL.Query().Get("pageIndex")])
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	useScrapeConfig(t, []string{"ETH"}, []string{"PUT"})
+	pageCache = newPageCache()
+
+	// 每轮新写入的日志中包含产品列表的行数
+	scrape := func() int {
+		logs.Reset()
+		if err := runFullScrape(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(logs.String(), `"list"`)
+	}
+
+	if n := scrape(); n != 2 {
+		t.Fatalf("第一轮写入 %d 页, want 2\n%s", n, logs.String())
+	}
+	if n := scrape(); n != 0 {
+		t.Fatalf("内容未变化的第二轮写入 %d 页, want 0\n%s", n, logs.String())
+	}
+
+	mu.Lock()
+	pages["1"] = `{"total":2,"list":[{"id":"a2"}]}`
+	mu.Unlock()
+	if n := scrape(); n != 1 {
+		t.Fatalf("第 1 页变化后写入 %d 页, want 1\n%s", n, logs.String())
+	}
+	if hits, misses := pageCache.Stats(); hits != 3 || misses != 3 {
+		t.Errorf("Stats() = %d, %d, want 3, 3", hits, misses)
+	}
+}
+
+// 用模拟的 exchangeInfo 校验交易对：处于交易状态的通过，拼写错误和停止交易的被拒绝，列表只请求一次
+func TestValidateSymbol(t *testing.T) {
+	var requests atomic.Int32
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/exchangeInfo" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT","status":"TRADING"},{"symbol":"BTCUSDT","status":"TRADING"},{"symbol":"WBETHFDUSD","status":"BREAK"}]}`)
+	})
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		symbol string
+		ok     bool
+	}{
+		{"ETHUSDT", true},
+		{"BTCUSDT", true},
+		{"ETHUST", false},
+		{"WBETHFDUSD", false},
+	} {
+		err := validateSymbol(ctx, tc.symbol)
+		if (err == nil) != tc.ok {
+			t.Errorf("validateSymbol(%s) = %v, want ok=%v", tc.symbol, err, tc.ok)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("请求了 %d 次 exchangeInfo, want 1", n)
+	}
+}
+
+// exchangeInfo 返回错误时校验失败，且不缓存失败的结果
+func TestValidateSymbolExchangeInfoError(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":-1121,"msg":"Invalid symbol."}`)
+			return
+		}
+		fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT","status":"TRADING"}]}`)
+	})
+
+	if err := validateSymbol(context.Background(), "ETHUSDT"); err == nil {
+		t.Fatal("exchangeInfo 出错时 validateSymbol 应返回错误")
+	}
+	fail.Store(false)
+	if err := validateSymbol(context.Background(), "ETHUSDT"); err != nil {
+		t.Fatalf("恢复后 validateSymbol = %v", err)
+	}
+}
+
+// 模拟抓取中途被杀后重启：断点记录最后成功的页，重启后读取断点并从下一页继续，不重复抓取已写入的页
+func TestRunFullScrapeResumesFromCheckpoint(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	var crash context.CancelFunc
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/exchangeInfo":
+			fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT","status":"TRADING"}]}`)
+		case "/api/v3/ticker/price":
+			fmt.Fprint(w, `{"symbol":"ETHUSDT","price":"2000.00"}`)
+		case "/sapi/v1/dci/product/list":
+			query := r.URL.Query()
+			page := query.Get("optionType") + "/" + query.Get("pageIndex")
+			mu.Lock()
+			requested = append(requested, page)
+			if page == "PUT/3" && crash != nil {
+				// 第一次运行在请求 PUT 第 3 页时被杀
+				crash()
+			}
+			mu.Unlock()
+			if query.Get("pageIndex") == "3" {
+				fmt.Fprint(w, `{"total":4,"list":[]}`)
+				return
+			}
+			fmt.Fprintf(w, `{"total":4,"list":[{"id":"%s"}]}`, page)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	useScrapeConfig(t, []string{"ETH"}, []string{"PUT", "CALL"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mu.Lock()
+	crash = cancel
+	mu.Unlock()
+	if err := runFullScrape(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("第一次运行返回 %v, want %v", err, context.Canceled)
+	}
+
+	// 重启：从输出目录读取断点
+	resume, err := loadCheckpoint(outputPath(checkpointFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resume == nil {
+		t.Fatal("中断后没有断点文件")
+	}
+	if resume.StableCoin != "USDT" || resume.Coin != "ETH" || resume.OptionType != "PUT" || resume.Page != 2 {
+		t.Fatalf("断点 = %+v, want USDT ETH PUT 第 2 页", *resume)
+	}
+	if _, err := os.Stat(outputPath(checkpointFile) + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("临时文件没有被重命名: %v", err)
+	}
+
+	mu.Lock()
+	requested, crash = nil, nil
+	mu.Unlock()
+	logs.Reset()
+	if err := runFullScrape(context.Background(), resume); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	got := strings.Join(requested, " ")
+	mu.Unlock()
+	if want := "PUT/3 CALL/1 CALL/2 CALL/3"; got != want {
+		t.Errorf("重启后请求的页 = %s, want %s", got, want)
+	}
+	for _, page := range []string{"PUT/1", "PUT/2"} {
+		if strings.Contains(logs.String(), page) {
+			t.Errorf("重启后重复写入了 %s", page)
+		}
+	}
+
+	resume, err = loadCheckpoint(outputPath(checkpointFile))
+	if err != nil || resume == nil || resume.OptionType != "CALL" || resume.Page != 3 {
+		t.Errorf("完成后断点 = %+v, %v, want CALL 第 3 页", resume, err)
+	}
+}
+
+// product 子命令：在模拟的列表接口中按ID找到产品，请求带签名，结果缓存后不再请求，-refresh 时重新请求
+func TestFetchProductDetail(t *testing.T) {
+	var requests atomic.Int32
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sapi/v1/dci/product/list" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		query := r.URL.Query()
+		if r.Header.Get("X-MBX-APIKEY") != "key" || query.Get("signature") == "" || query.Get("timestamp") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code":-2014,"msg":"API-key format invalid."}`)
+			return
+		}
+		switch query.Get("optionType") + "/" + query.Get("pageIndex") {
+		case "PUT/1":
+			fmt.Fprint(w, `{"total":2,"list":[{"id":"111","investCoin":"USDT","exercisedCoin":"ETH","strikePrice":"1900","duration":1,"apr":"0.5","optionType":"PUT"}]}`)
+		case "PUT/2":
+			fmt.Fprint(w, `{"total":2,"list":[{"id":"222","investCoin":"USDT","exercisedCoin":"ETH","strikePrice":"1800","duration":3,"apr":"0.25","canPurchase":true,"optionType":"PUT"}]}`)
+		default:
+			fmt.Fprint(w, `{"total":2,"list":[]}`)
+		}
+	})
+	useScrapeConfig(t, []string{"ETH"}, []string{"CALL", "PUT"})
+
+	product, err := fetchProductDetail(apiKey, signer, "222", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.ID != "222" || product.StrikePrice != "1800" || product.Duration != 3 || product.APR != "0.25" || !product.CanPurchase {
+		t.Errorf("product = %+v", *product)
+	}
+	// CALL 第 1 页（空）、PUT 第 1、2 页
+	if n := requests.Load(); n != 3 {
+		t.Errorf("请求了 %d 页, want 3", n)
+	}
+	if _, err := os.Stat(outputPath(filepath.Join(productCacheDir, "222.json"))); err != nil {
+		t.Errorf("没有写入缓存: %v", err)
+	}
+
+	cached, err := fetchProductDetail(apiKey, signer, "222", false)
+	if err != nil || !reflect.DeepEqual(cached, product) {
+		t.Fatalf("读取缓存: %+v, %v", cached, err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("有缓存时仍请求了接口（共 %d 次）", n)
+	}
+	if _, err := fetchProductDetail(apiKey, signer, "222", true); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 6 {
+		t.Errorf("-refresh 后共请求 %d 次, want 6", n)
+	}
+
+	if _, err := fetchProductDetail(apiKey, signer, "999", false); err == nil || !strings.Contains(err.Error(), "未找到产品 999") {
+		t.Errorf("不存在的产品: err = %v", err)
+	}
+}
+
+// 接口返回 Binance 错误时得到 *APIError
+func TestFetchProductDetailAPIError(t *testing.T) {
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"code":-1022,"msg":"Signature for this request is not valid."}`)
+	})
+	useScrapeConfig(t, []string{"ETH"}, []string{"PUT"})
+
+	_, err := fetchProductDetail(apiKey, signer, "222", true)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != -1022 {
+		t.Fatalf("err = %v, want *APIError -1022", err)
+	}
+}
+
+// 接口中的数值字段是字符串，解析为 float64；格式错误时返回错误而不是0
+func TestProductNumericFields(t *testing.T) {
+	data := `{"id":"123","investCoin":"USDT","exercisedCoin":"ETH","strikePrice":"1850.5","duration":7,
+		"apr":"0.4215","minAmount":"0.1","maxAmount":"250000","optionType":"PUT"}`
+	var product Product
+	if err := json.Unmarshal([]byte(data), &product); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		parse func() (float64, error)
+		want  float64
+	}{
+		{"APR", product.APRFloat, 0.4215},
+		{"StrikePrice", product.StrikePriceFloat, 1850.5},
+		{"MinAmount", product.MinAmountFloat, 0.1},
+		{"MaxAmount", product.MaxAmountFloat, 250000},
+	} {
+		got, err := tc.parse()
+		if err != nil || got != tc.want {
+			t.Errorf("%s = %v, %v, want %v", tc.name, got, err, tc.want)
+		}
+	}
+	// 原始字符串保留，需要精确金额时直接使用
+	if product.APR != "0.4215" || product.MinAmount != "0.1" {
+		t.Errorf("原始字符串 APR=%q MinAmount=%q", product.APR, product.MinAmount)
+	}
+
+	product.StrikePrice = ""
+	if _, err := product.StrikePriceFloat(); err == nil {
+		t.Error("空的 strikePrice 应返回错误")
+	}
+	product.APR = "N/A"
+	if _, err := product.APRFloat(); err == nil {
+		t.Error("无法解析的 apr 应返回错误")
+	}
+}
+
+// 非 USDT 稳定币：CALL 的行权币是稳定币、投资币是 coin，PUT 相反；请求参数中带上对应的币种
+func TestDCICoinsNonUSDTStable(t *testing.T) {
+	for _, tc := range []struct {
+		optionType, coin, stable string
+		exercised, invest        string
+	}{
+		{"CALL", "ETH", "USDC", "USDC", "ETH"},
+		{"PUT", "ETH", "USDC", "ETH", "USDC"},
+		{"CALL", "BTC", "FDUSD", "FDUSD", "BTC"},
+		{"PUT", "BTC", "FDUSD", "BTC", "FDUSD"},
+		{"PUT", "ETH", "USDT", "ETH", "USDT"},
+	} {
+		exercised, invest := dciCoins(tc.optionType, tc.coin, tc.stable)
+		if exercised != tc.exercised || invest != tc.invest {
+			t.Errorf("dciCoins(%s, %s, %s) = %s, %s, want %s, %s",
+				tc.optionType, tc.coin, tc.stable, exercised, invest, tc.exercised, tc.invest)
+		}
+	}
+
+	var mu sync.Mutex
+	var got []string
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		mu.Lock()
+		got = append(got, query.Get("optionType")+":"+query.Get("exercisedCoin")+"/"+query.Get("investCoin"))
+		mu.Unlock()
+		fmt.Fprint(w, `{"total":0,"list":[]}`)
+	})
+	for _, optionType := range []string{"CALL", "PUT"} {
+		if _, err := fetchPageRaw(context.Background(), "key", HMACSigner{secret: "secret"}, optionType, "ETH", "USDC", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "CALL:USDC/ETH PUT:ETH/USDC"; strings.Join(got, " ") != want {
+		t.Errorf("请求参数 = %v, want %s", got, want)
+	}
+
+	for _, stable := range []string{"USDT", "USDC", "FDUSD"} {
+		if err := validateStableCoin(stable); err != nil {
+			t.Errorf("validateStableCoin(%s) = %v", stable, err)
+		}
+	}
+	if err := validateStableCoin("BUSD"); err == nil {
+		t.Error("validateStableCoin(BUSD) 应返回错误")
+	}
+}
+
+// 交易所没有 coin+稳定币 交易对时跳过该币种，不请求它的 DCI 产品
+func TestRunFullScrapeSkipsUnsupportedPair(t *testing.T) {
+	var mu sync.Mutex
+	var scraped []string
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/exchangeInfo":
+			fmt.Fprint(w, `{"symbols":[{"symbol":"ETHFDUSD","status":"TRADING"},{"symbol":"WBETHUSDT","status":"TRADING"}]}`)
+		case "/api/v3/ticker/price":
+			fmt.Fprintf(w, `{"symbol":"%s","price":"2000.00"}`, r.URL.Query().Get("symbol"))
+		case "/sapi/v1/dci/product/list":
+			query := r.URL.Query()
+			mu.Lock()
+			scraped = append(scraped, query.Get("exercisedCoin")+"/"+query.Get("investCoin"))
+			mu.Unlock()
+			fmt.Fprint(w, `{"total":0,"list":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	useScrapeConfig(t, []string{"ETH", "WBETH"}, []string{"PUT"})
+	defer func(old string) { stableCoin = old }(stableCoin)
+	stableCoin = "FDUSD"
+
+	if err := runFullScrape(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(scraped, " "); got != "ETH/FDUSD" {
+		t.Errorf("抓取了 %s, want ETH/FDUSD", got)
+	}
+	if !strings.Contains(logs.String(), "跳过 WBETHFDUSD") {
+		t.Errorf("没有记录跳过 WBETHFDUSD:\n%s", logs.String())
+	}
+}
+
+// -product-type 只抓取选定的期权类型：PUT 或 CALL 时只请求该类型，BOTH 两种都请求；其他值报错
+func TestProductTypeFiltersScrape(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/exchangeInfo":
+			fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT","status":"TRADING"}]}`)
+		case "/api/v3/ticker/price":
+			fmt.Fprint(w, `{"symbol":"ETHUSDT","price":"2000.00"}`)
+		case "/sapi/v1/dci/product/list":
+			mu.Lock()
+			requested = append(requested, r.URL.Query().Get("optionType"))
+			mu.Unlock()
+			fmt.Fprint(w, `{"total":0,"list":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	useScrapeConfig(t, []string{"ETH"}, nil)
+	defer func(old string) { stableCoin = old }(stableCoin)
+	stableCoin = "USDT"
+
+	for _, tc := range []struct{ value, want string }{
+		{"PUT", "PUT"},
+		{"call", "CALL"},
+		{" Both ", "PUT CALL"},
+	} {
+		types, err := parseProductType(tc.value)
+		if err != nil {
+			t.Fatalf("parseProductType(%q): %v", tc.value, err)
+		}
+		optionTypes = types
+		requested = nil
+		if err := runFullScrape(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(requested, " "); got != tc.want {
+			t.Errorf("-product-type %q 请求了 %q, want %q", tc.value, got, tc.want)
+		}
+	}
+
+	for _, value := range []string{"", "PUTS", "ALL"} {
+		if _, err := parseProductType(value); err == nil {
+			t.Errorf("parseProductType(%q) 应返回错误", value)
+		}
+	}
+}
+
+// 维护期间 DCI 接口返回 503 和 HTML 页面：本轮抓取立即停止并返回 *MaintenanceError，
+// 不重试、不继续请求其他期权类型，也不按解析失败处理
+func TestRunFullScrapeMaintenance(t *testing.T) {
+	var mu sync.Mutex
+	dciRequests := 0
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/exchangeInfo":
+			fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT","status":"TRADING"}]}`)
+		case "/api/v3/ticker/price":
+			fmt.Fprint(w, `{"symbol":"ETHUSDT","price":"2000.00"}`)
+		case "/sapi/v1/dci/product/list":
+			mu.Lock()
+			dciRequests++
+			mu.Unlock()
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "<html><body><h1>System maintenance</h1></body></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	useScrapeConfig(t, []string{"ETH"}, []string{"PUT", "CALL"})
+	defer func(old string) { stableCoin = old }(stableCoin)
+	stableCoin = "USDT"
+
+	err := runFullScrape(context.Background(), nil)
+	var maintenance *MaintenanceError
+	if !errors.As(err, &maintenance) || maintenance.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("runFullScrape 返回 %v, want *MaintenanceError(503)", err)
+	}
+	if dciRequests != 1 {
+		t.Errorf("维护期间请求了 %d 次 DCI 接口, want 1", dciRequests)
+	}
+}
+
+// 503 和返回 HTML 的 5xx 视为维护；其他非 JSON 响应是普通错误；JSON（包括 code/msg 错误）照常返回
+func TestCheckResponseBody(t *testing.T) {
+	for _, tc := range []struct {
+		status      int
+		body        string
+		maintenance bool
+		err         bool
+	}{
+		{503, "<html>maintenance</html>", true, true},
+		{503, `{"code":-1008,"msg":"busy"}`, true, true},
+		{502, "  <!DOCTYPE html>", true, true},
+		{500, "internal error", false, true},
+		{200, "", false, true},
+		{200, `{"total":0,"list":[]}`, false, false},
+		{400, `{"code":-1102,"msg":"bad param"}`, false, false},
+		{200, `[1,2]`, false, false},
+	} {
+		err := checkResponseBody(tc.status, []byte(tc.body))
+		var maintenance *MaintenanceError
+		if errors.As(err, &maintenance) != tc.maintenance || (err != nil) != tc.err {
+			t.Errorf("checkResponseBody(%d, %q) = %v", tc.status, tc.body, err)
+		}
+	}
+}
+
+// -split-output 时每个 (币种, 期权类型) 的产品写入各自的文件，每行一个产品，保持接口返回的 JSON
+func TestSplitOutputPerPairFiles(t *testing.T) {
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/exchangeInfo":
+			fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT","status":"TRADING"},{"symbol":"BTCUSDT","status":"TRADING"}]}`)
+		case "/api/v3/ticker/price":
+			fmt.Fprintf(w, `{"symbol":"%s","price":"2000.00"}`, r.URL.Query().Get("symbol"))
+		case "/sapi/v1/dci/product/list":
+			query := r.URL.Query()
+			if query.Get("pageIndex") != "1" {
+				fmt.Fprint(w, `{"total":2,"list":[]}`)
+				return
+			}
+			coin, optionType := query.Get("exercisedCoin"), query.Get("optionType")
+			if optionType == "CALL" {
+				coin = query.Get("investCoin")
+			}
+			fmt.Fprintf(w, `{"total":2,"list":[{"id":"%s-%s-1","optionType":"%s"},{"id":"%s-%s-2","optionType":"%s"}]}`,
+				coin, optionType, optionType, coin, optionType, optionType)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	useScrapeConfig(t, []string{"ETH", "BTC"}, []string{"PUT", "CALL"})
+	defer func(old string) { stableCoin = old }(stableCoin)
+	stableCoin = "USDT"
+	defer func() { pairOutputs = nil }()
+	pairOutputs = newPairWriters(LoggerConfig{MaxSize: 1})
+
+	if err := runFullScrape(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, coin := range []string{"ETH", "BTC"} {
+		for _, optionType := range []string{"PUT", "CALL"} {
+			data, err := os.ReadFile(filepath.Join(outputDir, pairFileName(coin, optionType)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := fmt.Sprintf(`{"id":"%s-%s-1","optionType":"%s"}`+"\n"+`{"id":"%s-%s-2","optionType":"%s"}`+"\n",
+				coin, optionType, optionType, coin, optionType, optionType)
+			if string(data) != want {
+				t.Errorf("%s:\n%s\nwant:\n%s", pairFileName(coin, optionType), data, want)
+			}
+		}
+	}
+}
+
+// 看门狗：DCI 请求卡住超过一轮的截止时间时本轮被取消并返回 DeadlineExceeded，
+// 被取消的请求不计入熔断器失败，下一轮抓取照常完成
+func TestRunScrapeWithDeadlineCancelsHungCycle(t *testing.T) {
+	var hang atomic.Bool
+	hang.Store(true)
+	var completed atomic.Int32
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/exchangeInfo":
+			fmt.Fprint(w, `{"symbols":[{"symbol":"ETHUSDT","status":"TRADING"}]}`)
+		case "/api/v3/ticker/price":
+			fmt.Fprint(w, `{"symbol":"ETHUSDT","price":"2000.00"}`)
+		case "/sapi/v1/dci/product/list":
+			if hang.Load() {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+				return
+			}
+			completed.Add(1)
+			fmt.Fprint(w, `{"total":0,"list":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	useScrapeConfig(t, []string{"ETH"}, []string{"PUT"})
+	defer func(old string) { stableCoin = old }(stableCoin)
+	stableCoin = "USDT"
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	start := time.Now()
+	err := runScrapeWithDeadline(200*time.Millisecond, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("卡住的一轮返回 %v, 期望 context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("看门狗 %v 后才返回，期望在截止时间附近取消", elapsed)
+	}
+
+	hang.Store(false)
+	if err := runScrapeWithDeadline(2*time.Second, nil); err != nil {
+		t.Fatalf("下一轮抓取失败: %v", err)
+	}
+	if completed.Load() == 0 {
+		t.Error("下一轮抓取没有请求 DCI 接口")
+	}
+	if err := scrapeBreaker.Allow(); err != nil {
+		t.Errorf("取消的请求不应使熔断器打开: %v", err)
+	}
+}
+
+// 公开接口不带签名、时间戳和 X-MBX-APIKEY 请求头，参数照常放在查询字符串中；
+// 同样配置下的签名请求两者都带上
+func TestPublicGetSendsNoCredentials(t *testing.T) {
+	type seen struct {
+		query  url.Values
+		apiKey string
+	}
+	requests := make(chan seen, 2)
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests <- seen{r.URL.Query(), r.Header.Get("X-MBX-APIKEY")}
+		fmt.Fprint(w, `{"symbol":"ETHUSDT","price":"2000.00"}`)
+	})
+	useScrapeConfig(t, nil, nil)
+
+	body, err := publicGet(context.Background(), "/api/v3/ticker/price", map[string]string{"symbol": "ETHUSDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "2000.00") {
+		t.Errorf("publicGet 返回 %s", body)
+	}
+	public := <-requests
+	if public.apiKey != "" {
+		t.Errorf("publicGet 发送了 X-MBX-APIKEY: %q", public.apiKey)
+	}
+	for _, key := range []string{"signature", "timestamp", "recvWindow"} {
+		if public.query.Has(key) {
+			t.Errorf("publicGet 的查询字符串包含 %s: %s", key, public.query.Encode())
+		}
+	}
+	if public.query.Get("symbol") != "ETHUSDT" {
+		t.Errorf("publicGet 没有发送 symbol 参数: %s", public.query.Encode())
+	}
+
+	if _, err := signedGet(context.Background(), apiKey, signer, "/api/v3/ticker/price", map[string]string{"symbol": "ETHUSDT"}); err != nil {
+		t.Fatal(err)
+	}
+	signed := <-requests
+	if signed.apiKey != "key" || !signed.query.Has("signature") || !signed.query.Has("timestamp") {
+		t.Errorf("signedGet 应带 API Key 和签名: key=%q query=%s", signed.apiKey, signed.query.Encode())
+	}
+}
+
+// 诊断签名：模拟接口按服务端的 Secret Key 验签，正确的凭证通过，错误的 Secret Key 返回 -1022
+// 并归因于凭证而不是签名代码；诊断结果中不包含 Secret Key
+func TestDiagnoseSignature(t *testing.T) {
+	const serverSecret = "server-secret"
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != signatureCheckPath {
+			http.NotFound(w, r)
+			return
+		}
+		unsigned, signature, _ := strings.Cut(r.URL.RawQuery, "&signature=")
+		if r.Header.Get("X-MBX-APIKEY") != "key" || signature != hmacSignature(unsigned, serverSecret) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":-1022,"msg":"Signature for this request is not valid."}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":"Normal"}`)
+	})
+
+	d := diagnoseSignature(context.Background(), "key", HMACSigner{secret: serverSecret})
+	if !d.CodeOK || !d.OK || d.Code != 0 {
+		t.Errorf("正确的凭证诊断结果 %+v, 期望通过", d)
+	}
+
+	const wrongSecret = "wrong-secret"
+	d = diagnoseSignature(context.Background(), "key", HMACSigner{secret: wrongSecret})
+	if !d.CodeOK || d.OK || d.Code != -1022 {
+		t.Fatalf("错误的 Secret Key 诊断结果 %+v, 期望签名代码通过、接口返回 -1022", d)
+	}
+	if !strings.Contains(d.Cause, "Secret Key") || !strings.Contains(d.Message, "not valid") {
+		t.Errorf("-1022 应归因于 Secret Key 并带上接口的错误信息: %+v", d)
+	}
+	if text := fmt.Sprintf("%+v", d); strings.Contains(text, wrongSecret) {
+		t.Errorf("诊断结果中包含 Secret Key: %s", text)
+	}
+}
+
+// 两种签名方式对同一查询字符串签名：HMAC 复现文档示例的 64 位十六进制签名，
+// Ed25519 输出 base64 的 64 字节签名并能用公钥验证；签名在查询字符串中经过URL编码，
+// BINANCE_KEY_TYPE 按配置选择签名方式
+func TestSigners(t *testing.T) {
+	hmacSig := HMACSigner{secret: signatureExampleSecret}.Sign(signatureExampleQuery)
+	if hmacSig != signatureExampleSignature {
+		t.Errorf("HMAC 签名 = %s, want %s", hmacSig, signatureExampleSignature)
+	}
+
+	edKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	edSigner := Ed25519Signer{key: edKey}
+	edSig := edSigner.Sign(signatureExampleQuery)
+	raw, err := base64.StdEncoding.DecodeString(edSig)
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		t.Fatalf("Ed25519 签名 %q 不是 %d 字节的 base64: %v", edSig, ed25519.SignatureSize, err)
+	}
+	if !ed25519.Verify(edKey.Public().(ed25519.PublicKey), []byte(signatureExampleQuery), raw) {
+		t.Error("Ed25519 签名无法用公钥验证")
+	}
+	if edSigner.Sign(signatureExampleQuery) != edSig {
+		t.Error("Ed25519 签名应是确定的")
+	}
+
+	for _, signer := range []Signer{HMACSigner{secret: "secret"}, edSigner} {
+		query := getSignedQueryString(map[string]string{"symbol": "ETHUSDT"}, signer)
+		unsigned, _, _ := strings.Cut(query, "&signature=")
+		values, err := url.ParseQuery(query)
+		if err != nil || values.Get("signature") != signer.Sign(unsigned) {
+			t.Errorf("%T: 查询字符串 %s 中的签名与参数不符", signer, query)
+		}
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ed25519.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BINANCE_API_KEY", "key")
+	t.Setenv("BINANCE_SECRET_KEY", "secret")
+	t.Setenv("BINANCE_PRIVATE_KEY_FILE", keyFile)
+	for _, tc := range []struct {
+		keyType string
+		want    Signer
+	}{
+		{"", HMACSigner{secret: "secret"}},
+		{"HMAC", HMACSigner{secret: "secret"}},
+		{"ed25519", edSigner},
+	} {
+		t.Setenv("BINANCE_KEY_TYPE", tc.keyType)
+		_, signer, err := loadCredentials()
+		if err != nil || !reflect.DeepEqual(signer, tc.want) {
+			t.Errorf("BINANCE_KEY_TYPE=%q: signer = %T, err = %v", tc.keyType, signer, err)
+		}
+	}
+	t.Setenv("BINANCE_KEY_TYPE", "rsa")
+	if _, _, err := loadCredentials(); err == nil {
+		t.Error("未知的 BINANCE_KEY_TYPE 应返回错误")
+	}
+}
+
+// -recv-window 和 -recv-window-endpoint 设置的 recvWindow 出现在签名的参数中，单独设置的接口优先；
+// 超过 60000 或格式错误时报错且不改变当前设置
+func TestRecvWindowConfig(t *testing.T) {
+	defer func(window int, endpoints map[string]int) {
+		recvWindow, endpointRecvWindows = window, endpoints
+	}(recvWindow, endpointRecvWindows)
+
+	windows := make(chan string, 2)
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		unsigned, _, _ := strings.Cut(r.URL.RawQuery, "&signature=")
+		values, _ := url.ParseQuery(unsigned)
+		windows <- r.URL.Path + "=" + values.Get("recvWindow")
+		fmt.Fprint(w, `{}`)
+	})
+
+	apply := func(args ...string) error {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := registerRecvWindowFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		return f.Apply()
+	}
+	if err := apply(); err != nil || recvWindow != defaultRecvWindow {
+		t.Fatalf("默认 recvWindow = %d, err = %v, want %d", recvWindow, err, defaultRecvWindow)
+	}
+	if err := apply("-recv-window", "20000", "-recv-window-endpoint", "/sapi/v1/slow=60000"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/sapi/v1/fast", "/sapi/v1/slow"} {
+		if _, err := signedGet(context.Background(), "key", HMACSigner{secret: "secret"}, path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := []string{<-windows, <-windows}; got[0] != "/sapi/v1/fast=20000" || got[1] != "/sapi/v1/slow=60000" {
+		t.Errorf("签名参数中的 recvWindow = %v, want [/sapi/v1/fast=20000 /sapi/v1/slow=60000]", got)
+	}
+
+	for _, args := range [][]string{
+		{"-recv-window", "60001"},
+		{"-recv-window", "0"},
+		{"-recv-window-endpoint", "/sapi/v1/slow=70000"},
+		{"-recv-window-endpoint", "sapi/v1/slow=10000"},
+		{"-recv-window-endpoint", "/sapi/v1/slow"},
+	} {
+		if err := apply(args...); err == nil {
+			t.Errorf("%v 应返回错误", args)
+		}
+	}
+	if recvWindow != 20000 || endpointRecvWindow("/sapi/v1/slow") != 60000 {
+		t.Errorf("参数无效时改变了设置: recvWindow = %d, /sapi/v1/slow = %d", recvWindow, endpointRecvWindow("/sapi/v1/slow"))
+	}
+}
+
+// help <子命令> 输出该子命令的说明、示例和全部参数；help 列出每个子命令及示例；
+// 子命令遇到未知参数时输出该子命令（而不是其他子命令）的用法并以状态 2 退出
+func TestSubcommandHelp(t *testing.T) {
+	if testing.Short() {
+		t.Skip("-short 时跳过编译工具")
+	}
+	binary := filepath.Join(t.TempDir(), "binance")
+	if out, err := exec.Command("go", "build", "-o", binary, "main.go").CombinedOutput(); err != nil {
+		t.Fatalf("编译失败: %v\n%s", err, out)
+	}
+	run := func(args ...string) (string, int) {
+		out, err := exec.Command(binary, args...).CombinedOutput()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return string(out), exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out), 0
+	}
+
+	out, code := run("help", "ivrank")
+	if code != 0 {
+		t.Fatalf("help ivrank 退出状态 %d:\n%s", code, out)
+	}
+	ivrank := findCommand("ivrank")
+	for _, want := range []string{ivrank.Summary, "示例: " + ivrank.Example, "-days", "-current", "-band-pct", "-min-history", "-top", "-output-dir"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("help ivrank 缺少 %q:\n%s", want, out)
+		}
+	}
+
+	out, code = run("help")
+	if code != 0 {
+		t.Fatalf("help 退出状态 %d:\n%s", code, out)
+	}
+	for _, cmd := range commands {
+		if cmd.Name != "" && (!strings.Contains(out, cmd.Name) || !strings.Contains(out, cmd.Example)) {
+			t.Errorf("help 缺少子命令 %s 或其示例:\n%s", cmd.Name, out)
+		}
+	}
+
+	out, code = run("ivrank", "-no-such-flag")
+	if code != 2 || !strings.Contains(out, "-no-such-flag") || !strings.Contains(out, "-band-pct") || strings.Contains(out, "-min-apr") {
+		t.Errorf("ivrank 未知参数: 退出状态 %d, 应输出 ivrank 的用法:\n%s", code, out)
+	}
+
+	out, code = run("no-such-command")
+	if code != 2 || !strings.Contains(out, "未知的子命令") {
+		t.Errorf("未知子命令: 退出状态 %d:\n%s", code, out)
+	}
+}
+
+// 模拟 userDataStream 接口和用户数据流：记录 listenKey 的创建、续期和关闭请求，
+// WebSocket 连接后调用 onConnect 推送事件
+type userStreamMock struct {
+	mu          sync.Mutex
+	requests    []string
+	created     int
+	rejectRenew bool
+}
+
+func (m *userStreamMock) record(r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := r.Method
+	if key := r.URL.Query().Get("listenKey"); key != "" {
+		entry += " " + key
+	}
+	if r.Header.Get("X-MBX-APIKEY") != "key" {
+		entry += " (没有 API Key)"
+	}
+	m.requests = append(m.requests, entry)
+}
+
+func (m *userStreamMock) Requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.requests...)
+}
+
+func (m *userStreamMock) count(entry string) int {
+	n := 0
+	for _, r := range m.Requests() {
+		if r == entry {
+			n++
+		}
+	}
+	return n
+}
+
+func startUserStreamMock(t *testing.T, onConnect func(conn *websocket.Conn)) *userStreamMock {
+	mock := &userStreamMock{}
+	server := startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/ws/") {
+			upgrader := websocket.Upgrader{}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			onConnect(conn)
+			return
+		}
+		if r.URL.Path != userDataStreamPath {
+			http.NotFound(w, r)
+			return
+		}
+		mock.record(r)
+		mock.mu.Lock()
+		defer mock.mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			mock.created++
+			fmt.Fprintf(w, `{"listenKey":"key-%d"}`, mock.created)
+		case http.MethodPut:
+			if mock.rejectRenew {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"code":-1125,"msg":"This listenKey does not exist."}`)
+				return
+			}
+			fmt.Fprint(w, `{}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	})
+
+	oldURL, oldInterval := userStreamBaseURL, listenKeyKeepaliveInterval
+	userStreamBaseURL = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/"
+	listenKeyKeepaliveInterval = 20 * time.Millisecond
+	t.Cleanup(func() {
+		userStreamBaseURL, listenKeyKeepaliveInterval = oldURL, oldInterval
+	})
+	return mock
+}
+
+// 创建 listenKey 后定时续期，账户事件转发出去，收到 listenKeyExpired 时返回并关闭 listenKey
+func TestUserDataStreamKeepalive(t *testing.T) {
+	var mock *userStreamMock
+	mock = startUserStreamMock(t, func(conn *websocket.Conn) {
+		// 等客户端续期两次后再推送
+		deadline := time.Now().Add(5 * time.Second)
+		for mock.count("PUT key-1") < 2 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"e":"balanceUpdate","E":1767225600000,"a":"USDT","d":"-100"}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"e":"listenKeyExpired","E":1767225660000}`))
+		conn.ReadMessage()
+	})
+
+	events := make(chan AccountEvent, 10)
+	err := streamUserData("key", events)
+	if err == nil || !strings.Contains(err.Error(), "listenKey 已失效") {
+		t.Fatalf("streamUserData 返回 %v, want listenKey 已失效", err)
+	}
+
+	close(events)
+	var got []string
+	for event := range events {
+		got = append(got, fmt.Sprintf("%s %d", event.EventType, event.EventTime))
+		if !strings.Contains(string(event.Raw), `"a":"USDT"`) {
+			t.Errorf("事件原文 = %s", event.Raw)
+		}
+	}
+	if want := "balanceUpdate 1767225600000"; strings.Join(got, ",") != want {
+		t.Errorf("转发的事件 = %v, want %s", got, want)
+	}
+
+	requests := mock.Requests()
+	if len(requests) < 4 || requests[0] != "POST" || requests[len(requests)-1] != "DELETE key-1" {
+		t.Fatalf("请求 = %v, want POST、至少两次 PUT key-1、DELETE key-1", requests)
+	}
+	for _, r := range requests[1 : len(requests)-1] {
+		if r != "PUT key-1" {
+			t.Errorf("续期请求 = %q, want PUT key-1", r)
+		}
+	}
+}
+
+// 续期失败（listenKey 已失效）时断开连接并关闭旧的 listenKey，下一次重新创建新的 listenKey
+func TestUserDataStreamRenewFailure(t *testing.T) {
+	mock := startUserStreamMock(t, func(conn *websocket.Conn) {
+		// 一直等到客户端断开
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	mock.rejectRenew = true
+
+	events := make(chan AccountEvent, 10)
+	done := make(chan error, 1)
+	go func() { done <- streamUserData("key", events) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("续期失败后 streamUserData 应返回错误")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("续期失败后没有断开连接")
+	}
+	if got, want := strings.Join(mock.Requests(), ","), "POST,PUT key-1,DELETE key-1"; got != want {
+		t.Errorf("请求 = %s, want %s", got, want)
+	}
+
+	key, err := createListenKey("key")
+	if err != nil || key != "key-2" {
+		t.Errorf("重新创建 listenKey = %q, %v, want key-2", key, err)
+	}
+	var apiErr *APIError
+	if err := keepaliveListenKey("key", "key-1"); !errors.As(err, &apiErr) || apiErr.Code != -1125 {
+		t.Errorf("续期失效的 listenKey: err = %v, want *APIError -1125", err)
+	}
+}
+
+// 续期请求卡住时按 userDataStreamTimeout 超时返回错误，续期协程不会一直等待；超时不计入熔断器的失败
+func TestUserDataStreamRequestTimeout(t *testing.T) {
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	})
+	oldTimeout := userDataStreamTimeout
+	userDataStreamTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { userDataStreamTimeout = oldTimeout })
+	scrapeBreaker = newCircuitBreaker(1, time.Minute)
+
+	done := make(chan error, 1)
+	go func() { done <- keepaliveListenKey("key", "key-1") }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("续期卡住时返回 %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("续期请求卡住时没有超时返回")
+	}
+	if err := closeListenKey("key", "key-1"); err != nil {
+		t.Errorf("超时之后关闭 listenKey 失败（熔断器不应打开）: %v", err)
+	}
+}
+
+// -stdout 时同一条日志既写入滚动日志文件也写到终端；不 tee（未指定 -stdout 或指定了 -q）时只写文件
+func TestLogWriterTee(t *testing.T) {
+	defer func(old string) { outputDir = old }(outputDir)
+	outputDir = t.TempDir()
+
+	for _, tee := range []bool{true, false} {
+		name := fmt.Sprintf("tee_%v.log", tee)
+		file := newLogFile(LoggerConfig{MaxSize: 1}, name)
+		var stdout bytes.Buffer
+		var w io.Writer = logWriter(file, nil)
+		if tee {
+			w = logWriter(file, &stdout)
+		}
+		logger := log.New(w, "", 0)
+		logger.Println(`{"id":"111","apr":"0.5"}`)
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{"id":"111","apr":"0.5"}` + "\n"
+		if string(data) != want {
+			t.Errorf("tee=%v: 日志文件内容 %q, want %q", tee, data, want)
+		}
+		if tee && stdout.String() != want {
+			t.Errorf("终端输出 %q, want %q", stdout.String(), want)
+		}
+	}
+}
+
+// 接口持续返回 5xx 时连续失败达到阈值后熔断器打开，冷却期内的请求不再发出；
+// 冷却结束后半开只放行一个探测请求，探测失败重新打开，接口恢复后探测成功即恢复正常；4xx 不计为失败
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	const cooldown = 50 * time.Millisecond
+	breaker := newCircuitBreaker(3, cooldown)
+	do := func() error {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := breaker.Do(req, nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	isOpen := func(err error) bool {
+		var open *CircuitOpenError
+		return errors.As(err, &open)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := do(); err != nil {
+			t.Fatalf("第 %d 次请求: %v", i+1, err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := do(); !isOpen(err) {
+			t.Fatalf("熔断器打开后请求返回 %v, want CircuitOpenError", err)
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("熔断期间发出了请求: 共 %d 次, want 3", n)
+	}
+	if !strings.Contains(logs.String(), "circuit open") {
+		t.Errorf("没有记录 circuit open:\n%s", logs.String())
+	}
+
+	// 冷却结束后探测仍然失败，重新打开
+	time.Sleep(cooldown + 10*time.Millisecond)
+	if err := do(); err != nil {
+		t.Fatalf("半开时的探测请求: %v", err)
+	}
+	if err := do(); !isOpen(err) {
+		t.Fatalf("探测失败后请求返回 %v, want CircuitOpenError", err)
+	}
+
+	// 接口恢复，探测成功后正常请求；4xx 说明接口可达，不会让熔断器打开
+	status.Store(http.StatusBadRequest)
+	time.Sleep(cooldown + 10*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if err := do(); err != nil {
+			t.Fatalf("恢复后第 %d 次请求: %v", i+1, err)
+		}
+	}
+	if n := requests.Load(); n != 9 {
+		t.Errorf("共发出 %d 次请求, want 9", n)
+	}
+	if !strings.Contains(logs.String(), "熔断器恢复") {
+		t.Errorf("没有记录恢复:\n%s", logs.String())
+	}
+}
+
+// 半开状态下探测请求还没有结果时，其他请求仍被拒绝；threshold<=0 时从不熔断
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	breaker := newCircuitBreaker(1, time.Millisecond)
+	breaker.Record(false)
+	time.Sleep(2 * time.Millisecond)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("冷却结束后的探测被拒绝: %v", err)
+	}
+	if err := breaker.Allow(); err == nil {
+		t.Fatal("探测进行中时放行了第二个请求")
+	}
+	breaker.Release()
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("探测被取消后新的探测被拒绝: %v", err)
+	}
+
+	disabled := newCircuitBreaker(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		disabled.Record(false)
+	}
+	if err := disabled.Allow(); err != nil {
+		t.Errorf("threshold=0 时熔断器打开: %v", err)
+	}
+}
+
+// 熔断器打开时请求在预留权重之前就被拒绝：不占用权重，预算用完时也不会等到下一分钟
+func TestOpenBreakerReservesNoWeight(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	var requests atomic.Int32
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprint(w, `{}`)
+	})
+	scrapeBreaker = newCircuitBreaker(1, time.Minute)
+	scrapeBreaker.Record(false)
+
+	isOpen := func(err error) bool {
+		var open *CircuitOpenError
+		return errors.As(err, &open)
+	}
+	if _, err := publicGet(context.Background(), "/api/v3/exchangeInfo", nil); !isOpen(err) {
+		t.Errorf("publicGet = %v, want CircuitOpenError", err)
+	}
+	if _, err := signedRequestOnce(context.Background(), http.MethodGet, "key", HMACSigner{secret: "secret"}, "/sapi/v1/dci/product/list", nil); !isOpen(err) {
+		t.Errorf("signedRequestOnce = %v, want CircuitOpenError", err)
+	}
+	if apiWeights.used != 0 || sapiWeights.used != 0 {
+		t.Errorf("被拒绝的请求占用了权重: api %d, sapi %d", apiWeights.used, sapiWeights.used)
+	}
+
+	// 本分钟预算已用完：熔断器打开时直接返回，而不是先等权重
+	apiWeights.SetBudgetPct(1)
+	if err := apiWeights.Acquire(context.Background(), 60); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := publicGet(context.Background(), "/api/v3/exchangeInfo", nil); !isOpen(err) {
+		t.Errorf("预算用完时 publicGet = %v, want CircuitOpenError", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("熔断器打开时等待了 %v", elapsed)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("熔断期间发出了 %d 次请求", n)
+	}
+}
+
+// 百分位：低于当前值的比例，相等的算一半
+func TestPercentileRank(t *testing.T) {
+	history := []float64{1, 2, 3, 4}
+	for _, tc := range []struct{ value, want float64 }{
+		{0, 0},
+		{2.5, 50},
+		{3, 62.5},
+		{5, 100},
+	} {
+		if got := percentileRank(history, tc.value); got != tc.want {
+			t.Errorf("percentileRank(%v) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+	if got := percentileRank(nil, 1); !math.IsNaN(got) {
+		t.Errorf("历史为空时 = %v, want NaN", got)
+	}
+}
+
+// 日志中 10 次历史报价（APR 0.1 到 1.0，行权价 1900 / 现价 2000 为 -1 档），之后的当前产品按同类历史排名：
+// APR 0.75 高于 7 个历史值为 70%，0.3 高于 2 个、等于 1 个为 25%；另一个期限只有 2 个历史样本，少于 -min-history 不排名
+func TestRankIVSeededHistory(t *testing.T) {
+	defer func(old string) { stableCoin = old }(stableCoin)
+	stableCoin = "USDT"
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	var lines []string
+	logLine := func(at time.Time, msg string) {
+		lines = append(lines, at.Format(logTimeLayout)+" main.go:1: "+msg)
+	}
+	product := func(id string, duration int, apr float64) string {
+		return fmt.Sprintf(`{"total":1,"list":[{"id":"%s","investCoin":"USDT","exercisedCoin":"ETH","strikePrice":"1900",`+
+			`"duration":%d,"apr":"%g","optionType":"PUT"}]}`, id, duration, apr)
+	}
+	for i := 0; i < 10; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		logLine(at, `获取 ETHUSDT 价格成功: {"symbol":"ETHUSDT","price":"2000.00"}`)
+		logLine(at, product(fmt.Sprintf("h%d", i), 3, float64(i+1)/10))
+		if i < 2 {
+			logLine(at, product(fmt.Sprintf("w%d", i), 7, 0.2))
+		}
+	}
+	now := start.Add(12 * time.Hour)
+	logLine(now, `获取 ETHUSDT 价格成功: {"symbol":"ETHUSDT","price":"2000.00"}`)
+	logLine(now, product("A", 3, 0.75))
+	logLine(now, product("C", 3, 0.3))
+	logLine(now, product("W", 7, 0.9))
+
+	path := filepath.Join(t.TempDir(), "binance.log")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	observations, err := readIVObservations([]string{path}, start.Add(-time.Hour), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(observations) != 15 {
+		t.Fatalf("读出 %d 条报价, want 15", len(observations))
+	}
+	if key := observations[0].Key; key != (IVKey{Coin: "ETH", OptionType: "PUT", StrikeBand: -1, Duration: 3}) {
+		t.Errorf("分组键 = %+v", key)
+	}
+
+	ranks := rankIV(observations, time.Minute, 5)
+	var got []string
+	for _, r := range ranks {
+		got = append(got, fmt.Sprintf("%s:%g/%d", r.Observation.Product.ID, r.Percentile, r.HistorySize))
+	}
+	if want := "A:70/10 C:25/10"; strings.Join(got, " ") != want {
+		t.Errorf("排名 = %v, want %s", got, want)
+	}
+}
+
+// 写入超过 -log-max-size 的内容后日志滚动：当前文件不超过上限，-no-compress 时备份保留为可直接查看的 .log
+func TestLogFileRotatesAtMaxSize(t *testing.T) {
+	defer func(old string) { outputDir = old }(outputDir)
+	outputDir = t.TempDir()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := registerLogFlags(fs)
+	if err := fs.Parse([]string{"-log-max-size", "1", "-log-max-backups", "3", "-no-compress"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := flags.Config(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != (LoggerConfig{MaxSize: 1, MaxBackups: 3, Compress: false}) {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+
+	file := newLogFile(cfg, "binance.log")
+	line := []byte(strings.Repeat("x", 1023) + "\n")
+	for i := 0; i < 1536; i++ { // 1.5MB
+		if _, err := file.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(outputDir, "binance.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1024*1024 {
+		t.Errorf("当前日志 %d 字节，超过 1MB 的上限", info.Size())
+	}
+	backups, err := filepath.Glob(filepath.Join(outputDir, "binance-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("备份 = %v, want 一个未压缩的 .log", backups)
+	}
+	backup, err := os.Stat(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Size()+info.Size() != 1536*1024 {
+		t.Errorf("备份和当前日志共 %d + %d 字节, want %d", backup.Size(), info.Size(), 1536*1024)
+	}
+
+	for _, args := range [][]string{{"-log-max-size", "0"}, {"-log-max-backups", "-1"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags := registerLogFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := flags.Config(false); err == nil {
+			t.Errorf("%v 应返回错误", args)
+		}
+	}
+}
+
+// 币种列表文件中的注释和空行被忽略，与 -coins 合并时统一大写并去重，保持首次出现的顺序
+func TestLoadCoinsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coins.txt")
+	content := "# 主流币\nBTC\n\n  eth  \nSOL # 行尾注释\n#DOGE\n\t\nbnb\r\nBTC\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileCoins, err := loadCoinsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"BTC", "eth", "SOL", "bnb", "BTC"}; !reflect.DeepEqual(fileCoins, want) {
+		t.Errorf("loadCoinsFile = %q, want %q", fileCoins, want)
+	}
+
+	merged := mergeCoins(strings.Split("eth,WBETH,,", ","), fileCoins)
+	if want := []string{"ETH", "WBETH", "BTC", "SOL", "BNB"}; !reflect.DeepEqual(merged, want) {
+		t.Errorf("mergeCoins = %q, want %q", merged, want)
+	}
+
+	if _, err := loadCoinsFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("文件不存在时应返回错误")
+	}
+}
+
+// 签名请求带上 API Key 请求头，签名是对其余参数（含 timestamp 和 recvWindow）编码后的串做 HMAC SHA256；
+// GET 和 DELETE 的参数在查询字符串中、没有请求体，POST 的在表单请求体中、没有查询字符串
+func TestSignedRequestSignature(t *testing.T) {
+	type request struct {
+		method, path, payload, apiKey, contentType string
+		elsewhere                                  string // 不该放参数的另一处（POST 的查询字符串，GET/DELETE 的请求体）
+	}
+	requests := make(chan request, 3)
+	startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payload, elsewhere := r.URL.RawQuery, string(body)
+		if r.Method == http.MethodPost {
+			payload, elsewhere = elsewhere, payload
+		}
+		requests <- request{r.Method, r.URL.Path, payload, r.Header.Get("X-MBX-APIKEY"), r.Header.Get("Content-Type"), elsewhere}
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+
+	params := map[string]string{"coin": "ETH", "amount": "1.5"}
+	for _, tc := range []struct {
+		method string
+		call   func() ([]byte, error)
+	}{
+		{http.MethodGet, func() ([]byte, error) {
+			return signedGet(context.Background(), "my-key", HMACSigner{secret: "my-secret"}, "/sapi/v1/test/get", params)
+		}},
+		{http.MethodPost, func() ([]byte, error) {
+			return signedPost(context.Background(), "my-key", HMACSigner{secret: "my-secret"}, "/sapi/v1/test/post", params)
+		}},
+		{http.MethodDelete, func() ([]byte, error) {
+			return signedDelete(context.Background(), "my-key", HMACSigner{secret: "my-secret"}, "/sapi/v1/test/delete", params)
+		}},
+	} {
+		body, err := tc.call()
+		if err != nil || string(body) != `{"ok":true}` {
+			t.Fatalf("%s: body = %s, err = %v", tc.method, body, err)
+		}
+		req := <-requests
+		if req.method != tc.method || req.apiKey != "my-key" {
+			t.Errorf("%s: 收到 %s 请求, X-MBX-APIKEY = %q", tc.method, req.method, req.apiKey)
+		}
+		if tc.method == http.MethodPost && req.contentType != "application/x-www-form-urlencoded" {
+			t.Errorf("POST Content-Type = %q", req.contentType)
+		}
+		if req.elsewhere != "" {
+			t.Errorf("%s: 参数放错了位置: %s", tc.method, req.elsewhere)
+		}
+
+		unsigned, signature, ok := strings.Cut(req.payload, "&signature=")
+		if !ok {
+			t.Fatalf("%s: 参数中没有签名: %s", tc.method, req.payload)
+		}
+		mac := hmac.New(sha256.New, []byte("my-secret"))
+		mac.Write([]byte(unsigned))
+		if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+			t.Errorf("%s: signature = %s, want %s", tc.method, signature, want)
+		}
+		values, err := url.ParseQuery(unsigned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values.Get("coin") != "ETH" || values.Get("amount") != "1.5" || values.Get("timestamp") == "" || values.Get("recvWindow") == "" {
+			t.Errorf("%s: 签名的参数 %v", tc.method, values)
+		}
+	}
+}
+
+// 第一次请求失败（网络问题或 -1021）后等待重试，重试时用新的时间戳重新签名，而不是重放旧的签名 URL；
+// -1021 时先同步服务器时间；POST 遇到网络错误不重试
+func TestSignedRequestRetryResigns(t *testing.T) {
+	if testing.Short() {
+		t.Skip("-short 时跳过重试等待")
+	}
+	for _, tc := range []struct {
+		name     string
+		method   string
+		first    func(w http.ResponseWriter)
+		requests int
+		synced   bool
+	}{
+		{"网络错误", http.MethodGet, func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "upstream connect error")
+		}, 2, false},
+		{"-1021", http.MethodGet, func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":-1021,"msg":"Timestamp for this request is outside of the recvWindow."}`)
+		}, 2, true},
+		{"POST 网络错误", http.MethodPost, func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "upstream connect error")
+		}, 1, false},
+	} {
+		var mu sync.Mutex
+		var payloads []string
+		synced := false
+		startTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v3/time" {
+				mu.Lock()
+				synced = true
+				mu.Unlock()
+				fmt.Fprintf(w, `{"serverTime":%d}`, time.Now().UnixMilli())
+				return
+			}
+			payload := r.URL.RawQuery
+			if r.Method == http.MethodPost {
+				body, _ := io.ReadAll(r.Body)
+				payload = string(body)
+			}
+			mu.Lock()
+			payloads = append(payloads, payload)
+			attempt := len(payloads)
+			mu.Unlock()
+			if attempt == 1 {
+				tc.first(w)
+				return
+			}
+			fmt.Fprint(w, `{"ok":true}`)
+		})
+		log.SetOutput(io.Discard)
+
+		_, err := signedRequest(context.Background(), tc.method, "key", HMACSigner{secret: "secret"}, "/sapi/v1/test", map[string]string{"coin": "ETH"})
+		log.SetOutput(os.Stderr)
+		if (err == nil) != (tc.requests == 2) {
+			t.Errorf("%s: err = %v", tc.name, err)
+		}
+		if len(payloads) != tc.requests || synced != tc.synced {
+			t.Fatalf("%s: 请求 %d 次, 同步时间 %v, want %d 次, %v", tc.name, len(payloads), synced, tc.requests, tc.synced)
+		}
+		if tc.requests == 1 {
+			continue
+		}
+
+		var timestamps []int64
+		for _, payload := range payloads {
+			unsigned, signature, _ := strings.Cut(payload, "&signature=")
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(unsigned))
+			if signature != hex.EncodeToString(mac.Sum(nil)) {
+				t.Errorf("%s: 签名与参数不符: %s", tc.name, payload)
+			}
+			values, _ := url.ParseQuery(unsigned)
+			ts, err := strconv.ParseInt(values.Get("timestamp"), 10, 64)
+			if err != nil {
+				t.Fatalf("%s: timestamp = %q", tc.name, values.Get("timestamp"))
+			}
+			timestamps = append(timestamps, ts)
+		}
+		if timestamps[1]-timestamps[0] < signedRequestRetryDelay.Milliseconds()-100 {
+			t.Errorf("%s: 重试的时间戳 %d 没有更新（第一次 %d）", tc.name, timestamps[1], timestamps[0])
+		}
+		if payloads[0] == payloads[1] {
+			t.Errorf("%s: 重试重放了旧的签名请求", tc.name)
+		}
+	}
+}
+
+// 取出 lines 中已有的全部行，不阻塞
+func drainLines(lines chan string) []string {
+	var got []string
+	for {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		default:
+			return got
+		}
+	}
+}
+
+// 跟踪日志：只输出打开之后写入的完整行；日志被滚动时先读完旧文件再切到新文件，被截断时从头读；
+// 从日志行中取出的产品按 APR、币种和期权类型过滤
+func TestTailFollowsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "binance.log")
+	appendLog := func(name, content string) {
+		t.Helper()
+		file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := func(products ...string) string {
+		return `2026/01/01 00:00:00 main.go:100: {"total":1,"list":[` + strings.Join(products, ",") + "]}\n"
+	}
+	high := `{"id":"1","investCoin":"USDT","exercisedCoin":"ETH","apr":"0.85","optionType":"PUT"}`
+	low := `{"id":"2","investCoin":"USDT","exercisedCoin":"ETH","apr":"0.10","optionType":"PUT"}`
+	btcCall := `{"id":"3","investCoin":"BTC","exercisedCoin":"USDT","apr":"0.90","optionType":"CALL"}`
+
+	appendLog(path, page(high)+"旧的内容\n")
+	f := &logFollower{path: path}
+	if err := f.open(true); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.file.Close() }()
+	lines := make(chan string, 100)
+
+	appendLog(path, page(high, low, btcCall)+"2026/01/01 00:00:05 main.go:200: 第 1 页请求失败\n半行")
+	if err := f.readLines(lines); err != nil {
+		t.Fatal(err)
+	}
+	got := drainLines(lines)
+	if len(got) != 2 {
+		t.Fatalf("读到 %d 行, want 2（已有内容和不完整的行不输出）: %q", len(got), got)
+	}
+	filter := TailFilter{MinAPR: 0.5, Coin: "ETH", OptionType: "PUT"}
+	var matched []string
+	for _, line := range got {
+		for _, p := range parseLogProducts(line) {
+			if filter.Match(p) {
+				matched = append(matched, p.ID)
+			}
+		}
+	}
+	if !reflect.DeepEqual(matched, []string{"1"}) {
+		t.Errorf("满足条件的产品 %v, want [1]", matched)
+	}
+
+	// 补完半行后滚动：旧文件改名，新文件写入新的内容
+	appendLog(path, "的结尾\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(path, page(btcCall))
+	if err := f.checkRotation(lines); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.readLines(lines); err != nil {
+		t.Fatal(err)
+	}
+	got = drainLines(lines)
+	if len(got) != 2 || got[0] != "半行的结尾" || len(parseLogProducts(got[1])) != 1 {
+		t.Fatalf("滚动后读到 %q", got)
+	}
+
+	// 截断后写入更短的内容，从头读
+	if err := os.WriteFile(path, []byte("截断之后\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.checkRotation(lines); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.readLines(lines); err != nil {
+		t.Fatal(err)
+	}
+	if got := drainLines(lines); !reflect.DeepEqual(got, []string{"截断之后"}) {
+		t.Errorf("截断后读到 %q", got)
+	}
+}
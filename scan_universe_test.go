@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// n 根1分钟随机游走K线，每分钟收益率标准差为 vol；jump 为最后一根额外的涨跌幅
+func universeKlines(seed int64, n int, vol, jump float64) []Kline {
+	rng := rand.New(rand.NewSource(seed))
+	klines := make([]Kline, n)
+	price := 100.0
+	for i := range klines {
+		if i > 0 {
+			price *= 1 + rng.NormFloat64()*vol
+		}
+		if i == n-1 {
+			price *= 1 + jump
+		}
+		klines[i] = Kline{OpenTime: 1767225600000 + int64(i)*60000, Time: "2026-01-01 00:00:00", Close: price}
+	}
+	return klines
+}
+
+// 几个合成交易对中，最后一分钟被植入大幅下跌的交易对排在第一，即使它平时的波动最小；
+// 波动大但走势正常的交易对不会因为波动大而排在前面，数据不足的交易对不参与排名
+func TestScanUniverseRanksPlantedExtremeFirst(t *testing.T) {
+	windows := []int{1, 5, 15, 60}
+	specs := []struct {
+		symbol string
+		seed   int64
+		n      int
+		vol    float64
+		jump   float64
+	}{
+		{"BTCUSDT", 1, 2000, 0.001, 0},
+		{"DOGEUSDT", 2, 2000, 0.02, 0},
+		{"ETHUSDT", 3, 2000, 0.0005, -0.01}, // 约 -20 倍的1分钟标准差
+		{"SOLUSDT", 4, 2000, 0.002, 0},
+		{"NEWUSDT", 5, 1, 0.001, 0},
+	}
+	var universe []SymbolData
+	for _, s := range specs {
+		klines := universeKlines(s.seed, s.n, s.vol, s.jump)
+		universe = append(universe, newSymbolData(s.symbol, klines, klinePrices(klines, PriceClose), windows, "simple"))
+	}
+
+	results := scanUniverse(universe, windows, "simple")
+	if len(results) != 4 {
+		t.Fatalf("%d 个结果, want 4（NEWUSDT 数据不足）: %+v", len(results), results)
+	}
+	top := results[0]
+	if top.Symbol != "ETHUSDT" || top.Window != 1 || top.ZScore > -10 {
+		t.Errorf("排名第一: %s %d 分钟 z=%.2f, want ETHUSDT 1 分钟 z < -10", top.Symbol, top.Window, top.ZScore)
+	}
+	for i, r := range results {
+		if i > 0 && math.Abs(r.ZScore) > math.Abs(results[i-1].ZScore) {
+			t.Errorf("第 %d 名 %s |z|=%.2f 大于上一名 %.2f", i+1, r.Symbol, math.Abs(r.ZScore), math.Abs(results[i-1].ZScore))
+		}
+		if i > 0 && math.Abs(r.ZScore) > 4 {
+			t.Errorf("走势正常的 %s 的 |z| = %.2f", r.Symbol, math.Abs(r.ZScore))
+		}
+		for window, z := range r.ZScores {
+			if math.Abs(z) > math.Abs(r.ZScore) {
+				t.Errorf("%s 的 %d 分钟 |z|=%.2f 大于报告的最大值 %.2f", r.Symbol, window, math.Abs(z), math.Abs(r.ZScore))
+			}
+		}
+	}
+}
@@ -0,0 +1,1062 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// 合成数据的参数：每分钟对数收益率的标准差，以及最后一根K线人为放大的倍数
+const (
+	selftestSigma        = 0.001
+	selftestPlantedSigma = 8.0
+	selftestStartPrice   = 2000.0
+)
+
+// 最后一根K线的 1 分钟 z-score 至少要达到该值，才说明极端值被识别出来
+const selftestZBound = 5.0
+
+// 流水线使用的 -lookback-days，分析程序需要多于 3 天的数据
+const selftestLookbackDays = 4
+
+// 自检使用的关键窗口（分钟），都不超过 selftestLookbackDays 天
+const selftestWindows = "1,5,15,30,60,120,240,1440"
+
+// 流水线中的一步：在源码目录用 go run 运行一个程序
+type PipelineStep struct {
+	Program string
+	Args    []string
+}
+
+func main() {
+	srcDir := flag.String("src", ".", "各程序源码所在目录（自检用 go run 运行这些程序）")
+	seed := flag.Int64("seed", 1, "生成合成K线的随机种子，相同种子得到相同数据")
+	days := flag.Int("days", 10, "合成K线的天数（每天 1440 根1分钟K线），至少为流水线 -lookback-days 的两倍，保证波动率有足够样本")
+	keep := flag.Bool("keep", false, "保留临时目录，方便检查中间文件")
+	verbose := flag.Bool("v", false, "打印每一步程序的输出")
+	flag.Parse()
+	if *days < 2*selftestLookbackDays {
+		log.Fatalf("-days 至少为 %d: %d", 2*selftestLookbackDays, *days)
+	}
+
+	dir, err := os.MkdirTemp("", "binance-selftest-")
+	if err != nil {
+		log.Fatal("创建临时目录失败:", err)
+	}
+	if *keep {
+		fmt.Printf("临时目录: %s\n", dir)
+	}
+
+	err = runSelftest(dir, *srcDir, *seed, *days, *verbose)
+	if !*keep {
+		os.RemoveAll(dir)
+	}
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("\n自检通过")
+}
+
+// 在 dir 中生成合成K线、运行整个流水线并检查结果，srcDir 为各程序源码所在目录
+// 流水线某一步运行失败或有检查不通过时返回错误，错误中列出全部失败的检查
+func runSelftest(dir, srcDir string, seed int64, days int, verbose bool) error {
+	// 合成数据同时作为完整历史和最近14天的数据，最后一根K线是需要被识别出来的极端值
+	n := days * 1440
+	klines := selftestKlines(n, seed)
+	for _, name := range []string{"ETHUSDT_minute_klines.csv", "ETHUSDT_latest_14days.csv"} {
+		if err := writeSyntheticKlinesCSV(filepath.Join(dir, name), klines); err != nil {
+			return fmt.Errorf("生成合成K线失败: %v", err)
+		}
+	}
+	fmt.Printf("已生成 %d 根合成K线（种子 %d，最后一根为 %.0f 倍标准差的上涨）\n", n, seed, selftestPlantedSigma)
+
+	dirArgs := []string{"-input-dir", dir, "-output-dir", dir}
+	lookback := strconv.Itoa(selftestLookbackDays)
+	steps := []PipelineStep{
+		{"calculate_volatility.go", append([]string{"-lookback-days", lookback, "-q"}, dirArgs...)},
+		{"calculate_zscore.go", append([]string{"-windows", selftestWindows}, dirArgs...)},
+		{"calculate_zscore_matrix.go", append([]string{"-lookback-days", lookback, "-matrix-windows", selftestWindows, "-q"}, dirArgs...)},
+		{"analyze_price_surge.go", []string{"-input-dir", dir, "-lookback-days", lookback, "-windows", selftestWindows}},
+		{"analyze_recent_hours.go", []string{"-input-dir", dir, "-lookback-days", lookback, "-windows", selftestWindows}},
+	}
+	for _, step := range steps {
+		if err := runStep(srcDir, step, verbose); err != nil {
+			return err
+		}
+	}
+
+	var failures []string
+	for _, check := range []func(string) error{checkVolatilityScaling, checkPlantedZScore, checkMatrixConsistency} {
+		if err := check(dir); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("自检失败（%d 项）:\n  - %s", len(failures), strings.Join(failures, "\n  - "))
+	}
+	return nil
+}
+
+// 自检用的 n 根合成K线，最后一根为 selftestPlantedSigma 倍标准差的上涨
+func selftestKlines(n int, seed int64) []Kline {
+	return generateKlines(n, seed, GBMParams{
+		StartPrice: selftestStartPrice,
+		StartTime:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Volatility: selftestSigma,
+		JumpIndex:  n - 1,
+		JumpSigma:  selftestPlantedSigma,
+	})
+}
+
+// 不经过 go run，直接用各程序共用的函数在内存中算出波动率、最后一根K线的 z-score 和最近 selftestLookbackDays 天的z-score矩阵，
+// 检查与 runSelftest 相同的不变量；不需要编译其他程序，供 go test 使用
+func runSelftestInProcess(seed int64, days int) error {
+	n := days * 1440
+	klines := selftestKlines(n, seed)
+	prices := make([]float64, n)
+	for i, k := range klines {
+		prices[i] = k.Close
+	}
+	windows, err := parseWindows(selftestWindows)
+	if err != nil {
+		return err
+	}
+
+	// 波动率：与 calculate_volatility 的等权结果相同
+	volatility := estimateVolatility(prices, windows, "simple")
+	stdDevs := make(map[int]float64, len(volatility))
+	for window, data := range volatility {
+		stdDevs[window] = data.StdDev
+	}
+
+	// 矩阵：最近 selftestLookbackDays 天的每一分钟，最后一行就是 calculate_zscore 计算的时刻
+	rows := selftestLookbackDays * 1440
+	matrix := make([][]float64, rows)
+	for i := range matrix {
+		matrix[i] = make([]float64, len(windows))
+		computeZScoreRow(prices, n-rows+i, windows, volatility, "simple", matrix[i])
+	}
+
+	var failures []string
+	if err := checkStdDevScaling(stdDevs, windows); err != nil {
+		failures = append(failures, err.Error())
+	}
+	z := math.NaN()
+	if data, ok := volatility[1]; ok && data.StdDev > 0 {
+		z = (calculateReturn(prices[n-2], prices[n-1], "simple") - data.Mean) / data.StdDev
+	}
+	if err := checkPlantedZ(z); err != nil {
+		failures = append(failures, err.Error())
+	}
+	for i, row := range matrix {
+		for j, value := range row {
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				failures = append(failures, fmt.Sprintf("矩阵第 %d 行 %d 分钟窗口的 z-score 为 %v", i, windows[j], value))
+			}
+		}
+	}
+	// selftestWindows 的第一个窗口是 1 分钟
+	if matrixZ := matrix[rows-1][0]; !(math.Abs(matrixZ-z) <= 1e-9) {
+		failures = append(failures, fmt.Sprintf("矩阵最后一行的 1 分钟 z-score %.4f 与单独计算的 %.4f 不一致", matrixZ, z))
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("自检失败（%d 项）:\n  - %s", len(failures), strings.Join(failures, "\n  - "))
+	}
+	return nil
+}
+
+// 运行流水线中的一步，失败时把程序输出带在错误里
+func runStep(srcDir string, step PipelineStep, verbose bool) error {
+	fmt.Printf("运行 %s ...\n", step.Program)
+	cmd := exec.Command("go", append([]string{"run", step.Program}, step.Args...)...)
+	cmd.Dir = srcDir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	startTime := time.Now()
+	err := cmd.Run()
+	if verbose || err != nil {
+		os.Stdout.Write(out.Bytes())
+	}
+	if err != nil {
+		return fmt.Errorf("%s 运行失败: %v", step.Program, err)
+	}
+	fmt.Printf("  完成，用时 %.1f 秒\n", time.Since(startTime).Seconds())
+	return nil
+}
+
+// 读取波动率表中各窗口的标准差
+func loadSelftestVolatility(dir string) (map[int]float64, error) {
+	version, records, err := readSchemaCSV(filepath.Join(dir, "multi_timeframe_volatility.csv"))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchema("multi_timeframe_volatility.csv", version, volatilitySchemaVersion, volatilitySchemaMigrations, records, volatilityRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	stdDevs := make(map[int]float64)
+	for _, record := range records[1:] {
+		window, err1 := strconv.Atoi(record[0])
+		stdDev, err2 := strconv.ParseFloat(record[3], 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("multi_timeframe_volatility.csv 中有无法解析的行: %v", record)
+		}
+		stdDevs[window] = stdDev
+	}
+	return stdDevs, nil
+}
+
+// 独立同分布的收益率下，标准差应随窗口大致单调增加，并按 sqrt(窗口) 缩放
+func checkVolatilityScaling(dir string) error {
+	stdDevs, err := loadSelftestVolatility(dir)
+	if err != nil {
+		return err
+	}
+	windows, err := parseWindows(selftestWindows)
+	if err != nil {
+		return err
+	}
+	return checkStdDevScaling(stdDevs, windows)
+}
+
+// 各窗口的标准差（%）应随窗口单调增加，4 小时以内按 sqrt(窗口) 缩放
+func checkStdDevScaling(stdDevs map[int]float64, windows []int) error {
+	base, ok := stdDevs[1]
+	if !ok || !(base > 0) {
+		return fmt.Errorf("波动率表缺少 1 分钟窗口")
+	}
+	prev := 0.0
+	for _, window := range windows {
+		stdDev, ok := stdDevs[window]
+		if !ok {
+			return fmt.Errorf("波动率表缺少 %d 分钟窗口", window)
+		}
+		// 允许 5% 的抽样误差
+		if stdDev < prev*0.95 {
+			return fmt.Errorf("波动率不单调: %d 分钟窗口标准差 %.6f%% 小于前一个窗口的 %.6f%%", window, stdDev, prev)
+		}
+		prev = stdDev
+
+		// 长窗口的独立样本太少，只检查 4 小时以内的缩放
+		ratio := stdDev / (base * math.Sqrt(float64(window)))
+		if window <= 240 && (ratio < 0.7 || ratio > 1.3) {
+			return fmt.Errorf("%d 分钟窗口标准差与 sqrt(窗口) 缩放相差过大: 比值 %.3f", window, ratio)
+		}
+	}
+	fmt.Printf("波动率检查通过: 1 分钟 %.6f%%（合成数据为 %.6f%%），%d 个窗口单调增加\n",
+		base, selftestSigma*100, len(windows))
+	return nil
+}
+
+// 读取 zscore_results.csv 中 1 分钟窗口的 z-score
+func loadSelftestZScore(dir string) (float64, error) {
+	version, records, err := readSchemaCSV(filepath.Join(dir, "zscore_results.csv"))
+	if err != nil {
+		return 0, err
+	}
+	if err := checkSchema("zscore_results.csv", version, zscoreResultsSchemaVersion, zscoreResultsSchemaMigrations, records, zscoreResultsRequiredHeader); err != nil {
+		return 0, err
+	}
+	for _, record := range records[1:] {
+		if record[0] == "1" {
+			return strconv.ParseFloat(record[5], 64)
+		}
+	}
+	return 0, fmt.Errorf("zscore_results.csv 缺少 1 分钟窗口")
+}
+
+// 最后一根K线是人为放大的极端值，1 分钟 z-score 应超过 selftestZBound
+func checkPlantedZScore(dir string) error {
+	z, err := loadSelftestZScore(dir)
+	if err != nil {
+		return err
+	}
+	return checkPlantedZ(z)
+}
+
+// 1 分钟 z-score 应超过 selftestZBound，NaN 视为没有识别出来
+func checkPlantedZ(z float64) error {
+	if !(z > selftestZBound) {
+		return fmt.Errorf("最后一根K线的 1 分钟 z-score 为 %.4f，应大于 %.1f", z, selftestZBound)
+	}
+	fmt.Printf("极端值检查通过: 1 分钟 z-score = %.4f（阈值 %.1f）\n", z, selftestZBound)
+	return nil
+}
+
+// z-score矩阵按 -matrix-windows 只包含自检窗口，标题应与之一一对应
+// 最后一行与 calculate_zscore 算的是同一时刻，1 分钟窗口的结果应一致
+func checkMatrixConsistency(dir string) error {
+	version, records, err := readSchemaCSV(filepath.Join(dir, "zscore_matrix.csv"))
+	if err != nil {
+		return err
+	}
+	if err := checkSchema("zscore_matrix.csv", version, matrixSchemaVersion, matrixSchemaMigrations, records, []string{"TimeIndex"}); err != nil {
+		return err
+	}
+	columns, err := matrixColumns("zscore_matrix.csv", records[0])
+	if err != nil {
+		return err
+	}
+	windows, err := parseWindows(selftestWindows)
+	if err != nil {
+		return err
+	}
+	if len(columns) != len(windows) {
+		return fmt.Errorf("zscore_matrix.csv 应只有 %d 个窗口列（%s），实际标题为 %v", len(windows), selftestWindows, records[0][1:])
+	}
+	for i, window := range windows {
+		if columns[window] != i+1 {
+			return fmt.Errorf("zscore_matrix.csv 第 %d 列应为 %d 分钟窗口，实际标题为 %v", i+2, window, records[0][1:])
+		}
+	}
+	col, ok := columns[1]
+	if !ok {
+		return fmt.Errorf("zscore_matrix.csv 缺少 1 分钟窗口")
+	}
+	if rows := selftestLookbackDays * 1440; len(records) != rows+1 {
+		return fmt.Errorf("zscore_matrix.csv 应有 %d 行，实际 %d 行", rows, len(records)-1)
+	}
+
+	last := records[len(records)-1]
+	matrixZ, err := strconv.ParseFloat(last[col], 64)
+	if err != nil {
+		return fmt.Errorf("zscore_matrix.csv 最后一行无法解析: %v", err)
+	}
+	z, err := loadSelftestZScore(dir)
+	if err != nil {
+		return err
+	}
+	// 两个文件都保留 4 位小数
+	if math.Abs(matrixZ-z) > 1e-3 {
+		return fmt.Errorf("矩阵最后一行的 1 分钟 z-score %.4f 与 zscore_results.csv 的 %.4f 不一致", matrixZ, z)
+	}
+	fmt.Printf("矩阵检查通过: 最后一行 1 分钟 z-score = %.4f，与 zscore_results.csv 一致\n", matrixZ)
+	return nil
+}
+
+// CSV 第一行的版本标记，格式为 "# schema=N"
+// 没有版本行的文件视为 schema=1（加版本行之前的格式）
+const schemaPrefix = "# schema="
+
+// 旧版本文件的迁移说明，Compatible 表示当前读取方仍能正确解析（例如只在末尾追加过列）
+type SchemaMigration struct {
+	Compatible bool
+	Note       string
+}
+
+// 读取带版本行的CSV，返回版本号和全部记录（含标题行）
+func readSchemaCSV(path string) (int, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	br, err := newCSVInput(path, file)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	if prefix, err := br.Peek(len(schemaPrefix)); err == nil && string(prefix) == schemaPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaPrefix)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s 的版本行格式错误: %q", path, strings.TrimSpace(line))
+		}
+	}
+
+	records, err := newCSVReader(br).ReadAll()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, records, nil
+}
+
+// 校验版本和标题：当前版本直接通过，兼容的旧版本打印迁移说明后继续，其余情况报错
+// expected 为读取方依赖的前几列
+func checkSchema(name string, version, current int, migrations map[int]SchemaMigration, records [][]string, expected []string) error {
+	if version != current {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%s 的版本 schema=%d 无法识别（当前为 schema=%d），请用当前版本的工具重新生成", name, version, current)
+		}
+		if !m.Compatible {
+			return fmt.Errorf("%s 是不兼容的旧版本 schema=%d: %s", name, version, m.Note)
+		}
+		fmt.Printf("注意: %s 是旧版本 schema=%d: %s\n", name, version, m.Note)
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("%s 缺少标题行", name)
+	}
+	header := records[0]
+	if len(header) < len(expected) {
+		return fmt.Errorf("%s 的标题只有 %d 列，至少需要 %d 列", name, len(header), len(expected))
+	}
+	for i, col := range expected {
+		if header[i] != col {
+			return fmt.Errorf("%s 第 %d 列应为 %s，实际为 %s", name, i+1, col, header[i])
+		}
+	}
+	return nil
+}
+
+// 原子写入文件：先写到同目录下的临时文件，write 成功并关闭后再改名为目标文件
+// 中途出错或崩溃时目标文件保持原样，读取方不会看到写了一半的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 默认的关键时间窗口（分钟），各工具只输出自己数据范围内的窗口，可用 -windows 覆盖
+var DefaultWindows = []int{1, 5, 15, 30, 60, 120, 240, 1440, 1440 * 2, 1440 * 3, 1440 * 7}
+
+// 解析 -windows 参数（逗号分隔的分钟数，如 "5,60,1440"），为空时返回 DefaultWindows
+func parseWindows(value string) ([]int, error) {
+	if value == "" {
+		return DefaultWindows, nil
+	}
+	var windows []int
+	for _, field := range strings.Split(value, ",") {
+		window, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("无效的窗口: %q（需要正整数分钟数）", field)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// 波动率表的版本，列有变化时加1，并在 volatilitySchemaMigrations 中说明旧版本如何处理
+const volatilitySchemaVersion = 3
+
+// 读取波动率表时依赖的列
+var volatilityRequiredHeader = []string{"Window_Minutes", "Window_Days", "Mean_Pct", "StdDev_Pct", "Sample_Count"}
+
+// 旧版本波动率表的迁移说明
+var volatilitySchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有 VaR_Pct 列（由旧版 calculate_volatility 或 calculate_multi_timeframe_volatility.py 生成），不影响z-score计算，需要 VaR 时请重新运行 calculate_volatility"},
+	2: {Compatible: true, Note: "没有 Realized_Vol_Pct 列，不影响z-score计算"},
+}
+
+// zscore_results.csv 的版本，列有变化时加1
+// v3: 缺少波动率数据的窗口不再跳过，写为 NaN
+// v4: 增加 Trend_Slope_Pct 列（窗口内回归斜率，每根K线百分比）
+// v5: 增加 Interpretation 列（z-score 所在区间的说明）
+const zscoreResultsSchemaVersion = 5
+
+// 读取 zscore_results.csv 时依赖的列
+var zscoreResultsRequiredHeader = []string{"Window_Minutes", "Window_Days", "Return_Pct", "Mean_Pct", "StdDev_Pct", "Z_Score"}
+
+// 旧版本 zscore_results.csv 的迁移说明
+var zscoreResultsSchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "没有版本行，缺少波动率数据的窗口被跳过，也没有 Trend_Slope_Pct 和 Interpretation 列"},
+	2: {Compatible: true, Note: "缺少波动率数据的窗口被跳过，也没有 Trend_Slope_Pct 和 Interpretation 列"},
+	3: {Compatible: true, Note: "没有 Trend_Slope_Pct 和 Interpretation 列，导出时写为 null"},
+	4: {Compatible: true, Note: "没有 Interpretation 列，导出时写为 null"},
+}
+
+// z-score矩阵的版本，列有变化时加1，并在 matrixSchemaMigrations 中说明旧版本如何处理
+// v3: 缺少波动率数据的窗口写为 NaN（v2 写为 0）
+// v4: 列可以只包含 -matrix-windows 指定的窗口，标题为窗口的分钟数，读取方按标题找列
+const matrixSchemaVersion = 4
+
+// 旧版本z-score矩阵的迁移说明
+var matrixSchemaMigrations = map[int]SchemaMigration{
+	1: {Compatible: true, Note: "加版本行之前生成的矩阵，列布局相同；缺少波动率数据的窗口为 0，无法和 z=0 区分"},
+	2: {Compatible: true, Note: "缺少波动率数据的窗口为 0，无法和 z=0 区分"},
+	3: {Compatible: true, Note: "包含全部窗口，按标题读取结果相同"},
+}
+
+// K线数据，对应下载脚本输出的CSV列
+type Kline struct {
+	OpenTime int64  // Open Time (毫秒)
+	Time     string // Open Time (UTC)
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// 解析失败的行，Line 为文件中的行号（标题行为第1行）
+type RowError struct {
+	Line int
+	Raw  []string
+	Err  error
+}
+
+// 解析失败的行占比超过该阈值时直接报错，避免在损坏的文件上得到误导性的结果
+const maxRowErrorRate = 0.01
+
+// K线输入格式（-input-format）：csv 为下载脚本输出的CSV，json 为 /api/v3/klines 返回的原始数组
+var inputFormat = "csv"
+
+// 为 true 时去掉重复的分钟（同一开盘时间的K线保留第一根），由 -drop-duplicates 指定
+var dropDuplicateKlines bool
+
+// Binance K线数组中前 6 个元素: Open Time, Open, High, Low, Close, Volume
+const minJSONKlineFields = 6
+
+// 按 -input-format 读取K线，解析失败的行不再静默跳过，而是收集后随数据一起返回
+// strict 为 true 时遇到第一行解析失败就返回错误；空文件和没有数据行的文件返回错误
+// 重复的分钟默认只报告，strict 时报错，-drop-duplicates 时去掉
+func loadKlines(path string, strict bool) ([]Kline, []RowError, error) {
+	var klines []Kline
+	var rowErrors []RowError
+	var err error
+	switch inputFormat {
+	case "csv":
+		klines, rowErrors, err = loadKlinesCSV(path, strict)
+	case "json":
+		// 文件名沿用CSV的约定，只把扩展名换成 .json
+		if strings.HasSuffix(path, ".csv") {
+			path = strings.TrimSuffix(path, ".csv") + ".json"
+		}
+		klines, rowErrors, err = loadKlinesJSON(path, strict)
+	default:
+		return nil, nil, fmt.Errorf("未知的输入格式 %q（可选 csv、json）", inputFormat)
+	}
+	if err != nil {
+		return klines, rowErrors, err
+	}
+	klines, err = checkDuplicateKlines(path, klines, strict)
+	return klines, rowErrors, err
+}
+
+// 重复的分钟（下载脚本出错时常见）会打乱每天 1440 根K线的假设，窗口对应的时间全部错位
+func checkDuplicateKlines(path string, klines []Kline, strict bool) ([]Kline, error) {
+	timestamps := make([]string, len(klines))
+	for i, k := range klines {
+		timestamps[i] = strconv.FormatInt(k.OpenTime, 10)
+	}
+	duplicates := detectDuplicateTimestamps(timestamps)
+	if len(duplicates) == 0 {
+		return klines, nil
+	}
+
+	first := klines[duplicates[0]].Time
+	if dropDuplicateKlines {
+		fmt.Printf("去掉 %d 根重复的K线（同一分钟保留第一根，首个重复在 %s）\n", len(duplicates), first)
+		return dropKlines(klines, duplicates), nil
+	}
+	if strict {
+		return nil, fmt.Errorf("%s 中有 %d 根重复的K线（首个重复在 %s），可用 -drop-duplicates 去重", path, len(duplicates), first)
+	}
+	fmt.Printf("警告: %s 中有 %d 根重复的K线（首个重复在 %s），按分钟数计算的窗口会错位，可用 -drop-duplicates 去重\n",
+		path, len(duplicates), first)
+	return klines, nil
+}
+
+// 返回重复时间戳的下标（升序），每个时间戳第一次出现的位置不算重复
+func detectDuplicateTimestamps(timestamps []string) []int {
+	seen := make(map[string]bool, len(timestamps))
+	var duplicates []int
+	for i, ts := range timestamps {
+		if seen[ts] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[ts] = true
+	}
+	return duplicates
+}
+
+// 去掉 indices（升序）对应的K线，其余保持原顺序
+func dropKlines(klines []Kline, indices []int) []Kline {
+	kept := make([]Kline, 0, len(klines)-len(indices))
+	next := 0
+	for i, k := range klines {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept
+}
+
+// 读取K线CSV（跳过标题行）
+func loadKlinesCSV(path string, strict bool) ([]Kline, []RowError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := newCSVReader(input)
+	reader.FieldsPerRecord = -1
+
+	klines := make([]Kline, 0)
+	var rowErrors []RowError
+	total := 0
+	lines := 0
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		lines++
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, nil, err
+			}
+			total++
+			rowErrors = append(rowErrors, RowError{Line: parseErr.Line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if header {
+			continue
+		}
+
+		total++
+		kline, err := parseKline(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			rowErrors = append(rowErrors, RowError{Line: line, Raw: record, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 行解析失败: %v", path, line, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+
+	// 下载失败时常留下空文件或只有标题的文件，明确报错，避免调用方在空数据上越界
+	if lines == 0 {
+		return nil, nil, fmt.Errorf("%s 是空文件，没有标题行和数据行（下载可能失败了）", path)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("%s 只有标题行，没有数据行（下载可能失败了）", path)
+	}
+	return checkRowErrors(path, klines, rowErrors, total)
+}
+
+// 读取 /api/v3/klines 返回的JSON数组: [[openTime, "open", "high", "low", "close", "volume", ...], ...]
+// RowError.Line 为K线在数组中的序号（从1开始）
+func loadKlinesJSON(path string, strict bool) ([]Kline, []RowError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, nil, fmt.Errorf("%s 不是K线JSON数组: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s 中没有K线数据（下载可能失败了）", path)
+	}
+
+	klines := make([]Kline, 0, len(rows))
+	var rowErrors []RowError
+	for i, raw := range rows {
+		var kline Kline
+		record, err := jsonKlineRecord(raw)
+		if err == nil {
+			kline, err = parseKline(record)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: i + 1, Raw: []string{string(raw)}, Err: err})
+			if strict {
+				return nil, rowErrors, fmt.Errorf("%s 第 %d 条K线解析失败: %v", path, i+1, err)
+			}
+			continue
+		}
+		klines = append(klines, kline)
+	}
+	return checkRowErrors(path, klines, rowErrors, len(rows))
+}
+
+// 把一条JSON K线转换成与CSV相同的列顺序，交给 parseKline 统一校验
+// Binance 的时间是数字、价格和成交量是字符串，这里两种写法都接受
+func jsonKlineRecord(raw json.RawMessage) ([]string, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("不是数组: %v", err)
+	}
+	if len(fields) < minJSONKlineFields {
+		return nil, fmt.Errorf("元素不足: 需要至少 %d 个，实际 %d 个", minJSONKlineFields, len(fields))
+	}
+
+	values := make([]string, minJSONKlineFields)
+	for i := range values {
+		var text string
+		if err := json.Unmarshal(fields[i], &text); err == nil {
+			values[i] = text
+			continue
+		}
+		var number json.Number
+		if err := json.Unmarshal(fields[i], &number); err != nil {
+			return nil, fmt.Errorf("第 %d 个元素既不是数字也不是字符串: %s", i+1, fields[i])
+		}
+		values[i] = number.String()
+	}
+
+	openTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+	timeUTC := time.UnixMilli(openTime).UTC().Format("2006-01-02 15:04:05")
+	return append([]string{values[0], timeUTC}, values[1:]...), nil
+}
+
+// 汇总解析失败的行，占比超过 maxRowErrorRate 时返回错误
+func checkRowErrors(path string, klines []Kline, rowErrors []RowError, total int) ([]Kline, []RowError, error) {
+	if len(rowErrors) > 0 {
+		fmt.Printf("跳过 %d / %d 行无法解析的数据（首个错误在第 %d 行: %v）\n",
+			len(rowErrors), total, rowErrors[0].Line, rowErrors[0].Err)
+		if float64(len(rowErrors))/float64(total) > maxRowErrorRate {
+			return klines, rowErrors, fmt.Errorf("%s 中 %d / %d 行解析失败，超过 %.0f%% 的阈值",
+				path, len(rowErrors), total, maxRowErrorRate*100)
+		}
+	}
+	return klines, rowErrors, nil
+}
+
+// 解析一行K线: Open Time, Open Time (UTC), Open, High, Low, Close, Volume, ...
+func parseKline(record []string) (Kline, error) {
+	if len(record) < 7 {
+		return Kline{}, fmt.Errorf("列数不足: 需要至少 7 列，实际 %d 列", len(record))
+	}
+
+	openTime, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Kline{}, fmt.Errorf("Open Time 格式错误: %v", err)
+	}
+
+	var values [5]float64
+	names := []string{"Open", "High", "Low", "Close", "Volume"}
+	for i := range values {
+		values[i], err = strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return Kline{}, fmt.Errorf("%s 格式错误: %v", names[i], err)
+		}
+	}
+	// 价格为0或负数的K线（错误报价）会让收益率变成 Inf/NaN，当作无法解析的行处理
+	for i, v := range values[:4] {
+		if !(v > 0) || math.IsInf(v, 0) {
+			return Kline{}, fmt.Errorf("%s 必须是大于 0 的有限值，实际为 %v", names[i], v)
+		}
+	}
+
+	return Kline{
+		OpenTime: openTime,
+		Time:     record[1],
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+	}, nil
+}
+
+// 合成K线的参数（几何布朗运动，每根K线1分钟）
+type GBMParams struct {
+	StartPrice float64
+	StartTime  time.Time
+	Drift      float64 // 每分钟对数收益率的期望（小数，如 0.00001）
+	Volatility float64 // 每分钟对数收益率的标准差（小数，如 0.001）
+	JumpIndex  int     // 在该下标的K线插入一次跳跃，< 0 表示不插入
+	JumpSigma  float64 // 跳跃的大小，为 Volatility 的倍数，负数表示下跌
+}
+
+// 生成 n 根确定性的1分钟K线：相同的 seed 和参数得到完全相同的数据
+// 跳跃K线仍会消耗一次随机数，因此有无跳跃时其余K线保持一致
+func generateKlines(n int, seed int64, params GBMParams) []Kline {
+	rng := rand.New(rand.NewSource(seed))
+	klines := make([]Kline, n)
+	price := params.StartPrice
+	for i := range klines {
+		logReturn := params.Drift - params.Volatility*params.Volatility/2 + params.Volatility*rng.NormFloat64()
+		if i == params.JumpIndex {
+			logReturn = params.JumpSigma * params.Volatility
+		}
+		open := price
+		price *= math.Exp(logReturn)
+		openTime := params.StartTime.Add(time.Duration(i) * time.Minute)
+		klines[i] = Kline{
+			OpenTime: openTime.UnixMilli(),
+			Time:     openTime.UTC().Format("2006-01-02 15:04:05"),
+			Open:     open,
+			High:     math.Max(open, price) * (1 + math.Abs(rng.NormFloat64())*params.Volatility/4),
+			Low:      math.Min(open, price) * (1 - math.Abs(rng.NormFloat64())*params.Volatility/4),
+			Close:    price,
+			Volume:   10 + rng.ExpFloat64()*50,
+		}
+	}
+	return klines
+}
+
+// 按下载脚本的格式写出合成K线，没有的列（成交额、笔数等）写为 0
+func writeSyntheticKlinesCSV(path string, klines []Kline) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Open Time", "Open Time (UTC)", "Open", "High", "Low", "Close", "Volume",
+			"Close Time", "Close Time (UTC)", "Quote Asset Volume", "Number of Trades",
+			"Taker Buy Base Asset Volume", "Taker Buy Quote Asset Volume"})
+
+		for _, k := range klines {
+			closeTime := time.UnixMilli(k.OpenTime).Add(time.Minute - time.Millisecond)
+			writer.Write([]string{
+				strconv.FormatInt(k.OpenTime, 10),
+				k.Time,
+				strconv.FormatFloat(k.Open, 'f', -1, 64),
+				strconv.FormatFloat(k.High, 'f', -1, 64),
+				strconv.FormatFloat(k.Low, 'f', -1, 64),
+				strconv.FormatFloat(k.Close, 'f', -1, 64),
+				strconv.FormatFloat(k.Volume, 'f', -1, 64),
+				strconv.FormatInt(closeTime.UnixMilli(), 10),
+				closeTime.UTC().Format("2006-01-02 15:04:05"),
+				"0", "0", "0", "0",
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+}
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+type VolatilityData struct {
+	Mean        float64
+	StdDev      float64
+	SampleCount int
+}
+
+// 按整个价格序列计算各窗口重叠收益率的均值和样本标准差（与 calculate_volatility 的等权结果相同），
+// 样本少于 2 个的窗口不出现在结果中
+func estimateVolatility(prices []float64, windows []int, returnMode string) map[int]VolatilityData {
+	volatility := make(map[int]VolatilityData, len(windows))
+	for _, window := range windows {
+		var n int
+		var mean, m2 float64
+		for i := window; i < len(prices); i++ {
+			r := calculateReturn(prices[i-window], prices[i], returnMode)
+			if math.IsNaN(r) {
+				continue
+			}
+			n++
+			delta := r - mean
+			mean += delta / float64(n)
+			m2 += delta * (r - mean)
+		}
+		if n < 2 {
+			continue
+		}
+		volatility[window] = VolatilityData{Mean: mean, StdDev: math.Sqrt(m2 / float64(n-1)), SampleCount: n}
+	}
+	return volatility
+}
+
+// 计算 timeIdx 时刻各窗口的z-score，row[j] 对应 windows[j]
+// 缺少波动率数据或标准差为0的窗口设为 NaN，window > timeIdx 无法计算的窗口设为0
+func computeZScoreRow(prices []float64, timeIdx int, windows []int, volatilityData map[int]VolatilityData, returnMode string, row []float64) {
+	currentPrice := prices[timeIdx]
+
+	// 对于每个时间窗口
+	for j, window := range windows {
+		// 对于 window > timeIdx 的情况，无法计算，设为0
+		if window > timeIdx {
+			row[j] = 0
+			continue
+		}
+		prevPrice := prices[timeIdx-window]
+		returnPct := calculateReturn(prevPrice, currentPrice, returnMode)
+
+		// 获取该窗口的均值和标准差
+		volData, exists := volatilityData[window]
+		if !exists {
+			row[j] = math.NaN()
+			continue
+		}
+
+		// 计算z-score，标准差为0时没有意义，和缺少波动率数据一样写为 NaN
+		zScore := math.NaN()
+		if volData.StdDev > 0 {
+			zScore = (returnPct - volData.Mean) / volData.StdDev
+		}
+
+		row[j] = zScore
+	}
+}
+
+// z-score矩阵标题中的窗口（分钟）到列下标的映射，第 0 列是 TimeIndex
+// v4 起矩阵可以只包含生成时 -matrix-windows 指定的窗口，必须按标题中的窗口值找列，不能假设第 N 列就是 N 分钟窗口
+func matrixColumns(name string, header []string) (map[int]int, error) {
+	columns := make(map[int]int, len(header))
+	for i, col := range header[1:] {
+		window, err := strconv.Atoi(col)
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("%s 第 %d 列的窗口不是正整数: %q", name, i+2, col)
+		}
+		columns[window] = i + 1
+	}
+	return columns, nil
+}
+
+// CSV 输入的分隔符（-csv-delimiter）和编码（-csv-encoding）
+// Excel 在部分地区导出时用分号分隔，另存为“Unicode 文本”时是 UTF-16 加制表符
+var csvDelimiter = ','
+var csvEncoding = "auto"
+
+// Excel 另存为“CSV UTF-8”时会在第一个标题前加上 BOM，不去掉的话标题比较和第一列解析都会失败
+const utf8BOM = "\ufeff"
+
+// 解析 -csv-delimiter：单个字符，或 tab 表示制表符
+func setCSVDelimiter(value string) error {
+	if value == "tab" || value == `\t` {
+		csvDelimiter = '\t'
+		return nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return fmt.Errorf("无效的分隔符 %q（需要单个字符，如 , ; | 或 tab）", value)
+	}
+	csvDelimiter = runes[0]
+	return nil
+}
+
+// 解析 -csv-encoding：auto 按 BOM 识别，没有 BOM 时按 UTF-8 读取
+func setCSVEncoding(value string) error {
+	switch value {
+	case "auto", "utf-8", "utf-16le", "utf-16be":
+		csvEncoding = value
+		return nil
+	}
+	return fmt.Errorf("未知的编码 %q（可选 auto、utf-8、utf-16le、utf-16be）", value)
+}
+
+// 把CSV输入转换成去掉 BOM 的 UTF-8，name 只用于错误信息
+// auto 模式下开头不是合法 UTF-8 时直接报错（常见于 Excel 导出的 GBK 文件），避免后面出现难以理解的列名不匹配
+func newCSVInput(name string, r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	encoding := csvEncoding
+	if encoding == "auto" {
+		encoding = "utf-8"
+		if prefix, _ := br.Peek(2); len(prefix) == 2 {
+			switch {
+			case prefix[0] == 0xff && prefix[1] == 0xfe:
+				encoding = "utf-16le"
+			case prefix[0] == 0xfe && prefix[1] == 0xff:
+				encoding = "utf-16be"
+			}
+		}
+		if encoding == "utf-8" {
+			head, err := br.Peek(br.Size())
+			if !validUTF8Prefix(head, err != nil) {
+				return nil, fmt.Errorf("%s 不是 UTF-8 编码（可能是 Excel 导出的 GBK 文件），请另存为“CSV UTF-8”，或用 -csv-encoding 指定编码", name)
+			}
+		}
+	}
+
+	if encoding == "utf-8" {
+		if prefix, err := br.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+			br.Discard(len(utf8BOM))
+		}
+		return br, nil
+	}
+
+	// UTF-16 的文件一般来自 Excel 手工导出，数据量不大，整个读入后一次转换
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%s 的长度不是偶数字节，不是 %s 编码", name, encoding)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if encoding == "utf-16le" {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return bufio.NewReader(strings.NewReader(string(utf16.Decode(units)))), nil
+}
+
+// 检查 head 是否为合法的 UTF-8；complete 为 false 时 head 只是文件的开头，末尾被截断的字符不算错误
+func validUTF8Prefix(head []byte, complete bool) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// 按 -csv-delimiter 创建 csv.Reader
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = csvDelimiter
+	return reader
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在内存中用共用的函数计算波动率、z-score 和矩阵，检查与 go run selftest.go 相同的不变量
+func TestSelftestPipeline(t *testing.T) {
+	if err := runSelftestInProcess(1, 2*selftestLookbackDays); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 没有植入极端值时（最后一根K线是普通的波动）检查失败，说明不变量确实在起作用
+func TestSelftestDetectsMissingExtreme(t *testing.T) {
+	n := 2 * selftestLookbackDays * 1440
+	klines := selftestKlines(n, 1)
+	prices := make([]float64, n)
+	for i, k := range klines {
+		prices[i] = k.Close
+	}
+	prices[n-1] = prices[n-2]
+	volatility := estimateVolatility(prices, []int{1}, "simple")
+	z := (calculateReturn(prices[n-2], prices[n-1], "simple") - volatility[1].Mean) / volatility[1].StdDev
+	if err := checkPlantedZ(z); err == nil {
+		t.Errorf("最后一根K线没有变化（z=%.4f）时极端值检查通过", z)
+	}
+	if err := checkStdDevScaling(map[int]float64{1: 0.1, 5: 0.05}, []int{1, 5}); err == nil {
+		t.Error("标准差随窗口减小时波动率检查通过")
+	}
+}
+
+// 相同种子得到相同的K线，写出后能被流水线的加载函数原样读回
+func TestSyntheticKlinesRoundTrip(t *testing.T) {
+	params := GBMParams{
+		StartPrice: selftestStartPrice,
+		StartTime:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Volatility: selftestSigma,
+		JumpIndex:  99,
+		JumpSigma:  selftestPlantedSigma,
+	}
+	klines := generateKlines(100, 7, params)
+	if again := generateKlines(100, 7, params); !reflect.DeepEqual(klines, again) {
+		t.Fatal("相同种子生成的K线不一致")
+	}
+
+	path := filepath.Join(t.TempDir(), "ETHUSDT_minute_klines.csv")
+	if err := writeSyntheticKlinesCSV(path, klines); err != nil {
+		t.Fatal(err)
+	}
+	loaded, rowErrors, err := loadKlines(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rowErrors) != 0 || !reflect.DeepEqual(loaded, klines) {
+		t.Fatalf("读回 %d 根K线（%d 个错误行），与写出的 %d 根不一致", len(loaded), len(rowErrors), len(klines))
+	}
+}
+
+// 极端值检查：z-score 低于 selftestZBound 时报告失败
+func TestCheckPlantedZScore(t *testing.T) {
+	for _, tc := range []struct {
+		z    string
+		fail bool
+	}{
+		{"7.9781", false},
+		{"3.2000", true},
+		{"NaN", true},
+	} {
+		dir := t.TempDir()
+		data := "# schema=5\nWindow_Minutes,Window_Days,Return_Pct,Mean_Pct,StdDev_Pct,Z_Score\n" +
+			"1,0.000694,0.8,0,0.1," + tc.z + "\n"
+		if err := os.WriteFile(filepath.Join(dir, "zscore_results.csv"), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := checkPlantedZScore(dir)
+		if (err != nil) != tc.fail {
+			t.Errorf("z=%s: checkPlantedZScore() = %v, 期望失败 %v", tc.z, err, tc.fail)
+		}
+		if err != nil && !strings.Contains(err.Error(), "应大于") {
+			t.Errorf("z=%s: 错误信息 %q 没有说明阈值", tc.z, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package shared
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+var errDiskFull = errors.New("磁盘已满")
+
+// 写入 limit 字节之后返回错误，模拟写到一半时磁盘满
+type failingWriter struct {
+	w     io.Writer
+	limit int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > f.limit {
+		n, _ := f.w.Write(p[:f.limit])
+		f.limit = 0
+		return n, errDiskFull
+	}
+	f.limit -= len(p)
+	return f.w.Write(p)
+}
+
+// 写 rows 行CSV，每行之后 Flush，把写入错误返回给调用方
+func writeRows(w io.Writer, rows int) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"Window_Minutes", "Z_Score"})
+	for i := 1; i <= rows; i++ {
+		writer.Write([]string{strconv.Itoa(i), "1.234567"})
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 写到一半出错时目标文件保持原样，不留下临时文件
+func TestWriteFileAtomicErrorLeavesDestination(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zscore_results.csv")
+	original := []byte("Window_Minutes,Z_Score\n1,0.5\n")
+	if err := os.WriteFile(path, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		return writeRows(&failingWriter{w: w, limit: 100}, 1000)
+	})
+	if !errors.Is(err, errDiskFull) {
+		t.Fatalf("writeFileAtomic 返回 %v, want %v", err, errDiskFull)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(original) {
+		t.Errorf("写入失败后目标文件被修改: %q", data)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		for _, entry := range entries {
+			t.Errorf("目录中有 %s", entry.Name())
+		}
+	}
+
+	// 目标文件不存在时，写入失败也不会创建它
+	missing := filepath.Join(dir, "zscore_matrix.csv")
+	if err := writeFileAtomic(missing, func(w io.Writer) error { return errDiskFull }); !errors.Is(err, errDiskFull) {
+		t.Fatalf("writeFileAtomic 返回 %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("写入失败后创建了目标文件: %v", err)
+	}
+}
+
+// 写入成功时替换目标文件，权限为 0644
+func TestWriteFileAtomicReplaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zscore_results.csv")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, func(w io.Writer) error { return writeRows(w, 3) }); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Window_Minutes,Z_Score\n1,1.234567\n2,1.234567\n3,1.234567\n"; string(data) != want {
+		t.Errorf("文件内容 %q, want %q", data, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("权限 = %v, want 0644", perm)
+	}
+}
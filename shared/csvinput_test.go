@@ -0,0 +1,101 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// Excel 导出的 UTF-8 BOM 文件：K线的第一列、带版本行的波动率表和矩阵的 TimeIndex 标题都能正确解析
+func TestCSVInputUTF8BOM(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(utf8BOM+content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("ETHUSDT_minute_klines.csv", klinesHeader+"\n1767225600000,2026-01-01 00:00:00,2000,2001,1999,2000.5,10,1767225659999,,0,0,0,0\n")
+	klines, rowErrors, err := loadKlines(path, true)
+	if err != nil || len(rowErrors) != 0 || len(klines) != 1 || klines[0].OpenTime != 1767225600000 {
+		t.Errorf("BOM K线: klines = %+v, rowErrors = %v, err = %v", klines, rowErrors, err)
+	}
+
+	path = write("multi_timeframe_volatility.csv", "# schema=3\nWindow_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count,VaR_Pct,Realized_Vol_Pct\n5,0.0035,0.001,0.2,100,-0.3,0.2\n")
+	volatility, err := loadVolatilityData(path, true)
+	if err != nil || volatility[5].StdDev != 0.2 {
+		t.Errorf("BOM 波动率表: %+v, err = %v", volatility, err)
+	}
+
+	path = write("zscore_matrix.csv", "TimeIndex,1,5\n0,0.5,-1\n")
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	input, err := newCSVInput(path, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := newCSVReader(input).ReadAll()
+	if err != nil || records[0][0] != "TimeIndex" || records[1][0] != "0" {
+		t.Errorf("BOM 矩阵: records = %q, err = %v", records, err)
+	}
+}
+
+// Excel“Unicode 文本”（UTF-16LE 带 BOM、制表符分隔）和分号分隔的文件按 -csv-encoding、-csv-delimiter 读取；
+// 自动识别时遇到 GBK 文件直接报错
+func TestCSVInputEncodingAndDelimiter(t *testing.T) {
+	defer func(delimiter rune, encoding string) { csvDelimiter, csvEncoding = delimiter, encoding }(csvDelimiter, csvEncoding)
+	dir := t.TempDir()
+	row := "1767225600000,2026-01-01 00:00:00,2000,2001,1999,2000.5,10,1767225659999,,0,0,0,0"
+
+	utf16Text := utf16.Encode([]rune(utf8BOM + strings.ReplaceAll(klinesHeader+"\n"+row+"\n", ",", "\t")))
+	data := make([]byte, 2*len(utf16Text))
+	for i, u := range utf16Text {
+		data[2*i], data[2*i+1] = byte(u), byte(u>>8)
+	}
+	path := filepath.Join(dir, "utf16.csv")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setCSVDelimiter("tab"); err != nil {
+		t.Fatal(err)
+	}
+	klines, _, err := loadKlines(path, true)
+	if err != nil || len(klines) != 1 || klines[0].Close != 2000.5 {
+		t.Errorf("UTF-16LE: klines = %+v, err = %v", klines, err)
+	}
+
+	path = filepath.Join(dir, "semicolon.csv")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(klinesHeader+"\n"+row+"\n", ",", ";")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setCSVDelimiter(";"); err != nil {
+		t.Fatal(err)
+	}
+	if klines, _, err := loadKlines(path, true); err != nil || len(klines) != 1 {
+		t.Errorf("分号分隔: klines = %+v, err = %v", klines, err)
+	}
+
+	path = filepath.Join(dir, "gbk.csv")
+	if err := os.WriteFile(path, []byte("\xbf\xaa\xc5\xcc\xca\xb1\xbc\xe4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadKlines(path, false); err == nil || !strings.Contains(err.Error(), "不是 UTF-8 编码") {
+		t.Errorf("GBK 文件: err = %v", err)
+	}
+
+	for _, value := range []string{"", ",;", `"`} {
+		if err := setCSVDelimiter(value); err == nil {
+			t.Errorf("setCSVDelimiter(%q) 应返回错误", value)
+		}
+	}
+	if err := setCSVEncoding("gbk"); err == nil {
+		t.Error("setCSVEncoding(gbk) 应返回错误")
+	}
+}
@@ -0,0 +1,16 @@
+// Package shared 是各分析工具共用代码的唯一来源。
+//
+// 仓库根目录下的每个工具都是可以单独 go run 的 package main 文件，不能导入其他包，
+// 所以 K线读取、CSV 输入、窗口解析、表格输出等共用的声明仍然复制在各个工具中。
+// 这些声明只在这里修改，然后运行
+//
+//	go generate ./shared
+//
+// 把各工具中同名的顶层声明（连同文档注释）替换成这里的版本，并补上或去掉相应的 import。
+// 只检查不修改时运行 go run shared/gen.go -root . -check，有工具与这里不一致时列出并返回非0。
+//
+// 新工具需要某个共用声明时先复制一份，之后由生成器保持同步；
+// 与这里同名的声明都会被覆盖，工具自己特有的版本需要换一个名字（例如 windowReturns 和 barReturns 在不同工具中含义不同，就没有放在这里）。
+package shared
+
+//go:generate go run gen.go -root ..
@@ -0,0 +1,295 @@
+//go:build ignore
+
+// 把 shared 包中的声明同步到仓库根目录的各个工具中，见 doc.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 一个顶层声明在源文件中的位置，Start 包含文档注释
+type declSpan struct {
+	Key        string
+	Start, End int
+}
+
+func main() {
+	root := flag.String("root", "..", "仓库根目录（工具所在目录），shared 包在其下的 shared 目录")
+	check := flag.Bool("check", false, "只检查，不修改文件；有工具与 shared 不一致时列出并返回非0")
+	flag.Parse()
+
+	canonical, imports, err := loadShared(filepath.Join(*root, "shared"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(*root, "*.go"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	stale := 0
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if isIgnored(src) {
+			continue
+		}
+		updated, keys, err := syncFile(path, src, canonical, imports)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		stale++
+		if *check {
+			fmt.Printf("%s 与 shared 不一致: %s\n", filepath.Base(path), strings.Join(keys, ", "))
+			continue
+		}
+		if err := os.WriteFile(path, updated, 0644); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("已更新 %s: %s\n", filepath.Base(path), strings.Join(keys, ", "))
+	}
+	if *check && stale > 0 {
+		fmt.Printf("%d 个文件需要运行 go generate ./shared 更新\n", stale)
+		os.Exit(1)
+	}
+}
+
+// 带 //go:build ignore 的文件（例如本生成器）不是工具，跳过
+func isIgnored(src []byte) bool {
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+		if line == "//go:build ignore" {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取 shared 包的全部声明（键为声明名，值为连同文档注释的源码）和用到的 import（包名 -> 路径）
+func loadShared(dir string) (map[string]string, map[string]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, nil, err
+	}
+	canonical := make(map[string]string)
+	imports := make(map[string]string)
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isIgnored(src) {
+			continue
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, spec := range file.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			imports[importName(spec)] = importPath
+		}
+		for _, span := range declSpans(fset, file) {
+			if _, dup := canonical[span.Key]; dup {
+				return nil, nil, fmt.Errorf("shared 中 %s 重复声明", span.Key)
+			}
+			canonical[span.Key] = string(src[span.Start:span.End])
+		}
+	}
+	return canonical, imports, nil
+}
+
+// 文件中每个顶层声明的键和位置；const/var/type 组以组内全部名字为键，方法以 "接收者.方法" 为键
+func declSpans(fset *token.FileSet, file *ast.File) []declSpan {
+	var spans []declSpan
+	for _, decl := range file.Decls {
+		var key string
+		var doc *ast.CommentGroup
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			key, doc = d.Name.Name, d.Doc
+			if d.Recv != nil && len(d.Recv.List) == 1 {
+				key = receiverName(d.Recv.List[0].Type) + "." + key
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			var names []string
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						names = append(names, name.Name)
+					}
+				}
+			}
+			key, doc = d.Tok.String()+" "+strings.Join(names, ","), d.Doc
+		}
+		start := decl.Pos()
+		if doc != nil {
+			start = doc.Pos()
+		}
+		spans = append(spans, declSpan{Key: key, Start: fset.Position(start).Offset, End: fset.Position(decl.End()).Offset})
+	}
+	return spans
+}
+
+func receiverName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return "*" + receiverName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	importPath, _ := strconv.Unquote(spec.Path.Value)
+	return importPath[strings.LastIndex(importPath, "/")+1:]
+}
+
+// 把文件中与 shared 同名的声明替换成 shared 的版本并整理 import，返回新内容和被替换的声明
+func syncFile(path string, src []byte, canonical, sharedImports map[string]string) ([]byte, []string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var keys []string
+	out := append([]byte(nil), src...)
+	spans := declSpans(fset, file)
+	for i := len(spans) - 1; i >= 0; i-- {
+		span := spans[i]
+		text, ok := canonical[span.Key]
+		if !ok || string(src[span.Start:span.End]) == text {
+			continue
+		}
+		keys = append(keys, span.Key)
+		out = append(out[:span.Start:span.Start], append([]byte(text), out[span.End:]...)...)
+	}
+	if len(keys) == 0 {
+		return src, nil, nil
+	}
+	sort.Strings(keys)
+
+	out, err = fixImports(path, out, sharedImports)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, keys, nil
+}
+
+// 替换声明后补上 shared 代码用到而文件中还没有的 import，去掉不再使用的标准库 import
+// 只增删 shared 用到的包，工具自己的 import 不动
+func fixImports(path string, src []byte, sharedImports map[string]string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil {
+				used[x.Name] = true
+			}
+		}
+		return true
+	})
+
+	var specs []string
+	present := make(map[string]bool)
+	changed := false
+	for _, spec := range file.Imports {
+		name := importName(spec)
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		present[name] = true
+		if sharedImports[name] == importPath && !used[name] {
+			changed = true
+			continue
+		}
+		specs = append(specs, string(src[fset.Position(spec.Pos()).Offset:fset.Position(spec.End()).Offset]))
+	}
+	for name, importPath := range sharedImports {
+		if used[name] && !present[name] {
+			specs = append(specs, strconv.Quote(importPath))
+			changed = true
+		}
+	}
+	if !changed {
+		return format.Source(src)
+	}
+
+	// 标准库在前，第三方包在后，中间空一行
+	var std, other []string
+	for _, spec := range specs {
+		fields := strings.Fields(spec)
+		importPath, _ := strconv.Unquote(fields[len(fields)-1])
+		if strings.Contains(strings.Split(importPath, "/")[0], ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	var block bytes.Buffer
+	block.WriteString("import (\n")
+	for _, spec := range std {
+		block.WriteString("\t" + spec + "\n")
+	}
+	if len(std) > 0 && len(other) > 0 {
+		block.WriteString("\n")
+	}
+	for _, spec := range other {
+		block.WriteString("\t" + spec + "\n")
+	}
+	block.WriteString(")")
+
+	var start, end int
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			start, end = fset.Position(d.Pos()).Offset, fset.Position(d.End()).Offset
+			break
+		}
+	}
+	if end == 0 {
+		return nil, fmt.Errorf("%s 没有 import 声明", path)
+	}
+	out := append(append(append([]byte(nil), src[:start]...), block.Bytes()...), src[end:]...)
+	return format.Source(out)
+}
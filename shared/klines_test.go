@@ -0,0 +1,207 @@
+package shared
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const klinesHeader = "Open Time,Open Time (UTC),Open,High,Low,Close,Volume,Close Time,Close Time (UTC),Quote Asset Volume,Number of Trades,Taker Buy Base Asset Volume,Taker Buy Quote Asset Volume"
+
+// 写一个K线CSV：n 根正常的K线，bad 中的行（键为文件行号，标题行为第1行）替换对应位置的数据行
+func writeKlinesWithBadRows(t *testing.T, n int, bad map[int]string) string {
+	t.Helper()
+	lines := []string{klinesHeader}
+	for i := 0; len(lines) < n+len(bad)+1; i++ {
+		if row, ok := bad[len(lines)+1]; ok {
+			lines = append(lines, row)
+			continue
+		}
+		openTime := int64(1767225600000) + int64(i)*60000
+		lines = append(lines, fmt.Sprintf("%d,2026-01-01 00:00:00,2000,2001,1999,2000.5,10,%d,,0,0,0,0", openTime, openTime+59999))
+	}
+	path := filepath.Join(t.TempDir(), "ETHUSDT_minute_klines.csv")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 解析失败的行连同文件行号和原始内容一起返回，其余行照常读取
+func TestLoadKlinesCollectsRowErrors(t *testing.T) {
+	bad := map[int]string{
+		5:   "1767225600000x,2026-01-01 00:00:00,2000,2001,1999,2000.5,10",
+		50:  "1767228600000,2026-01-01 00:50:00,2000",
+		120: "1767232800000,2026-01-01 02:00:00,2000,2001,1999,0,10",
+		300: `1767243600000,"2026-01-01 05:00:00"x,2000,2001,1999,2000.5,10`,
+	}
+	path := writeKlinesWithBadRows(t, 500, bad)
+
+	klines, rowErrors, err := loadKlines(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(klines) != 500 {
+		t.Errorf("读取 %d 根K线, want 500", len(klines))
+	}
+	var lines []int
+	for _, rowErr := range rowErrors {
+		lines = append(lines, rowErr.Line)
+		if rowErr.Err == nil {
+			t.Errorf("第 %d 行没有错误信息", rowErr.Line)
+		}
+	}
+	if want := []int{5, 50, 120, 300}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("出错的行 = %v, want %v", lines, want)
+	}
+	if got := strings.Join(rowErrors[1].Raw, ","); got != bad[50] {
+		t.Errorf("第 50 行原始内容 = %q, want %q", got, bad[50])
+	}
+	for i, want := range []string{"Open Time", "列数不足", "Close", ""} {
+		if !strings.Contains(rowErrors[i].Err.Error(), want) {
+			t.Errorf("第 %d 行错误 %q 中没有 %q", lines[i], rowErrors[i].Err, want)
+		}
+	}
+}
+
+// 解析失败的行超过阈值时返回错误；strict 时第一行出错就返回错误
+func TestLoadKlinesRowErrorThreshold(t *testing.T) {
+	bad := map[int]string{3: "x", 4: "y"}
+	path := writeKlinesWithBadRows(t, 100, bad)
+	if _, rowErrors, err := loadKlines(path, false); err == nil || len(rowErrors) != 2 {
+		t.Errorf("2 / 102 行出错: err = %v, %d 个 RowError", err, len(rowErrors))
+	}
+
+	path = writeKlinesWithBadRows(t, 500, map[int]string{10: "x"})
+	if _, _, err := loadKlines(path, false); err != nil {
+		t.Errorf("1 / 501 行出错不应超过阈值: %v", err)
+	}
+	_, rowErrors, err := loadKlines(path, true)
+	if err == nil || !strings.Contains(err.Error(), "第 10 行") || len(rowErrors) != 1 {
+		t.Errorf("strict: err = %v, %d 个 RowError", err, len(rowErrors))
+	}
+}
+
+// 空文件、只有标题的文件返回各自明确的错误；只有一根K线的文件正常读取
+func TestLoadKlinesEmptyAndHeaderOnly(t *testing.T) {
+	openTime := "1767225600000"
+	row := openTime + ",2026-01-01 00:00:00,2000,2001,1999,2000.5,10,1767225659999,,0,0,0,0\n"
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string // 为空时应读取成功
+	}{
+		{"空文件", "", "是空文件"},
+		{"只有空行", "\n\n", "是空文件"},
+		{"只有标题", klinesHeader + "\n", "只有标题行，没有数据行"},
+		{"一根K线", klinesHeader + "\n" + row, ""},
+	} {
+		path := filepath.Join(t.TempDir(), "ETHUSDT_minute_klines.csv")
+		if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		klines, _, err := loadKlines(path, false)
+		if tc.err == "" {
+			if err != nil || len(klines) != 1 || klines[0].Close != 2000.5 {
+				t.Errorf("%s: klines = %+v, err = %v", tc.name, klines, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: err = %v, want 包含 %q", tc.name, err, tc.err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "ETHUSDT_minute_klines.json")
+	if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadKlinesJSON(path, false); err == nil || !strings.Contains(err.Error(), "没有K线数据") {
+		t.Errorf("空 JSON 数组: err = %v", err)
+	}
+}
+
+// -input-format json 读取 /api/v3/klines 的原始数组：字段按位置映射到 Kline，价格写成数字或字符串都接受，
+// 文件名沿用CSV的约定只换扩展名；元素不足的K线报错
+func TestLoadKlinesJSONFormat(t *testing.T) {
+	defer func(old string) { inputFormat = old }(inputFormat)
+	inputFormat = "json"
+
+	dir := t.TempDir()
+	payload := `[
+  [1767225600000,"2000.10","2001.50","1999.20","2000.80","12.5",1767225659999,"25010.0",42,"6.0","12000.0","0"],
+  [1767225660000,2000.8,2002,2000,2001.9,3,1767225719999,"6005.7",7,"1.0","2001.9","0"]
+]`
+	if err := os.WriteFile(filepath.Join(dir, "ETHUSDT_minute_klines.json"), []byte(payload), 0644); err != nil {
+		t.Fatal(err)
+	}
+	klines, rowErrors, err := loadKlines(filepath.Join(dir, "ETHUSDT_minute_klines.csv"), true)
+	if err != nil || len(rowErrors) != 0 {
+		t.Fatalf("err = %v, rowErrors = %v", err, rowErrors)
+	}
+	want := []Kline{
+		{OpenTime: 1767225600000, Time: "2026-01-01 00:00:00", Open: 2000.10, High: 2001.50, Low: 1999.20, Close: 2000.80, Volume: 12.5},
+		{OpenTime: 1767225660000, Time: "2026-01-01 00:01:00", Open: 2000.8, High: 2002, Low: 2000, Close: 2001.9, Volume: 3},
+	}
+	if !reflect.DeepEqual(klines, want) {
+		t.Errorf("klines = %+v\nwant %+v", klines, want)
+	}
+
+	path := filepath.Join(dir, "short.json")
+	if err := os.WriteFile(path, []byte(`[[1767225600000,"2000","2001","1999","2000.5"]]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, rowErrors, err = loadKlinesJSON(path, true)
+	if err == nil || len(rowErrors) != 1 || !strings.Contains(rowErrors[0].Err.Error(), "元素不足") {
+		t.Errorf("元素不足的K线: err = %v, rowErrors = %v", err, rowErrors)
+	}
+}
+
+// 文件中重复的分钟：默认照常读取并警告，-strict 时报错，-drop-duplicates 时去掉重复并保留第一根
+func TestLoadKlinesDuplicateTimestamps(t *testing.T) {
+	defer func(old bool) { dropDuplicateKlines = old }(dropDuplicateKlines)
+
+	if got := detectDuplicateTimestamps([]string{"a", "b", "a", "c", "b", "a"}); !reflect.DeepEqual(got, []int{2, 4, 5}) {
+		t.Errorf("detectDuplicateTimestamps = %v, want [2 4 5]", got)
+	}
+	if got := detectDuplicateTimestamps([]string{"a", "b", "c"}); len(got) != 0 {
+		t.Errorf("没有重复时 = %v", got)
+	}
+
+	// 5 根连续的K线，第 2 根（00:01）之后又出现一根开盘时间相同、收盘价不同的K线
+	lines := []string{klinesHeader}
+	for i := 0; i < 5; i++ {
+		openTime := int64(1767225600000) + int64(i)*60000
+		lines = append(lines, fmt.Sprintf("%d,2026-01-01 00:0%d:00,2000,2001,1999,2000.5,10,%d,,0,0,0,0", openTime, i, openTime+59999))
+		if i == 1 {
+			lines = append(lines, fmt.Sprintf("%d,2026-01-01 00:01:00,2000,2001,1999,1234,10,%d,,0,0,0,0", openTime, openTime+59999))
+		}
+	}
+	path := filepath.Join(t.TempDir(), "ETHUSDT_minute_klines.csv")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dropDuplicateKlines = false
+	klines, _, err := loadKlines(path, false)
+	if err != nil || len(klines) != 6 {
+		t.Fatalf("默认不去重: %d 根, err = %v", len(klines), err)
+	}
+	if _, _, err := loadKlines(path, true); err == nil || !strings.Contains(err.Error(), "1 根重复的K线") {
+		t.Errorf("-strict 时应报告重复: err = %v", err)
+	}
+
+	dropDuplicateKlines = true
+	klines, _, err = loadKlines(path, true)
+	if err != nil || len(klines) != 5 {
+		t.Fatalf("-drop-duplicates: %d 根, err = %v", len(klines), err)
+	}
+	for i, k := range klines {
+		if want := int64(1767225600000) + int64(i)*60000; k.OpenTime != want || k.Close != 2000.5 {
+			t.Errorf("第 %d 根: OpenTime = %d, Close = %v, want %d 和保留的第一根 2000.5", i, k.OpenTime, k.Close, want)
+		}
+	}
+}
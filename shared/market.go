@@ -0,0 +1,39 @@
+package shared
+
+import "fmt"
+
+// 行情市场，决定请求的域名和接口路径
+type Market int
+
+const (
+	Spot Market = iota
+	USDMFutures
+)
+
+func parseMarket(name string) (Market, error) {
+	switch name {
+	case "spot":
+		return Spot, nil
+	case "usdm":
+		return USDMFutures, nil
+	}
+	return Spot, fmt.Errorf("未知的市场: %s（可选 spot 或 usdm）", name)
+}
+
+// 各市场的 REST 接口地址，测试中指向 httptest 服务器
+var marketBaseURLs = map[Market]string{
+	Spot:        "https://api.binance.com",
+	USDMFutures: "https://fapi.binance.com",
+}
+
+func (m Market) BaseURL() string {
+	return marketBaseURLs[m]
+}
+
+// 两个市场的K线返回格式相同，只有路径不同
+func (m Market) KlinesPath() string {
+	if m == USDMFutures {
+		return "/fapi/v1/klines"
+	}
+	return "/api/v3/klines"
+}
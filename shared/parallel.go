@@ -0,0 +1,13 @@
+package shared
+
+import "runtime"
+
+// 按 -max-cpus 限制并行度，返回 worker 数
+// maxCPUs > 0 时同时设置 GOMAXPROCS，让其他 goroutine（如写文件、GC）也不超过该CPU数；<= 0 表示使用全部CPU
+func setMaxCPUs(maxCPUs int) int {
+	if maxCPUs <= 0 || maxCPUs >= runtime.NumCPU() {
+		return runtime.NumCPU()
+	}
+	runtime.GOMAXPROCS(maxCPUs)
+	return maxCPUs
+}
@@ -0,0 +1,23 @@
+package shared
+
+import (
+	"runtime"
+	"testing"
+)
+
+// -max-cpus 小于CPU数时返回该值并同步设置 GOMAXPROCS；<= 0 或超过CPU数时使用全部CPU
+func TestSetMaxCPUs(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	for _, maxCPUs := range []int{0, -1, runtime.NumCPU(), runtime.NumCPU() + 4} {
+		if got := setMaxCPUs(maxCPUs); got != runtime.NumCPU() {
+			t.Errorf("setMaxCPUs(%d) = %d, want %d", maxCPUs, got, runtime.NumCPU())
+		}
+	}
+	if runtime.NumCPU() < 2 {
+		t.Skip("单CPU机器无法测试上限")
+	}
+	if got := setMaxCPUs(1); got != 1 || runtime.GOMAXPROCS(0) != 1 {
+		t.Errorf("setMaxCPUs(1) = %d, GOMAXPROCS = %d, want 1", got, runtime.GOMAXPROCS(0))
+	}
+}
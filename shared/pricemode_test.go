@@ -0,0 +1,49 @@
+package shared
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// 每种价格取法在一组小K线上得到预期的序列
+func TestKlinePrices(t *testing.T) {
+	klines := []Kline{
+		{Open: 100, High: 110, Low: 90, Close: 105},
+		{Open: 105, High: 106, Low: 101, Close: 102},
+		{Open: 102, High: 108, Low: 102, Close: 108},
+	}
+	for _, tc := range []struct {
+		name string
+		want []float64
+	}{
+		{"close", []float64{105, 102, 108}},
+		{"mid", []float64{100, 103.5, 105}},
+		{"typical", []float64{305.0 / 3, 103, 106}},
+		{"ohlc4", []float64{101.25, 103.5, 105}},
+	} {
+		mode, err := parsePriceMode(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := klinePrices(klines, mode)
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: %d 个价格, want %d", tc.name, len(got), len(tc.want))
+		}
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("%s: 第 %d 根 = %v, want %v", tc.name, i, got[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestParsePriceMode(t *testing.T) {
+	var zero PriceMode
+	if zero != PriceClose {
+		t.Error("PriceMode 的零值应为收盘价")
+	}
+	if _, err := parsePriceMode("vwap"); err == nil || !strings.Contains(err.Error(), "vwap") {
+		t.Errorf("未知的取法: err = %v", err)
+	}
+}
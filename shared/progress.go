@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// 进度输出。stdout 是终端时用 \r 覆盖同一行，避免刷屏；
+// 重定向到文件或管道、或指定 -q 时每次更新输出一行，方便在日志里查看
+type Progress struct {
+	mu      sync.Mutex
+	w       io.Writer
+	compact bool
+	pending bool // 紧凑模式下当前进度行还没有换行
+}
+
+func newProgress(f *os.File, lineMode bool) *Progress {
+	return &Progress{w: f, compact: !lineMode && isTerminal(f)}
+}
+
+// 输出一条进度，可以在多个 goroutine 中同时调用
+func (p *Progress) Update(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if p.compact {
+		// \033[K 清除上一条更长的进度留下的字符
+		fmt.Fprintf(p.w, "\r%s\033[K", msg)
+		p.pending = true
+		return
+	}
+	fmt.Fprintln(p.w, msg)
+}
+
+// 结束当前进度行，之后的普通输出从新的一行开始
+func (p *Progress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending {
+		fmt.Fprintln(p.w)
+		p.pending = false
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// 进度回调：done 从 1 递增到 total，可用于在其他程序或界面中显示计算进度
+type ProgressFunc func(done, total int)
+
+// 在多个 worker 之间累计完成数，按 done 递增的顺序逐个回调 fn（fn 为 nil 时只计数）
+type progressCounter struct {
+	mu    sync.Mutex
+	done  int
+	total int
+	fn    ProgressFunc
+}
+
+func (c *progressCounter) Add() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.done++
+	if c.fn != nil {
+		c.fn(c.done, c.total)
+	}
+}
@@ -0,0 +1,53 @@
+package shared
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 输出不是终端（重定向到文件）时每次更新一行，没有 \r
+func TestProgressNonTTYFallback(t *testing.T) {
+	for _, lineMode := range []bool{false, true} {
+		f, err := os.Create(filepath.Join(t.TempDir(), "progress.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		progress := newProgress(f, lineMode)
+		if progress.compact {
+			t.Fatalf("lineMode=%v: 文件不是终端，不应使用紧凑模式", lineMode)
+		}
+		for _, pct := range []int{10, 50, 100} {
+			progress.Update("[%d%%] 窗口 %d 分钟", pct, pct*10)
+		}
+		progress.Done()
+		f.Close()
+
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "[10%] 窗口 100 分钟\n[50%] 窗口 500 分钟\n[100%] 窗口 1000 分钟\n"; string(data) != want {
+			t.Errorf("lineMode=%v: 输出 %q, want %q", lineMode, data, want)
+		}
+	}
+}
+
+// 紧凑模式用 \r 覆盖同一行，Done 时换行，之后的输出从新的一行开始
+func TestProgressCompact(t *testing.T) {
+	var buf bytes.Buffer
+	progress := &Progress{w: &buf, compact: true}
+	progress.Update("[%d%%]", 10)
+	progress.Update("[%d%%]", 100)
+	progress.Done()
+	progress.Done()
+
+	if want := "\r[10%]\033[K\r[100%]\033[K\n"; buf.String() != want {
+		t.Errorf("输出 %q, want %q", buf.String(), want)
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Error("重复调用 Done 不应再换行")
+	}
+}
@@ -0,0 +1,118 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 检测单根K线的异常跳变（例如小数点错位导致价格差10倍），这类错误报价会让波动率和z-score严重失真
+// 与最近一个正常价格相比涨跌幅超过 maxBarMove（0.5 即 50%）的K线视为异常，直到价格回到该范围内为止；
+// 返回修复后的副本（异常K线按两侧正常价格线性插值）和被标记的下标，调用方可以只报告不使用副本
+// 数据末尾没有回到正常范围的异常K线无法插值，只标记不修复；maxBarMove<=0 时不检测
+func sanitizePrices(prices []float64, maxBarMove float64) ([]float64, []int) {
+	if maxBarMove <= 0 || len(prices) == 0 {
+		return prices, nil
+	}
+	repaired := make([]float64, len(prices))
+	copy(repaired, prices)
+
+	var flagged []int
+	lastGood := -1 // 最近一个正常价格的下标，第一个有效价格视为正常
+	for i, p := range prices {
+		if !(p > 0) {
+			continue // 缺失或非正数的价格由收益率计算跳过，这里不处理
+		}
+		if lastGood < 0 || math.Abs(p/prices[lastGood]-1) <= maxBarMove {
+			// 回到正常范围，对中间被标记的K线插值
+			for j := lastGood + 1; lastGood >= 0 && j < i; j++ {
+				if prices[j] > 0 {
+					frac := float64(j-lastGood) / float64(i-lastGood)
+					repaired[j] = prices[lastGood] + (p-prices[lastGood])*frac
+				}
+			}
+			lastGood = i
+			continue
+		}
+		flagged = append(flagged, i)
+	}
+	return repaired, flagged
+}
+
+// 报告异常跳变的K线，repair 为 true 时返回插值修复后的价格，否则原样返回
+func checkPriceSpikes(klines []Kline, prices []float64, maxBarMove float64, repair bool) []float64 {
+	repaired, flagged := sanitizePrices(prices, maxBarMove)
+	if len(flagged) == 0 {
+		return prices
+	}
+	fmt.Printf("警告: %d 根K线与前一个正常价格相差超过 %.0f%%，可能是错误报价（首个在 %s，价格 %v）\n",
+		len(flagged), maxBarMove*100, klines[flagged[0]].Time, prices[flagged[0]])
+	if !repair {
+		fmt.Println("可以用 -repair-spikes 按两侧正常价格插值修复")
+		return prices
+	}
+	unrepaired := 0
+	for _, i := range flagged {
+		if repaired[i] == prices[i] {
+			unrepaired++
+		}
+	}
+	fmt.Printf("已插值修复 %d 根K线", len(flagged)-unrepaired)
+	if unrepaired > 0 {
+		fmt.Printf("，数据末尾的 %d 根没有回到正常范围，无法修复", unrepaired)
+	}
+	fmt.Println()
+	return repaired
+}
+
+// 用 n 根K线的简单移动平均平滑价格，减少单根K线的噪声，n<=1 时原样返回
+// 平滑会带来约 (n-1)/2 根K线的滞后，并压低短窗口的波动，所以波动率和z-score必须使用相同的 -smooth
+// 前 n-1 个点用已有的K线求平均，保证长度和下标不变
+func smoothPrices(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	smoothed := make([]float64, len(prices))
+	sum := 0.0
+	for i, p := range prices {
+		sum += p
+		count := i + 1
+		if i >= n {
+			sum -= prices[i-n]
+			count = n
+		}
+		smoothed[i] = sum / float64(count)
+	}
+	return smoothed
+}
+
+// 用最近 n 根K线（含当前）的中位数过滤价格，去掉瞬间的错误报价：单根离群的价格在 n>=3 时会被完全剔除，
+// 而持续的真实行情只会滞后约 (n-1)/2 根K线；n<=1 时原样返回
+// 和 -smooth 一样，波动率和z-score必须使用相同的 -median；前 n-1 个点用已有的K线取中位数
+func medianFilter(prices []float64, n int) []float64 {
+	if n <= 1 {
+		return prices
+	}
+	filtered := make([]float64, len(prices))
+	// 窗口内的价格按升序保存，每次滑动二分查找删除最旧的、插入最新的
+	sorted := make([]float64, 0, n)
+	for i, p := range prices {
+		if i >= n {
+			old := prices[i-n]
+			j := sort.SearchFloat64s(sorted, old)
+			sorted = append(sorted[:j], sorted[j+1:]...)
+		}
+		j := sort.SearchFloat64s(sorted, p)
+		sorted = append(sorted, 0)
+		copy(sorted[j+1:], sorted[j:])
+		sorted[j] = p
+
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 1 {
+			filtered[i] = sorted[mid]
+		} else {
+			filtered[i] = (sorted[mid-1] + sorted[mid]) / 2
+		}
+	}
+	return filtered
+}
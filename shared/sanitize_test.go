@@ -0,0 +1,214 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// 随机游走的1分钟价格，每根K线的收益率标准差约 0.01%
+func noisyPrices(n int, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	prices := make([]float64, n)
+	prices[0] = 2000
+	for i := 1; i < n; i++ {
+		prices[i] = prices[i-1] * (1 + rng.NormFloat64()*0.0001)
+	}
+	return prices
+}
+
+// 用没有离群点的历史计算1分钟收益率的均值和标准差（与波动率表相同，历史和当前数据做同样的平滑），
+// 返回带离群点的序列中最大的 |z|
+func maxAbsZScore(history, prices []float64, filter func([]float64) []float64) float64 {
+	history, prices = filter(history), filter(prices)
+	returns := make([]float64, 0, len(history)-1)
+	for i := 1; i < len(history); i++ {
+		returns = append(returns, calculateReturn(history[i-1], history[i], "simple"))
+	}
+	mean := calculateMean(returns)
+	stdDev := calculateStdDev(returns, mean)
+
+	maxZ := 0.0
+	for i := 1; i < len(prices); i++ {
+		z := math.Abs((calculateReturn(prices[i-1], prices[i], "simple") - mean) / stdDev)
+		if z > maxZ {
+			maxZ = z
+		}
+	}
+	return maxZ
+}
+
+// 单根K线的错误报价（+3%，下一根回到原来的水平）在平滑后 z-score 明显减小，中值滤波完全去掉它
+func TestSmoothingAttenuatesOutlier(t *testing.T) {
+	history := noisyPrices(5000, 1)
+	prices := noisyPrices(200, 2)
+	prices[100] *= 1.03
+
+	raw := maxAbsZScore(history, prices, func(p []float64) []float64 { return p })
+	if raw < 100 {
+		t.Fatalf("未平滑时离群点的 |z| = %.1f, 构造的数据有问题", raw)
+	}
+	for _, tc := range []struct {
+		name   string
+		filter func([]float64) []float64
+		max    float64
+	}{
+		{"-smooth 5", func(p []float64) []float64 { return smoothPrices(p, 5) }, raw / 2},
+		{"-smooth 20", func(p []float64) []float64 { return smoothPrices(p, 20) }, raw / 4},
+		{"-median 3", func(p []float64) []float64 { return medianFilter(p, 3) }, 6},
+	} {
+		if z := maxAbsZScore(history, prices, tc.filter); z > tc.max {
+			t.Errorf("%s: |z| = %.1f, 未平滑 %.1f, want <= %.1f", tc.name, z, raw, tc.max)
+		}
+	}
+}
+
+// n<=1 时不平滑，平滑后长度和下标不变，常数序列不受影响
+func TestSmoothPricesIdentity(t *testing.T) {
+	prices := noisyPrices(50, 3)
+	for _, n := range []int{0, 1} {
+		if got := smoothPrices(prices, n); &got[0] != &prices[0] {
+			t.Errorf("smoothPrices(n=%d) 应原样返回", n)
+		}
+		if got := medianFilter(prices, n); &got[0] != &prices[0] {
+			t.Errorf("medianFilter(n=%d) 应原样返回", n)
+		}
+	}
+
+	flat := []float64{7, 7, 7, 7, 7, 7}
+	for _, got := range [][]float64{smoothPrices(flat, 4), medianFilter(flat, 4)} {
+		if len(got) != len(flat) {
+			t.Fatalf("长度 %d, want %d", len(got), len(flat))
+		}
+		for i, p := range got {
+			if p != 7 {
+				t.Errorf("常数序列第 %d 个平滑后为 %v", i, p)
+			}
+		}
+	}
+}
+
+// 单根错误报价被中值滤波完全去掉，持续的真实行情保留下来，只滞后 (n-1)/2 根K线
+func TestMedianFilterRemovesSpikeKeepsMoves(t *testing.T) {
+	prices := make([]float64, 60)
+	for i := range prices {
+		switch {
+		case i < 30:
+			prices[i] = 100
+		case i < 45:
+			prices[i] = 110
+		default:
+			prices[i] = 95
+		}
+	}
+	prices[20] = 130 // 单根错误报价
+	prices[38] = 80
+
+	filtered := medianFilter(prices, 5)
+	for i, want := range prices {
+		switch {
+		case i == 20:
+			want = 100
+		case i == 38:
+			want = 110
+		case i == 30 || i == 31:
+			want = 100 // 上涨滞后两根
+		case i == 45 || i == 46:
+			want = 110 // 下跌滞后两根
+		}
+		if filtered[i] != want {
+			t.Errorf("第 %d 根 = %v, want %v", i, filtered[i], want)
+		}
+	}
+}
+
+// 滑动中位数与每个窗口排序后取中位数的结果一致，包括前 n-1 个点和窗口中有重复价格的情况
+func TestMedianFilterMatchesSort(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	prices := make([]float64, 500)
+	for i := range prices {
+		prices[i] = float64(rng.Intn(20))
+	}
+	for _, n := range []int{2, 3, 4, 7, 30} {
+		filtered := medianFilter(prices, n)
+		for i := range prices {
+			start := i - n + 1
+			if start < 0 {
+				start = 0
+			}
+			window := append([]float64(nil), prices[start:i+1]...)
+			sort.Float64s(window)
+			mid := len(window) / 2
+			want := window[mid]
+			if len(window)%2 == 0 {
+				want = (window[mid-1] + window[mid]) / 2
+			}
+			if filtered[i] != want {
+				t.Fatalf("n=%d 第 %d 根 = %v, want %v", n, i, filtered[i], want)
+			}
+		}
+	}
+}
+
+// 小数点错位的 10 倍报价被标记并按两侧插值修复，其余价格不变，原序列不被修改；
+// 连续两根 0.1 倍的报价一起修复；数据末尾没有回到正常范围的只标记不修复
+func TestSanitizePricesTenXSpike(t *testing.T) {
+	prices := noisyPrices(200, 3)
+	original := append([]float64(nil), prices...)
+	prices[50] *= 10
+	prices[120] /= 10
+	prices[121] /= 10
+	prices[199] *= 10
+	planted := append([]float64(nil), prices...)
+
+	repaired, flagged := sanitizePrices(prices, 0.5)
+	if want := []int{50, 120, 121, 199}; !reflect.DeepEqual(flagged, want) {
+		t.Fatalf("标记的下标 = %v, want %v", flagged, want)
+	}
+	if !reflect.DeepEqual(prices, planted) {
+		t.Fatal("sanitizePrices 修改了传入的序列")
+	}
+	for i := range repaired {
+		var want float64
+		switch i {
+		case 50:
+			want = (original[49] + original[51]) / 2
+		case 120:
+			want = original[119] + (original[122]-original[119])/3
+		case 121:
+			want = original[119] + (original[122]-original[119])*2/3
+		case 199:
+			want = planted[199]
+		default:
+			want = original[i]
+		}
+		if math.Abs(repaired[i]-want) > 1e-9 {
+			t.Errorf("修复后第 %d 个价格 = %v, want %v", i, repaired[i], want)
+		}
+	}
+
+	if _, flagged := sanitizePrices(original, 0.5); len(flagged) != 0 {
+		t.Errorf("正常价格被标记: %v", flagged)
+	}
+	if _, flagged := sanitizePrices(planted, 0); len(flagged) != 0 {
+		t.Errorf("maxBarMove=0 时不应检测: %v", flagged)
+	}
+}
+
+// 只报告模式返回原价格，-repair-spikes 时返回修复后的价格
+func TestCheckPriceSpikesRepairOptional(t *testing.T) {
+	prices := []float64{100, 101, 1010, 102, 103}
+	klines := make([]Kline, len(prices))
+	for i := range klines {
+		klines[i] = Kline{Time: fmt.Sprintf("2026-01-01 00:0%d:00", i), Close: prices[i]}
+	}
+	if got := checkPriceSpikes(klines, prices, 0.5, false); !reflect.DeepEqual(got, prices) {
+		t.Errorf("只报告时返回 %v, want 原价格", got)
+	}
+	if got := checkPriceSpikes(klines, prices, 0.5, true); got[2] != 101.5 || got[3] != 102 {
+		t.Errorf("修复后 = %v, want 第 3 个为 101.5", got)
+	}
+}
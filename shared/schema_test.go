@@ -0,0 +1,89 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTempCSV(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 旧版本或未知版本的波动率表要明确报错，不能按当前的列顺序读出错误的数值
+func TestLoadVolatilityDataSchema(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		err  string // 为空时应读取成功
+	}{
+		{
+			name: "当前版本",
+			data: "# schema=3\nWindow_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count,VaR_Pct,Realized_Vol_Pct\n5,0.0035,0.01,0.2,1000,-0.4,0.3\n",
+		},
+		{
+			name: "没有版本行的兼容旧版本",
+			data: "Window_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count\n5,0.0035,0.01,0.2,1000\n",
+		},
+		{
+			name: "没有版本行且列顺序不同的旧文件",
+			data: "Window_Minutes,Window_Days,StdDev_Pct,Mean_Pct,Sample_Count\n5,0.0035,0.2,0.01,1000\n",
+			err:  "第 3 列应为 Mean_Pct",
+		},
+		{
+			name: "更新的未知版本",
+			data: "# schema=9\nWindow_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count\n5,0.0035,0.01,0.2,1000\n",
+			err:  "schema=9 无法识别",
+		},
+		{
+			name: "版本行格式错误",
+			data: "# schema=v3\nWindow_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count\n",
+			err:  "版本行格式错误",
+		},
+	} {
+		path := writeTempCSV(t, "multi_timeframe_volatility.csv", tc.data)
+		volatilityData, err := loadVolatilityData(path, true)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: err = %v, want 包含 %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := volatilityData[5]; got != (VolatilityData{Mean: 0.01, StdDev: 0.2, SampleCount: 1000}) {
+			t.Errorf("%s: 5 分钟窗口 = %+v", tc.name, got)
+		}
+	}
+}
+
+// 迁移说明中标记为不兼容的旧版本报错并给出说明，兼容的旧版本继续读取
+func TestCheckSchemaMigrations(t *testing.T) {
+	migrations := map[int]SchemaMigration{
+		1: {Compatible: false, Note: "列顺序不同，请重新生成"},
+		2: {Compatible: true, Note: "缺少末尾的列"},
+	}
+	records := [][]string{{"A", "B"}}
+	for _, tc := range []struct {
+		version int
+		err     string
+	}{
+		{3, ""},
+		{2, ""},
+		{1, "列顺序不同，请重新生成"},
+		{4, "无法识别"},
+	} {
+		err := checkSchema("test.csv", tc.version, 3, migrations, records, []string{"A", "B"})
+		if (tc.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("schema=%d: err = %v, want %q", tc.version, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+)
+
+// 计算收益率（百分比）
+// simple: (p1-p0)/p0*100，默认方式，与历史结果保持一致
+// log:    ln(p1/p0)*100，对数收益率可以按时间相加（两个相邻子区间之和等于整个区间），
+// 简单收益率不满足这一点，多周期聚合时误差会累积
+// 任一价格不是正数时返回 NaN（与缺少数据的约定相同），调用方需要跳过，不能当作0收益
+func calculateReturn(prevPrice, price float64, returnMode string) float64 {
+	if !(prevPrice > 0) || !(price > 0) {
+		return math.NaN()
+	}
+	if returnMode == "log" {
+		return math.Log(price/prevPrice) * 100
+	}
+	return ((price - prevPrice) / prevPrice) * 100
+}
+
+// 空切片返回0，避免 0/0 得到 NaN
+func calculateMean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0.0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func calculateStdDev(values []float64, mean float64) float64 {
+	if len(values) <= 1 {
+		return 0.0
+	}
+	sumSqDiff := 0.0
+	for _, v := range values {
+		diff := v - mean
+		sumSqDiff += diff * diff
+	}
+	variance := sumSqDiff / float64(len(values)-1)
+	return math.Sqrt(variance)
+}
+
+// 标准正态分布的累积分布函数(CDF)
+// 使用误差函数的近似公式
+func normalCDF(z float64) float64 {
+	// 使用Abramowitz and Stegun近似公式
+	// 对于负值，使用对称性: P(Z <= -z) = 1 - P(Z <= z)
+	if z < 0 {
+		return 1 - normalCDF(-z)
+	}
+
+	// 对于z >= 0的情况
+	t := 1.0 / (1.0 + 0.2316419*z)
+	d := 0.3989423 * math.Exp(-z*z/2)
+	p := d * t * (0.3193815 + t*(-0.3565638+t*(1.781478+t*(-1.821256+t*1.330274))))
+
+	return 1 - p
+}
+
+// 普通最小二乘回归 y = slope*x + intercept，同时返回拟合优度 R²
+// 长度不一致、样本少于2个或 x 没有变化（无法确定斜率）时返回错误
+func ols(x, y []float64) (float64, float64, float64, error) {
+	if len(x) != len(y) {
+		return 0, 0, 0, fmt.Errorf("回归数据长度不一致: x %d 个，y %d 个", len(x), len(y))
+	}
+	if len(x) < 2 {
+		return 0, 0, 0, fmt.Errorf("回归至少需要 2 个样本，实际 %d 个", len(x))
+	}
+
+	n := float64(len(x))
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= n
+	meanY /= n
+
+	var sxx, syy, sxy float64
+	for i := range x {
+		dx := x[i] - meanX
+		dy := y[i] - meanY
+		sxx += dx * dx
+		syy += dy * dy
+		sxy += dx * dy
+	}
+	if sxx == 0 {
+		return 0, 0, 0, fmt.Errorf("x 的方差为 0，无法回归")
+	}
+
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+	// y 没有变化时直线完全拟合
+	r2 := 1.0
+	if syy > 0 {
+		r2 = sxy * sxy / (sxx * syy)
+	}
+	return slope, intercept, r2, nil
+}
@@ -0,0 +1,120 @@
+package shared
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// 对数收益率可以按时间相加：两个相邻子区间的收益率之和等于整个区间的收益率，简单收益率不满足
+func TestCalculateReturnAggregation(t *testing.T) {
+	for _, prices := range [][3]float64{
+		{100, 110, 121},
+		{100, 90, 99},
+		{2000, 2600, 1900},
+		{0.5, 0.49, 0.52},
+	} {
+		p0, p1, p2 := prices[0], prices[1], prices[2]
+
+		full := calculateReturn(p0, p2, "log")
+		sum := calculateReturn(p0, p1, "log") + calculateReturn(p1, p2, "log")
+		if math.Abs(sum-full) > 1e-9 {
+			t.Errorf("%v: 对数收益率 %v + %v = %v, 整个区间 %v", prices,
+				calculateReturn(p0, p1, "log"), calculateReturn(p1, p2, "log"), sum, full)
+		}
+
+		full = calculateReturn(p0, p2, "simple")
+		sum = calculateReturn(p0, p1, "simple") + calculateReturn(p1, p2, "simple")
+		if math.Abs(sum-full) < 1e-6 {
+			t.Errorf("%v: 简单收益率之和 %v 不应等于整个区间 %v", prices, sum, full)
+		}
+	}
+}
+
+// 未指定或未知的 returnMode 按简单收益率计算，保持原有结果
+func TestCalculateReturnDefaultSimple(t *testing.T) {
+	for _, mode := range []string{"", "simple", "unknown"} {
+		if got := calculateReturn(100, 110, mode); math.Abs(got-10) > 1e-9 {
+			t.Errorf("calculateReturn(100, 110, %q) = %v, want 10", mode, got)
+		}
+	}
+	if got, want := calculateReturn(100, 110, "log"), math.Log(1.1)*100; math.Abs(got-want) > 1e-9 {
+		t.Errorf("calculateReturn(100, 110, log) = %v, want %v", got, want)
+	}
+}
+
+// 完全线性的数据 R² 为1；带噪声的数据斜率和截距接近真实值，R² 小于1
+func TestOLS(t *testing.T) {
+	x := make([]float64, 50)
+	y := make([]float64, 50)
+	for i := range x {
+		x[i] = float64(i)
+		y[i] = 3 - 0.5*x[i]
+	}
+	slope, intercept, r2, err := ols(x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(slope+0.5) > 1e-12 || math.Abs(intercept-3) > 1e-12 || math.Abs(r2-1) > 1e-12 {
+		t.Errorf("线性数据: slope=%v intercept=%v r2=%v, want -0.5, 3, 1", slope, intercept, r2)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	x = make([]float64, 2000)
+	y = make([]float64, 2000)
+	for i := range x {
+		x[i] = rng.Float64() * 10
+		y[i] = 2*x[i] + 1 + rng.NormFloat64()
+	}
+	slope, intercept, r2, err = ols(x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 理论 R² = var(2x) / (var(2x) + 1) = 33.3 / 34.3
+	if math.Abs(slope-2) > 0.05 || math.Abs(intercept-1) > 0.2 || math.Abs(r2-0.971) > 0.01 {
+		t.Errorf("带噪声的数据: slope=%v intercept=%v r2=%v, want 约 2, 1, 0.971", slope, intercept, r2)
+	}
+}
+
+// 长度不一致、样本不足和 x 没有变化时返回错误
+func TestOLSErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		x, y []float64
+	}{
+		{"长度不一致", []float64{1, 2, 3}, []float64{1, 2}},
+		{"样本不足", []float64{1}, []float64{1}},
+		{"x 没有变化", []float64{5, 5, 5}, []float64{1, 2, 3}},
+	} {
+		if _, _, _, err := ols(tc.x, tc.y); err == nil {
+			t.Errorf("%s: 没有返回错误", tc.name)
+		}
+	}
+}
+
+// 价格为0或负数时收益率为 NaN（调用方跳过）；空切片和单个值的均值、标准差为0，不会得到 NaN
+func TestStatsGuardZeroAndEmpty(t *testing.T) {
+	for _, mode := range []string{"simple", "log"} {
+		for _, prices := range [][2]float64{{0, 100}, {100, 0}, {-5, 100}, {math.NaN(), 100}} {
+			if r := calculateReturn(prices[0], prices[1], mode); !math.IsNaN(r) {
+				t.Errorf("%s: calculateReturn(%v, %v) = %v, want NaN", mode, prices[0], prices[1], r)
+			}
+		}
+	}
+	for _, values := range [][]float64{nil, {}, {3.5}} {
+		mean := calculateMean(values)
+		if std := calculateStdDev(values, mean); math.IsNaN(mean) || std != 0 {
+			t.Errorf("%v: mean = %v, stddev = %v", values, mean, std)
+		}
+	}
+	if mean := calculateMean(nil); mean != 0 {
+		t.Errorf("calculateMean(nil) = %v, want 0", mean)
+	}
+
+	// 价格为0的K线当作无法解析的行
+	record := []string{"1767225600000", "2026-01-01 00:00:00", "2000", "2001", "1999", "0", "10"}
+	if _, err := parseKline(record); err == nil || !strings.Contains(err.Error(), "Close") {
+		t.Errorf("Close 为0: err = %v", err)
+	}
+}
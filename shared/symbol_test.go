@@ -0,0 +1,38 @@
+package shared
+
+import "testing"
+
+// -symbol-suffix 为 USDC 时交易对为 ETHUSDC，K线文件名随之改变；大小写和空白不影响，非字母数字的计价币报错
+func TestSymbolSuffixFileNames(t *testing.T) {
+	defer func(old string) { symbolSuffix = old }(symbolSuffix)
+
+	if got := klinesFileName("minute_klines"); got != "ETHUSDT_minute_klines.csv" {
+		t.Errorf("默认文件名 = %s, want ETHUSDT_minute_klines.csv", got)
+	}
+	if err := setSymbolSuffix(" usdc "); err != nil {
+		t.Fatal(err)
+	}
+	if got := composeSymbol(baseAsset, symbolSuffix); got != "ETHUSDC" {
+		t.Errorf("交易对 = %s, want ETHUSDC", got)
+	}
+	for kind, want := range map[string]string{
+		"minute_klines": "ETHUSDC_minute_klines.csv",
+		"latest_14days": "ETHUSDC_latest_14days.csv",
+	} {
+		if got := klinesFileName(kind); got != want {
+			t.Errorf("klinesFileName(%q) = %s, want %s", kind, got, want)
+		}
+	}
+	if got := composeSymbol(" btc", "fdusd "); got != "BTCFDUSD" {
+		t.Errorf("composeSymbol = %s, want BTCFDUSD", got)
+	}
+
+	for _, value := range []string{"", "  ", "US-DT", "USDT/", "ＵＳＤＣ"} {
+		if err := setSymbolSuffix(value); err == nil {
+			t.Errorf("setSymbolSuffix(%q) 应返回错误", value)
+		}
+	}
+	if symbolSuffix != "USDC" {
+		t.Errorf("无效的计价币改变了设置: %s", symbolSuffix)
+	}
+}
@@ -0,0 +1,14 @@
+package shared
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// 各工具中的共用声明必须与 shared 一致，不一致时运行 go generate ./shared
+func TestToolsInSync(t *testing.T) {
+	out, err := exec.Command("go", "run", "gen.go", "-root", "..", "-check").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// 合成K线的参数（几何布朗运动，每根K线1分钟）
+type GBMParams struct {
+	StartPrice float64
+	StartTime  time.Time
+	Drift      float64 // 每分钟对数收益率的期望（小数，如 0.00001）
+	Volatility float64 // 每分钟对数收益率的标准差（小数，如 0.001）
+	JumpIndex  int     // 在该下标的K线插入一次跳跃，< 0 表示不插入
+	JumpSigma  float64 // 跳跃的大小，为 Volatility 的倍数，负数表示下跌
+}
+
+// 生成 n 根确定性的1分钟K线：相同的 seed 和参数得到完全相同的数据
+// 跳跃K线仍会消耗一次随机数，因此有无跳跃时其余K线保持一致
+func generateKlines(n int, seed int64, params GBMParams) []Kline {
+	rng := rand.New(rand.NewSource(seed))
+	klines := make([]Kline, n)
+	price := params.StartPrice
+	for i := range klines {
+		logReturn := params.Drift - params.Volatility*params.Volatility/2 + params.Volatility*rng.NormFloat64()
+		if i == params.JumpIndex {
+			logReturn = params.JumpSigma * params.Volatility
+		}
+		open := price
+		price *= math.Exp(logReturn)
+		openTime := params.StartTime.Add(time.Duration(i) * time.Minute)
+		klines[i] = Kline{
+			OpenTime: openTime.UnixMilli(),
+			Time:     openTime.UTC().Format("2006-01-02 15:04:05"),
+			Open:     open,
+			High:     math.Max(open, price) * (1 + math.Abs(rng.NormFloat64())*params.Volatility/4),
+			Low:      math.Min(open, price) * (1 - math.Abs(rng.NormFloat64())*params.Volatility/4),
+			Close:    price,
+			Volume:   10 + rng.ExpFloat64()*50,
+		}
+	}
+	return klines
+}
+
+// 按下载脚本的格式写出合成K线，没有的列（成交额、笔数等）写为 0
+func writeSyntheticKlinesCSV(path string, klines []Kline) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Open Time", "Open Time (UTC)", "Open", "High", "Low", "Close", "Volume",
+			"Close Time", "Close Time (UTC)", "Quote Asset Volume", "Number of Trades",
+			"Taker Buy Base Asset Volume", "Taker Buy Quote Asset Volume"})
+
+		for _, k := range klines {
+			closeTime := time.UnixMilli(k.OpenTime).Add(time.Minute - time.Millisecond)
+			writer.Write([]string{
+				strconv.FormatInt(k.OpenTime, 10),
+				k.Time,
+				strconv.FormatFloat(k.Open, 'f', -1, 64),
+				strconv.FormatFloat(k.High, 'f', -1, 64),
+				strconv.FormatFloat(k.Low, 'f', -1, 64),
+				strconv.FormatFloat(k.Close, 'f', -1, 64),
+				strconv.FormatFloat(k.Volume, 'f', -1, 64),
+				strconv.FormatInt(closeTime.UnixMilli(), 10),
+				closeTime.UTC().Format("2006-01-02 15:04:05"),
+				"0", "0", "0", "0",
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+}
@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// 每种格式的输出结构：text 各列按显示宽度对齐（中文占两列），tsv 每行列数相同且单元格内没有制表符，
+// markdown 第二行为分隔行、每行以 | 开头结尾且竖线被转义、列数不足的行补齐
+func TestTableRenderFormats(t *testing.T) {
+	defer func(old string) { outputFormat = old }(outputFormat)
+
+	table := newTable("窗口", "z-score", "说明")
+	table.Add("1分钟", "2.3980", "显著偏离")
+	table.Add("1440分钟", "-0.12", "a|b\tc")
+	table.Add("无数据")
+
+	render := func(format string) []string {
+		t.Helper()
+		if err := setOutputFormat(format); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := table.Render(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	}
+
+	text := render("text")
+	if len(text) != 5 || strings.Trim(text[1], "- ") != "" {
+		t.Fatalf("text 输出:\n%s", strings.Join(text, "\n"))
+	}
+	// 第二列在每行（分隔行除外）的起始显示位置相同
+	column := displayWidth("1440分钟") + 2
+	for i, cell := range map[int]string{0: "z-score", 2: "2.3980", 3: "-0.12"} {
+		if at := strings.Index(text[i], cell); at < 0 || displayWidth(text[i][:at]) != column {
+			t.Errorf("text 第二列没有对齐: %q", text[i])
+		}
+	}
+
+	tsv := render("tsv")
+	if len(tsv) != 4 {
+		t.Fatalf("tsv 输出 %d 行, want 4", len(tsv))
+	}
+	if tsv[0] != "窗口\tz-score\t说明" || tsv[2] != "1440分钟\t-0.12\ta|b c" || tsv[3] != "无数据" {
+		t.Errorf("tsv 输出:\n%s", strings.Join(tsv, "\n"))
+	}
+
+	markdown := render("markdown")
+	want := []string{
+		"| 窗口 | z-score | 说明 |",
+		"| --- | --- | --- |",
+		"| 1分钟 | 2.3980 | 显著偏离 |",
+		"| 1440分钟 | -0.12 | a\\|b\tc |",
+		"| 无数据 |  |  |",
+	}
+	if strings.Join(markdown, "\n") != strings.Join(want, "\n") {
+		t.Errorf("markdown 输出:\n%s\nwant:\n%s", strings.Join(markdown, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err := setOutputFormat("csv"); err == nil {
+		t.Error("未知的格式应返回错误")
+	}
+}
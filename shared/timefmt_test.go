@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // 测试环境可能没有系统时区数据
+)
+
+// 三天的1分钟K线，从 2026-01-01 00:00 UTC 开始
+func threeDaysOfKlines() []Kline {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	klines := make([]Kline, 3*1440)
+	for i := range klines {
+		t := start.Add(time.Duration(i) * time.Minute)
+		klines[i] = Kline{OpenTime: t.UnixMilli(), Time: t.Format(timestampLayout), Close: 2000}
+	}
+	return klines
+}
+
+// 选出已知的子范围，检查第一根和最后一根K线的时间
+func TestSelectTimeRange(t *testing.T) {
+	klines := threeDaysOfKlines()
+	for _, tc := range []struct {
+		since, until string
+		first, last  string
+	}{
+		{"2026-01-02", "2026-01-03", "2026-01-02 00:00:00", "2026-01-02 23:59:00"},
+		{"2026-01-01T06:30:00Z", "2026-01-01T08:00:00Z", "2026-01-01 06:30:00", "2026-01-01 07:59:00"},
+		{"2026-01-02T12:00:00+08:00", "", "2026-01-02 04:00:00", "2026-01-03 23:59:00"},
+		{"", "2026-01-01T00:10:00Z", "2026-01-01 00:00:00", "2026-01-01 00:09:00"},
+		{"", "", "2026-01-01 00:00:00", "2026-01-03 23:59:00"},
+	} {
+		start, end, err := selectTimeRange(klines, tc.since, tc.until)
+		if err != nil {
+			t.Errorf("since=%q until=%q: %v", tc.since, tc.until, err)
+			continue
+		}
+		if klines[start].Time != tc.first || klines[end-1].Time != tc.last {
+			t.Errorf("since=%q until=%q: %s 到 %s, want %s 到 %s",
+				tc.since, tc.until, klines[start].Time, klines[end-1].Time, tc.first, tc.last)
+		}
+	}
+}
+
+// since 不早于 until、超出数据范围或格式错误时明确报错
+func TestSelectTimeRangeErrors(t *testing.T) {
+	klines := threeDaysOfKlines()
+	for _, tc := range []struct {
+		since, until string
+		err          string
+	}{
+		{"2026-01-03", "2026-01-02", "必须早于"},
+		{"2026-01-02", "2026-01-02", "必须早于"},
+		{"2025-12-31", "2026-01-02", "超出了数据覆盖的范围"},
+		{"2026-01-02", "2026-01-05", "超出了数据覆盖的范围"},
+		{"2026/01/02", "", "无法解析时间"},
+		{"2026-01-02T00:00:10Z", "2026-01-02T00:00:50Z", "没有数据"},
+	} {
+		_, _, err := selectTimeRange(klines, tc.since, tc.until)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("since=%q until=%q: err = %v, want 包含 %q", tc.since, tc.until, err, tc.err)
+		}
+	}
+	if _, _, err := selectTimeRange(nil, "", ""); err == nil {
+		t.Error("没有K线时应返回错误")
+	}
+}
+
+// CSV 中的 UTC 时间按 -tz 转换显示；未知的时区返回错误且不改变当前设置；无法解析的时间原样返回
+func TestFormatTimestampTimezones(t *testing.T) {
+	defer func() { displayLocation = time.UTC }()
+	for _, tc := range []struct {
+		tz, value, want string
+	}{
+		{"UTC", "2026-01-01 00:30:00", "2026-01-01 00:30:00 UTC"},
+		{"Asia/Shanghai", "2026-01-01 20:30:00", "2026-01-02 04:30:00 CST"},
+		{"America/New_York", "2026-01-01 00:30:00", "2025-12-31 19:30:00 EST"},
+		{"America/New_York", "2026-07-01 00:30:00", "2026-06-30 20:30:00 EDT"},
+	} {
+		if err := setDisplayTimezone(tc.tz); err != nil {
+			t.Fatal(err)
+		}
+		if got := formatTimestamp(tc.value); got != tc.want {
+			t.Errorf("%s: formatTimestamp(%q) = %q, want %q", tc.tz, tc.value, got, tc.want)
+		}
+	}
+
+	if err := setDisplayTimezone("Mars/Olympus"); err == nil || !strings.Contains(err.Error(), "Mars/Olympus") {
+		t.Errorf("未知的时区: err = %v", err)
+	}
+	if displayLocation.String() != "America/New_York" {
+		t.Errorf("设置失败后时区变成了 %s", displayLocation)
+	}
+	if got := formatTimestamp("N/A"); got != "N/A" {
+		t.Errorf("formatTimestamp(\"N/A\") = %q", got)
+	}
+}
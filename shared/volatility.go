@@ -0,0 +1,119 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+type VolatilityData struct {
+	Mean        float64
+	StdDev      float64
+	SampleCount int
+}
+
+// 读取 calculate_volatility 输出的波动率表
+// 无法解析的行默认跳过，strict 为 true 时返回错误
+func loadVolatilityData(path string, strict bool) (map[int]VolatilityData, error) {
+	version, volRecords, err := readSchemaCSV(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取波动率CSV失败: %v", err)
+	}
+	if err := checkSchema("multi_timeframe_volatility.csv", version, volatilitySchemaVersion, volatilitySchemaMigrations, volRecords, volatilityRequiredHeader); err != nil {
+		return nil, err
+	}
+
+	// 解析波动率数据（跳过标题行）
+	volatilityData := make(map[int]VolatilityData)
+	for i := 1; i < len(volRecords); i++ {
+		data, window, err := parseVolatilityRow(volRecords[i])
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("multi_timeframe_volatility.csv 第 %d 条数据解析失败: %v", i, err)
+			}
+			continue
+		}
+		volatilityData[window] = data
+	}
+	return volatilityData, nil
+}
+
+// 解析波动率表的一行: Window_Minutes, Window_Days, Mean_Pct, StdDev_Pct, Sample_Count, ...
+func parseVolatilityRow(record []string) (VolatilityData, int, error) {
+	if len(record) < 5 {
+		return VolatilityData{}, 0, fmt.Errorf("列数不足: 需要至少 5 列，实际 %d 列", len(record))
+	}
+	window, err := strconv.Atoi(record[0])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Window_Minutes 格式错误: %v", err)
+	}
+	mean, err := strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Mean_Pct 格式错误: %v", err)
+	}
+	stdDev, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("StdDev_Pct 格式错误: %v", err)
+	}
+	sampleCount, err := strconv.Atoi(record[4])
+	if err != nil {
+		return VolatilityData{}, 0, fmt.Errorf("Sample_Count 格式错误: %v", err)
+	}
+	return VolatilityData{Mean: mean, StdDev: stdDev, SampleCount: sampleCount}, window, nil
+}
+
+// 波动率表记录的K线数与当前价格数据相差超过该比例时，认为波动率表已过期
+const volatilityStaleTolerance = 0.01
+
+// 由波动率表的样本数反推计算时用了多少根K线：窗口 w 的样本数为 K线数 - w
+func volatilityBarCount(volatilityData map[int]VolatilityData) int {
+	smallest := 0
+	for window := range volatilityData {
+		if smallest == 0 || window < smallest {
+			smallest = window
+		}
+	}
+	if smallest == 0 {
+		return 0
+	}
+	return volatilityData[smallest].SampleCount + smallest
+}
+
+// 检查波动率表是否基于当前的价格数据计算，K线数相差超过容差时返回错误
+func checkVolatilityFreshness(volatilityData map[int]VolatilityData, bars int) error {
+	volBars := volatilityBarCount(volatilityData)
+	if volBars == 0 || bars == 0 {
+		return nil
+	}
+	diff := math.Abs(float64(bars-volBars)) / float64(bars)
+	if diff > volatilityStaleTolerance {
+		return fmt.Errorf("波动率表基于 %d 根K线计算，当前价格数据有 %d 根（相差 %.1f%%，超过 %.0f%% 的容差），波动率表可能已过期，请重新运行 calculate_volatility",
+			volBars, bars, diff*100, volatilityStaleTolerance*100)
+	}
+	return nil
+}
+
+// 按整个价格序列计算各窗口重叠收益率的均值和样本标准差（与 calculate_volatility 的等权结果相同），
+// 样本少于 2 个的窗口不出现在结果中
+func estimateVolatility(prices []float64, windows []int, returnMode string) map[int]VolatilityData {
+	volatility := make(map[int]VolatilityData, len(windows))
+	for _, window := range windows {
+		var n int
+		var mean, m2 float64
+		for i := window; i < len(prices); i++ {
+			r := calculateReturn(prices[i-window], prices[i], returnMode)
+			if math.IsNaN(r) {
+				continue
+			}
+			n++
+			delta := r - mean
+			mean += delta / float64(n)
+			m2 += delta * (r - mean)
+		}
+		if n < 2 {
+			continue
+		}
+		volatility[window] = VolatilityData{Mean: mean, StdDev: math.Sqrt(m2 / float64(n-1)), SampleCount: n}
+	}
+	return volatility
+}
@@ -0,0 +1,31 @@
+package shared
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWindows(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  []int
+		ok    bool
+	}{
+		{"", DefaultWindows, true},
+		{"5,60,1440", []int{5, 60, 1440}, true},
+		{" 15 , 30 ", []int{15, 30}, true},
+		{"5,,60", nil, false},
+		{"0", nil, false},
+		{"-5", nil, false},
+		{"1h", nil, false},
+	} {
+		got, err := parseWindows(tc.value)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseWindows(%q) error = %v, want ok=%v", tc.value, err, tc.ok)
+			continue
+		}
+		if tc.ok && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseWindows(%q) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// z-score 的解释阈值：|z| 超过 zScoreSignificant 为显著偏离，超过 zScoreNotable 为偏离
+const (
+	zScoreSignificant = 2.0
+	zScoreNotable     = 1.0
+)
+
+// z-score 所在区间的说明，控制台报告和 zscore_results.csv 使用同一套阈值
+func interpretZScore(zscore float64) string {
+	switch {
+	case math.IsNaN(zscore):
+		return "无法计算（缺少波动率数据或标准差为0）"
+	case zscore > zScoreSignificant:
+		return "显著高于均值"
+	case zscore > zScoreNotable:
+		return "高于均值"
+	case zscore < -zScoreSignificant:
+		return "显著低于均值"
+	case zscore < -zScoreNotable:
+		return "低于均值"
+	}
+	return "接近均值"
+}
+
+// 增量计算z-score：用环形缓冲区保存最近 maxWindow+1 个价格，
+// 每来一根新K线只计算被跟踪窗口的z-score，结果与批量矩阵一致
+type ZScoreTracker struct {
+	volatilityData map[int]VolatilityData
+	windows        []int
+	returnMode     string
+	prices         []float64 // 环形缓冲区
+	next           int       // 下一个写入位置
+	count          int       // 已写入的价格总数
+}
+
+func newZScoreTracker(volatilityData map[int]VolatilityData, windows []int, returnMode string) *ZScoreTracker {
+	maxWindow := 0
+	for _, w := range windows {
+		if w > maxWindow {
+			maxWindow = w
+		}
+	}
+	return &ZScoreTracker{
+		volatilityData: volatilityData,
+		windows:        windows,
+		returnMode:     returnMode,
+		prices:         make([]float64, maxWindow+1),
+	}
+}
+
+// 跳过 n 根缺失的K线，按 NaN 价格写入缓冲区，引用到它们的窗口 z-score 为 NaN
+func (t *ZScoreTracker) Skip(n int) {
+	for i := 0; i < n && i < len(t.prices); i++ {
+		t.prices[t.next] = math.NaN()
+		t.next = (t.next + 1) % len(t.prices)
+	}
+	t.count += n
+}
+
+// 推入最新价格，返回每个跟踪窗口的当前z-score
+// 历史数据不足或缺少波动率数据的窗口不出现在结果中；跨过缺失数据或标准差为0的窗口为 NaN
+func (t *ZScoreTracker) Update(price float64) map[int]float64 {
+	t.prices[t.next] = price
+	current := t.next
+	t.next = (t.next + 1) % len(t.prices)
+	t.count++
+
+	zScores := make(map[int]float64, len(t.windows))
+	for _, window := range t.windows {
+		if window >= t.count {
+			continue
+		}
+		volData, exists := t.volatilityData[window]
+		if !exists {
+			continue
+		}
+
+		prevPrice := t.prices[(current-window+len(t.prices))%len(t.prices)]
+		returnPct := calculateReturn(prevPrice, price, t.returnMode)
+
+		// 标准差为0时z-score没有意义，写为 NaN 而不是0，避免被当作“正好在均值上”
+		zScore := math.NaN()
+		if volData.StdDev > 0 {
+			zScore = (returnPct - volData.Mean) / volData.StdDev
+		}
+		zScores[window] = zScore
+	}
+	return zScores
+}
+
+// 计算 timeIdx 时刻各窗口的z-score，row[j] 对应 windows[j]
+// 缺少波动率数据或标准差为0的窗口设为 NaN，window > timeIdx 无法计算的窗口设为0
+func computeZScoreRow(prices []float64, timeIdx int, windows []int, volatilityData map[int]VolatilityData, returnMode string, row []float64) {
+	currentPrice := prices[timeIdx]
+
+	// 对于每个时间窗口
+	for j, window := range windows {
+		// 对于 window > timeIdx 的情况，无法计算，设为0
+		if window > timeIdx {
+			row[j] = 0
+			continue
+		}
+		prevPrice := prices[timeIdx-window]
+		returnPct := calculateReturn(prevPrice, currentPrice, returnMode)
+
+		// 获取该窗口的均值和标准差
+		volData, exists := volatilityData[window]
+		if !exists {
+			row[j] = math.NaN()
+			continue
+		}
+
+		// 计算z-score，标准差为0时没有意义，和缺少波动率数据一样写为 NaN
+		zScore := math.NaN()
+		if volData.StdDev > 0 {
+			zScore = (returnPct - volData.Mean) / volData.StdDev
+		}
+
+		row[j] = zScore
+	}
+}
+
+// z-score矩阵标题中的窗口（分钟）到列下标的映射，第 0 列是 TimeIndex
+// v4 起矩阵可以只包含生成时 -matrix-windows 指定的窗口，必须按标题中的窗口值找列，不能假设第 N 列就是 N 分钟窗口
+func matrixColumns(name string, header []string) (map[int]int, error) {
+	columns := make(map[int]int, len(header))
+	for i, col := range header[1:] {
+		window, err := strconv.Atoi(col)
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("%s 第 %d 列的窗口不是正整数: %q", name, i+2, col)
+		}
+		columns[window] = i + 1
+	}
+	return columns, nil
+}
+
+// windows 中不超过 maxWindow（矩阵覆盖的分钟数）却不在矩阵中的窗口，用于提示生成矩阵时的 -matrix-windows 没有包含它们
+func missingMatrixWindows(columns map[int]int, windows []int, maxWindow int) []int {
+	var missing []int
+	for _, window := range windows {
+		if _, ok := columns[window]; !ok && window <= maxWindow {
+			missing = append(missing, window)
+		}
+	}
+	return missing
+}
+
+// 一段连续突破阈值的行情，下标相对于传入的z-score序列
+type ExtremeEvent struct {
+	Start   int
+	End     int // 最后一个突破阈值的点（含）
+	PeakIdx int
+	PeakZ   float64
+}
+
+// 两个突破点之间有 gap 个未突破的点时是否属于同一个事件：间隔少于 minGap，或两点相邻
+func sameExtremeEvent(gap, minGap int) bool {
+	return gap == 0 || gap < minGap
+}
+
+// 把突破阈值的点合并成事件，避免一段持续的行情每分钟都报一次
+// sign 为 1 时找 z > threshold（暴涨），为 -1 时找 z < -threshold（暴跌）；NaN 视为未突破
+// 两个突破点之间未突破的点少于 minGap 时算作同一个事件；相邻的突破点总是同一个事件（minGap 为 0 时也是）
+func scanExtremes(zscores []float64, threshold float64, sign int, minGap int) []ExtremeEvent {
+	var events []ExtremeEvent
+	for i, z := range zscores {
+		if !(float64(sign)*z > threshold) {
+			continue
+		}
+
+		if n := len(events); n > 0 && sameExtremeEvent(i-events[n-1].End-1, minGap) {
+			event := &events[n-1]
+			event.End = i
+			if float64(sign)*z > float64(sign)*event.PeakZ {
+				event.PeakIdx = i
+				event.PeakZ = z
+			}
+			continue
+		}
+		events = append(events, ExtremeEvent{Start: i, End: i, PeakIdx: i, PeakZ: z})
+	}
+	return events
+}
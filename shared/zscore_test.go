@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"math"
+	"testing"
+)
+
+// 两段相隔较远的突破阈值的行情各算一个事件；一段之内短暂回落（少于 minGap）不拆开
+func TestScanExtremesTwoClusters(t *testing.T) {
+	zscores := make([]float64, 200)
+	for i := range zscores {
+		zscores[i] = 0.5
+	}
+	for i := 20; i < 35; i++ {
+		zscores[i] = 2.5
+	}
+	zscores[27] = 1.0 // 段内短暂回落
+	zscores[30] = 4.2
+	for i := 120; i < 130; i++ {
+		zscores[i] = 3
+	}
+	zscores[125] = math.NaN()
+
+	events := scanExtremes(zscores, 2, 1, 10)
+	want := []ExtremeEvent{
+		{Start: 20, End: 34, PeakIdx: 30, PeakZ: 4.2},
+		{Start: 120, End: 129, PeakIdx: 120, PeakZ: 3},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("%d 个事件, want %d: %+v", len(events), len(want), events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("事件 %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+
+	// 反方向找暴跌时没有事件；minGap 为 1 时只合并相邻的点，段内的回落把每段拆成两个
+	if events := scanExtremes(zscores, 2, -1, 10); len(events) != 0 {
+		t.Errorf("暴跌事件 %+v, want 无", events)
+	}
+	// minGap 为 0 时相邻的点同样合并，而不是每个突破的点各算一个事件
+	for _, minGap := range []int{1, 0} {
+		if events := scanExtremes(zscores, 2, 1, minGap); len(events) != 4 {
+			t.Errorf("minGap=%d: %d 个事件, want 4: %+v", minGap, len(events), events)
+		}
+	}
+}
+
+// 每个区间对应的说明，边界值（|z| 恰好为 1 或 2）归入较低的一档
+func TestInterpretZScoreBands(t *testing.T) {
+	for _, tc := range []struct {
+		z    float64
+		want string
+	}{
+		{3.5, "显著高于均值"},
+		{2.0001, "显著高于均值"},
+		{2, "高于均值"},
+		{1.5, "高于均值"},
+		{1, "接近均值"},
+		{0, "接近均值"},
+		{-1, "接近均值"},
+		{-1.5, "低于均值"},
+		{-2, "低于均值"},
+		{-2.0001, "显著低于均值"},
+		{math.Inf(-1), "显著低于均值"},
+		{math.NaN(), "无法计算（缺少波动率数据或标准差为0）"},
+	} {
+		if got := interpretZScore(tc.z); got != tc.want {
+			t.Errorf("interpretZScore(%v) = %s, want %s", tc.z, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 小矩阵上的超过比例：NaN 不计入，|z| 恰好等于阈值不算超过，最小/最大值取有效格子
+func TestMatrixSummaryExceedance(t *testing.T) {
+	nan := math.NaN()
+	summary := newMatrixSummary([]int{1, 5}, []float64{1, 2})
+	for _, row := range [][]float64{
+		{0.5, nan},
+		{-1.5, nan},
+		{2, 3},
+		{-2.5, -0.5},
+		{1, nan},
+	} {
+		summary.Add(row)
+	}
+	if summary.Rows != 5 {
+		t.Errorf("Rows = %d, want 5", summary.Rows)
+	}
+
+	for _, tc := range []struct {
+		col      int
+		count    int
+		min, max float64
+		fracs    []float64
+	}{
+		{0, 5, -2.5, 2, []float64{3.0 / 5, 1.0 / 5}},
+		{1, 2, -0.5, 3, []float64{1.0 / 2, 1.0 / 2}},
+	} {
+		ws := summary.Windows[tc.col]
+		if ws.Count != tc.count || ws.Min != tc.min || ws.Max != tc.max {
+			t.Errorf("%d 分钟: Count=%d Min=%v Max=%v, want %d %v %v", ws.Window, ws.Count, ws.Min, ws.Max, tc.count, tc.min, tc.max)
+		}
+		for j, want := range tc.fracs {
+			if got := ws.Fraction(j); math.Abs(got-want) > 1e-12 {
+				t.Errorf("%d 分钟 |z|>%v 的比例 = %v, want %v", ws.Window, summary.Thresholds[j], got, want)
+			}
+		}
+	}
+
+	empty := newMatrixSummary([]int{60}, []float64{2})
+	empty.Add([]float64{nan})
+	if got := empty.Windows[0].Fraction(0); !math.IsNaN(got) {
+		t.Errorf("没有有效数据时比例 = %v, want NaN", got)
+	}
+}
+
+// 从矩阵文件逐行统计与直接累计的结果相同
+func TestSummarizeMatrixFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zscore_matrix.csv")
+	content := "# schema=4\nTimeIndex,1,5\n0,0.5,NaN\n1,-1.5,NaN\n2,2,3\n3,-2.5,-0.5\n4,1,NaN\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := summarizeMatrixFile(path, []float64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Rows != 5 || summary.Windows[0].Window != 1 || summary.Windows[1].Window != 5 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	if got := summary.Windows[0].Exceed; got[0] != 3 || got[1] != 1 {
+		t.Errorf("1 分钟超过阈值的格子数 = %v, want [3 1]", got)
+	}
+	if got := summary.Windows[1].Exceed; got[0] != 1 || got[1] != 1 {
+		t.Errorf("5 分钟超过阈值的格子数 = %v, want [1 1]", got)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.csv")
+	if err := os.WriteFile(bad, []byte("# schema=4\nTimeIndex,1\n0,abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := summarizeMatrixFile(bad, []float64{1}); err == nil {
+		t.Error("非数字的格子应返回错误")
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	got, err := parseThresholds("3, 1,2")
+	if err != nil || len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("parseThresholds = %v, %v", got, err)
+	}
+	for _, value := range []string{"", "1,-2", "0", "abc", "inf"} {
+		if _, err := parseThresholds(value); err == nil {
+			t.Errorf("parseThresholds(%q) 应返回错误", value)
+		}
+	}
+}
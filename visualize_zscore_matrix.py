@@ -13,8 +13,11 @@ print("正在读取z-score矩阵...")
 # 读取CSV文件
 matrix_data = []
 with open('zscore_matrix.csv', 'r') as f:
-    reader = csv.reader(f)
-    header = next(reader)  # 跳过标题行
+    # 跳过 "# schema=N" 版本行
+    reader = csv.reader(line for line in f if not line.startswith('#'))
+    header = next(reader)
+    # 标题为各列的窗口分钟数，矩阵可能只包含 -matrix-windows 指定的窗口
+    windows = [int(x) for x in header[1:]]
     for row in reader:
         # 跳过第一列（时间索引），只读取z-score值
         matrix_data.append([float(x) for x in row[1:]])
@@ -42,15 +45,17 @@ if HAS_MATPLOTLIB:
     # 设置坐标轴标签
     ax.set_xlabel('Time Window (minutes)', fontsize=12)
     ax.set_ylabel('Time Index (last 7 days, per minute)', fontsize=12)
-    ax.set_title('Z-Score Heatmap (Last 7 Days × 1-10080 Minutes Windows)', fontsize=14, fontweight='bold')
+    ax.set_title(f'Z-Score Heatmap ({len(windows)} Windows, {windows[0]}-{windows[-1]} Minutes)', fontsize=14, fontweight='bold')
 
     # 添加颜色条
     cbar = plt.colorbar(im, ax=ax, label='Z-Score', shrink=0.8)
     cbar.ax.set_ylabel('Z-Score', rotation=270, labelpad=20)
 
-    # 设置x轴刻度（显示关键时间窗口）
-    x_ticks = [0, 60, 240, 1440, 2880, 4320, 5760, 7200, 8640, 10080]
-    x_labels = ['1m', '1h', '4h', '1d', '2d', '3d', '4d', '5d', '6d', '7d']
+    # 设置x轴刻度（显示关键时间窗口），按标题中的窗口找列
+    key_windows = [(1, '1m'), (60, '1h'), (240, '4h'), (1440, '1d'), (2880, '2d'), (4320, '3d'),
+                   (5760, '4d'), (7200, '5d'), (8640, '6d'), (10080, '7d')]
+    x_ticks = [windows.index(w) for w, _ in key_windows if w in windows]
+    x_labels = [label for w, label in key_windows if w in windows]
     ax.set_xticks(x_ticks)
     ax.set_xticklabels(x_labels)
 
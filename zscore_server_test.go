@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在临时输入目录中写入 ETHUSDT 的K线（每分钟上涨 0.1）和波动率表，启动服务
+func startZScoreServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	oldDir := inputDir
+	inputDir = t.TempDir()
+	t.Cleanup(func() { inputDir = oldDir })
+
+	lines := []string{"Open Time,Open Time (UTC),Open,High,Low,Close,Volume"}
+	for i := 0; i < 100; i++ {
+		price := 100 + float64(i)*0.1
+		lines = append(lines, fmt.Sprintf("%d,2026-01-01 00:%02d:00,%g,%g,%g,%g,1", 1767225600000+int64(i)*60000, i%60, price, price, price, price))
+	}
+	volatility := "# schema=3\nWindow_Minutes,Window_Days,Mean_Pct,StdDev_Pct,Sample_Count,VaR_Pct,Realized_Vol_Pct\n" +
+		"1,0.0007,0.1,0.05,99,0,0\n" +
+		"5,0.0035,0,0.1,95,0,0\n" +
+		"60,0.0417,0,0.5,40,0,0\n" +
+		"30,0.0208,0,0,70,0,0\n"
+	for name, data := range map[string]string{
+		"ETHUSDT_minute_klines.csv":      strings.Join(lines, "\n") + "\n",
+		"multi_timeframe_volatility.csv": volatility,
+	} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := &ZScoreServer{returnMode: "simple", cache: make(map[string]*symbolData)}
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+// 请求并解析 JSON，返回状态码和对象
+func getJSON(t *testing.T, url string) (int, map[string]interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: Content-Type = %q", url, ct)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: 响应不是 JSON 对象: %v", url, err)
+	}
+	return resp.StatusCode, body
+}
+
+func keys(m map[string]interface{}) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestZScoreEndpoint(t *testing.T) {
+	server := startZScoreServer(t)
+	status, body := getJSON(t, server.URL+"/zscore?symbol=ethusdt&window=5")
+	if status != http.StatusOK {
+		t.Fatalf("状态码 %d: %v", status, body)
+	}
+	if got, want := keys(body), []string{"price", "pvalue", "returnPct", "time", "window", "zscore"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("字段 = %v, want %v", got, want)
+	}
+
+	returnPct := (109.9 - 109.4) / 109.4 * 100
+	for name, want := range map[string]float64{
+		"window":    5,
+		"returnPct": returnPct,
+		"zscore":    returnPct / 0.1,
+		"pvalue":    2 * (1 - normalCDF(returnPct/0.1)),
+		"price":     109.9,
+	} {
+		if got, ok := body[name].(float64); !ok || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, body[name], want)
+		}
+	}
+	if body["time"] != "2026-01-01 00:39:00" {
+		t.Errorf("time = %v", body["time"])
+	}
+}
+
+func TestZScoreEndpointErrors(t *testing.T) {
+	server := startZScoreServer(t)
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"window=0", http.StatusBadRequest},
+		{"window=abc", http.StatusBadRequest},
+		{"symbol=BTCUSDT&window=5", http.StatusNotFound},
+		{"symbol=../etc&window=5", http.StatusBadRequest},
+		{"symbol=ETHUSDT&window=15", http.StatusNotFound},  // 波动率表中没有
+		{"symbol=ETHUSDT&window=30", http.StatusNotFound},  // 标准差为0
+		{"symbol=ETHUSDT&window=120", http.StatusNotFound}, // 数据不足
+	} {
+		status, body := getJSON(t, server.URL+"/zscore?"+tc.query)
+		if status != tc.status {
+			t.Errorf("%s: 状态码 %d, want %d", tc.query, status, tc.status)
+		}
+		if msg, ok := body["error"].(string); !ok || msg == "" || len(body) != 1 {
+			t.Errorf("%s: 错误响应 = %v, want {\"error\": ...}", tc.query, body)
+		}
+	}
+}
+
+// /extremes 只返回 |z| 达到阈值的窗口，按 |z| 从大到小排序
+func TestExtremesEndpoint(t *testing.T) {
+	server := startZScoreServer(t)
+	status, body := getJSON(t, server.URL+"/extremes?symbol=ETHUSDT&threshold=2")
+	if status != http.StatusOK {
+		t.Fatalf("状态码 %d: %v", status, body)
+	}
+	if got, want := keys(body), []string{"extremes", "symbol", "threshold"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("字段 = %v, want %v", got, want)
+	}
+	if body["symbol"] != "ETHUSDT" || body["threshold"] != 2.0 {
+		t.Errorf("symbol = %v, threshold = %v", body["symbol"], body["threshold"])
+	}
+
+	// 1 分钟: (0.0911-0.1)/0.05 ≈ -0.18；5 分钟: 0.457/0.1 ≈ 4.6；60 分钟: 5.77/0.5 ≈ 11.5
+	extremes, ok := body["extremes"].([]interface{})
+	if !ok {
+		t.Fatalf("extremes = %v", body["extremes"])
+	}
+	var windows []float64
+	for _, e := range extremes {
+		windows = append(windows, e.(map[string]interface{})["window"].(float64))
+	}
+	if want := []float64{60, 5}; !reflect.DeepEqual(windows, want) {
+		t.Errorf("超过阈值的窗口 = %v, want %v", windows, want)
+	}
+
+	_, body = getJSON(t, server.URL+"/extremes?symbol=ETHUSDT&threshold=100")
+	if extremes, ok := body["extremes"].([]interface{}); !ok || len(extremes) != 0 {
+		t.Errorf("没有超过阈值的窗口时 extremes = %v, want []", body["extremes"])
+	}
+}
+
+// -input-format json 时只有 .json 文件也能读取，文件修改后重新加载
+func TestZScoreEndpointJSONInput(t *testing.T) {
+	server := startZScoreServer(t)
+	oldFormat := inputFormat
+	inputFormat = "json"
+	t.Cleanup(func() { inputFormat = oldFormat })
+
+	writeJSONKlines := func(last float64) {
+		t.Helper()
+		var rows []string
+		for i := 0; i < 100; i++ {
+			price := 100 + float64(i)*0.1
+			if i == 99 {
+				price = last
+			}
+			rows = append(rows, fmt.Sprintf(`[%d,"%g","%g","%g","%g","1"]`, 1767225600000+int64(i)*60000, price, price, price, price))
+		}
+		path := filepath.Join(inputDir, "ETHUSDT_minute_klines.json")
+		if err := os.WriteFile(path, []byte("["+strings.Join(rows, ",")+"]"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(inputDir, "ETHUSDT_minute_klines.csv")); err != nil {
+		t.Fatal(err)
+	}
+	writeJSONKlines(109.9)
+
+	status, body := getJSON(t, server.URL+"/zscore?symbol=ETHUSDT&window=5")
+	if status != http.StatusOK {
+		t.Fatalf("状态码 %d: %v", status, body)
+	}
+	if body["price"] != 109.9 || body["time"] != "2026-01-01 01:39:00" {
+		t.Errorf("price = %v, time = %v, want 109.9, 2026-01-01 01:39:00", body["price"], body["time"])
+	}
+
+	// 修改时间变化后按新文件计算，而不是继续用缓存
+	writeJSONKlines(120)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(inputDir, "ETHUSDT_minute_klines.json"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, body = getJSON(t, server.URL+"/zscore?symbol=ETHUSDT&window=5"); body["price"] != 120.0 {
+		t.Errorf("更新 .json 后 price = %v, want 120", body["price"])
+	}
+}
+
+// 波动率表只对应 ETHUSDT：BTCUSDT 的 z-score 按它自己的历史收益率估算，而不是除以 ETH 的均值和标准差
+func TestZScoreEndpointPerSymbolVolatility(t *testing.T) {
+	server := startZScoreServer(t)
+
+	lines := []string{"Open Time,Open Time (UTC),Open,High,Low,Close,Volume"}
+	var prices []float64
+	for i := 0; i < 100; i++ {
+		price := 30000 * (1 + 0.01*math.Sin(float64(i)))
+		prices = append(prices, price)
+		lines = append(lines, fmt.Sprintf("%d,2026-01-01 00:%02d:00,%g,%g,%g,%g,1", 1767225600000+int64(i)*60000, i%60, price, price, price, price))
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "BTCUSDT_minute_klines.csv"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, window := range []int{1, 5, 60} {
+		var returns []float64
+		for i := window; i < len(prices); i++ {
+			returns = append(returns, calculateReturn(prices[i-window], prices[i], "simple"))
+		}
+		var mean, ss float64
+		for _, r := range returns {
+			mean += r / float64(len(returns))
+		}
+		for _, r := range returns {
+			ss += (r - mean) * (r - mean)
+		}
+		want := (returns[len(returns)-1] - mean) / math.Sqrt(ss/float64(len(returns)-1))
+
+		status, body := getJSON(t, fmt.Sprintf("%s/zscore?symbol=BTCUSDT&window=%d", server.URL, window))
+		if status != http.StatusOK {
+			t.Fatalf("window %d: 状态码 %d: %v", window, status, body)
+		}
+		if got, ok := body["zscore"].(float64); !ok || math.Abs(got-want) > 1e-9 {
+			t.Errorf("BTCUSDT window %d: zscore = %v, want %v（按自己的历史估算）", window, body["zscore"], want)
+		}
+	}
+
+	// 波动率表中没有的窗口（15 分钟）也能按自己的历史计算
+	if status, body := getJSON(t, server.URL+"/zscore?symbol=BTCUSDT&window=15"); status != http.StatusOK {
+		t.Errorf("BTCUSDT window 15: 状态码 %d: %v", status, body)
+	}
+	// ETHUSDT 仍然使用波动率表
+	if _, body := getJSON(t, server.URL+"/zscore?symbol=ETHUSDT&window=15"); body["error"] == nil {
+		t.Errorf("ETHUSDT window 15 = %v, want 波动率表中没有该窗口的错误", body)
+	}
+}